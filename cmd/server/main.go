@@ -8,29 +8,78 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/lepinkainen/commander/internal/api"
 	"github.com/lepinkainen/commander/internal/assets"
+	"github.com/lepinkainen/commander/internal/events"
 	"github.com/lepinkainen/commander/internal/executor"
 	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/notify"
 	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/task"
 )
 
+// Build information, injected at build time via -ldflags "-X main.Version=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
 func main() {
 	var (
 		addr       = flag.String("addr", ":8080", "Server address")
 		workers    = flag.Int("workers", 4, "Number of workers per tool")
-		configPath = flag.String("config", "./config/tools.json", "Path to tools configuration")
-		dbPath     = flag.String("db", "./data/commander.db", "Path to SQLite database")
+		configPath = flag.String("config", "./config/tools.json", "Path to tools configuration file or directory")
+		dataDir    = flag.String("data-dir", "./data", "Directory for the database, uploads and backups")
+		dlDir      = flag.String("downloads-dir", files.DefaultDownloadsDir, "Directory the default and per-tool download directories are created in")
+		dbPath     = flag.String("db", "", "Path to SQLite database (default <data-dir>/commander.db)")
 		dev        = flag.Bool("dev", false, "Development mode - serve static files from filesystem instead of embedded")
+		allowCmd   = flag.Bool("allow-command-override", false, "Allow task requests to override the tool's configured command")
+		apiKey     = flag.String("api-key", os.Getenv("COMMANDER_API_KEY"), "API key required for admin endpoints (default $COMMANDER_API_KEY)")
+		shareKey   = flag.String("share-key", os.Getenv("COMMANDER_SHARE_KEY"), "Key signing file share links, change it to revoke all links; empty disables sharing (default $COMMANDER_SHARE_KEY)")
+		uploadDir  = flag.String("upload-dir", "", "Directory for files uploaded through the API (default <data-dir>/uploads)")
+		maxUpload  = flag.Int64("max-upload-size", 4<<30, "Maximum upload size in bytes, 0 disables uploads")
+		uploadTTL  = flag.Duration("upload-session-ttl", files.DefaultUploadSessionTTL, "Drop resumable uploads that receive no chunk for this long, 0 keeps them forever")
+		roots      = flag.String("allowed-roots", "", "Comma-separated directories file operations are restricted to (default: unrestricted)")
+		minFree    = flag.Uint64("min-free-disk", 1<<30, "Report not ready on /readyz when the downloads file system has fewer free bytes, 0 only reports free space")
+		diskEvery  = flag.Duration("disk-check-interval", files.DefaultDiskCheckInterval, "How often to check free space on the downloads file system")
+		serveAll   = flag.Bool("serve-outside-directories", false, "Allow downloading files that lie outside every registered directory")
+		readOnly   = flag.Bool("read-only", false, "Start in read-only mode: reject requests that change state and don't start queued tasks until it is switched off")
+		webhook    = flag.String("notify-webhook", os.Getenv("COMMANDER_NOTIFY_WEBHOOK"), "URL to post finished task notifications to as JSON (default $COMMANDER_NOTIFY_WEBHOOK)")
+		discord    = flag.String("notify-discord", os.Getenv("COMMANDER_NOTIFY_DISCORD"), "Discord webhook URL for finished task notifications (default $COMMANDER_NOTIFY_DISCORD)")
+		slack      = flag.String("notify-slack", os.Getenv("COMMANDER_NOTIFY_SLACK"), "Slack webhook URL for finished task notifications (default $COMMANDER_NOTIFY_SLACK)")
+		dlIdle     = flag.Duration("download-idle-timeout", api.DefaultDownloadIdleTimeout, "Abort a file download when the client accepts no data for this long, 0 disables")
+		compress   = flag.Bool("compress-output", false, "Store the output of finished tasks gzip compressed in the database")
+		maxLine    = flag.Int("max-line-length", executor.DefaultMaxLineLength, "Truncate task output lines longer than this many bytes, 0 keeps full lines")
+		wsQueue    = flag.Int("ws-queue-size", events.DefaultBufferSize, "Events buffered per WebSocket connection")
+		wsDrop     = flag.String("ws-drop-policy", "oldest", "Events a slow WebSocket client loses when its queue is full: oldest or newest")
+		wsMissed   = flag.Int("ws-max-missed", api.DefaultWebSocketMaxMissed, "Disconnect a WebSocket client after it misses this many events without catching up, 0 never disconnects")
 	)
 	flag.Parse()
 
+	dropPolicy, err := events.ParseDropPolicy(*wsDrop)
+	if err != nil {
+		log.Fatalf("Invalid -ws-drop-policy: %v", err)
+	}
+
+	buildInfo := api.NewBuildInfo(Version, Commit, BuildDate)
+	log.Printf("Commander %s (commit %s, built %s, %s)", buildInfo.Version, buildInfo.Commit, buildInfo.BuildDate, buildInfo.GoVersion)
+
+	// Everything stateful defaults to living under the data directory
+	if *dbPath == "" {
+		*dbPath = filepath.Join(*dataDir, "commander.db")
+	}
+	if *uploadDir == "" {
+		*uploadDir = filepath.Join(*dataDir, "uploads")
+	}
+
 	// Ensure data directory exists
-	if err := os.MkdirAll("./data", 0o755); err != nil {
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
@@ -45,11 +94,38 @@ func main() {
 		}
 	}()
 
+	// Task and file events share one bus so clients get both on one stream
+	bus := events.NewBus()
+
 	// Create task manager
 	manager := task.NewManager(repo)
+	manager.SetEventBus(bus)
+	if *compress {
+		manager.SetOutputCompressor(repo)
+	}
+
+	// Notify external services about finished tasks
+	var notifiers []notify.Notifier
+	if *webhook != "" {
+		notifiers = append(notifiers, notify.NewWebhook(*webhook))
+	}
+	if *discord != "" {
+		notifiers = append(notifiers, notify.NewDiscord(*discord))
+	}
+	if *slack != "" {
+		notifiers = append(notifiers, notify.NewSlack(*slack))
+	}
+	manager.SetNotifiers(notifiers...)
 
 	// Create file manager
 	fileManager := files.NewManager(repo)
+	fileManager.SetEventBus(bus)
+	fileManager.SetDownloadsDir(*dlDir)
+	if *roots != "" {
+		if err := fileManager.SetAllowedRoots(strings.Split(*roots, ",")); err != nil {
+			log.Fatalf("Failed to set allowed roots: %v", err)
+		}
+	}
 
 	// Create file discovery service
 	fileDiscovery := files.NewFileDiscovery(fileManager)
@@ -63,17 +139,86 @@ func main() {
 		log.Fatalf("Failed to create executor: %v", err)
 	}
 
-	// Start the executor
+	if *maxLine < 0 {
+		log.Fatalf("Invalid -max-line-length: must not be negative, got %d", *maxLine)
+	}
+	exec.SetMaxLineLength(*maxLine)
+	exec.SetWorkerStore(repo)
+
+	// Apply per-tool file organization patterns, already validated with the config
+	for _, tool := range exec.GetTools() {
+		if err := fileDiscovery.SetOrganizePattern(tool.Name, tool.OrganizePattern); err != nil {
+			log.Fatalf("Failed to configure tool %s: %v", tool.Name, err)
+		}
+	}
+
+	// Route organized files by MIME type. The routes were validated with the
+	// config, their directories also have to be inside the allowed roots.
+	for _, route := range exec.OrganizeRoutes() {
+		if err := fileManager.CheckPath(route.Directory); err != nil {
+			log.Fatalf("Invalid organize route for %s: %v", route.MimeType, err)
+		}
+	}
+	if err := fileDiscovery.SetOrganizeRoutes(exec.OrganizeRoutes()); err != nil {
+		log.Fatalf("Failed to configure organize routes: %v", err)
+	}
+	if err := fileManager.SetMimeTypes(exec.MimeTypes()); err != nil {
+		log.Fatalf("Failed to configure MIME types: %v", err)
+	}
+
+	// Start the executor, held back from the start in read-only mode so
+	// recovered tasks don't run either
+	if *readOnly {
+		exec.Pause()
+	}
 	if err := exec.Start(); err != nil {
 		log.Fatalf("Failed to start executor: %v", err)
 	}
 
+	// Tasks still marked running were interrupted by a crash or kill
+	if err := exec.RecoverInterrupted(context.Background()); err != nil {
+		log.Fatalf("Failed to recover interrupted tasks: %v", err)
+	}
+
+	// Probe free space in the background so /readyz stays cheap
+	diskMonitor := files.NewDiskMonitor(fileManager.DownloadsDir(), *minFree)
+	diskCtx, stopDiskMonitor := context.WithCancel(context.Background())
+	defer stopDiskMonitor()
+	go diskMonitor.Run(diskCtx, *diskEvery)
+
 	// Create API server
 	var staticFiles *embed.FS
 	if !*dev {
 		staticFiles = &assets.StaticFiles
 	}
 	server := api.NewServer(manager, exec, fileManager, staticFiles)
+	server.SetBuildInfo(buildInfo)
+	server.SetEventBus(bus)
+	server.SetAPIKey(*apiKey)
+	server.SetShareKey(*shareKey)
+	server.SetAllowCommandOverride(*allowCmd)
+	server.SetServeOutsideDirectories(*serveAll)
+	server.SetReadOnly(*readOnly)
+	server.SetMaintainer(repo, *dbPath)
+	server.SetAuditLog(repo)
+	server.SetFlags(api.FlagValues(flag.CommandLine, "api-key", "share-key", "notify-webhook", "notify-discord", "notify-slack"))
+	server.SetDownloadIdleTimeout(*dlIdle)
+	server.SetWebSocketQueue(*wsQueue, dropPolicy, *wsMissed)
+	server.SetDiskMonitor(diskMonitor)
+	if *maxUpload > 0 {
+		// Checked now rather than failing every upload later
+		if err := fileManager.CheckPath(*uploadDir); err != nil {
+			log.Fatalf("Invalid upload directory: %v", err)
+		}
+		uploader := files.NewUploader(fileManager, *uploadDir, *maxUpload)
+		uploader.SetSessionTTL(*uploadTTL)
+		server.SetUploader(uploader)
+		if *uploadTTL > 0 {
+			uploadCtx, stopUploadExpiry := context.WithCancel(context.Background())
+			defer stopUploadExpiry()
+			go uploader.RunExpiry(uploadCtx, min(*uploadTTL, time.Hour))
+		}
+	}
 
 	// Setup HTTP server
 	httpServer := &http.Server{
@@ -104,6 +249,7 @@ func main() {
 	defer cancel()
 
 	exec.Stop()
+	stopDiskMonitor()
 
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
@@ -8,4 +8,5 @@ require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/rs/cors v1.11.1
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+)
+
+// SetMaintainer enables the database maintenance endpoints. Backups without
+// an explicit path are written next to dbPath in a "backups" directory.
+func (s *Server) SetMaintainer(maintainer storage.Maintainer, dbPath string) {
+	s.maintainer = maintainer
+	s.dbPath = dbPath
+}
+
+// BackupRequest represents a database backup request
+type BackupRequest struct {
+	Path string `json:"path"`
+}
+
+// backupDatabase writes a consistent copy of the database to disk
+func (s *Server) backupDatabase(w http.ResponseWriter, r *http.Request) {
+	if s.maintainer == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Database maintenance not available")
+		return
+	}
+
+	var req BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	destPath := req.Path
+	if destPath == "" {
+		name := fmt.Sprintf("commander-%s.db", time.Now().UTC().Format("20060102-150405"))
+		destPath = filepath.Join(filepath.Dir(s.dbPath), "backups", name)
+	}
+
+	if _, err := os.Stat(destPath); err == nil {
+		writeError(w, http.StatusConflict, CodeConflict, "Backup destination already exists")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o755); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	start := time.Now()
+	if err := s.maintainer.Backup(r.Context(), destPath); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":        destPath,
+		"size_bytes":  info.Size(),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// vacuumDatabase reclaims unused space in the database file
+func (s *Server) vacuumDatabase(w http.ResponseWriter, r *http.Request) {
+	if s.maintainer == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Database maintenance not available")
+		return
+	}
+
+	sizeBefore := fileSize(s.dbPath)
+	start := time.Now()
+	if err := s.maintainer.Vacuum(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":              s.dbPath,
+		"size_before_bytes": sizeBefore,
+		"size_bytes":        fileSize(s.dbPath),
+		"duration_ms":       time.Since(start).Milliseconds(),
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// fileSize returns the size of a file, or 0 if it can't be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
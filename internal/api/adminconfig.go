@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+
+	"github.com/lepinkainen/commander/internal/executor"
+)
+
+// redactedValue replaces secret flag values in GET /api/admin/config
+const redactedValue = "[redacted]"
+
+// AdminConfig is the configuration the server actually runs with
+type AdminConfig struct {
+	// Config is the tools configuration with defaults filled in
+	Config executor.Config `json:"config"`
+
+	// Flags holds the value of every command line flag after defaults
+	// derived from other flags were resolved, secrets redacted
+	Flags map[string]string `json:"flags"`
+
+	Build BuildInfo `json:"build"`
+}
+
+// FlagValues returns the current value of every flag in fs by name. The
+// values of the secrets flags are replaced when they are set, an unset
+// secret stays empty so it shows the feature is off.
+func FlagValues(fs *flag.FlagSet, secrets ...string) map[string]string {
+	secret := make(map[string]bool, len(secrets))
+	for _, name := range secrets {
+		secret[name] = true
+	}
+
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secret[f.Name] && value != "" {
+			value = redactedValue
+		}
+		values[f.Name] = value
+	})
+	return values
+}
+
+// SetFlags sets the flag values reported by GET /api/admin/config, see
+// FlagValues
+func (s *Server) SetFlags(flags map[string]string) {
+	s.flags = flags
+}
+
+// getAdminConfig returns the effective server configuration for debugging
+func (s *Server) getAdminConfig(w http.ResponseWriter, r *http.Request) {
+	flags := s.flags
+	if flags == nil {
+		flags = map[string]string{}
+	}
+	config := AdminConfig{
+		Config: s.executor.EffectiveConfig(),
+		Flags:  flags,
+		Build:  s.buildInfo,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
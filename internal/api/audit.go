@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// Actors recorded in the audit log. There is a single API key, so requests
+// presenting it can't be told apart further than by their address.
+const (
+	ActorAPIKey    = "api-key"
+	ActorAnonymous = "anonymous"
+)
+
+// Limits for audit log pages
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// SetAuditLog enables recording every mutating API request, and the
+// endpoint listing them
+func (s *Server) SetAuditLog(auditLog storage.AuditLog) {
+	s.auditLog = auditLog
+}
+
+// statusRecorder remembers the status code a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records code and writes it
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records an implicit 200 before the first write
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// auditRequests records every request that may change state, whatever its
+// outcome, so that refused attempts are accounted for too. Reads aren't
+// recorded.
+func (s *Server) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auditLog == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		action := r.Method + " " + r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				action = r.Method + " " + template
+			}
+		}
+		actor := ActorAnonymous
+		if s.isAuthorized(r) {
+			actor = ActorAPIKey
+		}
+		entry := &types.AuditEntry{
+			CreatedAt:  time.Now(),
+			Actor:      actor,
+			RemoteAddr: r.RemoteAddr,
+			Action:     action,
+			Summary:    r.Method + " " + r.URL.Path,
+			Status:     rec.status,
+		}
+		// Record the request even when the client has gone away meanwhile
+		if err := s.auditLog.RecordAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+			log.Printf("Failed to record audit entry for %s: %v", entry.Summary, err)
+		}
+	})
+}
+
+// getAuditLog lists recorded requests newest first, optionally filtered by
+// actor, action and a since=24h window, paged with offset and limit
+func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	if s.auditLog == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Audit log not available")
+		return
+	}
+
+	query := r.URL.Query()
+	filters := types.AuditFilters{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'offset' must be a non-negative integer")
+			return
+		}
+		filters.Offset = parsed
+	}
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxAuditLimit {
+			writeError(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("Query parameter 'limit' must be between 1 and %d", maxAuditLimit))
+			return
+		}
+		filters.Limit = parsed
+	}
+	if v := query.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'since' must be a positive duration such as 24h or 90m")
+			return
+		}
+		since := time.Now().Add(-d)
+		filters.Since = &since
+	}
+
+	page, err := s.auditLog.ListAudit(r.Context(), filters)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// SetAPIKey sets the key required by protected endpoints. An empty key
+// disables those endpoints entirely.
+func (s *Server) SetAPIKey(key string) {
+	s.apiKey = key
+}
+
+// requireAuth rejects requests that don't present the configured API key,
+// either as "Authorization: Bearer <key>" or in the X-API-Key header
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.apiKey == "" {
+			writeError(w, http.StatusForbidden, CodeForbidden, "Admin API disabled: start the server with -api-key to enable it")
+			return
+		}
+
+		if !s.isAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAuthorized reports whether the request carries the configured API key
+func (s *Server) isAuthorized(r *http.Request) bool {
+	if s.apiKey == "" {
+		return false
+	}
+
+	presented := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(s.apiKey)) == 1
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lepinkainen/commander/internal/executor"
+)
+
+// ClientConfig describes server capabilities the frontend can adapt to
+type ClientConfig struct {
+	Tools       []ToolSummary   `json:"tools"`
+	Limits      ClientLimits    `json:"limits"`
+	AuthEnabled bool            `json:"auth_enabled"`
+	Features    map[string]bool `json:"features"`
+	Build       BuildInfo       `json:"build"`
+
+	// MimeTypes are the configured MIME type overrides by extension, which
+	// files are recorded with instead of the system's types
+	MimeTypes map[string]string `json:"mime_types"`
+}
+
+// ToolSummary is the client-facing view of a configured tool
+type ToolSummary struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Workers           int      `json:"workers"`
+	QueueSize         int      `json:"queue_size"`
+	DefaultTags       []string `json:"default_tags"`
+	MaxRuntimeSeconds int      `json:"max_runtime_seconds,omitempty"`
+}
+
+// ClientLimits holds request limits enforced by the server. A zero value
+// means the corresponding input is not accepted.
+type ClientLimits struct {
+	QueueSize      int   `json:"queue_size"`
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+	MaxStdinBytes  int64 `json:"max_stdin_bytes"`
+}
+
+// getConfig returns the client-facing server configuration
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	tools := s.executor.GetTools()
+	summaries := make([]ToolSummary, 0, len(tools))
+	for _, tool := range tools {
+		summaries = append(summaries, ToolSummary{
+			Name:              tool.Name,
+			Description:       tool.Description,
+			Workers:           s.executor.WorkerCount(tool),
+			QueueSize:         s.executor.QueueSize(tool),
+			DefaultTags:       defaultTags(tool),
+			MaxRuntimeSeconds: tool.MaxRuntimeSeconds,
+		})
+	}
+
+	var maxUploadBytes int64
+	if s.uploader != nil {
+		maxUploadBytes = s.uploader.MaxBytes()
+	}
+
+	config := ClientConfig{
+		Tools: summaries,
+		Limits: ClientLimits{
+			QueueSize:      executor.DefaultQueueSize,
+			MaxUploadBytes: maxUploadBytes,
+		},
+		AuthEnabled: s.apiKey != "",
+		Features: map[string]bool{
+			"file_discovery":   true,
+			"progress_parsing": false,
+			"thumbnails":       false,
+			"uploads":          s.uploader != nil,
+		},
+		Build:     s.buildInfo,
+		MimeTypes: s.fileManager.MimeTypes(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(config); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// defaultTags returns a tool's default tags, never nil so clients always get a list
+func defaultTags(tool executor.Tool) []string {
+	if tool.DefaultTags == nil {
+		return []string{}
+	}
+	return tool.DefaultTags
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lepinkainen/commander/internal/executor"
+	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+)
+
+// Error codes returned in the "code" field of error responses
+const (
+	CodeBadRequest      = "bad_request"
+	CodeValidation      = "validation_error"
+	CodeNotFound        = "not_found"
+	CodeToolUnavailable = "tool_unavailable"
+	CodeConflict        = "conflict"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeQueueFull       = "queue_full"
+	CodeTooLarge        = "too_large"
+	CodeNotImplemented  = "not_implemented"
+	CodeReadOnly        = "read_only"
+	CodeInternal        = "internal_error"
+)
+
+// ErrorResponse is the JSON envelope for all API errors
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes a single API error
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// AvailableTools lists the tools that can be used instead, for
+	// tool_unavailable errors
+	AvailableTools []string `json:"available_tools,omitempty"`
+
+	// Fields lists every invalid request field, for validation errors
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError describes what is wrong with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// fieldError carries a FieldError up from validation code that returns a
+// plain error, to be written with writeFieldErrors
+type fieldError struct {
+	FieldError
+}
+
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+// writeError writes a JSON error envelope with the given status and code
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetail(w, status, ErrorDetail{Code: code, Message: message})
+}
+
+// writeErrorDetail writes a JSON error envelope holding detail
+func writeErrorDetail(w http.ResponseWriter, status int, detail ErrorDetail) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(ErrorResponse{Error: detail}); err != nil {
+		log.Printf("Failed to encode error response: %v", err)
+	}
+}
+
+// writeFieldErrors writes a validation error listing each invalid field
+func writeFieldErrors(w http.ResponseWriter, fields []FieldError) {
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field.Message
+	}
+	writeErrorDetail(w, http.StatusBadRequest, ErrorDetail{
+		Code:    CodeValidation,
+		Message: strings.Join(messages, "; "),
+		Fields:  fields,
+	})
+}
+
+// writeServiceError maps errors from the lower layers to an HTTP status and
+// error code, treating anything unrecognized as an internal error
+func writeServiceError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	writeError(w, status, code, err.Error())
+}
+
+// errorStatus returns the HTTP status and error code for a service error
+func errorStatus(err error) (status int, code string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.Is(err, storage.ErrInUse):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, storage.ErrInvalidSearch):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, task.ErrTaskExists):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, task.ErrQueueFull):
+		return http.StatusServiceUnavailable, CodeQueueFull
+	case errors.Is(err, task.ErrNotQueued):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, task.ErrNoQueue):
+		return http.StatusBadRequest, CodeBadRequest
+	case errors.Is(err, files.ErrPathNotAllowed):
+		return http.StatusForbidden, CodeForbidden
+	case errors.Is(err, files.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge, CodeTooLarge
+	case errors.Is(err, files.ErrDirectoryNotWritable):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, files.ErrInvalidFilename):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, files.ErrSameDirectory):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, files.ErrUploadOffset):
+		return http.StatusConflict, CodeConflict
+	case errors.Is(err, executor.ErrInvalidWorkerCount):
+		return http.StatusBadRequest, CodeValidation
+	case errors.Is(err, executor.ErrUnknownTool):
+		return http.StatusNotFound, CodeNotFound
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// exportTask returns a task and its full output as a downloadable artifact.
+// With compress=gzip the artifact is compressed on the fly and sent with
+// Content-Encoding: gzip, so browsers save it decompressed.
+func (s *Server) exportTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID := vars["id"]
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "txt" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Unsupported format, use 'json' or 'txt'")
+		return
+	}
+	compress := r.URL.Query().Get("compress")
+	if compress != "" && compress != "gzip" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Unsupported compression, use 'gzip'")
+		return
+	}
+
+	t, err := s.manager.GetTask(r.Context(), taskID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	data := t.Clone()
+
+	var out io.Writer = w
+	if compress == "gzip" {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if err := gz.Close(); err != nil {
+				log.Printf("Failed to finish compressed export of task %s: %v", data.ID, err)
+			}
+		}()
+		out = gz
+	}
+
+	switch format {
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+data.ID+".log\"")
+		if err := writeTaskLog(out, data); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to write export")
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+data.ID+".json\"")
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+		}
+	}
+}
+
+// writeTaskLog renders a task as a readable header followed by its raw output lines
+func writeTaskLog(w io.Writer, data types.TaskData) error {
+	bw := bufio.NewWriter(w)
+
+	commandLine := strings.TrimSpace(data.Command + " " + strings.Join(data.Args, " "))
+	fmt.Fprintf(bw, "Task:     %s\n", data.ID)
+	fmt.Fprintf(bw, "Tool:     %s\n", data.Tool)
+	fmt.Fprintf(bw, "Command:  %s\n", commandLine)
+	fmt.Fprintf(bw, "Status:   %s\n", data.Status)
+	fmt.Fprintf(bw, "Created:  %s\n", formatExportTime(data.CreatedAt))
+	fmt.Fprintf(bw, "Started:  %s\n", formatExportTime(data.StartedAt))
+	fmt.Fprintf(bw, "Ended:    %s\n", formatExportTime(data.EndedAt))
+	if data.Error != "" {
+		fmt.Fprintf(bw, "Error:    %s\n", data.Error)
+	}
+	fmt.Fprintf(bw, "%s\n", strings.Repeat("-", 60))
+
+	for _, line := range data.Output {
+		fmt.Fprintln(bw, line)
+	}
+
+	return bw.Flush()
+}
+
+// formatExportTime formats a timestamp for the text export, leaving unset times blank
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// fileExportHeader names the columns of a CSV file export
+var fileExportHeader = []string{"filename", "path", "size", "mime_type", "directory", "tags", "created_at"}
+
+// exportFiles streams the files matching the same filters as getFiles as a
+// CSV download, or as JSON lines with format=json
+func (s *Server) exportFiles(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Unsupported format, use 'csv' or 'json'")
+		return
+	}
+
+	repo := s.fileManager.GetFileRepository()
+	dirs, err := repo.ListDirectories(r.Context(), types.DirectoryFilters{})
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	dirNames := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		dirNames[dir.ID] = dir.Name
+	}
+
+	// start sends the headers, and the CSV header row, before the first file
+	// is written, so a failing query can still be reported with a status
+	var start, flush func() error
+	var write func(file *types.File) error
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		start = func() error {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"files.jsonl\"")
+			return nil
+		}
+		write = func(file *types.File) error { return encoder.Encode(file) }
+		flush = func() error { return nil }
+	default:
+		cw := csv.NewWriter(w)
+		start = func() error {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"files.csv\"")
+			return cw.Write(fileExportHeader)
+		}
+		write = func(file *types.File) error {
+			return cw.Write([]string{
+				file.Filename,
+				file.FilePath,
+				strconv.FormatInt(file.FileSize, 10),
+				file.MimeType,
+				dirNames[file.DirectoryID],
+				strings.Join(file.Tags, ";"),
+				file.CreatedAt.Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+
+	started := false
+	err = repo.EachFile(r.Context(), parseFileFilters(r), func(file *types.File) error {
+		if !started {
+			started = true
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return write(file)
+	})
+	if err != nil && !started {
+		writeServiceError(w, err)
+		return
+	}
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		// The export is already under way, all that's left is to cut it short
+		log.Printf("Failed to export files: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/lepinkainen/commander/internal/task"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// Limits on a single import request
+const (
+	maxImportBytes = 64 << 20
+	maxImportTasks = 1000
+)
+
+// ImportTasksResponse reports the outcome of a task import
+type ImportTasksResponse struct {
+	// Imported lists the tasks that were created
+	Imported []ImportedTask `json:"imported"`
+
+	// Skipped lists the IDs of tasks that already existed
+	Skipped []string `json:"skipped"`
+}
+
+// ImportedTask is a task created by an import. SourceID is the ID in the
+// export, set only when IDs were regenerated.
+type ImportedTask struct {
+	ID       string `json:"id"`
+	SourceID string `json:"source_id,omitempty"`
+}
+
+// importTasks recreates tasks from a JSON export, an array of tasks as
+// returned by GET /api/tasks. Tasks keep their ID unless ?regenerate_ids=true,
+// and tasks whose ID already exists are skipped.
+func (s *Server) importTasks(w http.ResponseWriter, r *http.Request) {
+	regenerate := false
+	if value := r.URL.Query().Get("regenerate_ids"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'regenerate_ids' must be a boolean")
+			return
+		}
+		regenerate = parsed
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	var tasks []types.TaskData
+	if err := decoder.Decode(&tasks); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("Import is larger than %d bytes", int64(maxImportBytes)))
+			return
+		}
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "Unexpected data after the task array")
+		return
+	}
+	if len(tasks) == 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "No tasks to import")
+		return
+	}
+	if len(tasks) > maxImportTasks {
+		writeError(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("At most %d tasks can be imported at once", maxImportTasks))
+		return
+	}
+	if fields := validateImport(tasks, regenerate); len(fields) > 0 {
+		writeFieldErrors(w, fields)
+		return
+	}
+
+	resp := ImportTasksResponse{Imported: []ImportedTask{}, Skipped: []string{}}
+	for _, data := range tasks {
+		imported := ImportedTask{ID: data.ID}
+		if regenerate {
+			imported = ImportedTask{ID: uuid.New().String(), SourceID: data.ID}
+			data.ID = imported.ID
+		}
+		// Files aren't part of an export, and the queue wait and position
+		// are derived
+		data.AssociatedFiles = nil
+		data.QueueWaitMs = 0
+		data.QueuePosition = 0
+
+		err := s.manager.ImportTask(r.Context(), data)
+		if errors.Is(err, task.ErrTaskExists) {
+			resp.Skipped = append(resp.Skipped, data.ID)
+			continue
+		}
+		if err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		resp.Imported = append(resp.Imported, imported)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// validateImport checks every imported task, returning one error per invalid
+// field. Only finished tasks can be imported, a queued or running task would
+// never be picked up by a worker.
+func validateImport(tasks []types.TaskData, regenerate bool) []FieldError {
+	var fields []FieldError
+	for i, data := range tasks {
+		prefix := fmt.Sprintf("tasks[%d].", i)
+		if !regenerate {
+			if data.ID == "" {
+				fields = append(fields, FieldError{Field: prefix + "id", Message: fmt.Sprintf("task %d: id is required unless regenerate_ids is set", i)})
+			} else if _, err := uuid.Parse(data.ID); err != nil {
+				fields = append(fields, FieldError{Field: prefix + "id", Message: fmt.Sprintf("task %d: id must be a UUID", i)})
+			}
+		}
+		if data.Tool == "" {
+			fields = append(fields, FieldError{Field: prefix + "tool", Message: fmt.Sprintf("task %d: tool is required", i)})
+		}
+		if data.Command == "" {
+			fields = append(fields, FieldError{Field: prefix + "command", Message: fmt.Sprintf("task %d: command is required", i)})
+		}
+		switch data.Status {
+		case types.StatusComplete, types.StatusFailed, types.StatusCanceled:
+		default:
+			fields = append(fields, FieldError{Field: prefix + "status", Message: fmt.Sprintf("task %d: status must be complete, failed or canceled", i)})
+		}
+		if data.CreatedAt.IsZero() {
+			fields = append(fields, FieldError{Field: prefix + "created_at", Message: fmt.Sprintf("task %d: created_at is required", i)})
+		}
+		if data.TimeoutSeconds < 0 {
+			fields = append(fields, FieldError{Field: prefix + "timeout_seconds", Message: fmt.Sprintf("task %d: timeout_seconds must not be negative", i)})
+		}
+	}
+	return fields
+}
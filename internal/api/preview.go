@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// sniffLength is how much of a file is read to guess its type, all that
+// http.DetectContentType looks at
+const sniffLength = 512
+
+// scriptableTypes are the types a browser would run scripts from when
+// rendering them. Served inline from the API's origin they could read the
+// API key from the page, so they are shown as plain text instead.
+var scriptableTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+	"text/xml":              true,
+	"application/xml":       true,
+	"text/xsl":              true,
+}
+
+// parseDisposition reports whether a file download should be shown inline
+// by the browser, ?disposition=inline, rather than saved as an attachment
+func parseDisposition(query url.Values) (inline bool, err error) {
+	switch query.Get("disposition") {
+	case "", "attachment":
+		return false, nil
+	case "inline":
+		return true, nil
+	}
+	return false, errors.New("'disposition' must be 'attachment' or 'inline'")
+}
+
+// inlineContentType returns the type to serve a file inline with: the
+// stored type, or the one sniffed from content when nothing more specific
+// than application/octet-stream is stored. Types a browser could run
+// scripts from are replaced with text/plain. content is left at its start.
+func inlineContentType(stored string, content io.ReadSeeker) (string, error) {
+	contentType := stored
+	if mediaType, _, err := mime.ParseMediaType(stored); err != nil || mediaType == "application/octet-stream" {
+		buf := make([]byte, sniffLength)
+		n, err := io.ReadFull(content, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", err
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		contentType = http.DetectContentType(buf[:n])
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || scriptableTypes[mediaType] {
+		return "text/plain; charset=utf-8", nil
+	}
+	return contentType, nil
+}
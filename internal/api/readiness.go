@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lepinkainen/commander/internal/files"
+)
+
+// ReadinessResponse is returned by /readyz
+type ReadinessResponse struct {
+	Status   string            `json:"status"`
+	ReadOnly bool              `json:"read_only"`
+	Disk     *files.DiskStatus `json:"disk,omitempty"`
+}
+
+// SetDiskMonitor makes readiness depend on the free space it reports
+func (s *Server) SetDiskMonitor(monitor *files.DiskMonitor) {
+	s.diskMonitor = monitor
+}
+
+// readyz reports whether the server should receive traffic. It fails with
+// 503 while the downloads file system is short on space, so new downloads
+// go elsewhere instead of failing halfway. Read-only mode is reported but
+// doesn't fail it.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	response := ReadinessResponse{Status: "ready", ReadOnly: s.ReadOnly()}
+	status := http.StatusOK
+	if response.ReadOnly {
+		// Reads are still served, so the server stays in rotation
+		response.Status = "read_only"
+	}
+	if s.diskMonitor != nil {
+		disk := s.diskMonitor.Status()
+		response.Disk = &disk
+		if !disk.Ready {
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lepinkainen/commander/internal/events"
+)
+
+// EventReadOnly is published on events.TopicSystem as a ReadOnlyEvent when
+// read-only mode is switched on or off
+const EventReadOnly = "read_only"
+
+// ReadOnlyEvent announces a change of read-only mode
+type ReadOnlyEvent struct {
+	Type     string `json:"type"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// ReadOnlyRequest switches read-only mode
+type ReadOnlyRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// ReadOnlyResponse reports whether read-only mode is on
+type ReadOnlyResponse struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// readOnlyExempt lists the mutating routes that keep working in read-only
+// mode: the admin routes, so backups can be taken and the mode switched off
+// again, and previews, which don't change anything
+var readOnlyExempt = []string{"/api/admin/", "/api/tasks/preview"}
+
+// SetReadOnly switches read-only mode. While it is on, requests that could
+// change state fail with 503 and the executor stops picking up queued
+// tasks, letting running ones finish.
+func (s *Server) SetReadOnly(enabled bool) {
+	if s.readOnly.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		s.executor.Pause()
+		log.Printf("Read-only mode enabled")
+	} else {
+		s.executor.Resume()
+		log.Printf("Read-only mode disabled")
+	}
+	s.bus.Publish(events.TopicSystem, ReadOnlyEvent{Type: EventReadOnly, ReadOnly: enabled})
+}
+
+// ReadOnly reports whether read-only mode is on
+func (s *Server) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// rejectWhenReadOnly fails every request that may change state with 503
+// while read-only mode is on. Reads, downloads included, are served as
+// usual.
+func (s *Server) rejectWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ReadOnly() || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				path = template
+			}
+		}
+		for _, exempt := range readOnlyExempt {
+			if strings.HasPrefix(path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		writeError(w, http.StatusServiceUnavailable, CodeReadOnly, "The server is in read-only mode for maintenance, try again later")
+	})
+}
+
+// getReadOnly reports whether read-only mode is on
+func (s *Server) getReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ReadOnlyResponse{ReadOnly: s.ReadOnly()}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// setReadOnly switches read-only mode on or off
+func (s *Server) setReadOnly(w http.ResponseWriter, r *http.Request) {
+	var req ReadOnlyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if req.Enabled == nil {
+		writeFieldErrors(w, []FieldError{{Field: "enabled", Message: "enabled is required"}})
+		return
+	}
+
+	s.SetReadOnly(*req.Enabled)
+	s.getReadOnly(w, r)
+}
@@ -1,19 +1,29 @@
 package api
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/lepinkainen/commander/internal/events"
 	"github.com/lepinkainen/commander/internal/executor"
 	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/task"
 	"github.com/lepinkainen/commander/internal/types"
 	"github.com/rs/cors"
@@ -24,17 +34,54 @@ type Server struct {
 	manager     *task.Manager
 	executor    *executor.Executor
 	fileManager *files.Manager
+	bus         *events.Bus
 	upgrader    websocket.Upgrader
 	staticFiles *embed.FS
+	buildInfo   BuildInfo
+	apiKey      string
+	shareKey    []byte
+	maintainer  storage.Maintainer
+	auditLog    storage.AuditLog
+	flags       map[string]string
+	dbPath      string
+	uploader    *files.Uploader
+	diskMonitor *files.DiskMonitor
+	wsQueue     events.SubscribeOptions
+
+	downloadIdleTimeout time.Duration
+
+	allowCommandOverride    bool
+	serveOutsideDirectories bool
+
+	// See SetReadOnly
+	readOnly atomic.Bool
 }
 
+// DefaultDownloadIdleTimeout is how long a file download may make no
+// progress before the connection is dropped
+const DefaultDownloadIdleTimeout = time.Minute
+
+// Defaults for the per-connection WebSocket send queue
+const (
+	DefaultWebSocketMaxMissed = 1000
+	wsWriteTimeout            = 10 * time.Second
+)
+
 // NewServer creates a new API server
 func NewServer(manager *task.Manager, exec *executor.Executor, fileManager *files.Manager, staticFiles *embed.FS) *Server {
 	return &Server{
-		manager:     manager,
-		executor:    exec,
-		fileManager: fileManager,
-		staticFiles: staticFiles,
+		manager:             manager,
+		executor:            exec,
+		fileManager:         fileManager,
+		bus:                 manager.EventBus(),
+		staticFiles:         staticFiles,
+		buildInfo:           NewBuildInfo("dev", "unknown", "unknown"),
+		downloadIdleTimeout: DefaultDownloadIdleTimeout,
+		wsQueue: events.SubscribeOptions{
+			BufferSize: events.DefaultBufferSize,
+			Policy:     events.DropOldest,
+			MaxMissed:  DefaultWebSocketMaxMissed,
+		},
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				// Allow all origins in development
@@ -51,39 +98,86 @@ func (s *Server) Router() http.Handler {
 
 	// API routes
 	api := router.PathPrefix("/api").Subrouter()
+	api.Use(s.auditRequests)
+	api.Use(s.rejectWhenReadOnly)
 	api.HandleFunc("/tasks", s.createTask).Methods("POST")
 	api.HandleFunc("/tasks", s.getTasks).Methods("GET")
+	api.HandleFunc("/tasks/search", s.searchTasks).Methods("GET")
+	api.HandleFunc("/tasks/preview", s.previewTask).Methods("POST")
+	api.Handle("/tasks/import", s.requireAuth(http.HandlerFunc(s.importTasks))).Methods("POST")
 	api.HandleFunc("/tasks/{id}", s.getTask).Methods("GET")
+	api.HandleFunc("/tasks/{id}", s.updateTask).Methods("PATCH")
 	api.HandleFunc("/tasks/{id}/cancel", s.cancelTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}/export", s.exportTask).Methods("GET")
+	api.HandleFunc("/tasks/{id}/output", s.getTaskOutput).Methods("GET")
+	api.HandleFunc("/tasks/{id}/output/search", s.searchTaskOutput).Methods("GET")
 	api.HandleFunc("/tools", s.getTools).Methods("GET")
+	api.HandleFunc("/tools/{name}/stats", s.getToolStats).Methods("GET")
+	api.HandleFunc("/tools/{name}/failures", s.getToolFailures).Methods("GET")
+	api.HandleFunc("/tools/{name}/requeue-failed", s.requeueFailed).Methods("POST")
+	api.HandleFunc("/tools/{name}/workers", s.setToolWorkers).Methods("POST")
 	api.HandleFunc("/stats", s.getStats).Methods("GET")
+	api.HandleFunc("/config", s.getConfig).Methods("GET")
+	api.HandleFunc("/version", s.getVersion).Methods("GET")
 	api.HandleFunc("/ws", s.handleWebSocket)
 
 	// File management routes
 	api.HandleFunc("/directories", s.getDirectories).Methods("GET")
 	api.HandleFunc("/directories", s.createDirectory).Methods("POST")
+	api.HandleFunc("/directories/scan-all", s.scanAllDirectories).Methods("POST")
 	api.HandleFunc("/directories/{id}", s.getDirectory).Methods("GET")
 	api.HandleFunc("/directories/{id}", s.updateDirectory).Methods("PUT")
 	api.HandleFunc("/directories/{id}", s.deleteDirectory).Methods("DELETE")
 	api.HandleFunc("/directories/{id}/scan", s.scanDirectory).Methods("POST")
+	api.HandleFunc("/directories/{id}/move-all", s.moveAllFiles).Methods("POST")
+	api.HandleFunc("/directories/{id}/usage", s.getDirectoryUsage).Methods("GET")
 	api.HandleFunc("/directories/{id}/files", s.getDirectoryFiles).Methods("GET")
+	api.HandleFunc("/directories/{id}/duplicates", s.getDuplicates).Methods("GET")
 
 	api.HandleFunc("/files", s.getFiles).Methods("GET")
 	api.HandleFunc("/files/search", s.searchFiles).Methods("GET")
+	api.HandleFunc("/files/duplicates", s.getDuplicates).Methods("GET")
+	api.HandleFunc("/files/export", s.exportFiles).Methods("GET")
 	api.HandleFunc("/files/{id}", s.getFile).Methods("GET")
 	api.HandleFunc("/files/{id}", s.deleteFile).Methods("DELETE")
 	api.HandleFunc("/files/{id}/download", s.downloadFile).Methods("GET")
+	api.HandleFunc("/files/{id}/share", s.shareFile).Methods("POST")
+	api.HandleFunc("/shared/{token}", s.downloadSharedFile).Methods("GET")
+	api.HandleFunc("/files/{id}/task", s.getFileTask).Methods("GET")
 	api.HandleFunc("/files/{id}/move", s.moveFile).Methods("POST")
-	api.HandleFunc("/files/{id}/tags", s.updateFileTags).Methods("POST")
+	api.HandleFunc("/files/{id}/copy", s.copyFile).Methods("POST")
+	api.HandleFunc("/files/{id}/tags", s.updateFileTags).Methods("POST", "PUT")
+	api.HandleFunc("/files/{id}/tags/add", s.addFileTags).Methods("POST")
 
 	// Bulk operations
 	api.HandleFunc("/files/bulk/delete", s.bulkDeleteFiles).Methods("POST")
 	api.HandleFunc("/files/bulk/move", s.bulkMoveFiles).Methods("POST")
 	api.HandleFunc("/files/bulk/tag", s.bulkTagFiles).Methods("POST")
+	api.HandleFunc("/files/bulk/untag", s.bulkUntagFiles).Methods("POST")
+
+	// Uploads
+	api.HandleFunc("/uploads", s.uploadFile).Methods("POST")
+	api.HandleFunc("/uploads/sessions", s.createUploadSession).Methods("POST")
+	api.HandleFunc("/uploads/sessions/{id}", s.getUploadSession).Methods("GET")
+	api.HandleFunc("/uploads/sessions/{id}", s.uploadChunk).Methods("PATCH")
 
 	// Task-file relationships
 	api.HandleFunc("/tasks/{id}/files", s.getTaskFiles).Methods("GET")
 
+	// Admin routes require the API key
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(s.requireAuth)
+	admin.HandleFunc("/backup", s.backupDatabase).Methods("POST")
+	admin.HandleFunc("/vacuum", s.vacuumDatabase).Methods("POST")
+	admin.HandleFunc("/audit", s.getAuditLog).Methods("GET")
+	admin.HandleFunc("/config", s.getAdminConfig).Methods("GET")
+	admin.HandleFunc("/readonly", s.getReadOnly).Methods("GET")
+	admin.HandleFunc("/readonly", s.setReadOnly).Methods("POST")
+
+	// Readiness for load balancers and orchestrators, registered before the
+	// static files catch everything else
+	router.HandleFunc("/readyz", s.readyz).Methods("GET")
+
 	// Static files - use embedded files if available, fallback to filesystem
 	if s.staticFiles != nil {
 		staticFS, err := fs.Sub(*s.staticFiles, "static")
@@ -101,7 +195,7 @@ func (s *Server) Router() http.Handler {
 	// Add CORS middleware
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
@@ -114,116 +208,1136 @@ type CreateTaskRequest struct {
 	Tool    string   `json:"tool"`
 	Command string   `json:"command"`
 	Args    []string `json:"args"`
+
+	// Options are flags with values, flattened before Args, which then hold
+	// the positional arguments. An empty value makes a bare flag.
+	Options map[string]string `json:"options,omitempty"`
+
+	// Organize overrides the tool's organize setting when present
+	Organize *bool `json:"organize,omitempty"`
+
+	// Tags are applied to the task's discovered files in addition to the
+	// tool's default tags
+	Tags []string `json:"tags,omitempty"`
+
+	// ExpectedOutput lists the files the task will write. They are
+	// registered directly instead of discovered, and the task fails if any
+	// is missing.
+	ExpectedOutput []string `json:"expected_output,omitempty"`
+
+	// TimeoutSeconds kills the task after running this long. The tool's
+	// max_runtime_seconds still applies when it is smaller.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// Notes annotate the task and don't affect how it runs
+	Notes string `json:"notes,omitempty"`
+}
+
+// Limits applied to task arguments
+const (
+	maxTaskArgs        = 256
+	maxTaskArgLength   = 4096
+	maxTaskTags        = 32
+	maxTaskOutputs     = 64
+	maxTaskNotesLength = 4096
+)
+
+// validateCreateTaskRequest normalizes a task creation request in place and
+// returns a descriptive error for the first rule it violates
+func (s *Server) validateCreateTaskRequest(ctx context.Context, req *CreateTaskRequest) error {
+	req.Tool = strings.TrimSpace(req.Tool)
+	if req.Tool == "" {
+		return errors.New("tool is required")
+	}
+
+	tool, err := s.executor.CheckTool(req.Tool)
+	if err != nil {
+		return err
+	}
+
+	// Use tool's command if not specified
+	req.Command = strings.TrimSpace(req.Command)
+	if req.Command == "" {
+		req.Command = tool.Command
+	} else if req.Command != tool.Command && !s.allowCommandOverride {
+		return fmt.Errorf("command %q does not match the configured command for %s", req.Command, req.Tool)
+	}
+
+	args, err := normalizeTaskArgs(req.Args, req.Options)
+	if err != nil {
+		return err
+	}
+	req.Args = args
+	if n := len(req.argv()); n > maxTaskArgs {
+		return fmt.Errorf("too many arguments: %d (maximum %d)", n, maxTaskArgs)
+	}
+
+	// Combine the tool's default tags with the requested ones
+	if len(req.Tags) > maxTaskTags {
+		return fmt.Errorf("too many tags: %d (maximum %d)", len(req.Tags), maxTaskTags)
+	}
+	req.Tags = files.NormalizeTags(append(append([]string{}, tool.DefaultTags...), req.Tags...))
+
+	// Clean expected output paths and drop empty ones
+	if len(req.ExpectedOutput) > maxTaskOutputs {
+		return fmt.Errorf("too many expected outputs: %d (maximum %d)", len(req.ExpectedOutput), maxTaskOutputs)
+	}
+	outputs := make([]string, 0, len(req.ExpectedOutput))
+	for _, path := range req.ExpectedOutput {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if len(path) > maxTaskArgLength {
+			return fmt.Errorf("expected output %d exceeds the maximum length of %d characters", len(outputs)+1, maxTaskArgLength)
+		}
+		outputs = append(outputs, filepath.Clean(path))
+	}
+	req.ExpectedOutput = outputs
+	if len(req.ExpectedOutput) > 0 {
+		dirs, err := s.fileManager.ToolDirectories(ctx, req.Tool)
+		if err != nil {
+			return err
+		}
+		if err := s.checkExpectedOutput(req.ExpectedOutput, dirs, executor.OutputDir(tool, req.argv())); err != nil {
+			return err
+		}
+	}
+
+	if err := validateTimeout(req.TimeoutSeconds); err != nil {
+		return err
+	}
+
+	notes, err := validateTaskNotes(req.Notes)
+	if err != nil {
+		return err
+	}
+	req.Notes = notes
+
+	if req.Organize == nil {
+		organize := tool.Organize
+		req.Organize = &organize
+	}
+
+	return nil
+}
+
+// normalizeTaskArgs trims arguments, drops empty ones and checks the
+// arguments and options against the length limits
+func normalizeTaskArgs(args []string, options map[string]string) ([]string, error) {
+	normalized := make([]string, 0, len(args))
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		if len(arg) > maxTaskArgLength {
+			return nil, fmt.Errorf("argument %d exceeds the maximum length of %d characters", len(normalized)+1, maxTaskArgLength)
+		}
+		normalized = append(normalized, arg)
+	}
+
+	for key, value := range options {
+		if err := task.ValidateOptionKey(key); err != nil {
+			return nil, err
+		}
+		if len(value) > maxTaskArgLength {
+			return nil, fmt.Errorf("option %q exceeds the maximum length of %d characters", key, maxTaskArgLength)
+		}
+	}
+	return normalized, nil
+}
+
+// checkExpectedOutput checks that every expected output lies inside one of
+// the tool's directories, toolDirs, or the task's output directory, and
+// inside the allowed roots. The files are moved into the library when the
+// task organizes them, so any other path would let a request take over
+// arbitrary files.
+func (s *Server) checkExpectedOutput(outputs, toolDirs []string, outputDir string) error {
+	dirs := toolDirs
+	if outputDir != "" {
+		dirs = append(append([]string{}, toolDirs...), outputDir)
+	}
+	for i, path := range outputs {
+		err := files.CheckInDirectories(path, dirs)
+		if err == nil {
+			err = s.fileManager.CheckPath(path)
+		}
+		if err != nil {
+			return fieldError{FieldError{
+				Field:   fmt.Sprintf("expected_output[%d]", i),
+				Message: fmt.Sprintf("expected output must be inside the tool's directory or the task's output directory: %v", err),
+			}}
+		}
+	}
+	return nil
+}
+
+// validateTimeout checks a task's timeout_seconds
+func validateTimeout(seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("timeout_seconds must not be negative, got %d", seconds)
+	}
+	return nil
+}
+
+// validateTaskNotes trims notes and checks their length
+func validateTaskNotes(notes string) (string, error) {
+	notes = strings.TrimSpace(notes)
+	if len(notes) > maxTaskNotesLength {
+		return "", fmt.Errorf("notes exceed the maximum length of %d characters", maxTaskNotesLength)
+	}
+	return notes, nil
+}
+
+// argv returns the arguments the requested task runs with, its options
+// flattened in front of the positional arguments
+func (req *CreateTaskRequest) argv() []string {
+	if len(req.Options) == 0 {
+		return req.Args
+	}
+	return task.FlattenOptions(req.Options, req.Args)
+}
+
+// writeCreateTaskError reports an invalid task creation request. Requests
+// for a tool that can't be used list the tools that can.
+func (s *Server) writeCreateTaskError(w http.ResponseWriter, err error) {
+	if errors.Is(err, executor.ErrUnknownTool) || errors.Is(err, executor.ErrCommandMissing) || errors.Is(err, executor.ErrVersionTooOld) {
+		writeErrorDetail(w, http.StatusBadRequest, ErrorDetail{
+			Code:           CodeToolUnavailable,
+			Message:        err.Error(),
+			AvailableTools: s.executor.AvailableTools(),
+		})
+		return
+	}
+	var field fieldError
+	if errors.As(err, &field) {
+		writeFieldErrors(w, []FieldError{field.FieldError})
+		return
+	}
+	writeError(w, http.StatusBadRequest, CodeValidation, err.Error())
+}
+
+// SetAllowCommandOverride controls whether task requests may run a command
+// other than the one configured for their tool
+func (s *Server) SetAllowCommandOverride(allow bool) {
+	s.allowCommandOverride = allow
+}
+
+// SetServeOutsideDirectories controls whether files whose records point
+// outside every registered directory may still be downloaded. They are
+// refused by default.
+func (s *Server) SetServeOutsideDirectories(allow bool) {
+	s.serveOutsideDirectories = allow
+}
+
+// createTask handles task creation
+func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	if err := s.validateCreateTaskRequest(r.Context(), &req); err != nil {
+		s.writeCreateTaskError(w, err)
+		return
+	}
+
+	// Create task
+	newTask := task.NewTask(req.Tool, req.Command, req.argv())
+	if len(req.Options) > 0 {
+		newTask.Options = req.Options
+		newTask.PositionalArgs = req.Args
+	}
+	newTask.Organize = *req.Organize
+	if len(req.Tags) > 0 {
+		newTask.Tags = req.Tags
+	}
+	if len(req.ExpectedOutput) > 0 {
+		newTask.ExpectedOutput = req.ExpectedOutput
+	}
+	newTask.TimeoutSeconds = req.TimeoutSeconds
+	newTask.Notes = req.Notes
+
+	// Add to manager
+	if err := s.manager.AddTask(r.Context(), newTask); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newTask); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// PreviewTaskResponse describes what a task creation request would run
+type PreviewTaskResponse struct {
+	Tool           string   `json:"tool"`
+	Command        string   `json:"command"`
+	Argv           []string `json:"argv"`
+	CommandLine    string   `json:"command_line"`
+	Organize       bool     `json:"organize"`
+	Tags           []string `json:"tags"`
+	ExpectedOutput []string `json:"expected_output"`
+}
+
+// previewTask validates a task creation request and returns the command it
+// would run, without creating the task
+func (s *Server) previewTask(w http.ResponseWriter, r *http.Request) {
+	var req CreateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	if err := s.validateCreateTaskRequest(r.Context(), &req); err != nil {
+		s.writeCreateTaskError(w, err)
+		return
+	}
+
+	tool, _ := s.executor.GetTool(req.Tool)
+	argv := append([]string{req.Command}, executor.CommandArgs(tool, req.argv())...)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(PreviewTaskResponse{
+		Tool:           req.Tool,
+		Command:        req.Command,
+		Argv:           argv,
+		CommandLine:    shellQuote(argv),
+		Organize:       *req.Organize,
+		Tags:           req.Tags,
+		ExpectedOutput: req.ExpectedOutput,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// shellQuote joins argv into a line that can be pasted into a POSIX shell.
+// Commands are never run through a shell; this is for display only.
+func shellQuote(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		if arg != "" && strings.IndexFunc(arg, needsQuoting) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// needsQuoting reports whether r has a special meaning to the shell
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case strings.ContainsRune("-_./:=,+@%", r):
+		return false
+	}
+	return true
+}
+
+// getTasks returns the tasks matching the query's filters with their output
+func (s *Server) getTasks(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseTaskFilters(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter "+err.Error())
+		return
+	}
+	filters.WithOutput = true
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "jsonl":
+		s.streamTasks(w, r, filters)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'format' must be 'json' or 'jsonl'")
+		return
+	}
+
+	tasks, err := s.manager.ListTasks(r.Context(), filters)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// streamTasks writes tasks as JSON lines, one task per line as it is read
+// from the database, so large histories aren't buffered in memory
+func (s *Server) streamTasks(w http.ResponseWriter, r *http.Request, filters types.TaskFilters) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	started := false
+	err := s.manager.EachTask(r.Context(), filters, func(t *task.Task) error {
+		started = true
+		return encoder.Encode(t)
+	})
+	if err == nil {
+		return
+	}
+	if !started {
+		writeServiceError(w, err)
+		return
+	}
+	// The status has already been sent, so the error can only end the stream
+	log.Printf("Failed to stream tasks: %v", err)
+}
+
+// parseTaskFilters builds task list filters from query parameters. The
+// window start is either relative, since=24h meaning tasks created in the
+// last 24 hours before now, or absolute with from=<RFC3339>. When both are
+// given since wins. until=<RFC3339> ends the window.
+func parseTaskFilters(query url.Values, now time.Time) (types.TaskFilters, error) {
+	filters := types.TaskFilters{
+		Tool:  query.Get("tool"),
+		Host:  query.Get("host"),
+		Query: strings.TrimSpace(query.Get("q")),
+	}
+
+	if v := query.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			switch status := types.Status(strings.TrimSpace(status)); status {
+			case types.StatusQueued, types.StatusRunning, types.StatusComplete, types.StatusFailed, types.StatusCanceled:
+				filters.Statuses = append(filters.Statuses, status)
+			default:
+				return filters, errors.New("'status' must be a comma-separated list of queued, running, complete, failed or canceled")
+			}
+		}
+	}
+	if v := query.Get("output"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return filters, errors.New("'output' must be a boolean")
+		}
+		filters.QueryOutput = parsed
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, errors.New("'from' must be an RFC3339 timestamp")
+		}
+		filters.CreatedFrom = &from
+	}
+	if v := query.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return filters, errors.New("'since' must be a positive duration such as 24h or 90m")
+		}
+		from := now.Add(-d)
+		filters.CreatedFrom = &from
+	}
+	if v := query.Get("until"); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filters, errors.New("'until' must be an RFC3339 timestamp")
+		}
+		filters.CreatedTo = &until
+	}
+	if filters.CreatedFrom != nil && filters.CreatedTo != nil && !filters.CreatedFrom.Before(*filters.CreatedTo) {
+		return filters, errors.New("'until' must be after the window start")
+	}
+
+	switch order := types.TaskSort(query.Get("sort")); order {
+	case "", types.TaskSortNewest, types.TaskSortOldest:
+		filters.Sort = order
+	default:
+		return filters, errors.New("'sort' must be 'newest' or 'oldest'")
+	}
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			return filters, errors.New("'offset' must be a non-negative integer")
+		}
+		filters.Offset = parsed
+	}
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			return filters, errors.New("'limit' must be a positive integer")
+		}
+		filters.Limit = parsed
+	}
+
+	return filters, nil
+}
+
+// Limits for task search results
+const (
+	defaultTaskSearchLimit = 50
+	maxTaskSearchLimit     = 500
+)
+
+// searchTasks finds tasks by tool, command, arguments, notes and optionally
+// output. It takes the same filters as getTasks but requires a query, and
+// returns at most maxTaskSearchLimit tasks without their output.
+func (s *Server) searchTasks(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseTaskFilters(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter "+err.Error())
+		return
+	}
+	if filters.Query == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'q' is required")
+		return
+	}
+	if filters.Limit == 0 {
+		filters.Limit = defaultTaskSearchLimit
+	}
+	filters.Limit = min(filters.Limit, maxTaskSearchLimit)
+
+	tasks, err := s.manager.ListTasks(r.Context(), filters)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tasks); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// getTask returns a specific task
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID := vars["id"]
+
+	taskData, err := s.manager.GetTask(r.Context(), taskID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(taskData); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// UpdateTaskRequest holds the task fields to change. Fields left out are
+// kept.
+type UpdateTaskRequest struct {
+	// Notes can be changed in any status
+	Notes *string `json:"notes,omitempty"`
+
+	// Args, Options and TimeoutSeconds change how the task runs, so they
+	// can only be changed while it is queued. Args and Options replace the
+	// positional arguments and options the task was created with. There is
+	// no output directory to edit apart from them: tasks write where their
+	// tool's output_dir_arg in the arguments, or its output_dir, says.
+	Args           []string          `json:"args,omitempty"`
+	Options        map[string]string `json:"options,omitempty"`
+	TimeoutSeconds *int              `json:"timeout_seconds,omitempty"`
+}
+
+// editsQueuedFields reports whether the request changes fields that can
+// only be changed while the task is queued
+func (req *UpdateTaskRequest) editsQueuedFields() bool {
+	return req.Args != nil || req.Options != nil || req.TimeoutSeconds != nil
+}
+
+// updateTask edits a task's notes, and while it is still queued how it
+// runs. Edits to the arguments of a task that is running or finished are
+// rejected with 409.
+func (s *Server) updateTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID := vars["id"]
+
+	var req UpdateTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if req.Notes == nil && !req.editsQueuedFields() {
+		writeError(w, http.StatusBadRequest, CodeValidation, "No fields to update")
+		return
+	}
+
+	if req.Notes != nil {
+		notes, err := validateTaskNotes(*req.Notes)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, err.Error())
+			return
+		}
+		req.Notes = &notes
+	}
+	if req.TimeoutSeconds != nil {
+		if err := validateTimeout(*req.TimeoutSeconds); err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, err.Error())
+			return
+		}
+	}
+	if req.Args != nil || req.Options != nil {
+		args, err := normalizeTaskArgs(req.Args, req.Options)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, err.Error())
+			return
+		}
+		if req.Args != nil {
+			req.Args = args
+		}
+	}
+
+	var updated *task.Task
+	var err error
+	if req.editsQueuedFields() {
+		// New arguments may change the task's output directory, so its
+		// expected output is checked again as on creation
+		checkArgs := func(*types.TaskData, []string) error { return nil }
+		if req.Args != nil || req.Options != nil {
+			checkArgs, err = s.expectedOutputCheck(r.Context(), taskID)
+			if err != nil {
+				writeServiceError(w, err)
+				return
+			}
+		}
+
+		// The argument count depends on what the request keeps, so it is
+		// checked against the task as it is when the edit applies
+		var invalid error
+		updated, err = s.manager.EditQueuedTask(r.Context(), taskID, func(data *types.TaskData) error {
+			invalid = applyTaskEdit(data, &req, checkArgs)
+			return invalid
+		})
+		var field fieldError
+		if errors.As(invalid, &field) {
+			writeFieldErrors(w, []FieldError{field.FieldError})
+			return
+		}
+		if invalid != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, invalid.Error())
+			return
+		}
+	} else {
+		updated, err = s.manager.SetTaskNotes(r.Context(), taskID, *req.Notes)
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// expectedOutputCheck returns a function checking a task's expected output
+// against the output directory of new arguments, see checkExpectedOutput.
+// The tool's directories are looked up once, before the task is locked for
+// the edit.
+func (s *Server) expectedOutputCheck(ctx context.Context, taskID string) (func(*types.TaskData, []string) error, error) {
+	current, err := s.manager.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	data := current.Clone()
+	if len(data.ExpectedOutput) == 0 {
+		return func(*types.TaskData, []string) error { return nil }, nil
+	}
+	toolDirs, err := s.fileManager.ToolDirectories(ctx, data.Tool)
+	if err != nil {
+		return nil, err
+	}
+	return func(data *types.TaskData, args []string) error {
+		tool, _ := s.executor.GetTool(data.Tool)
+		return s.checkExpectedOutput(data.ExpectedOutput, toolDirs, executor.OutputDir(tool, args))
+	}, nil
+}
+
+// applyTaskEdit changes a queued task's data as requested, checking new
+// arguments with checkArgs. It leaves data unchanged when the edit is
+// invalid.
+func applyTaskEdit(data *types.TaskData, req *UpdateTaskRequest, checkArgs func(data *types.TaskData, args []string) error) error {
+	if req.Args != nil || req.Options != nil {
+		// Tasks created without options run their positional arguments as is
+		positional, options := data.PositionalArgs, data.Options
+		if options == nil {
+			positional = data.Args
+		}
+		if req.Args != nil {
+			positional = req.Args
+		}
+		if req.Options != nil {
+			options = req.Options
+		}
+
+		args := positional
+		if len(options) > 0 {
+			args = task.FlattenOptions(options, positional)
+		} else {
+			positional, options = nil, nil
+		}
+		if len(args) > maxTaskArgs {
+			return fmt.Errorf("too many arguments: %d (maximum %d)", len(args), maxTaskArgs)
+		}
+		if err := checkArgs(data, args); err != nil {
+			return err
+		}
+		data.Args, data.Options, data.PositionalArgs = args, options, positional
+	}
+
+	if req.TimeoutSeconds != nil {
+		data.TimeoutSeconds = *req.TimeoutSeconds
+	}
+	if req.Notes != nil {
+		data.Notes = *req.Notes
+	}
+	return nil
+}
+
+// Limits for task output pages
+const (
+	defaultOutputLimit = 1000
+	maxOutputLimit     = 10000
+)
+
+// getTaskOutput returns a range of a task's output lines, so long logs can
+// be loaded from the tail upward
+func (s *Server) getTaskOutput(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID := vars["id"]
+	query := r.URL.Query()
+
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'offset' must be an integer")
+			return
+		}
+		offset = parsed
+	}
+
+	limit := defaultOutputLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'limit' must be a positive integer")
+			return
+		}
+		limit = min(parsed, maxOutputLimit)
+	}
+
+	stream := types.Stream(query.Get("stream"))
+	if stream != "" && stream != types.StreamStdout && stream != types.StreamStderr {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'stream' must be 'stdout' or 'stderr'")
+		return
+	}
+
+	page, err := s.manager.GetTaskOutput(r.Context(), taskID, stream, offset, limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// Limits for matches returned by a task output search
+const (
+	defaultOutputSearchLimit = 100
+	maxOutputSearchLimit     = 1000
+)
+
+// searchTaskOutput finds lines in a task's output, so a long log can be
+// searched without loading all of it. Matching ignores case unless
+// case_sensitive is set, and q is a regular expression when regex is set.
+func (s *Server) searchTaskOutput(w http.ResponseWriter, r *http.Request) {
+	taskID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	search := types.OutputSearch{Query: query.Get("q"), Limit: defaultOutputSearchLimit}
+	if search.Query == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'q' is required")
+		return
+	}
+
+	if v := query.Get("regex"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'regex' must be a boolean")
+			return
+		}
+		search.Regex = parsed
+	}
+
+	if v := query.Get("case_sensitive"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'case_sensitive' must be a boolean")
+			return
+		}
+		search.CaseSensitive = parsed
+	}
+
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'limit' must be a positive integer")
+			return
+		}
+		search.Limit = min(parsed, maxOutputSearchLimit)
+	}
+
+	result, err := s.manager.SearchTaskOutput(r.Context(), taskID, search)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// CancelTaskRequest represents a task cancellation, the body is optional
+type CancelTaskRequest struct {
+	// Reason notes why the task was canceled, for later review
+	Reason string `json:"reason"`
+}
+
+// maxCancelReasonLength caps the length of a cancellation reason
+const maxCancelReasonLength = 1024
+
+// cancelTask cancels a task
+func (s *Server) cancelTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID := vars["id"]
+
+	var req CancelTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxCancelReasonLength {
+		writeFieldErrors(w, []FieldError{{Field: "reason", Message: fmt.Sprintf("reason exceeds the maximum length of %d characters", maxCancelReasonLength)}})
+		return
+	}
+
+	if err := s.manager.CancelTask(r.Context(), taskID, req.Reason); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "canceled"}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// ToolInfo is a configured tool as listed by GET /api/tools
+type ToolInfo struct {
+	executor.Tool
+
+	// DetectedVersion is the version found on startup, empty when the tool
+	// sets neither min_version nor version_command or detection failed
+	DetectedVersion string `json:"detected_version,omitempty"`
+
+	// Available is false when tasks can't be run with the tool, because
+	// its command is missing or older than required
+	Available bool `json:"available"`
+}
+
+// getTools returns available tools with the number of workers each runs
+func (s *Server) getTools(w http.ResponseWriter, r *http.Request) {
+	configured := s.executor.GetTools()
+	tools := make([]ToolInfo, len(configured))
+	for i, tool := range configured {
+		tool.Workers = s.executor.WorkerCount(tool)
+		_, err := s.executor.CheckTool(tool.Name)
+		tools[i] = ToolInfo{
+			Tool:            tool,
+			DetectedVersion: s.executor.ToolVersion(tool.Name),
+			Available:       err == nil,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tools); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// defaultToolStatsDays is the stats window used when none is requested
+const defaultToolStatsDays = 30
+
+// getToolStats returns historical statistics for a tool. The window is set
+// with ?days=N, where 0 covers the tool's whole history.
+func (s *Server) getToolStats(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !s.executor.IsToolAvailable(name) {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("tool %s not found", name))
+		return
+	}
+
+	days := defaultToolStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'days' must be a non-negative integer")
+			return
+		}
+		days = parsed
+	}
+
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, -days)
+	}
+
+	stats, err := s.manager.GetToolStats(r.Context(), name, since)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// Limits on the failed tasks listed by getToolFailures
+const (
+	defaultToolFailuresLimit = 20
+	maxToolFailuresLimit     = 500
+)
+
+// getToolFailures returns a tool's failure rate over the last days and its
+// most recent failed tasks with their errors
+func (s *Server) getToolFailures(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !s.executor.IsToolAvailable(name) {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("tool %s not found", name))
+		return
+	}
+
+	query := r.URL.Query()
+	days := defaultToolStatsDays
+	if v := query.Get("days"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'days' must be a non-negative integer")
+			return
+		}
+		days = parsed
+	}
+	limit := defaultToolFailuresLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > maxToolFailuresLimit {
+			writeError(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("Query parameter 'limit' must be between 1 and %d", maxToolFailuresLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	var since time.Time
+	if days > 0 {
+		since = time.Now().AddDate(0, 0, -days)
+	}
+
+	failures, err := s.manager.GetToolFailures(r.Context(), name, since, limit)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(failures); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// RequeueFailedResponse reports the reruns queued for a tool's failed tasks
+type RequeueFailedResponse struct {
+	Requeued int      `json:"requeued"`
+	TaskIDs  []string `json:"task_ids"`
+
+	// Remaining counts the failed tasks left out because the queue filled up
+	Remaining int `json:"remaining"`
+}
+
+// requeueFailed queues a fresh copy of every failed task of a tool,
+// optionally limited to a creation time window
+func (s *Server) requeueFailed(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !s.executor.IsToolAvailable(name) {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("tool %s not found", name))
+		return
+	}
+
+	filters, err := parseTaskFilters(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter "+err.Error())
+		return
+	}
+	filters.Tool = name
+
+	requeued, remaining, err := s.manager.RequeueFailed(r.Context(), filters)
+	if err != nil && len(requeued) == 0 {
+		writeServiceError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Requeued %d failed %s tasks, %d left: %v", len(requeued), name, remaining, err)
+	}
+
+	resp := RequeueFailedResponse{Requeued: len(requeued), TaskIDs: make([]string, len(requeued)), Remaining: remaining}
+	for i, t := range requeued {
+		resp.TaskIDs[i] = t.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// SetWorkersRequest changes how many workers a tool runs
+type SetWorkersRequest struct {
+	Count *int `json:"count"`
+}
+
+// ToolWorkersResponse reports a tool's worker count
+type ToolWorkersResponse struct {
+	Tool    string `json:"tool"`
+	Workers int    `json:"workers"`
 }
 
-// createTask handles task creation
-func (s *Server) createTask(w http.ResponseWriter, r *http.Request) {
-	var req CreateTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// setToolWorkers starts or drains workers of a tool without a restart. The
+// new count is saved and applied again on the next start.
+func (s *Server) setToolWorkers(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	tool, ok := s.executor.GetTool(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("tool %s not found", name))
 		return
 	}
 
-	// Validate tool exists
-	if !s.executor.IsToolAvailable(req.Tool) {
-		http.Error(w, "Tool not available", http.StatusBadRequest)
+	var req SetWorkersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
-
-	// Use tool's command if not specified
-	if req.Command == "" {
-		for _, tool := range s.executor.GetTools() {
-			if tool.Name == req.Tool {
-				req.Command = tool.Command
-				break
-			}
-		}
+	if req.Count == nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "count is required")
+		return
 	}
 
-	// Create task
-	newTask := task.NewTask(req.Tool, req.Command, req.Args)
-
-	// Add to manager
-	if err := s.manager.AddTask(newTask); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.executor.SetWorkers(r.Context(), name, *req.Count); err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(newTask); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(ToolWorkersResponse{Tool: name, Workers: s.executor.WorkerCount(tool)}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
-// getTasks returns all tasks
-func (s *Server) getTasks(w http.ResponseWriter, r *http.Request) {
-	tool := r.URL.Query().Get("tool")
-
-	var tasks []*task.Task
-	if tool != "" {
-		tasks = s.manager.GetTasksByTool(tool)
-	} else {
-		tasks = s.manager.GetAllTasks()
+// getStats returns queue statistics. With ?wait= it holds the request until
+// they change, a long poll for clients that can't use the WebSocket.
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	wait, err := parseStatsWait(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter "+err.Error())
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(tasks); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	var stats map[string]task.QueueStats
+	if wait > 0 {
+		// Wait for a change from the stats the client last saw, or from the
+		// current ones when it didn't say
+		etag := r.Header.Get("If-None-Match")
+		if etag == "" {
+			etag = statsETag(s.queueStats(r.Context()))
+		}
+		// The server's write timeout would cut the wait short
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 15*time.Second)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Failed to extend the write deadline for stats: %v", err)
+		}
+		stats = s.waitForStats(r.Context(), etag, wait)
+	} else {
+		stats = s.queueStats(r.Context())
 	}
-}
-
-// getTask returns a specific task
-func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	taskID := vars["id"]
 
-	taskData, err := s.manager.GetTask(taskID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	etag := statsETag(stats)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(taskData); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
-// cancelTask cancels a task
-func (s *Server) cancelTask(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	taskID := vars["id"]
-
-	if err := s.manager.UpdateTaskStatus(taskID, types.StatusCanceled); err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
+// SetEventBus sets the bus WebSocket clients subscribe to. It defaults to the
+// task manager's bus.
+func (s *Server) SetEventBus(bus *events.Bus) {
+	s.bus = bus
+}
 
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "canceled"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-	}
+// SetWebSocketQueue configures the send queue each WebSocket connection owns:
+// its size, which events a slow client loses when it is full, and how many
+// events a client may miss before it is disconnected (0 never disconnects)
+func (s *Server) SetWebSocketQueue(size int, policy events.DropPolicy, maxMissed int) {
+	s.wsQueue = events.SubscribeOptions{BufferSize: size, Policy: policy, MaxMissed: maxMissed}
 }
 
-// getTools returns available tools
-func (s *Server) getTools(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(s.executor.GetTools()); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-	}
+// SetDownloadIdleTimeout sets how long a file download may go without the
+// client accepting any data before it is aborted. Zero disables the timeout,
+// leaving only the server's write timeout.
+func (s *Server) SetDownloadIdleTimeout(timeout time.Duration) {
+	s.downloadIdleTimeout = timeout
 }
 
-// getStats returns queue statistics
-func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
-	stats := s.manager.GetQueueStats()
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+// parseTopics parses a comma-separated list of event topics. An empty list
+// subscribes to every topic.
+func parseTopics(value string) ([]events.Topic, error) {
+	var topics []events.Topic
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		topic := events.Topic(name)
+		switch topic {
+		case events.TopicTasks, events.TopicFiles, events.TopicSystem:
+			topics = append(topics, topic)
+		default:
+			return nil, fmt.Errorf("unknown topic %q", name)
+		}
 	}
+	return topics, nil
 }
 
-// handleWebSocket handles WebSocket connections for real-time updates
+// handleWebSocket handles WebSocket connections for real-time updates. The
+// optional topics query parameter selects which events are sent.
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	topics, err := parseTopics(r.URL.Query().Get("topics"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, err.Error())
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
@@ -235,15 +1349,46 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Subscribe to task events
-	events := s.manager.Subscribe()
-	defer s.manager.Unsubscribe(events)
+	// Each connection gets its own queue, so a slow client only loses its
+	// own events
+	sub := s.bus.SubscribeWith(s.wsQueue, topics...)
+	defer s.bus.Unsubscribe(sub)
+
+	// Clients don't send anything, reading only notices when they go away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Send events to client from a dedicated writer
+	writeErr := make(chan error, 1)
+	go func() {
+		for event := range sub.C {
+			if err := conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+				writeErr <- err
+				return
+			}
+			if err := conn.WriteJSON(event.Payload); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}()
 
-	// Send events to client
-	for event := range events {
-		if err := conn.WriteJSON(event); err != nil {
-			log.Printf("WebSocket write failed: %v", err)
-			break
+	select {
+	case <-gone:
+	case err := <-writeErr:
+		log.Printf("WebSocket write failed: %v", err)
+	case <-sub.Overflow:
+		log.Printf("Closing WebSocket to %s: client missed %d events without catching up", r.RemoteAddr, s.wsQueue.MaxMissed)
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow")
+		if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout)); err != nil {
+			log.Printf("Error sending WebSocket close: %v", err)
 		}
 	}
 }
@@ -258,37 +1403,73 @@ type CreateDirectoryRequest struct {
 	DefaultDir bool    `json:"default_dir"`
 }
 
+// validateDirectoryRequest trims the request's fields and checks them,
+// returning an error for each invalid field. An empty tool name is treated
+// as no tool.
+func (s *Server) validateDirectoryRequest(req *CreateDirectoryRequest) []FieldError {
+	var fields []FieldError
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Message: "name is required"})
+	}
+
+	req.Path = strings.TrimSpace(req.Path)
+	if req.Path == "" {
+		fields = append(fields, FieldError{Field: "path", Message: "path is required"})
+	}
+
+	if req.ToolName != nil {
+		toolName := strings.TrimSpace(*req.ToolName)
+		if toolName == "" {
+			req.ToolName = nil
+		} else if _, ok := s.executor.GetTool(toolName); !ok {
+			fields = append(fields, FieldError{Field: "tool_name", Message: fmt.Sprintf("unknown tool %q", toolName)})
+		} else {
+			req.ToolName = &toolName
+		}
+	}
+
+	return fields
+}
+
 // createDirectory handles directory creation
 func (s *Server) createDirectory(w http.ResponseWriter, r *http.Request) {
 	var req CreateDirectoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if fields := s.validateDirectoryRequest(&req); len(fields) > 0 {
+		writeFieldErrors(w, fields)
 		return
 	}
 
 	dir, err := s.fileManager.CreateDirectory(r.Context(), req.Name, req.Path, req.ToolName, req.DefaultDir)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(dir); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
-// getDirectories returns all directories
+// getDirectories returns all directories, or only a tool's with ?tool=, with
+// their file count and total size
 func (s *Server) getDirectories(w http.ResponseWriter, r *http.Request) {
-	dirs, err := s.fileManager.GetFileRepository().ListDirectories(r.Context())
+	filters := types.DirectoryFilters{ToolName: r.URL.Query().Get("tool")}
+	dirs, err := s.fileManager.ListDirectories(r.Context(), filters)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(dirs); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -299,13 +1480,13 @@ func (s *Server) getDirectory(w http.ResponseWriter, r *http.Request) {
 
 	dir, err := s.fileManager.GetFileRepository().GetDirectory(r.Context(), dirID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(dir); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -316,14 +1497,18 @@ func (s *Server) updateDirectory(w http.ResponseWriter, r *http.Request) {
 
 	var req CreateDirectoryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if fields := s.validateDirectoryRequest(&req); len(fields) > 0 {
+		writeFieldErrors(w, fields)
 		return
 	}
 
 	// Get existing directory first
 	dir, err := s.fileManager.GetFileRepository().GetDirectory(r.Context(), dirID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -333,14 +1518,14 @@ func (s *Server) updateDirectory(w http.ResponseWriter, r *http.Request) {
 	dir.ToolName = req.ToolName
 	dir.DefaultDir = req.DefaultDir
 
-	if err := s.fileManager.GetFileRepository().UpdateDirectory(r.Context(), dir); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.fileManager.UpdateDirectory(r.Context(), dir); err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(dir); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -350,29 +1535,108 @@ func (s *Server) deleteDirectory(w http.ResponseWriter, r *http.Request) {
 	dirID := vars["id"]
 
 	if err := s.fileManager.GetFileRepository().DeleteDirectory(r.Context(), dirID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "deleted"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// MoveAllRequest names the directory to move a directory's files into
+type MoveAllRequest struct {
+	TargetDirectoryID string `json:"target_directory_id"`
+}
+
+// moveAllFiles moves every file of a directory into another one, reporting
+// the files that couldn't be moved
+func (s *Server) moveAllFiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dirID := vars["id"]
+
+	var req MoveAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.TargetDirectoryID) == "" {
+		writeFieldErrors(w, []FieldError{{Field: "target_directory_id", Message: "target_directory_id is required"}})
+		return
+	}
+
+	result, err := s.fileManager.MoveAllFiles(r.Context(), dirID, req.TargetDirectoryID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
+// ScanDirectoryResponse reports a finished directory scan
+type ScanDirectoryResponse struct {
+	Status string `json:"status"`
+	files.ScanResult
+}
+
 // scanDirectory scans a directory for files
 func (s *Server) scanDirectory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	dirID := vars["id"]
 
-	if err := s.fileManager.ScanDirectory(r.Context(), dirID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	result, err := s.fileManager.ScanDirectory(r.Context(), dirID)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{"status": "scanned"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	if err := json.NewEncoder(w).Encode(ScanDirectoryResponse{Status: "scanned", ScanResult: result}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// ScanAllResponse reports a finished scan of every directory
+type ScanAllResponse struct {
+	Status string `json:"status"`
+	files.ScanAllResult
+}
+
+// scanAllDirectories scans every registered directory. Canceling the
+// request stops the scan after the current batch.
+func (s *Server) scanAllDirectories(w http.ResponseWriter, r *http.Request) {
+	result, err := s.fileManager.ScanAll(r.Context())
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ScanAllResponse{Status: "scanned", ScanAllResult: result}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// getDirectoryUsage compares a directory's tracked files with its contents
+// on disk
+func (s *Server) getDirectoryUsage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	dirID := vars["id"]
+
+	report, err := s.fileManager.GetDirectoryUsage(r.Context(), dirID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -385,25 +1649,51 @@ func (s *Server) getDirectoryFiles(w http.ResponseWriter, r *http.Request) {
 		DirectoryID: dirID,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fileList); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// getDuplicates reports duplicate files within a directory, or across the
+// whole library when no directory is given
+func (s *Server) getDuplicates(w http.ResponseWriter, r *http.Request) {
+	dirID := mux.Vars(r)["id"]
+	if dirID != "" {
+		// Make sure the directory exists so a typo doesn't look like "no duplicates"
+		if _, err := s.fileManager.GetFileRepository().GetDirectory(r.Context(), dirID); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
+
+	report, err := s.fileManager.GetDuplicateReport(r.Context(), dirID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
 // File management handlers
 
-// getFiles returns all files with optional filters
-func (s *Server) getFiles(w http.ResponseWriter, r *http.Request) {
+// parseFileFilters builds file filters from query parameters shared by the
+// list and search endpoints
+func parseFileFilters(r *http.Request) types.FileFilters {
 	query := r.URL.Query()
 
 	filters := types.FileFilters{
 		DirectoryID: query.Get("directory_id"),
 		MimeType:    query.Get("mime_type"),
+		Query:       strings.TrimSpace(query.Get("q")),
 	}
 
 	if minSize := query.Get("min_size"); minSize != "" {
@@ -418,35 +1708,55 @@ func (s *Server) getFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	fileList, err := s.fileManager.GetFileRepository().ListFiles(r.Context(), filters)
+	if orphaned, err := strconv.ParseBool(query.Get("orphaned")); err == nil {
+		filters.Orphaned = orphaned
+	}
+
+	// Tags may be repeated (?tag=a&tag=b) or comma separated (?tags=a,b)
+	tags := query["tag"]
+	if tagList := query.Get("tags"); tagList != "" {
+		tags = append(tags, strings.Split(tagList, ",")...)
+	}
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filters.Tags = append(filters.Tags, tag)
+		}
+	}
+
+	return filters
+}
+
+// getFiles returns all files with optional filters
+func (s *Server) getFiles(w http.ResponseWriter, r *http.Request) {
+	fileList, err := s.fileManager.GetFileRepository().ListFiles(r.Context(), parseFileFilters(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fileList); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
-// searchFiles searches for files
+// searchFiles searches for files, accepting the same filters as getFiles
 func (s *Server) searchFiles(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+	filters := parseFileFilters(r)
+	if filters.Query == "" {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'q' is required")
 		return
 	}
 
-	fileList, err := s.fileManager.SearchFiles(r.Context(), query)
+	fileList, err := s.fileManager.GetFileRepository().ListFiles(r.Context(), filters)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fileList); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -457,13 +1767,40 @@ func (s *Server) getFile(w http.ResponseWriter, r *http.Request) {
 
 	file, err := s.fileManager.GetFileRepository().GetFile(r.Context(), fileID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(file); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// getFileTask returns the task that produced a file
+func (s *Server) getFileTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	file, err := s.fileManager.GetFileRepository().GetFile(r.Context(), fileID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	if file.TaskID == nil {
+		writeError(w, http.StatusNotFound, CodeNotFound, "File was not produced by a task")
+		return
+	}
+
+	taskData, err := s.manager.GetTask(r.Context(), *file.TaskID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(taskData); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -473,13 +1810,13 @@ func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request) {
 	fileID := vars["id"]
 
 	if err := s.fileManager.DeleteFile(r.Context(), fileID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "deleted"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -490,14 +1827,38 @@ func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
 
 	file, err := s.fileManager.GetFileRepository().GetFile(r.Context(), fileID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeServiceError(w, err)
+		return
+	}
+
+	s.serveFile(w, r, file)
+}
+
+// serveFile streams a library file as an attachment, or for the browser to
+// show with ?disposition=inline
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, file *types.File) {
+	inline, err := parseDisposition(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter "+err.Error())
+		return
+	}
+
+	// Never serve a file a crafted record points outside the allowed roots
+	if err := s.fileManager.CheckPath(file.FilePath); err != nil {
+		writeServiceError(w, err)
 		return
 	}
+	if !s.serveOutsideDirectories {
+		if err := s.fileManager.CheckInDirectory(r.Context(), file.FilePath); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+	}
 
 	// Open the file
 	fileHandle, err := os.Open(file.FilePath)
 	if err != nil {
-		http.Error(w, "File not found on filesystem", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, CodeNotFound, "File not found on filesystem")
 		return
 	}
 	defer func() {
@@ -505,16 +1866,62 @@ func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error closing file: %v", err)
 		}
 	}()
+	info, err := fileHandle.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to stat file")
+		return
+	}
+
+	// Set headers. Browsers must not second-guess the type, or content
+	// served as plain text could still be rendered as HTML.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if inline {
+		contentType, err := inlineContentType(file.MimeType, fileHandle)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to read file")
+			return
+		}
+		w.Header().Set("Content-Disposition", "inline; filename=\""+file.Filename+"\"")
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+file.Filename+"\"")
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+
+	// Stream the file, with range support. Each write gets a fresh deadline,
+	// so a client that stops reading is dropped while a slow one can take as
+	// long as it needs.
+	http.ServeContent(newIdleTimeoutWriter(w, s.downloadIdleTimeout), r, file.Filename, info.ModTime(), fileHandle)
+}
+
+// idleTimeoutWriter extends the connection's write deadline before every
+// write, turning it into an idle timeout for long streaming responses
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
 
-	// Set headers
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+file.Filename+"\"")
-	w.Header().Set("Content-Type", file.MimeType)
-	w.Header().Set("Content-Length", strconv.FormatInt(file.FileSize, 10))
+// newIdleTimeoutWriter wraps w so that a write blocking for longer than
+// timeout fails. A zero timeout leaves w unchanged.
+func newIdleTimeoutWriter(w http.ResponseWriter, timeout time.Duration) http.ResponseWriter {
+	if timeout <= 0 {
+		return w
+	}
+	return &idleTimeoutWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: timeout}
+}
 
-	// Stream the file
-	if _, err := io.Copy(w, fileHandle); err != nil {
-		log.Printf("Error streaming file: %v", err)
+// Write sets the next deadline and writes p
+func (w *idleTimeoutWriter) Write(p []byte) (int, error) {
+	if err := w.rc.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
 	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 // MoveFileRequest represents a file move request
@@ -529,18 +1936,47 @@ func (s *Server) moveFile(w http.ResponseWriter, r *http.Request) {
 
 	var req MoveFileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
 	if err := s.fileManager.MoveFile(r.Context(), fileID, req.DirectoryID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "moved"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// CopyFileRequest represents a file copy request
+type CopyFileRequest struct {
+	DirectoryID string `json:"directory_id"`
+}
+
+// copyFile copies a file into another directory, keeping its tags
+func (s *Server) copyFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	var req CopyFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	copied, err := s.fileManager.CopyFile(r.Context(), fileID, req.DirectoryID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(copied); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -549,25 +1985,47 @@ type UpdateFileTagsRequest struct {
 	Tags []string `json:"tags"`
 }
 
-// updateFileTags updates tags for a file
+// updateFileTags replaces the tags of a file with the requested set
 func (s *Server) updateFileTags(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
 
 	var req UpdateFileTagsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	if err := s.fileManager.SetFileTags(r.Context(), fileID, req.Tags); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "tagged"}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// addFileTags adds tags to a file, keeping the ones it already has
+func (s *Server) addFileTags(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	var req UpdateFileTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
 	if err := s.fileManager.TagFile(r.Context(), fileID, req.Tags); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "tagged"}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -576,6 +2034,13 @@ type BulkOperationRequest struct {
 	FileIDs []string `json:"file_ids"`
 }
 
+// BulkDeletePreview reports what a bulk delete would remove, see
+// files.Manager.PlanBulkDelete
+type BulkDeletePreview struct {
+	Status string `json:"status"`
+	files.DeletePlan
+}
+
 // BulkMoveRequest represents a bulk move request
 type BulkMoveRequest struct {
 	FileIDs     []string `json:"file_ids"`
@@ -592,21 +2057,37 @@ type BulkTagRequest struct {
 func (s *Server) bulkDeleteFiles(w http.ResponseWriter, r *http.Request) {
 	var req BulkOperationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
-	if err := s.fileManager.BulkDeleteFiles(r.Context(), req.FileIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Query parameter 'dry_run' must be a boolean")
+			return
+		}
+		if dryRun {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(BulkDeletePreview{Status: "dry_run", DeletePlan: s.fileManager.PlanBulkDelete(r.Context(), req.FileIDs)}); err != nil {
+				writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+			}
+			return
+		}
+	}
+
+	deleted, err := s.fileManager.BulkDeleteFiles(r.Context(), req.FileIDs)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":      "deleted",
-		"files_count": len(req.FileIDs),
+		"files_count": deleted,
 	}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -614,12 +2095,12 @@ func (s *Server) bulkDeleteFiles(w http.ResponseWriter, r *http.Request) {
 func (s *Server) bulkMoveFiles(w http.ResponseWriter, r *http.Request) {
 	var req BulkMoveRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
 	if err := s.fileManager.BulkMoveFiles(r.Context(), req.FileIDs, req.DirectoryID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -628,7 +2109,7 @@ func (s *Server) bulkMoveFiles(w http.ResponseWriter, r *http.Request) {
 		"status":      "moved",
 		"files_count": len(req.FileIDs),
 	}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -636,12 +2117,12 @@ func (s *Server) bulkMoveFiles(w http.ResponseWriter, r *http.Request) {
 func (s *Server) bulkTagFiles(w http.ResponseWriter, r *http.Request) {
 	var req BulkTagRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
 		return
 	}
 
 	if err := s.fileManager.BulkTagFiles(r.Context(), req.FileIDs, req.Tags); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -651,7 +2132,30 @@ func (s *Server) bulkTagFiles(w http.ResponseWriter, r *http.Request) {
 		"files_count": len(req.FileIDs),
 		"tags":        req.Tags,
 	}); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// bulkUntagFiles handles bulk tag removal
+func (s *Server) bulkUntagFiles(w http.ResponseWriter, r *http.Request) {
+	var req BulkTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	if err := s.fileManager.BulkUntagFiles(r.Context(), req.FileIDs, req.Tags); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "untagged",
+		"files_count": len(req.FileIDs),
+		"tags":        req.Tags,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
 
@@ -662,12 +2166,12 @@ func (s *Server) getTaskFiles(w http.ResponseWriter, r *http.Request) {
 
 	taskFiles, err := s.fileManager.GetTaskFiles(r.Context(), taskID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(taskFiles); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
 	}
 }
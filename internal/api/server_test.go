@@ -0,0 +1,2507 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/executor"
+	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// newTestServer creates a server backed by the mock repository with the
+// given tools, or a single "echo" tool when none are given
+func newTestServer(t *testing.T, tools ...executor.Tool) *Server {
+	t.Helper()
+
+	if len(tools) == 0 {
+		tools = []executor.Tool{{Name: "echo", Command: "echo", Description: "Echo arguments"}}
+	}
+	config := executor.Config{Tools: tools}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(configPath, data, 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	repo := storage.NewMockRepository()
+	manager := task.NewManager(repo)
+	exec, err := executor.NewExecutor(configPath, 1, manager)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	for _, tool := range tools {
+		manager.CreateQueue(tool.Name, executor.DefaultQueueSize)
+	}
+
+	return NewServer(manager, exec, files.NewManager(repo), nil)
+}
+
+// doRequest runs a request against the server router
+func doRequest(t *testing.T, s *Server, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("Failed to encode body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeError decodes an error envelope from a response
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorDetail {
+	t.Helper()
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	return resp.Error
+}
+
+func TestCreateTaskValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     CreateTaskRequest
+		message string
+	}{
+		{
+			name:    "empty tool",
+			req:     CreateTaskRequest{Tool: "  "},
+			message: "tool is required",
+		},
+		{
+			name:    "command override",
+			req:     CreateTaskRequest{Tool: "echo", Command: "rm"},
+			message: "does not match the configured command",
+		},
+		{
+			name:    "argument too long",
+			req:     CreateTaskRequest{Tool: "echo", Args: []string{strings.Repeat("a", maxTaskArgLength+1)}},
+			message: "exceeds the maximum length",
+		},
+		{
+			name:    "too many arguments",
+			req:     CreateTaskRequest{Tool: "echo", Args: make([]string, maxTaskArgs+1)},
+			message: "too many arguments",
+		},
+	}
+
+	// Fill the oversized argument list with non-empty values so they aren't dropped
+	for i := range tests[3].req.Args {
+		tests[3].req.Args[i] = "x"
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			rec := doRequest(t, s, http.MethodPost, "/api/tasks", tt.req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+
+			detail := decodeError(t, rec)
+			if detail.Code != CodeValidation {
+				t.Errorf("Expected code %s, got %s", CodeValidation, detail.Code)
+			}
+			if !strings.Contains(detail.Message, tt.message) {
+				t.Errorf("Expected message containing %q, got %q", tt.message, detail.Message)
+			}
+		})
+	}
+}
+
+func TestCreateTaskNormalizesArgs(t *testing.T) {
+	s := newTestServer(t)
+	req := CreateTaskRequest{Tool: " echo ", Args: []string{" hello ", "", "   ", "world"}}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+
+	if created.Tool != "echo" {
+		t.Errorf("Expected tool echo, got %q", created.Tool)
+	}
+	if created.Command != "echo" {
+		t.Errorf("Expected command echo, got %q", created.Command)
+	}
+	if len(created.Args) != 2 || created.Args[0] != "hello" || created.Args[1] != "world" {
+		t.Errorf("Expected args [hello world], got %v", created.Args)
+	}
+}
+
+func TestCreateTaskSourceHost(t *testing.T) {
+	s := newTestServer(t)
+
+	ids := map[string]string{}
+	for _, args := range [][]string{{"-o", "a.mp4", "https://Media.Example.com/a.mp4"}, {"no-url"}} {
+		rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Args: args})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var created task.Task
+		if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+			t.Fatalf("Failed to decode task: %v", err)
+		}
+		ids[created.SourceHost] = created.ID
+	}
+	if _, ok := ids["media.example.com"]; !ok || len(ids) != 2 {
+		t.Fatalf("Expected hosts media.example.com and none, got %v", ids)
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/api/tasks?host=example.com", []string{ids["media.example.com"]}},
+		{"/api/tasks/search?q=mp4&host=media.example.com", []string{ids["media.example.com"]}},
+		{"/api/tasks/search?q=mp4&host=example.org", []string{}},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodGet, tt.path, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.path, http.StatusOK, rec.Code)
+		}
+		var tasks []types.TaskData
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.path, err)
+		}
+		got := []string{}
+		for _, data := range tasks {
+			got = append(got, data.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestCreateTaskOptions(t *testing.T) {
+	s := newTestServer(t)
+	req := CreateTaskRequest{
+		Tool:    "echo",
+		Args:    []string{"https://example.com/a"},
+		Options: map[string]string{"f": "best", "no-warnings": ""},
+	}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+
+	wantArgs := []string{"-f", "best", "--no-warnings", "https://example.com/a"}
+	if !reflect.DeepEqual(created.Args, wantArgs) {
+		t.Errorf("Expected flattened args %v, got %v", wantArgs, created.Args)
+	}
+	if !reflect.DeepEqual(created.Options, req.Options) || !reflect.DeepEqual(created.PositionalArgs, req.Args) {
+		t.Errorf("Expected structured args to be kept, got %v and %v", created.Options, created.PositionalArgs)
+	}
+
+	req.Options = map[string]string{"f --exec rm": "x"}
+	rec = doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an invalid option, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestPreviewTask(t *testing.T) {
+	// Default args are expanded when the config is loaded
+	t.Setenv("HOME", "/home/media")
+	s := newTestServer(t, executor.Tool{Name: "yt-dlp", Command: "yt-dlp", Args: []string{"-o", "~/Downloads/%(title)s.%(ext)s"}})
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks/preview", CreateTaskRequest{Tool: "yt-dlp", Args: []string{" https://example.com/watch?v=1 ", "it's"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var preview PreviewTaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&preview); err != nil {
+		t.Fatalf("Failed to decode preview: %v", err)
+	}
+	wantArgv := []string{"yt-dlp", "-o", "/home/media/Downloads/%(title)s.%(ext)s", "https://example.com/watch?v=1", "it's"}
+	if !reflect.DeepEqual(preview.Argv, wantArgv) {
+		t.Errorf("Expected argv %v, got %v", wantArgv, preview.Argv)
+	}
+	wantLine := `yt-dlp -o '/home/media/Downloads/%(title)s.%(ext)s' 'https://example.com/watch?v=1' 'it'\''s'`
+	if preview.CommandLine != wantLine {
+		t.Errorf("Expected command line %s, got %s", wantLine, preview.CommandLine)
+	}
+
+	if tasks := s.manager.GetAllTasks(context.Background()); len(tasks) != 0 {
+		t.Errorf("Expected preview not to create a task, got %d", len(tasks))
+	}
+
+	rec = doRequest(t, s, http.MethodPost, "/api/tasks/preview", CreateTaskRequest{Tool: "missing"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if detail := decodeError(t, rec); detail.Code != CodeToolUnavailable {
+		t.Errorf("Expected code %s, got %s", CodeToolUnavailable, detail.Code)
+	}
+}
+
+func TestCreateTaskUnknownTool(t *testing.T) {
+	s := newTestServer(t)
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "nope"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	detail := decodeError(t, rec)
+	if detail.Code != CodeToolUnavailable {
+		t.Errorf("Expected code %s, got %s", CodeToolUnavailable, detail.Code)
+	}
+	if !strings.Contains(detail.Message, `"nope"`) {
+		t.Errorf("Expected message naming the tool, got %q", detail.Message)
+	}
+	if !reflect.DeepEqual(detail.AvailableTools, []string{"echo"}) {
+		t.Errorf("Expected available tools [echo], got %v", detail.AvailableTools)
+	}
+}
+
+func TestCreateTaskOrganize(t *testing.T) {
+	s := newTestServer(t)
+	organize := true
+
+	tests := []struct {
+		name string
+		req  CreateTaskRequest
+		want bool
+	}{
+		{name: "tool default", req: CreateTaskRequest{Tool: "echo"}, want: false},
+		{name: "task override", req: CreateTaskRequest{Tool: "echo", Organize: &organize}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(t, s, http.MethodPost, "/api/tasks", tt.req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+
+			var created task.Task
+			if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+				t.Fatalf("Failed to decode task: %v", err)
+			}
+			if created.Organize != tt.want {
+				t.Errorf("Expected organize %v, got %v", tt.want, created.Organize)
+			}
+		})
+	}
+}
+
+func TestCreateTaskTags(t *testing.T) {
+	s := newTestServer(t, executor.Tool{Name: "gallery-dl", Command: "gallery-dl", DefaultTags: []string{"gallery"}})
+
+	req := CreateTaskRequest{Tool: "gallery-dl", Tags: []string{" art ", "gallery", ""}}
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if len(created.Tags) != 2 || created.Tags[0] != "gallery" || created.Tags[1] != "art" {
+		t.Errorf("Expected tags [gallery art], got %v", created.Tags)
+	}
+}
+
+func TestCreateTaskExpectedOutput(t *testing.T) {
+	outputDir := t.TempDir()
+	s := newTestServer(t, executor.Tool{Name: "echo", Command: "echo", OutputDirArg: "-o"})
+	s.fileManager.SetDownloadsDir(t.TempDir())
+	toolDir := filepath.Join(s.fileManager.DownloadsDir(), "echo")
+
+	req := CreateTaskRequest{Tool: "echo", ExpectedOutput: []string{" " + toolDir + "/out/../video.mp4 ", ""}}
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	want := filepath.Join(toolDir, "video.mp4")
+	if len(created.ExpectedOutput) != 1 || created.ExpectedOutput[0] != want {
+		t.Errorf("Expected cleaned output [%s], got %v", want, created.ExpectedOutput)
+	}
+
+	// Files in the task's output directory are accepted too
+	req = CreateTaskRequest{Tool: "echo", Args: []string{"-o", outputDir}, ExpectedOutput: []string{filepath.Join(outputDir, "video.mp4")}}
+	rec = doRequest(t, s, http.MethodPost, "/api/tasks", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected output in the output directory to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+
+	// Editing the arguments can't move the output directory away from it
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Args: []string{"-o", t.TempDir()}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an edit moving the output directory, got %d", http.StatusBadRequest, rec.Code)
+	} else if detail := decodeError(t, rec); len(detail.Fields) != 1 || detail.Fields[0].Field != "expected_output[0]" {
+		t.Errorf("Expected a field error for expected_output[0], got %+v", detail)
+	}
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Args: []string{"--verbose", "-o", outputDir}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected an edit keeping the output directory to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Anything else could be moved into the library and served from there
+	for _, path := range []string{filepath.Join(outputDir, "video.mp4"), "video.mp4", "/etc/passwd"} {
+		req := CreateTaskRequest{Tool: "echo", ExpectedOutput: []string{want, path}}
+		rec := doRequest(t, s, http.MethodPost, "/api/tasks", req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, path, rec.Code)
+			continue
+		}
+		if detail := decodeError(t, rec); len(detail.Fields) != 1 || detail.Fields[0].Field != "expected_output[1]" {
+			t.Errorf("Expected a field error for expected_output[1], got %+v", detail)
+		}
+	}
+
+	// The allowed roots apply on top of the directories
+	if err := s.fileManager.SetAllowedRoots([]string{outputDir}); err != nil {
+		t.Fatalf("SetAllowedRoots failed: %v", err)
+	}
+	if rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", ExpectedOutput: []string{want}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected output outside the allowed roots to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCreateTaskAllowCommandOverride(t *testing.T) {
+	s := newTestServer(t)
+	s.SetAllowCommandOverride(true)
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Command: "printf"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	topics, err := parseTopics(" tasks, files ,")
+	if err != nil {
+		t.Fatalf("parseTopics() error = %v", err)
+	}
+	if len(topics) != 2 || topics[0] != events.TopicTasks || topics[1] != events.TopicFiles {
+		t.Errorf("Expected [tasks files], got %v", topics)
+	}
+
+	if topics, err := parseTopics(""); err != nil || len(topics) != 0 {
+		t.Errorf("Expected no topics for empty value, got %v, %v", topics, err)
+	}
+
+	if _, err := parseTopics("tasks,nope"); err == nil {
+		t.Error("Expected error for unknown topic")
+	}
+}
+
+func TestWebSocketUnknownTopic(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, http.MethodGet, "/api/ws?topics=nope", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestWebSocketEvents(t *testing.T) {
+	s := newTestServer(t)
+	bus := events.NewBus()
+	s.SetEventBus(bus)
+	s.SetWebSocketQueue(4, events.DropOldest, 0)
+
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/api/ws?topics=tasks", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The subscription is made after the upgrade, so publish until it arrives
+	deadline := time.Now().Add(time.Second)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+	received := make(chan map[string]string, 1)
+	go func() {
+		var msg map[string]string
+		if err := conn.ReadJSON(&msg); err == nil {
+			received <- msg
+		}
+		close(received)
+	}()
+	for {
+		bus.Publish(events.TopicTasks, map[string]string{"task_id": "1"})
+		select {
+		case msg, ok := <-received:
+			if !ok || msg["task_id"] != "1" {
+				t.Fatalf("Expected task event, got %v", msg)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+}
+
+func TestGetStatsWorkers(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, http.MethodGet, "/api/stats", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var stats map[string]task.QueueStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+
+	echo, ok := stats["echo"]
+	if !ok {
+		t.Fatalf("Expected stats for echo, got %v", stats)
+	}
+	if echo.Workers != 1 {
+		t.Errorf("Expected 1 worker, got %d", echo.Workers)
+	}
+	if echo.BusyWorkers != 0 {
+		t.Errorf("Expected no busy workers, got %d", echo.BusyWorkers)
+	}
+}
+
+func TestGetStatsLongPoll(t *testing.T) {
+	s := newTestServer(t)
+	getStats := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := getStats("/api/stats", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected stats with an ETag, got %d %q", rec.Code, etag)
+	}
+	if rec := getStats("/api/stats", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for unchanged stats, got %d", http.StatusNotModified, rec.Code)
+	}
+
+	// Without a change the wait runs out
+	start := time.Now()
+	if rec := getStats("/api/stats?wait=100ms", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d after the wait, got %d", http.StatusNotModified, rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the request to wait, it returned after %s", elapsed)
+	}
+
+	// A new task ends the wait early
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := s.manager.AddTask(context.Background(), task.NewTask("echo", "echo", nil)); err != nil {
+			t.Errorf("AddTask failed: %v", err)
+		}
+	}()
+	start = time.Now()
+	rec = getStats("/api/stats?wait=30s", etag)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the change to end the wait, it took %s", elapsed)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("Expected changed stats, got %d with ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	var stats map[string]task.QueueStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if stats["echo"].Pending != 1 {
+		t.Errorf("Expected 1 pending task, got %d", stats["echo"].Pending)
+	}
+
+	for _, wait := range []string{"soon", "-1s", "2m"} {
+		if rec := getStats("/api/stats?wait="+wait, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for wait=%s, got %d", http.StatusBadRequest, wait, rec.Code)
+		}
+	}
+}
+
+func TestSetToolWorkers(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{"unknown tool", "/api/tools/missing/workers", map[string]int{"count": 2}, http.StatusNotFound},
+		{"missing count", "/api/tools/echo/workers", map[string]int{}, http.StatusBadRequest},
+		{"zero workers", "/api/tools/echo/workers", map[string]int{"count": 0}, http.StatusBadRequest},
+		{"too many workers", "/api/tools/echo/workers", map[string]int{"count": 1000}, http.StatusBadRequest},
+		{"valid", "/api/tools/echo/workers", map[string]int{"count": 3}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodPost, tt.path, tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.wantStatus, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The new count shows up in the tool list and the stats
+	rec := doRequest(t, s, http.MethodGet, "/api/tools", nil)
+	var tools []ToolInfo
+	if err := json.NewDecoder(rec.Body).Decode(&tools); err != nil {
+		t.Fatalf("Failed to decode tools: %v", err)
+	}
+	if len(tools) != 1 || tools[0].Workers != 3 || !tools[0].Available {
+		t.Errorf("Expected an available echo with 3 workers, got %+v", tools)
+	}
+
+	rec = doRequest(t, s, http.MethodGet, "/api/stats", nil)
+	var stats map[string]task.QueueStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if stats["echo"].Workers != 3 {
+		t.Errorf("Expected 3 workers in stats, got %d", stats["echo"].Workers)
+	}
+}
+
+func TestGetTaskNotFound(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tasks/missing", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if detail := decodeError(t, rec); detail.Code != CodeNotFound {
+		t.Errorf("Expected code %s, got %s", CodeNotFound, detail.Code)
+	}
+}
+
+func TestUpdateTaskNotes(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Args: []string{"hi"}, Notes: "first try"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if created.Notes != "first try" {
+		t.Errorf("Expected notes from the request, got %q", created.Notes)
+	}
+
+	notes := "  re-download, first one was 480p "
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Notes: &notes})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var updated task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if updated.Notes != "re-download, first one was 480p" {
+		t.Errorf("Expected trimmed notes, got %q", updated.Notes)
+	}
+
+	found, err := s.manager.ListTasks(context.Background(), types.TaskFilters{Query: "480p"})
+	if err != nil || len(found) != 1 || found[0].ID != created.ID {
+		t.Errorf("Expected the task to be found by its notes, got %v, %v", found, err)
+	}
+
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/missing", UpdateTaskRequest{Notes: &notes})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing task, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	long := strings.Repeat("a", maxTaskNotesLength+1)
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Notes: &long})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for oversized notes, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestUpdateQueuedTask(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Args: []string{"https://example.com/480p"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var created task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+
+	// No workers run in the test server, so the task stays queued
+	timeout := 30
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{
+		Args:           []string{" https://example.com/1080p ", ""},
+		Options:        map[string]string{"-f": "best"},
+		TimeoutSeconds: &timeout,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	queued, err := s.manager.GetTask(context.Background(), created.ID)
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	data := queued.Clone()
+	if want := []string{"-f", "best", "https://example.com/1080p"}; !reflect.DeepEqual(data.Args, want) {
+		t.Errorf("Expected args %v, got %v", want, data.Args)
+	}
+	if !reflect.DeepEqual(data.PositionalArgs, []string{"https://example.com/1080p"}) || data.TimeoutSeconds != 30 {
+		t.Errorf("Expected positional args and timeout to be updated, got %+v", data)
+	}
+
+	// Once the task runs only its notes can change
+	if err := s.manager.UpdateTaskStatus(context.Background(), created.ID, types.StatusRunning); err != nil {
+		t.Fatalf("Failed to update status: %v", err)
+	}
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Args: []string{"other"}})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+	}
+	if detail := decodeError(t, rec); detail.Code != CodeConflict {
+		t.Errorf("Expected code %s, got %s", CodeConflict, detail.Code)
+	}
+	notes := "running now"
+	rec = doRequest(t, s, http.MethodPatch, "/api/tasks/"+created.ID, UpdateTaskRequest{Notes: &notes})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected notes to stay editable, got status %d", rec.Code)
+	}
+}
+
+func TestGetFileTask(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository()
+
+	taskID := "task"
+	if err := repo.(storage.TaskRepository).Create(ctx, types.TaskData{ID: taskID, Tool: "echo", Status: types.StatusComplete}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	missingTask := "missing"
+	for _, file := range []*types.File{
+		{ID: "produced", Filename: "a.txt", FilePath: "/tmp/a.txt", TaskID: &taskID},
+		{ID: "uploaded", Filename: "b.txt", FilePath: "/tmp/b.txt"},
+		{ID: "orphaned", Filename: "c.txt", FilePath: "/tmp/c.txt", TaskID: &missingTask},
+	} {
+		if err := repo.CreateFile(ctx, file); err != nil {
+			t.Fatalf("Failed to create file record: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/files/produced/task", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got types.TaskData
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got.ID != taskID {
+		t.Errorf("Expected task %s, got %s", taskID, got.ID)
+	}
+
+	for _, id := range []string{"uploaded", "orphaned", "missing"} {
+		rec := doRequest(t, s, http.MethodGet, "/api/files/"+id+"/task", nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d for file %s, got %d", http.StatusNotFound, id, rec.Code)
+		}
+	}
+}
+
+func TestExportTask(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := repo.Create(ctx, types.TaskData{ID: "task", Tool: "echo", Command: "echo", Args: []string{"hello", "world"}, Status: types.StatusFailed, Error: "exit status 1", CreatedAt: created}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	for _, line := range []string{"hello world", "done"} {
+		if err := repo.AppendOutput(ctx, "task", line); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+
+	// JSON is the default
+	for _, query := range []string{"", "?format=json"} {
+		rec := doRequest(t, s, http.MethodGet, "/api/tasks/task/export"+query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Expected a JSON content type, got %q", got)
+		}
+		if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="task.json"` {
+			t.Errorf("Expected the task's JSON file name, got %q", got)
+		}
+		var data types.TaskData
+		if err := json.NewDecoder(rec.Body).Decode(&data); err != nil {
+			t.Fatalf("Failed to decode export: %v", err)
+		}
+		if data.ID != "task" || data.Error != "exit status 1" || !reflect.DeepEqual(data.Output, []string{"hello world", "done"}) {
+			t.Errorf("Expected the task with its output, got %+v", data)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tasks/task/export?format=txt", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Expected a text content type, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="task.log"` {
+		t.Errorf("Expected the task's log file name, got %q", got)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"Task:     task\n", "Command:  echo hello world\n", "Status:   failed\n", "Created:  2024-05-01T12:00:00Z\n", "Started:  -\n", "Error:    exit status 1\n", "\nhello world\ndone\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the log to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	rec = doRequest(t, s, http.MethodGet, "/api/tasks/task/export?format=csv", nil)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != CodeValidation {
+		t.Errorf("Expected a validation error for an unknown format, got %d", rec.Code)
+	}
+	if rec := doRequest(t, s, http.MethodGet, "/api/tasks/missing/export", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing task, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestExportTaskCompressed(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	if err := repo.Create(ctx, types.TaskData{ID: "task", Tool: "echo", Command: "echo", Status: types.StatusComplete, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := repo.AppendOutput(ctx, "task", fmt.Sprintf("[download] %d%% of 1.00GiB", i)); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+
+	plain := doRequest(t, s, http.MethodGet, "/api/tasks/task/export?format=txt", nil)
+	if plain.Code != http.StatusOK || plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Expected an uncompressed export, got %d %v", plain.Code, plain.Header())
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tasks/task/export?format=txt&compress=gzip", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Expected Content-Encoding gzip, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="task.log"`) {
+		t.Errorf("Expected the log's file name, got %q", got)
+	}
+	if rec.Body.Len() >= plain.Body.Len() {
+		t.Errorf("Expected the compressed export to be smaller, got %d bytes for %d", rec.Body.Len(), plain.Body.Len())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip stream: %v", err)
+	}
+	decompressed, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress export: %v", err)
+	}
+	if !bytes.Equal(decompressed, plain.Body.Bytes()) {
+		t.Errorf("Expected the decompressed export to match the plain one")
+	}
+
+	if rec := doRequest(t, s, http.MethodGet, "/api/tasks/task/export?compress=zip", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown compression, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestBulkDeleteDryRun(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "clip.mp4")
+	if err := os.WriteFile(path, []byte("clip"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := repo.CreateFile(ctx, &types.File{ID: "clip", Filename: "clip.mp4", FilePath: path, FileSize: 4}); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+	req := BulkOperationRequest{FileIDs: []string{"clip", "missing"}}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/files/bulk/delete?dry_run=true", req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var preview BulkDeletePreview
+	if err := json.NewDecoder(rec.Body).Decode(&preview); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if preview.Status != "dry_run" || len(preview.Files) != 1 || preview.Files[0].FilePath != path || preview.TotalBytes != 4 {
+		t.Errorf("Expected clip.mp4 to be reported, got %+v", preview)
+	}
+	if len(preview.Failures) != 1 || preview.Failures[0].FileID != "missing" {
+		t.Errorf("Expected the missing file as a failure, got %+v", preview.Failures)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file to stay on disk: %v", err)
+	}
+	if _, err := repo.GetFile(ctx, "clip"); err != nil {
+		t.Errorf("Expected the file record to stay: %v", err)
+	}
+
+	rec = doRequest(t, s, http.MethodPost, "/api/files/bulk/delete?dry_run=maybe", req)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != CodeValidation {
+		t.Errorf("Expected a validation error for an invalid dry_run, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// dry_run=false deletes as usual, counting a repeated file once
+	rec = doRequest(t, s, http.MethodPost, "/api/files/bulk/delete?dry_run=false", BulkOperationRequest{FileIDs: []string{"clip", "clip"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var deleted struct {
+		FilesCount int `json:"files_count"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&deleted); err != nil || deleted.FilesCount != 1 {
+		t.Errorf("Expected files_count 1, got %d (%v)", deleted.FilesCount, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed, got %v", err)
+	}
+}
+
+func TestExportFiles(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository()
+
+	if err := repo.CreateDirectory(ctx, &types.Directory{ID: "videos", Name: "Videos", Path: "/srv/videos"}); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, file := range []*types.File{
+		{ID: "quoted", Filename: `clip "final", v2.mp4`, FilePath: "/srv/videos/clip.mp4", DirectoryID: "videos", FileSize: 2048, MimeType: "video/mp4", CreatedAt: created},
+		{ID: "other", Filename: "notes.txt", FilePath: "/srv/videos/notes.txt", DirectoryID: "videos", FileSize: 10, MimeType: "text/plain", CreatedAt: created},
+	} {
+		if err := repo.CreateFile(ctx, file); err != nil {
+			t.Fatalf("Failed to create file record: %v", err)
+		}
+	}
+	for _, tag := range []string{"keep", "family"} {
+		if err := repo.AddFileTag(ctx, "quoted", tag); err != nil {
+			t.Fatalf("Failed to tag file: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/files/export?format=csv&tag=keep", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Expected a CSV content type, got %q", got)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected a header and one file, got %v", records)
+	}
+	if strings.Join(records[0], ",") != "filename,path,size,mime_type,directory,tags,created_at" {
+		t.Errorf("Unexpected header %v", records[0])
+	}
+	row := records[1]
+	if row[0] != `clip "final", v2.mp4` || row[2] != "2048" || row[4] != "Videos" || row[6] != "2024-05-01T12:00:00Z" {
+		t.Errorf("Unexpected row %v", row)
+	}
+	if tags := strings.Split(row[5], ";"); !slices.Contains(tags, "keep") || !slices.Contains(tags, "family") {
+		t.Errorf("Expected both tags, got %q", row[5])
+	}
+
+	// JSON lines, one file per line
+	rec = doRequest(t, s, http.MethodGet, "/api/files/export?format=json", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %s", len(lines), rec.Body.String())
+	}
+	var file types.File
+	if err := json.Unmarshal([]byte(lines[0]), &file); err != nil || file.ID == "" {
+		t.Errorf("Expected a file per line, got %q: %v", lines[0], err)
+	}
+
+	// Without matches there is still a header
+	rec = doRequest(t, s, http.MethodGet, "/api/files/export?tag=missing", nil)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != strings.Join(fileExportHeader, ",") {
+		t.Errorf("Expected just the header, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := doRequest(t, s, http.MethodGet, "/api/files/export?format=xml", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestDirectoryValidation(t *testing.T) {
+	s := newTestServer(t)
+	blank := "  "
+	unknown := "wget"
+
+	tests := []struct {
+		name       string
+		req        CreateDirectoryRequest
+		wantFields []string
+	}{
+		{"missing name and path", CreateDirectoryRequest{Name: " ", Path: ""}, []string{"name", "path"}},
+		{"unknown tool", CreateDirectoryRequest{Name: "Videos", Path: t.TempDir(), ToolName: &unknown}, []string{"tool_name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(t, s, http.MethodPost, "/api/directories", tt.req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+			detail := decodeError(t, rec)
+			var fields []string
+			for _, field := range detail.Fields {
+				fields = append(fields, field.Field)
+			}
+			if detail.Code != CodeValidation || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("Expected validation errors for %v, got %+v", tt.wantFields, detail)
+			}
+		})
+	}
+
+	// A blank tool name means no tool
+	rec := doRequest(t, s, http.MethodPost, "/api/directories", CreateDirectoryRequest{Name: " Echo ", Path: t.TempDir(), ToolName: &blank})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var dir types.Directory
+	if err := json.NewDecoder(rec.Body).Decode(&dir); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if dir.Name != "Echo" || dir.ToolName != nil {
+		t.Errorf("Expected a trimmed directory without a tool, got %+v", dir)
+	}
+
+	// Updates are validated the same way
+	rec = doRequest(t, s, http.MethodPut, "/api/directories/"+dir.ID, CreateDirectoryRequest{Name: "", Path: dir.Path})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if detail := decodeError(t, rec); len(detail.Fields) != 1 || detail.Fields[0].Field != "name" {
+		t.Errorf("Expected a name error, got %+v", detail)
+	}
+}
+
+func TestGetDirectoriesByTool(t *testing.T) {
+	s := newTestServer(t)
+	echo := "echo"
+	for _, req := range []CreateDirectoryRequest{
+		{Name: "Echo", Path: t.TempDir(), ToolName: &echo},
+		{Name: "Other", Path: t.TempDir()},
+	} {
+		if rec := doRequest(t, s, http.MethodPost, "/api/directories", req); rec.Code != http.StatusOK {
+			t.Fatalf("Failed to create directory: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/directories?tool=echo", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var dirs []types.Directory
+	if err := json.NewDecoder(rec.Body).Decode(&dirs); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(dirs) != 1 || dirs[0].Name != "Echo" {
+		t.Errorf("Expected only the echo directory, got %+v", dirs)
+	}
+}
+
+func TestGetTasksJSONLines(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	now := time.Now()
+	for i, tool := range []string{"echo", "sleep", "echo"} {
+		data := types.TaskData{ID: fmt.Sprintf("task-%d", i), Tool: tool, Status: types.StatusComplete, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tasks?format=jsonl&tool=echo", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected JSON lines content type, got %s", ct)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+		var data types.TaskData
+		if err := json.Unmarshal([]byte(line), &data); err != nil {
+			t.Fatalf("Failed to decode line %q: %v", line, err)
+		}
+		ids = append(ids, data.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"task-2", "task-0"}) {
+		t.Errorf("Expected echo tasks newest first, got %v", ids)
+	}
+
+	if rec := doRequest(t, s, http.MethodGet, "/api/tasks?format=xml", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestGetTasksTimeWindow(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	now := time.Now()
+	ages := map[string]time.Duration{"old": 48 * time.Hour, "hour": time.Hour, "new": 0}
+	for id, age := range ages {
+		data := types.TaskData{ID: id, Tool: "echo", Status: types.StatusComplete, CreatedAt: now.Add(-age)}
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	stamp := func(age time.Duration) string {
+		return url.QueryEscape(now.Add(-age).Format(time.RFC3339))
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"relative", "since=24h", []string{"new", "hour"}},
+		{"absolute", "from=" + stamp(2*time.Hour) + "&until=" + stamp(30*time.Minute), []string{"hour"}},
+		{"since wins over from", "since=2h&from=" + stamp(72*time.Hour), []string{"new", "hour"}},
+		{"streamed", "since=2h&format=jsonl", []string{"new", "hour"}},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodGet, "/api/tasks?"+tt.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, http.StatusOK, rec.Code)
+		}
+		var tasks []types.TaskData
+		if strings.Contains(tt.query, "format=jsonl") {
+			for _, line := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+				var data types.TaskData
+				if err := json.Unmarshal([]byte(line), &data); err != nil {
+					t.Fatalf("%s: failed to decode line %q: %v", tt.name, line, err)
+				}
+				tasks = append(tasks, data)
+			}
+		} else if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.name, err)
+		}
+		var ids []string
+		for _, data := range tasks {
+			ids = append(ids, data.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+
+	for _, query := range []string{"since=yesterday", "since=-1h", "from=2024-01-01", "until=soon", "from=" + stamp(time.Hour) + "&until=" + stamp(2*time.Hour)} {
+		if rec := doRequest(t, s, http.MethodGet, "/api/tasks?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+}
+
+func TestGetTasksFilters(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	now := time.Now()
+	statuses := []types.Status{types.StatusComplete, types.StatusFailed, types.StatusCanceled, types.StatusFailed}
+	for i, status := range statuses {
+		data := types.TaskData{ID: fmt.Sprintf("task-%d", i), Tool: "echo", Command: "echo", Args: []string{fmt.Sprintf("file%d.iso", i)}, Status: status, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"statuses", "status=failed,canceled", []string{"task-3", "task-2", "task-1"}},
+		{"oldest first", "status=failed&sort=oldest", []string{"task-1", "task-3"}},
+		{"page", "offset=1&limit=2", []string{"task-2", "task-1"}},
+		{"query", "q=file2", []string{"task-2"}},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodGet, "/api/tasks?"+tt.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, http.StatusOK, rec.Code)
+		}
+		var tasks []types.TaskData
+		if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.name, err)
+		}
+		ids := []string{}
+		for _, data := range tasks {
+			ids = append(ids, data.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+
+	for _, query := range []string{"status=done", "sort=largest", "offset=-1", "limit=0", "output=maybe"} {
+		if rec := doRequest(t, s, http.MethodGet, "/api/tasks?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+}
+
+func TestGetTaskOutput(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	if err := repo.Create(ctx, types.TaskData{ID: "task", Tool: "echo", Status: types.StatusComplete}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := repo.AppendOutput(ctx, "task", fmt.Sprintf("line %d", i)); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tasks/task/output?offset=-2", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var page types.OutputPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if page.Offset != 3 || page.Total != 5 || !reflect.DeepEqual(page.Lines, []string{"line 3", "line 4"}) {
+		t.Errorf("Expected the last two lines, got %+v", page)
+	}
+
+	if err := repo.AppendOutput(ctx, "task", types.StderrPrefix+"warning"); err != nil {
+		t.Fatalf("Failed to append output: %v", err)
+	}
+	rec = doRequest(t, s, http.MethodGet, "/api/tasks/task/output?stream=stderr", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	page = types.OutputPage{}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if page.Stream != types.StreamStderr || page.Total != 1 || !reflect.DeepEqual(page.Lines, []string{"[ERROR] warning"}) {
+		t.Errorf("Expected only the stderr line, got %+v", page)
+	}
+
+	for path, status := range map[string]int{
+		"/api/tasks/task/output?stream=all": http.StatusBadRequest,
+		"/api/tasks/task/output?limit=0":    http.StatusBadRequest,
+		"/api/tasks/task/output?offset=x":   http.StatusBadRequest,
+		"/api/tasks/missing/output?limit=1": http.StatusNotFound,
+	} {
+		if rec := doRequest(t, s, http.MethodGet, path, nil); rec.Code != status {
+			t.Errorf("GET %s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+}
+
+func TestSearchTaskOutput(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	if err := repo.Create(ctx, types.TaskData{ID: "task", Tool: "echo", Status: types.StatusComplete}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	for _, line := range []string{"fetching page 1", "[ERROR] HTTP Error 429", "fetching page 2", "error: giving up"} {
+		if err := repo.AppendOutput(ctx, "task", line); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+
+	tests := []struct {
+		path      string
+		wantLines []int
+	}{
+		{"/api/tasks/task/output/search?q=error", []int{1, 3}},
+		{"/api/tasks/task/output/search?q=Error&case_sensitive=true", []int{1}},
+		{"/api/tasks/task/output/search?q=page+%5Cd&regex=true", []int{0, 2}},
+		{"/api/tasks/task/output/search?q=page&limit=1", []int{0}},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodGet, tt.path, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, got %d", tt.path, http.StatusOK, rec.Code)
+		}
+		var result types.OutputSearchResult
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		var got []int
+		for _, match := range result.Matches {
+			got = append(got, match.Line)
+		}
+		if !reflect.DeepEqual(got, tt.wantLines) {
+			t.Errorf("GET %s: expected lines %v, got %+v", tt.path, tt.wantLines, result)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/api/tasks/task/output/search":                   http.StatusBadRequest,
+		"/api/tasks/task/output/search?q=x&regex=maybe":   http.StatusBadRequest,
+		"/api/tasks/task/output/search?q=x&limit=0":       http.StatusBadRequest,
+		"/api/tasks/task/output/search?q=%28x&regex=true": http.StatusBadRequest,
+		"/api/tasks/missing/output/search?q=x":            http.StatusNotFound,
+	} {
+		if rec := doRequest(t, s, http.MethodGet, path, nil); rec.Code != status {
+			t.Errorf("GET %s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+}
+
+func TestErrorStatusNotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"not found", fmt.Errorf("file 1: %w", storage.ErrNotFound), http.StatusNotFound},
+		{"database failure", errors.New("database is locked"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := errorStatus(tt.err); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+		})
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	s := newTestServer(t)
+
+	upload := func(name, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write form file: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close form: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/uploads", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := upload("clip.mp4", "data"); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected status %d without uploader, got %d", http.StatusNotImplemented, rec.Code)
+	}
+
+	dir := t.TempDir()
+	s.SetUploader(files.NewUploader(s.fileManager, dir, 8))
+
+	rec := upload("../../clip.mp4", "data")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var file types.File
+	if err := json.NewDecoder(rec.Body).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if file.ID == "" || file.FilePath != filepath.Join(dir, "clip.mp4") {
+		t.Errorf("Unexpected file: %+v", file)
+	}
+
+	rec = upload("big.mp4", "0123456789")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if detail := decodeError(t, rec); detail.Code != CodeTooLarge {
+		t.Errorf("Expected code %s, got %s", CodeTooLarge, detail.Code)
+	}
+}
+
+func TestUploadChunks(t *testing.T) {
+	s := newTestServer(t)
+	s.SetUploader(files.NewUploader(s.fileManager, t.TempDir(), 100))
+
+	rec := doRequest(t, s, http.MethodPost, "/api/uploads/sessions", CreateUploadSessionRequest{Filename: "clip.mp4", Size: 6})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var session files.UploadSession
+	if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+		t.Fatalf("Failed to decode session: %v", err)
+	}
+
+	chunk := func(offset, content string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/uploads/sessions/"+session.ID, strings.NewReader(content))
+		req.Header.Set("Upload-Offset", offset)
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := chunk("0", "abc"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec := chunk("0", "abc"); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d for stale offset, got %d", http.StatusConflict, rec.Code)
+	}
+
+	rec = chunk("3", "def")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp UploadChunkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.File == nil || resp.File.FileSize != 6 {
+		t.Errorf("Expected registered 6 byte file, got %+v", resp.File)
+	}
+}
+
+func TestUploadOutlastsReadTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.SetUploader(files.NewUploader(s.fileManager, t.TempDir(), 100))
+
+	// Uploads may take far longer than the server's timeouts, as long as
+	// data keeps coming
+	server := httptest.NewUnstartedServer(s.Router())
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	// slowBody sends content in six pieces over 300ms
+	slowBody := func(content string) io.Reader {
+		body, client := io.Pipe()
+		go func() {
+			size := (len(content) + 5) / 6
+			for start := 0; start < len(content); start += size {
+				time.Sleep(50 * time.Millisecond)
+				if _, err := client.Write([]byte(content[start:min(start+size, len(content))])); err != nil {
+					return
+				}
+			}
+			client.Close()
+		}()
+		return body
+	}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/uploads/sessions", CreateUploadSessionRequest{Filename: "clip.mp4", Size: 6})
+	var session files.UploadSession
+	if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+		t.Fatalf("Failed to decode session: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/uploads/sessions/"+session.ID, slowBody("abcdef"))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Upload-Offset", "0")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Chunk upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d for the chunk, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, err := writer.CreateFormFile("file", "form.mp4")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	if _, err := part.Write([]byte("data")); err != nil {
+		t.Fatalf("Failed to write form file: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close form: %v", err)
+	}
+	resp, err = server.Client().Post(server.URL+"/api/uploads", writer.FormDataContentType(), slowBody(form.String()))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status %d for the upload, got %d", http.StatusCreated, resp.StatusCode)
+	}
+}
+
+// createTestDirectory registers a library directory in a temporary
+// directory and returns it
+func createTestDirectory(t *testing.T, s *Server) *types.Directory {
+	t.Helper()
+	dir := &types.Directory{ID: "library", Name: "Library", Path: t.TempDir(), CreatedAt: time.Now()}
+	if err := s.fileManager.GetFileRepository().CreateDirectory(context.Background(), dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	return dir
+}
+
+func TestDownloadFileRange(t *testing.T) {
+	s := newTestServer(t)
+	s.SetDownloadIdleTimeout(time.Second)
+	dir := createTestDirectory(t, s)
+
+	path := filepath.Join(dir.Path, "video.mp4")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	file := &types.File{ID: "video", Filename: "video.mp4", FilePath: path, DirectoryID: dir.ID, MimeType: "video/mp4", FileSize: 10}
+	if err := s.fileManager.GetFileRepository().CreateFile(context.Background(), file); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+
+	// A real connection, so write deadlines are actually set
+	ts := httptest.NewServer(s.Router())
+	defer ts.Close()
+
+	get := func(rangeHeader string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/files/video/download", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read body: %v", err)
+		}
+		return resp, string(body)
+	}
+
+	resp, body := get("")
+	if resp.StatusCode != http.StatusOK || body != "0123456789" {
+		t.Errorf("Expected the whole file, got %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Expected content type video/mp4, got %s", ct)
+	}
+
+	resp, body = get("bytes=4-6")
+	if resp.StatusCode != http.StatusPartialContent || body != "456" {
+		t.Errorf("Expected bytes 4-6, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestDownloadFileInline(t *testing.T) {
+	s := newTestServer(t)
+	dir := createTestDirectory(t, s)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 16)...)
+	files := []struct {
+		id, name, mimeType string
+		content            []byte
+	}{
+		{"pdf", "doc.pdf", "application/pdf", []byte("%PDF-1.4")},
+		{"unknown", "image.bin", "application/octet-stream", png},
+		{"page", "page.html", "text/html; charset=utf-8", []byte("<script>alert(1)</script>")},
+		{"sniffed", "page", "application/octet-stream", []byte("<html><script>alert(1)</script></html>")},
+		{"vector", "logo.svg", "image/svg+xml", []byte("<svg onload=\"alert(1)\"/>")},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir.Path, f.name)
+		if err := os.WriteFile(path, f.content, 0o644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		file := &types.File{ID: f.id, Filename: f.name, FilePath: path, DirectoryID: dir.ID, MimeType: f.mimeType, FileSize: int64(len(f.content))}
+		if err := s.fileManager.GetFileRepository().CreateFile(context.Background(), file); err != nil {
+			t.Fatalf("Failed to create file record: %v", err)
+		}
+	}
+
+	tests := []struct {
+		id          string
+		contentType string
+	}{
+		{"pdf", "application/pdf"},
+		{"unknown", "image/png"},
+		{"page", "text/plain; charset=utf-8"},
+		{"sniffed", "text/plain; charset=utf-8"},
+		{"vector", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		rec := doRequest(t, s, http.MethodGet, "/api/files/"+tt.id+"/download?disposition=inline", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", tt.id, http.StatusOK, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: expected content type %s, got %s", tt.id, tt.contentType, ct)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "inline;") {
+			t.Errorf("%s: expected an inline disposition, got %s", tt.id, cd)
+		}
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected nosniff", tt.id)
+		}
+	}
+	if rec := doRequest(t, s, http.MethodGet, "/api/files/unknown/download?disposition=inline", nil); rec.Body.Len() != len(png) {
+		t.Errorf("Expected the whole file after sniffing, got %d bytes", rec.Body.Len())
+	}
+
+	// Downloads stay attachments with the stored type by default
+	rec := doRequest(t, s, http.MethodGet, "/api/files/page/download", nil)
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Expected an HTML attachment, got %s %s", cd, rec.Header().Get("Content-Type"))
+	}
+	if rec := doRequest(t, s, http.MethodGet, "/api/files/pdf/download?disposition=preview", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown disposition, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestShareFile(t *testing.T) {
+	s := newTestServer(t)
+	dir := createTestDirectory(t, s)
+
+	path := filepath.Join(dir.Path, "clip.mp4")
+	if err := os.WriteFile(path, []byte("shared"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	file := &types.File{ID: "clip", Filename: "clip.mp4", FilePath: path, DirectoryID: dir.ID, MimeType: "video/mp4", FileSize: 6}
+	if err := s.fileManager.GetFileRepository().CreateFile(context.Background(), file); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+
+	// Sharing is off until a key is configured
+	rec := doRequest(t, s, http.MethodPost, "/api/files/clip/share", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d without a share key, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	s.SetShareKey("first-key")
+	rec = doRequest(t, s, http.MethodPost, "/api/files/clip/share", ShareFileRequest{ExpiresInSeconds: 60})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var share ShareFileResponse
+	if err := json.NewDecoder(rec.Body).Decode(&share); err != nil {
+		t.Fatalf("Failed to decode share: %v", err)
+	}
+	if !strings.HasSuffix(share.URL, "/api/shared/"+share.Token) {
+		t.Errorf("Expected URL to contain the token, got %s", share.URL)
+	}
+
+	rec = doRequest(t, s, http.MethodGet, "/api/shared/"+share.Token, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "shared" {
+		t.Errorf("Expected the shared file, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// A tampered token is rejected
+	tampered := signShareToken([]byte("guess"), "clip", share.ExpiresAt)
+	if rec := doRequest(t, s, http.MethodGet, "/api/shared/"+tampered, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a forged token, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	// Rotating the key revokes links issued with the old one
+	s.SetShareKey("second-key")
+	if rec := doRequest(t, s, http.MethodGet, "/api/shared/"+share.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d after rotating the key, got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestVerifyShareTokenExpiry(t *testing.T) {
+	key := []byte("key")
+	expiresAt := time.Now().Add(time.Minute)
+	token := signShareToken(key, "file-1", expiresAt)
+
+	if fileID, err := verifyShareToken(key, token, time.Now()); err != nil || fileID != "file-1" {
+		t.Errorf("verifyShareToken() = %q, %v", fileID, err)
+	}
+	if _, err := verifyShareToken(key, token, expiresAt.Add(time.Second)); !errors.Is(err, errShareExpired) {
+		t.Errorf("Expected errShareExpired, got %v", err)
+	}
+	if _, err := verifyShareToken(key, "not-a-token", time.Now()); !errors.Is(err, errShareInvalid) {
+		t.Errorf("Expected errShareInvalid, got %v", err)
+	}
+}
+
+func TestDownloadFileOutsideAllowedRoots(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.fileManager.SetAllowedRoots([]string{t.TempDir()}); err != nil {
+		t.Fatalf("SetAllowedRoots() error = %v", err)
+	}
+
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	file := &types.File{ID: "crafted", Filename: "secret.txt", FilePath: secret}
+	if err := s.fileManager.GetFileRepository().CreateFile(context.Background(), file); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/files/crafted/download", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	if detail := decodeError(t, rec); detail.Code != CodeForbidden {
+		t.Errorf("Expected code %s, got %s", CodeForbidden, detail.Code)
+	}
+
+	rec = doRequest(t, s, http.MethodPost, "/api/directories", CreateDirectoryRequest{Name: "Escape", Path: "../../etc"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d creating a directory with .., got %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestDownloadFileOutsideDirectories(t *testing.T) {
+	s := newTestServer(t)
+	dir := createTestDirectory(t, s)
+	ctx := context.Background()
+
+	secret := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	// A record pointing straight outside, and one through a symlink inside
+	// the directory
+	link := filepath.Join(dir.Path, "link.txt")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	for id, path := range map[string]string{"outside": secret, "link": link} {
+		file := &types.File{ID: id, Filename: filepath.Base(path), FilePath: path, DirectoryID: dir.ID}
+		if err := s.fileManager.GetFileRepository().CreateFile(ctx, file); err != nil {
+			t.Fatalf("Failed to create file record: %v", err)
+		}
+	}
+
+	for _, id := range []string{"outside", "link"} {
+		rec := doRequest(t, s, http.MethodGet, "/api/files/"+id+"/download", nil)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected status %d, got %d", id, http.StatusForbidden, rec.Code)
+		}
+		if detail := decodeError(t, rec); detail.Code != CodeForbidden {
+			t.Errorf("%s: expected code %s, got %s", id, CodeForbidden, detail.Code)
+		}
+	}
+
+	s.SetServeOutsideDirectories(true)
+	rec := doRequest(t, s, http.MethodGet, "/api/files/outside/download", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "secret" {
+		t.Errorf("Expected the file when serving outside directories is allowed, got %d", rec.Code)
+	}
+}
+
+func TestRequeueFailed(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	now := time.Now()
+	history := []types.TaskData{
+		{ID: "recent", Tool: "echo", Command: "echo", Args: []string{"again"}, Status: types.StatusFailed, CreatedAt: now.Add(-time.Hour)},
+		{ID: "ancient", Tool: "echo", Command: "echo", Status: types.StatusFailed, CreatedAt: now.Add(-30 * 24 * time.Hour)},
+		{ID: "done", Tool: "echo", Command: "echo", Status: types.StatusComplete, CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, data := range history {
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/tools/echo/requeue-failed?since=24h", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp RequeueFailedResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Requeued != 1 || len(resp.TaskIDs) != 1 || resp.Remaining != 0 {
+		t.Fatalf("Expected one requeued task, got %+v", resp)
+	}
+
+	rerun, err := repo.GetByID(ctx, resp.TaskIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to get rerun: %v", err)
+	}
+	if rerun.Status != types.StatusQueued || !reflect.DeepEqual(rerun.Args, []string{"again"}) || rerun.RerunOf != "recent" {
+		t.Errorf("Expected a queued copy of the recent failure, got %+v", rerun)
+	}
+
+	// The failure already has a rerun, so asking again queues nothing
+	rec = doRequest(t, s, http.MethodPost, "/api/tools/echo/requeue-failed?since=24h", nil)
+	resp = RequeueFailedResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Requeued != 0 || len(resp.TaskIDs) != 0 {
+		t.Errorf("Expected nothing to be requeued again, got %d %+v", rec.Code, resp)
+	}
+
+	if rec := doRequest(t, s, http.MethodPost, "/api/tools/missing/requeue-failed", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown tool, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := doRequest(t, s, http.MethodPost, "/api/tools/echo/requeue-failed?since=recently", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid window, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(t, s, "GET", "/readyz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 without a disk monitor, got %d", rec.Code)
+	}
+
+	tests := []struct {
+		name       string
+		minFree    uint64
+		wantStatus int
+	}{
+		{"enough space", 0, http.StatusOK},
+		{"disk full", math.MaxUint64, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetDiskMonitor(files.NewDiskMonitor(t.TempDir(), tt.minFree))
+
+			rec := doRequest(t, s, "GET", "/readyz", nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			var response ReadinessResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Disk == nil || response.Disk.TotalBytes == 0 {
+				t.Fatalf("Expected free and total bytes, got %+v", response.Disk)
+			}
+			if response.Disk.FreeBytes > response.Disk.TotalBytes {
+				t.Errorf("Free bytes %d exceed total bytes %d", response.Disk.FreeBytes, response.Disk.TotalBytes)
+			}
+		})
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	s := newTestServer(t, executor.Tool{Name: "echo", Command: "echo"})
+	s.SetAPIKey("secret")
+	adminRequest := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/admin/readonly", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := adminRequest(http.MethodPost, `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d without enabled, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := adminRequest(http.MethodPost, `{"enabled": true}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !s.ReadOnly() || !s.executor.Paused() {
+		t.Fatal("Expected read-only mode to be on and the executor paused")
+	}
+
+	// Changes are rejected, reads keep working
+	req := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(`{"tool": "echo", "args": ["hi"]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if code := decodeError(t, rec).Code; code != CodeReadOnly {
+		t.Errorf("Expected error code %q, got %q", CodeReadOnly, code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected reads to work in read-only mode, got %d", rec.Code)
+	}
+
+	rec = doRequest(t, s, http.MethodGet, "/readyz", nil)
+	var readiness ReadinessResponse
+	if err := json.NewDecoder(rec.Body).Decode(&readiness); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || !readiness.ReadOnly || readiness.Status != "read_only" {
+		t.Errorf("Expected a ready read_only status, got %d %+v", rec.Code, readiness)
+	}
+
+	if rec := adminRequest(http.MethodPost, `{"enabled": false}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if s.ReadOnly() || s.executor.Paused() {
+		t.Error("Expected read-only mode to be off and the executor resumed")
+	}
+}
+
+func TestImportTasks(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	existing := types.TaskData{ID: uuid.New().String(), Tool: "echo", Command: "echo", Status: types.StatusComplete, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, existing); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	imported := types.TaskData{
+		ID:        uuid.New().String(),
+		Tool:      "echo",
+		Command:   "echo",
+		Args:      []string{"hello"},
+		Status:    types.StatusFailed,
+		Output:    []string{"hello", "[STDERR] oops"},
+		Error:     "Command failed: exit status 1",
+		CreatedAt: time.Now().Add(-time.Hour).UTC().Truncate(time.Second),
+	}
+
+	importTasks := func(path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("Failed to encode body: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, path, &buf)
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := importTasks("/api/tasks/import", []types.TaskData{imported}); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d without an API key configured, got %d", http.StatusForbidden, rec.Code)
+	}
+	s.SetAPIKey("secret")
+	if rec := doRequest(t, s, http.MethodPost, "/api/tasks/import", []types.TaskData{imported}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without credentials, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	rec := importTasks("/api/tasks/import", []types.TaskData{imported, existing})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var resp ImportTasksResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Imported) != 1 || resp.Imported[0].ID != imported.ID || resp.Imported[0].SourceID != "" {
+		t.Errorf("Expected %s to be imported with its ID, got %+v", imported.ID, resp.Imported)
+	}
+	if !reflect.DeepEqual(resp.Skipped, []string{existing.ID}) {
+		t.Errorf("Expected %s to be skipped, got %v", existing.ID, resp.Skipped)
+	}
+	got, err := repo.GetByID(ctx, imported.ID)
+	if err != nil {
+		t.Fatalf("Failed to get imported task: %v", err)
+	}
+	if got.Status != imported.Status || got.Error != imported.Error || !got.CreatedAt.Equal(imported.CreatedAt) || !reflect.DeepEqual(got.Output, imported.Output) {
+		t.Errorf("Expected imported task %+v, got %+v", imported, got)
+	}
+
+	rec = importTasks("/api/tasks/import?regenerate_ids=true", []types.TaskData{imported})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	resp = ImportTasksResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Imported) != 1 || resp.Imported[0].SourceID != imported.ID || resp.Imported[0].ID == imported.ID {
+		t.Fatalf("Expected a copy of %s with a new ID, got %+v", imported.ID, resp.Imported)
+	}
+	if _, err := repo.GetByID(ctx, resp.Imported[0].ID); err != nil {
+		t.Errorf("Failed to get copied task: %v", err)
+	}
+
+	queued := imported
+	queued.ID = uuid.New().String()
+	queued.Status = types.StatusQueued
+	for name, body := range map[string]interface{}{
+		"queued task":   []types.TaskData{queued},
+		"missing id":    []types.TaskData{{Tool: "echo", Command: "echo", Status: types.StatusComplete, CreatedAt: time.Now()}},
+		"empty":         []types.TaskData{},
+		"unknown field": []map[string]interface{}{{"id": queued.ID, "bogus": true}},
+		"not an array":  imported,
+	} {
+		if rec := importTasks("/api/tasks/import", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if _, err := repo.GetByID(ctx, queued.ID); err == nil {
+		t.Error("Expected the invalid import to create no task")
+	}
+}
+
+func TestGetToolFailures(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	repo := s.fileManager.GetFileRepository().(storage.TaskRepository)
+
+	now := time.Now()
+	history := []types.TaskData{
+		{ID: "first", Status: types.StatusFailed, Error: "Command failed: exit status 1", EndedAt: now.Add(-2 * time.Hour)},
+		{ID: "second", Status: types.StatusFailed, Error: "Command failed: exit status 2", EndedAt: now.Add(-time.Hour)},
+		{ID: "done", Status: types.StatusComplete, EndedAt: now.Add(-time.Hour)},
+		{ID: "ancient", Status: types.StatusFailed, EndedAt: now.AddDate(0, 0, -60)},
+	}
+	for _, data := range history {
+		data.Tool = "echo"
+		data.Command = "echo"
+		data.CreatedAt = data.EndedAt.Add(-time.Minute)
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	rec := doRequest(t, s, http.MethodGet, "/api/tools/echo/failures?days=7&limit=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var failures types.ToolFailures
+	if err := json.NewDecoder(rec.Body).Decode(&failures); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if failures.Finished != 3 || failures.Failed != 2 || len(failures.Failures) != 1 {
+		t.Fatalf("Expected 2 of 3 tasks failed and one listed, got %+v", failures)
+	}
+	if got := failures.Failures[0]; got.TaskID != "second" || got.Error != "Command failed: exit status 2" {
+		t.Errorf("Expected the latest failure, got %+v", got)
+	}
+
+	for path, status := range map[string]int{
+		"/api/tools/missing/failures":        http.StatusNotFound,
+		"/api/tools/echo/failures?days=-1":   http.StatusBadRequest,
+		"/api/tools/echo/failures?limit=0":   http.StatusBadRequest,
+		"/api/tools/echo/failures?limit=all": http.StatusBadRequest,
+	} {
+		if rec := doRequest(t, s, http.MethodGet, path, nil); rec.Code != status {
+			t.Errorf("GET %s: expected status %d, got %d", path, status, rec.Code)
+		}
+	}
+}
+
+func TestMoveAllFiles(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	src, err := s.fileManager.CreateDirectory(ctx, "Source", filepath.Join(root, "src"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	dst, err := s.fileManager.CreateDirectory(ctx, "Target", filepath.Join(root, "dst"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	path := filepath.Join(src.Path, "clip.mp4")
+	if err := os.WriteFile(path, []byte("clip"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	file := &types.File{ID: "clip", Filename: "clip.mp4", FilePath: path, DirectoryID: src.ID}
+	if err := s.fileManager.GetFileRepository().CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	rec := doRequest(t, s, http.MethodPost, "/api/directories/"+src.ID+"/move-all", MoveAllRequest{TargetDirectoryID: dst.ID})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var result files.MoveResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Moved != 1 || len(result.Failures) != 0 {
+		t.Errorf("Expected one file moved, got %+v", result)
+	}
+
+	// The emptied directory can now be deleted
+	if rec := doRequest(t, s, http.MethodDelete, "/api/directories/"+src.ID, nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected the empty directory to be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for body, status := range map[string]int{
+		`{}`: http.StatusBadRequest,
+		`{"target_directory_id": "` + dst.ID + `"}`: http.StatusBadRequest,
+		`{"target_directory_id": "missing"}`:        http.StatusNotFound,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/directories/"+dst.ID+"/move-all", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Errorf("POST %s: expected status %d, got %d", body, status, rec.Code)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newTestServer(t)
+	repo := s.fileManager.GetFileRepository().(*storage.MockRepository)
+	s.SetAuditLog(repo)
+	s.SetAPIKey("secret")
+
+	withKey := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Mutations are recorded whatever their outcome, reads aren't
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Args: []string{"hello"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	doRequest(t, s, http.MethodGet, "/api/tasks", nil)
+	doRequest(t, s, http.MethodPost, "/api/tasks/missing/cancel", nil)
+	doRequest(t, s, http.MethodPost, "/api/admin/vacuum", nil)
+	withKey(http.MethodPost, "/api/admin/vacuum")
+
+	if rec := doRequest(t, s, http.MethodGet, "/api/admin/audit", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without credentials, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	rec = withKey(http.MethodGet, "/api/admin/audit?limit=3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var page types.AuditPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode audit log: %v", err)
+	}
+	if page.Total != 4 || len(page.Entries) != 3 {
+		t.Fatalf("Expected 3 of 4 entries, got %+v", page)
+	}
+	want := []struct {
+		actor, action, summary string
+		status                 int
+	}{
+		{ActorAPIKey, "POST /api/admin/vacuum", "POST /api/admin/vacuum", http.StatusNotImplemented},
+		{ActorAnonymous, "POST /api/admin/vacuum", "POST /api/admin/vacuum", http.StatusUnauthorized},
+		{ActorAnonymous, "POST /api/tasks/{id}/cancel", "POST /api/tasks/missing/cancel", http.StatusNotFound},
+	}
+	for i, w := range want {
+		got := page.Entries[i]
+		if got.Actor != w.actor || got.Action != w.action || got.Summary != w.summary || got.Status != w.status {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	rec = withKey(http.MethodGet, "/api/admin/audit?action="+url.QueryEscape("POST /api/tasks"))
+	page = types.AuditPage{}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode audit log: %v", err)
+	}
+	if page.Total != 1 || page.Entries[0].Status != http.StatusOK {
+		t.Errorf("Expected the task creation, got %+v", page)
+	}
+
+	for _, query := range []string{"limit=0", "limit=501", "offset=-1", "since=yesterday"} {
+		if rec := withKey(http.MethodGet, "/api/admin/audit?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	s := newTestServer(t,
+		executor.Tool{Name: "echo", Command: "echo", Description: "Echo arguments", Workers: 2, QueueSize: 5, DefaultTags: []string{"demo"}, MaxRuntimeSeconds: 60},
+		executor.Tool{Name: "true", Command: "true"},
+	)
+
+	get := func(auth string) ClientConfig {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var config ClientConfig
+		if err := json.NewDecoder(rec.Body).Decode(&config); err != nil {
+			t.Fatalf("Failed to decode config: %v", err)
+		}
+		return config
+	}
+
+	config := get("")
+	want := []ToolSummary{
+		{Name: "echo", Description: "Echo arguments", Workers: 2, QueueSize: 5, DefaultTags: []string{"demo"}, MaxRuntimeSeconds: 60},
+		{Name: "true", Workers: 1, QueueSize: executor.DefaultQueueSize, DefaultTags: []string{}},
+	}
+	if !reflect.DeepEqual(config.Tools, want) {
+		t.Errorf("Expected tools %+v, got %+v", want, config.Tools)
+	}
+	if config.AuthEnabled {
+		t.Error("Expected auth to be disabled without an API key")
+	}
+	if config.Limits.QueueSize != executor.DefaultQueueSize || config.Limits.MaxUploadBytes != 0 || config.Features["uploads"] {
+		t.Errorf("Expected no uploads without an uploader, got %+v %v", config.Limits, config.Features)
+	}
+
+	s.SetUploader(files.NewUploader(s.fileManager, t.TempDir(), 1<<20))
+	s.SetAPIKey("secret")
+	config = get("secret")
+	if !config.AuthEnabled {
+		t.Error("Expected auth to be enabled with an API key")
+	}
+	if config.Limits.MaxUploadBytes != 1<<20 || !config.Features["uploads"] {
+		t.Errorf("Expected the upload limit, got %+v %v", config.Limits, config.Features)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	s := newTestServer(t)
+
+	get := func() BuildInfo {
+		t.Helper()
+		rec := doRequest(t, s, http.MethodGet, "/api/version", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var info BuildInfo
+		if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+			t.Fatalf("Failed to decode build info: %v", err)
+		}
+		return info
+	}
+
+	if info := get(); info.Version != "dev" || info.GoVersion != runtime.Version() {
+		t.Errorf("Expected the dev build on %s, got %+v", runtime.Version(), info)
+	}
+
+	s.SetBuildInfo(NewBuildInfo("1.4.0", "abc1234", "2024-05-01T12:00:00Z"))
+	want := BuildInfo{Version: "1.4.0", Commit: "abc1234", BuildDate: "2024-05-01T12:00:00Z", GoVersion: runtime.Version()}
+	if info := get(); info != want {
+		t.Errorf("Expected %+v, got %+v", want, info)
+	}
+}
+
+func TestGetConfigMimeTypes(t *testing.T) {
+	s := newTestServer(t)
+
+	decode := func() ClientConfig {
+		t.Helper()
+		rec := doRequest(t, s, http.MethodGet, "/api/config", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var config ClientConfig
+		if err := json.NewDecoder(rec.Body).Decode(&config); err != nil {
+			t.Fatalf("Failed to decode config: %v", err)
+		}
+		return config
+	}
+
+	if config := decode(); config.MimeTypes == nil || len(config.MimeTypes) != 0 {
+		t.Errorf("Expected an empty map without overrides, got %v", config.MimeTypes)
+	}
+	if err := s.fileManager.SetMimeTypes(map[string]string{".ts": "video/mp2t"}); err != nil {
+		t.Fatalf("SetMimeTypes failed: %v", err)
+	}
+	if config := decode(); !reflect.DeepEqual(config.MimeTypes, map[string]string{".ts": "video/mp2t"}) {
+		t.Errorf("Expected the overrides, got %v", config.MimeTypes)
+	}
+}
+
+func TestDatabaseMaintenance(t *testing.T) {
+	s := newTestServer(t)
+	dataDir := t.TempDir()
+	dbPath := filepath.Join(dataDir, "commander.db")
+	repo, err := storage.NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+
+	admin := func(path, auth string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatalf("Failed to encode body: %v", err)
+			}
+		}
+		req := httptest.NewRequest(http.MethodPost, path, &buf)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		s.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The admin API needs an API key, and the key in the request
+	for _, path := range []string{"/api/admin/backup", "/api/admin/vacuum"} {
+		if rec := admin(path, "", nil); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d for %s without an API key, got %d", http.StatusForbidden, path, rec.Code)
+		}
+	}
+	s.SetAPIKey("secret")
+	for _, auth := range []string{"", "wrong"} {
+		if rec := admin("/api/admin/backup", auth, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d with key %q, got %d", http.StatusUnauthorized, auth, rec.Code)
+		}
+	}
+	if rec := admin("/api/admin/backup", "secret", nil); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d without a maintainer, got %d", http.StatusNotImplemented, rec.Code)
+	}
+
+	s.SetMaintainer(repo, dbPath)
+	dest := filepath.Join(t.TempDir(), "nested", "backup.db")
+	rec := admin("/api/admin/backup", "secret", BackupRequest{Path: dest})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var backup struct {
+		Path      string `json:"path"`
+		SizeBytes int64  `json:"size_bytes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&backup); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil || backup.Path != dest || backup.SizeBytes != info.Size() || info.Size() == 0 {
+		t.Errorf("Expected a backup at %s, got %+v (%v)", dest, backup, err)
+	}
+
+	// An existing file is never overwritten
+	if err := os.WriteFile(dest, []byte("keep"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if rec := admin("/api/admin/backup", "secret", BackupRequest{Path: dest}); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for an existing destination, got %d", http.StatusConflict, rec.Code)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) != "keep" {
+		t.Errorf("Expected the existing file to be kept, got %q (%v)", data, err)
+	}
+
+	// Without a path the backup goes next to the database
+	rec = admin("/api/admin/backup", "secret", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&backup); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if filepath.Dir(backup.Path) != filepath.Join(dataDir, "backups") {
+		t.Errorf("Expected a backup in %s, got %s", filepath.Join(dataDir, "backups"), backup.Path)
+	}
+
+	rec = admin("/api/admin/vacuum", "secret", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var vacuum struct {
+		Path      string `json:"path"`
+		SizeBytes int64  `json:"size_bytes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&vacuum); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if vacuum.Path != dbPath || vacuum.SizeBytes == 0 {
+		t.Errorf("Expected the vacuumed database's size, got %+v", vacuum)
+	}
+}
+
+func TestGetAdminConfig(t *testing.T) {
+	s := newTestServer(t)
+	s.SetAPIKey("secret")
+
+	fs := flag.NewFlagSet("commander", flag.ContinueOnError)
+	fs.String("api-key", "", "")
+	fs.String("notify-slack", "", "")
+	fs.String("data-dir", "./data", "")
+	if err := fs.Parse([]string{"-api-key", "secret", "-data-dir", "/srv/commander"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	s.SetFlags(FlagValues(fs, "api-key", "notify-slack"))
+
+	if rec := doRequest(t, s, http.MethodGet, "/api/admin/config", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without credentials, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/config", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("Expected the API key to be redacted, got %s", rec.Body.String())
+	}
+
+	var config AdminConfig
+	if err := json.NewDecoder(rec.Body).Decode(&config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	want := map[string]string{"api-key": redactedValue, "notify-slack": "", "data-dir": "/srv/commander"}
+	if !reflect.DeepEqual(config.Flags, want) {
+		t.Errorf("Expected flags %v, got %v", want, config.Flags)
+	}
+
+	// Defaults the executor falls back to are filled in
+	if len(config.Config.Tools) != 1 {
+		t.Fatalf("Expected one tool, got %+v", config.Config.Tools)
+	}
+	tool := config.Config.Tools[0]
+	if tool.Workers != 1 || tool.QueueSize != executor.DefaultQueueSize || tool.ArgsPosition != executor.ArgsPrepend || tool.OutputFlushLines != executor.DefaultOutputFlushLines {
+		t.Errorf("Expected defaults to be filled in, got %+v", tool)
+	}
+	if config.Config.Scheduling != executor.DefaultScheduling {
+		t.Errorf("Expected scheduling %q, got %q", executor.DefaultScheduling, config.Config.Scheduling)
+	}
+}
+
+func TestCancelTaskWithReason(t *testing.T) {
+	s := newTestServer(t)
+
+	create := func() string {
+		rec := doRequest(t, s, http.MethodPost, "/api/tasks", CreateTaskRequest{Tool: "echo", Args: []string{"hello"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var created types.TaskData
+		if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+			t.Fatalf("Failed to decode task: %v", err)
+		}
+		return created.ID
+	}
+
+	id := create()
+	rec := doRequest(t, s, http.MethodPost, "/api/tasks/"+id+"/cancel", CancelTaskRequest{Reason: "  queued the wrong URL "})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, s, http.MethodGet, "/api/tasks/"+id, nil)
+	var got types.TaskData
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("Failed to decode task: %v", err)
+	}
+	if got.Status != types.StatusCanceled || got.CancelReason != "queued the wrong URL" || got.Error != "" {
+		t.Errorf("Expected the trimmed reason apart from the error, got %+v", got)
+	}
+
+	// The body stays optional
+	id = create()
+	if rec := doRequest(t, s, http.MethodPost, "/api/tasks/"+id+"/cancel", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d without a body, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	long := CancelTaskRequest{Reason: strings.Repeat("x", maxCancelReasonLength+1)}
+	rec = doRequest(t, s, http.MethodPost, "/api/tasks/"+create()+"/cancel", long)
+	if rec.Code != http.StatusBadRequest || decodeError(t, rec).Fields[0].Field != "reason" {
+		t.Errorf("Expected a validation error for a long reason, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Lifetimes of share links
+const (
+	DefaultShareTTL = 24 * time.Hour
+	MaxShareTTL     = 30 * 24 * time.Hour
+)
+
+// Errors returned for share tokens that can't be used
+var (
+	errShareInvalid = errors.New("invalid share link")
+	errShareExpired = errors.New("share link has expired")
+)
+
+// SetShareKey sets the key share links are signed with. It is independent
+// of the API key, and changing it revokes every link issued with the old
+// one. An empty key disables sharing.
+func (s *Server) SetShareKey(key string) {
+	s.shareKey = []byte(key)
+}
+
+// ShareFileRequest represents a request for a share link
+type ShareFileRequest struct {
+	// ExpiresInSeconds is how long the link stays valid, DefaultShareTTL
+	// when zero
+	ExpiresInSeconds int `json:"expires_in_seconds,omitempty"`
+}
+
+// ShareFileResponse holds a share link and when it stops working
+type ShareFileResponse struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// shareFile issues a signed, time-limited link that downloads a file
+// without the API key
+func (s *Server) shareFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	if len(s.shareKey) == 0 {
+		writeError(w, http.StatusForbidden, CodeForbidden, "File sharing disabled: start the server with -share-key to enable it")
+		return
+	}
+
+	var req ShareFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	ttl := DefaultShareTTL
+	if req.ExpiresInSeconds != 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+		if ttl <= 0 || ttl > MaxShareTTL {
+			writeError(w, http.StatusBadRequest, CodeValidation, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int(MaxShareTTL.Seconds())))
+			return
+		}
+	}
+
+	if _, err := s.fileManager.GetFileRepository().GetFile(r.Context(), fileID); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	token := signShareToken(s.shareKey, fileID, expiresAt)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ShareFileResponse{
+		URL:       fmt.Sprintf("%s://%s/api/shared/%s", scheme, r.Host, token),
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// downloadSharedFile serves the file a valid share token points to
+func (s *Server) downloadSharedFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if len(s.shareKey) == 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, errShareInvalid.Error())
+		return
+	}
+
+	fileID, err := verifyShareToken(s.shareKey, vars["token"], time.Now())
+	if err != nil {
+		writeError(w, http.StatusForbidden, CodeForbidden, err.Error())
+		return
+	}
+
+	file, err := s.fileManager.GetFileRepository().GetFile(r.Context(), fileID)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	s.serveFile(w, r, file)
+}
+
+// signShareToken encodes the file ID and expiry and appends their HMAC, both
+// URL-safe
+func signShareToken(key []byte, fileID string, expiresAt time.Time) string {
+	payload := strconv.FormatInt(expiresAt.Unix(), 10) + ":" + fileID
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(shareMAC(key, payload))
+}
+
+// verifyShareToken checks a token's signature and expiry and returns the
+// file ID it was issued for
+func verifyShareToken(key []byte, token string, now time.Time) (string, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errShareInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errShareInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, shareMAC(key, string(payload))) {
+		return "", errShareInvalid
+	}
+
+	expiry, fileID, ok := strings.Cut(string(payload), ":")
+	if !ok || fileID == "" {
+		return "", errShareInvalid
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", errShareInvalid
+	}
+	if !now.Before(time.Unix(expiresAt, 0)) {
+		return "", errShareExpired
+	}
+	return fileID, nil
+}
+
+// shareMAC signs a share token payload
+func shareMAC(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/task"
+)
+
+// maxStatsWait caps how long GET /api/stats?wait= blocks, comfortably below
+// the idle timeouts of common proxies
+const maxStatsWait = time.Minute
+
+// parseStatsWait reads how long a stats request may wait for a change,
+// zero when it should return right away
+func parseStatsWait(query url.Values) (time.Duration, error) {
+	v := query.Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 || d > maxStatsWait {
+		return 0, fmt.Errorf("'wait' must be a positive duration up to %s, such as 30s", maxStatsWait)
+	}
+	return d, nil
+}
+
+// queueStats returns the queue statistics per tool with the executor's
+// worker utilization filled in
+func (s *Server) queueStats(ctx context.Context) map[string]task.QueueStats {
+	stats := s.manager.GetQueueStats(ctx)
+	for _, tool := range s.executor.GetTools() {
+		toolStats, ok := stats[tool.Name]
+		if !ok {
+			continue
+		}
+		toolStats.Workers = s.executor.WorkerCount(tool)
+		toolStats.BusyWorkers = s.executor.BusyWorkers(tool.Name)
+		toolStats.StalledTasks = s.executor.StalledTasks(tool.Name)
+		stats[tool.Name] = toolStats
+	}
+	return stats
+}
+
+// statsETag fingerprints stats. The queue wait grows with every millisecond
+// and is left out, or no two requests would ever see the same stats.
+func statsETag(stats map[string]task.QueueStats) string {
+	tools := make([]string, 0, len(stats))
+	for tool := range stats {
+		tools = append(tools, tool)
+	}
+	slices.Sort(tools)
+
+	h := fnv.New64a()
+	for _, tool := range tools {
+		toolStats := stats[tool]
+		toolStats.OldestQueueWaitMs = 0
+		fmt.Fprintf(h, "%+v\n", toolStats)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// waitForStats returns the stats once their ETag differs from etag, or the
+// latest ones when wait elapses or ctx is done first. Task events wake it up
+// to check, except output lines, which never change the counts.
+func (s *Server) waitForStats(ctx context.Context, etag string, wait time.Duration) map[string]task.QueueStats {
+	// Subscribe before reading the stats, so no change slips in between
+	sub := s.bus.Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer s.bus.Unsubscribe(sub)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	stats := s.queueStats(ctx)
+	for statsETag(stats) == etag {
+		select {
+		case <-ctx.Done():
+			return stats
+		case <-timer.C:
+			return stats
+		case event := <-sub.C:
+			if taskEvent, ok := event.Payload.(task.TaskEvent); ok && taskEvent.Type == "output" {
+				continue
+			}
+			stats = s.queueStats(ctx)
+		}
+	}
+	return stats
+}
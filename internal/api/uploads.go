@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// uploadFormOverhead allows for multipart headers and boundaries on top of
+// the file itself
+const uploadFormOverhead = 1 << 20
+
+// uploadIdleTimeout is how long an upload may go without receiving any data
+// before it is aborted. It replaces the server's read timeout, which would
+// cut off large uploads.
+const uploadIdleTimeout = time.Minute
+
+// SetUploader enables the upload endpoints
+func (s *Server) SetUploader(uploader *files.Uploader) {
+	s.uploader = uploader
+}
+
+// uploadFile stores the "file" field of a multipart form and registers it
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	if s.uploader == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Uploads not available")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, newIdleTimeoutBody(w, r.Body, uploadIdleTimeout), s.uploader.MaxBytes()+uploadFormOverhead)
+
+	// Stream the parts instead of buffering the whole form
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, CodeValidation, "Missing file field")
+			return
+		}
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		file, err := s.uploader.Upload(r.Context(), part.FileName(), part)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(file); err != nil {
+			writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+		}
+		return
+	}
+}
+
+// CreateUploadSessionRequest represents the start of a resumable upload
+type CreateUploadSessionRequest struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// UploadChunkResponse reports the state of a resumable upload. File is set
+// once the last chunk has been received.
+type UploadChunkResponse struct {
+	Session files.UploadSession `json:"session"`
+	File    *types.File         `json:"file,omitempty"`
+}
+
+// createUploadSession starts a resumable upload
+func (s *Server) createUploadSession(w http.ResponseWriter, r *http.Request) {
+	if s.uploader == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Uploads not available")
+		return
+	}
+
+	var req CreateUploadSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.uploader.StartSession(req.Filename, req.Size)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// getUploadSession returns the state of a resumable upload, letting clients
+// find the offset to resume from
+func (s *Server) getUploadSession(w http.ResponseWriter, r *http.Request) {
+	if s.uploader == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Uploads not available")
+		return
+	}
+
+	session, err := s.uploader.Session(mux.Vars(r)["id"])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// uploadChunk appends the request body to a resumable upload at the offset
+// given in the Upload-Offset header
+func (s *Server) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	if s.uploader == nil {
+		writeError(w, http.StatusNotImplemented, CodeNotImplemented, "Uploads not available")
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, CodeValidation, "Upload-Offset header must be a non-negative integer")
+		return
+	}
+
+	body := newIdleTimeoutBody(w, r.Body, uploadIdleTimeout)
+	session, file, err := s.uploader.WriteChunk(r.Context(), mux.Vars(r)["id"], offset, body)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(UploadChunkResponse{Session: session, File: file}); err != nil {
+		writeError(w, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+	}
+}
+
+// idleTimeoutBody extends the connection's read deadline before every read
+// of a request body, turning it into an idle timeout for long uploads. The
+// write deadline is moved along as well, it runs from the start of the
+// request and would otherwise expire before the response is written.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+// newIdleTimeoutBody wraps body so that a read blocking for longer than
+// timeout fails
+func newIdleTimeoutBody(w http.ResponseWriter, body io.ReadCloser, timeout time.Duration) io.ReadCloser {
+	return &idleTimeoutBody{ReadCloser: body, rc: http.NewResponseController(w), timeout: timeout}
+}
+
+// Read sets the next deadlines and reads into p
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(b.timeout)
+	if err := b.rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	if err := b.rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// writeUploadError reports an upload failure, treating a body cut off by
+// the request size limit as a too large upload
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, CodeTooLarge, files.ErrUploadTooLarge.Error())
+		return
+	}
+	writeServiceError(w, err)
+}
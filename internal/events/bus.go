@@ -0,0 +1,210 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Topic groups related events so subscribers only receive what they need
+type Topic string
+
+// Topics published by Commander
+const (
+	TopicTasks  Topic = "tasks"
+	TopicFiles  Topic = "files"
+	TopicSystem Topic = "system"
+)
+
+// DefaultBufferSize is the number of events a subscriber can fall behind
+// before events start being dropped for it
+const DefaultBufferSize = 100
+
+// DropPolicy decides which event a subscriber with a full buffer misses
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being published
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room, so a slow
+	// subscriber still ends up with the most recent events
+	DropOldest
+)
+
+// ParseDropPolicy parses "newest" or "oldest"
+func ParseDropPolicy(value string) (DropPolicy, error) {
+	switch value {
+	case "newest":
+		return DropNewest, nil
+	case "oldest":
+		return DropOldest, nil
+	default:
+		return 0, fmt.Errorf("unknown drop policy %q", value)
+	}
+}
+
+// SubscribeOptions configures how a subscription buffers events
+type SubscribeOptions struct {
+	BufferSize int
+	Policy     DropPolicy
+	// MaxMissed closes Overflow once the subscriber has missed this many
+	// events without catching up. Zero never closes it.
+	MaxMissed int
+}
+
+// Event is a message published on a topic. Payload is the topic's event
+// type, e.g. task.TaskEvent or files.FileEvent.
+type Event struct {
+	Topic   Topic
+	Payload interface{}
+}
+
+// Lagged is sent on TopicSystem to a subscriber that fell behind, before the
+// next event it receives. Missed is the number of events it didn't get.
+type Lagged struct {
+	Type   string `json:"type"`
+	Missed int    `json:"missed"`
+}
+
+// Subscription receives the events of the topics it subscribed to on C.
+// Overflow is closed when the subscriber keeps falling behind, see
+// SubscribeOptions.MaxMissed.
+type Subscription struct {
+	C        <-chan Event
+	Overflow <-chan struct{}
+
+	ch         chan Event
+	overflow   chan struct{}
+	topics     map[Topic]bool
+	policy     DropPolicy
+	maxMissed  int
+	mu         sync.Mutex
+	missed     int
+	overflowed bool
+}
+
+// wants reports whether the subscription receives events on topic
+func (s *Subscription) wants(topic Topic) bool {
+	return len(s.topics) == 0 || s.topics[topic]
+}
+
+// deliver sends an event without blocking. A subscriber whose buffer is full
+// misses an event, chosen by its drop policy, and is told how many it missed
+// once it catches up.
+func (s *Subscription) deliver(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.missed > 0 {
+		select {
+		case s.ch <- Event{Topic: TopicSystem, Payload: Lagged{Type: "lagged", Missed: s.missed}}:
+			s.missed = 0
+		default:
+			s.drop(event)
+			return
+		}
+	}
+
+	select {
+	case s.ch <- event:
+	default:
+		s.drop(event)
+	}
+}
+
+// drop handles an event that didn't fit in the buffer. Callers must hold
+// the lock.
+func (s *Subscription) drop(event Event) {
+	if s.policy == DropOldest {
+		select {
+		case old := <-s.ch:
+			// A dropped lag notice still has to be accounted for
+			if lagged, ok := old.Payload.(Lagged); ok && old.Topic == TopicSystem {
+				s.missed += lagged.Missed
+			} else {
+				s.missed++
+			}
+		default:
+		}
+		// Only the publisher sends, so the receive above made room
+		select {
+		case s.ch <- event:
+		default:
+			s.missed++
+		}
+	} else {
+		s.missed++
+	}
+
+	if s.maxMissed > 0 && s.missed >= s.maxMissed && !s.overflowed {
+		s.overflowed = true
+		close(s.overflow)
+	}
+}
+
+// Bus fans out published events to subscribers
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]bool
+}
+
+// NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe creates a subscription for the given topics, or for all topics
+// when none are given. Lagged notices are delivered regardless of topics.
+// Events that don't fit in the buffer are dropped with DropNewest.
+func (b *Bus) Subscribe(bufferSize int, topics ...Topic) *Subscription {
+	return b.SubscribeWith(SubscribeOptions{BufferSize: bufferSize}, topics...)
+}
+
+// SubscribeWith creates a subscription like Subscribe, with the buffering
+// described by opts
+func (b *Bus) SubscribeWith(opts SubscribeOptions, topics ...Topic) *Subscription {
+	ch := make(chan Event, opts.BufferSize)
+	overflow := make(chan struct{})
+	sub := &Subscription{
+		C:         ch,
+		Overflow:  overflow,
+		ch:        ch,
+		overflow:  overflow,
+		topics:    make(map[Topic]bool, len(topics)),
+		policy:    opts.Policy,
+		maxMissed: opts.MaxMissed,
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = true
+	return sub
+}
+
+// Unsubscribe removes a subscription and closes its channel
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish sends payload to every subscriber of topic without blocking
+func (b *Bus) Publish(topic Topic, payload interface{}) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	event := Event{Topic: topic, Payload: payload}
+	for sub := range b.subscribers {
+		if sub.wants(topic) {
+			sub.deliver(event)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// receive returns the next event on sub, failing the test if none arrives
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event := <-sub.C:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestBusTopicFiltering(t *testing.T) {
+	bus := NewBus()
+	tasks := bus.Subscribe(DefaultBufferSize, TopicTasks)
+	all := bus.Subscribe(DefaultBufferSize)
+
+	bus.Publish(TopicFiles, "file")
+	bus.Publish(TopicTasks, "task")
+
+	if event := receive(t, tasks); event.Topic != TopicTasks || event.Payload != "task" {
+		t.Errorf("Expected task event, got %+v", event)
+	}
+	if event := receive(t, all); event.Topic != TopicFiles || event.Payload != "file" {
+		t.Errorf("Expected file event first, got %+v", event)
+	}
+	if event := receive(t, all); event.Topic != TopicTasks {
+		t.Errorf("Expected task event second, got %+v", event)
+	}
+
+	select {
+	case event := <-tasks.C:
+		t.Errorf("Tasks subscriber received unexpected event %+v", event)
+	default:
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(DefaultBufferSize)
+
+	bus.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected channel to be closed after unsubscribe")
+	}
+
+	// Publishing and unsubscribing again must not panic
+	bus.Publish(TopicTasks, "task")
+	bus.Unsubscribe(sub)
+}
+
+func TestBusLagged(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(2, TopicTasks)
+
+	// Two events fit in the buffer, the next three are dropped
+	for i := 0; i < 5; i++ {
+		bus.Publish(TopicTasks, i)
+	}
+
+	if event := receive(t, sub); event.Payload != 0 {
+		t.Errorf("Expected first event, got %+v", event)
+	}
+	if event := receive(t, sub); event.Payload != 1 {
+		t.Errorf("Expected second event, got %+v", event)
+	}
+
+	// The subscriber caught up, so it is told what it missed before new events
+	bus.Publish(TopicTasks, 5)
+
+	event := receive(t, sub)
+	lagged, ok := event.Payload.(Lagged)
+	if event.Topic != TopicSystem || !ok || lagged.Missed != 3 {
+		t.Fatalf("Expected lagged notice for 3 events, got %+v", event)
+	}
+	if event := receive(t, sub); event.Payload != 5 {
+		t.Errorf("Expected event after lag notice, got %+v", event)
+	}
+}
+
+func TestBusDropOldest(t *testing.T) {
+	bus := NewBus()
+	sub := bus.SubscribeWith(SubscribeOptions{BufferSize: 2, Policy: DropOldest}, TopicTasks)
+
+	// The buffer keeps the two most recent events
+	for i := 0; i < 5; i++ {
+		bus.Publish(TopicTasks, i)
+	}
+
+	if event := receive(t, sub); event.Payload != 3 {
+		t.Errorf("Expected fourth event, got %+v", event)
+	}
+	if event := receive(t, sub); event.Payload != 4 {
+		t.Errorf("Expected fifth event, got %+v", event)
+	}
+
+	bus.Publish(TopicTasks, 5)
+
+	event := receive(t, sub)
+	lagged, ok := event.Payload.(Lagged)
+	if event.Topic != TopicSystem || !ok || lagged.Missed != 3 {
+		t.Fatalf("Expected lagged notice for 3 events, got %+v", event)
+	}
+	if event := receive(t, sub); event.Payload != 5 {
+		t.Errorf("Expected event after lag notice, got %+v", event)
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	for _, policy := range []DropPolicy{DropNewest, DropOldest} {
+		bus := NewBus()
+		sub := bus.SubscribeWith(SubscribeOptions{BufferSize: 1, Policy: policy, MaxMissed: 3})
+
+		for i := 0; i < 3; i++ {
+			bus.Publish(TopicTasks, i)
+		}
+		select {
+		case <-sub.Overflow:
+			t.Fatalf("Policy %d: overflow signaled after 2 missed events", policy)
+		default:
+		}
+
+		// Overflow is closed once and stays closed
+		bus.Publish(TopicTasks, 3)
+		bus.Publish(TopicTasks, 4)
+		select {
+		case <-sub.Overflow:
+		case <-time.After(time.Second):
+			t.Fatalf("Policy %d: expected overflow after 3 missed events", policy)
+		}
+	}
+}
+
+func TestParseDropPolicy(t *testing.T) {
+	if policy, err := ParseDropPolicy("oldest"); err != nil || policy != DropOldest {
+		t.Errorf("ParseDropPolicy(oldest) = %v, %v", policy, err)
+	}
+	if policy, err := ParseDropPolicy("newest"); err != nil || policy != DropNewest {
+		t.Errorf("ParseDropPolicy(newest) = %v, %v", policy, err)
+	}
+	if _, err := ParseDropPolicy("random"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
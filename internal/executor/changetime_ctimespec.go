@@ -0,0 +1,20 @@
+//go:build darwin || freebsd || netbsd
+
+package executor
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// changeTime returns when a file's contents or metadata last changed. Unlike
+// the modification time, tools can't set it to the time a download was
+// published.
+func changeTime(info fs.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Ctimespec.Unix())
+}
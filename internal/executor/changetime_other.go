@@ -0,0 +1,14 @@
+//go:build !linux && !openbsd && !dragonfly && !darwin && !freebsd && !netbsd
+
+package executor
+
+import (
+	"io/fs"
+	"time"
+)
+
+// changeTime returns the modification time, the change time isn't available
+// on this platform
+func changeTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,340 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lepinkainen/commander/internal/files"
+	"gopkg.in/yaml.v3"
+)
+
+// Upper bounds for per-tool settings, to catch typos like "workers": 400
+const (
+	maxWorkers   = 64
+	maxQueueSize = 10000
+	maxNice      = 19
+)
+
+// validateConfig checks a tool configuration for mistakes that would
+// otherwise surface later as confusing runtime behavior
+func validateConfig(config Config) error {
+	if len(config.Tools) == 0 {
+		return errors.New("no tools configured")
+	}
+
+	if config.MaxTasksPerHost < 0 {
+		return fmt.Errorf("max_tasks_per_host must not be negative, got %d", config.MaxTasksPerHost)
+	}
+	if config.MaxConcurrentTasks < 0 {
+		return fmt.Errorf("max_concurrent_tasks must not be negative, got %d", config.MaxConcurrentTasks)
+	}
+	switch config.Scheduling {
+	case "", SchedulingFIFO, SchedulingRoundRobin:
+	default:
+		return fmt.Errorf("scheduling must be %q or %q, got %q", SchedulingFIFO, SchedulingRoundRobin, config.Scheduling)
+	}
+
+	for i, route := range config.OrganizeRoutes {
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("organize_routes #%d: %w", i+1, err)
+		}
+	}
+	if err := files.ValidateMimeTypes(config.MimeTypes); err != nil {
+		return fmt.Errorf("mime_types: %w", err)
+	}
+
+	seen := make(map[string]bool, len(config.Tools))
+	for i, tool := range config.Tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool #%d: name is required", i+1)
+		}
+		if seen[tool.Name] {
+			return fmt.Errorf("tool %q: duplicate name", tool.Name)
+		}
+		seen[tool.Name] = true
+
+		if tool.Command == "" {
+			return fmt.Errorf("tool %q: command is required", tool.Name)
+		}
+		if tool.Workers < 0 || tool.Workers > maxWorkers {
+			return fmt.Errorf("tool %q: workers must be between 0 and %d, got %d", tool.Name, maxWorkers, tool.Workers)
+		}
+		if tool.QueueSize < 0 || tool.QueueSize > maxQueueSize {
+			return fmt.Errorf("tool %q: queue_size must be between 0 and %d, got %d", tool.Name, maxQueueSize, tool.QueueSize)
+		}
+		switch tool.ArgsPosition {
+		case "", ArgsPrepend, ArgsAppend:
+		default:
+			return fmt.Errorf("tool %q: args_position must be %q or %q, got %q", tool.Name, ArgsPrepend, ArgsAppend, tool.ArgsPosition)
+		}
+		if tool.Nice < 0 || tool.Nice > maxNice {
+			return fmt.Errorf("tool %q: nice must be between 0 and %d, got %d", tool.Name, maxNice, tool.Nice)
+		}
+		if tool.MaxRuntimeSeconds < 0 {
+			return fmt.Errorf("tool %q: max_runtime_seconds must not be negative, got %d", tool.Name, tool.MaxRuntimeSeconds)
+		}
+		if tool.OutputDirArg != "" && !strings.HasPrefix(tool.OutputDirArg, "-") {
+			return fmt.Errorf("tool %q: output_dir_arg must be a flag starting with '-', got %q", tool.Name, tool.OutputDirArg)
+		}
+		if tool.StallTimeoutSeconds < 0 {
+			return fmt.Errorf("tool %q: stall_timeout_seconds must not be negative, got %d", tool.Name, tool.StallTimeoutSeconds)
+		}
+		if tool.KillOnStall && tool.StallTimeoutSeconds == 0 {
+			return fmt.Errorf("tool %q: kill_on_stall needs stall_timeout_seconds", tool.Name)
+		}
+		if tool.OutputFlushLines < 0 || tool.OutputFlushLines > maxOutputFlushLines {
+			return fmt.Errorf("tool %q: output_flush_lines must be between 0 and %d, got %d", tool.Name, maxOutputFlushLines, tool.OutputFlushLines)
+		}
+		if tool.OutputFlushMs < 0 || tool.OutputFlushMs > maxOutputFlushMs {
+			return fmt.Errorf("tool %q: output_flush_ms must be between 0 and %d, got %d", tool.Name, maxOutputFlushMs, tool.OutputFlushMs)
+		}
+		if _, _, ok := parseVersion(tool.MinVersion); tool.MinVersion != "" && !ok {
+			return fmt.Errorf("tool %q: min_version must contain a version number, got %q", tool.Name, tool.MinVersion)
+		}
+		if tool.RequireMinVersion && tool.MinVersion == "" {
+			return fmt.Errorf("tool %q: require_min_version needs min_version", tool.Name)
+		}
+		if err := files.ValidateOrganizePattern(tool.OrganizePattern); err != nil {
+			return fmt.Errorf("tool %q: organize_pattern %q: %w", tool.Name, tool.OrganizePattern, err)
+		}
+		if _, err := buildOutputFilters(tool.OutputFilters); err != nil {
+			return fmt.Errorf("tool %q: %w", tool.Name, err)
+		}
+		for _, tag := range tool.DefaultTags {
+			if strings.TrimSpace(tag) == "" {
+				return fmt.Errorf("tool %q: default_tags must not contain empty tags", tool.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadConfig reads the tools configuration from path, which is either a
+// single JSON or YAML file or a directory of such files that are merged together
+func loadConfig(path string) (Config, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if info.IsDir() {
+		return loadConfigDir(path)
+	}
+
+	return loadConfigFile(path)
+}
+
+// loadConfigDir merges the tool definitions of every *.json, *.yaml and
+// *.yml file in dir, in file name order. A tool name defined in more than
+// one file is an error.
+func loadConfigDir(dir string) (Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to list config directory: %w", err)
+	}
+
+	var config Config
+	definedIn := make(map[string]string)
+	routesIn := ""
+	mimeTypesIn := make(map[string]string) // extension -> file setting it
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+
+		fileConfig, err := loadConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		if fileConfig.MaxTasksPerHost != 0 {
+			if config.MaxTasksPerHost != 0 && config.MaxTasksPerHost != fileConfig.MaxTasksPerHost {
+				return Config{}, fmt.Errorf("max_tasks_per_host is set to different values, %s sets %d", path, fileConfig.MaxTasksPerHost)
+			}
+			config.MaxTasksPerHost = fileConfig.MaxTasksPerHost
+		}
+		if fileConfig.MaxConcurrentTasks != 0 {
+			if config.MaxConcurrentTasks != 0 && config.MaxConcurrentTasks != fileConfig.MaxConcurrentTasks {
+				return Config{}, fmt.Errorf("max_concurrent_tasks is set to different values, %s sets %d", path, fileConfig.MaxConcurrentTasks)
+			}
+			config.MaxConcurrentTasks = fileConfig.MaxConcurrentTasks
+		}
+		if fileConfig.Scheduling != "" {
+			if config.Scheduling != "" && config.Scheduling != fileConfig.Scheduling {
+				return Config{}, fmt.Errorf("scheduling is set to different values, %s sets %q", path, fileConfig.Scheduling)
+			}
+			config.Scheduling = fileConfig.Scheduling
+		}
+
+		if len(fileConfig.OrganizeRoutes) > 0 {
+			if routesIn != "" {
+				return Config{}, fmt.Errorf("organize_routes is set in both %s and %s", routesIn, path)
+			}
+			routesIn = path
+			config.OrganizeRoutes = fileConfig.OrganizeRoutes
+		}
+
+		for ext, mimeType := range fileConfig.MimeTypes {
+			if other, exists := mimeTypesIn[ext]; exists && config.MimeTypes[ext] != mimeType {
+				return Config{}, fmt.Errorf("mime_types sets %s in both %s and %s", ext, other, path)
+			}
+			if config.MimeTypes == nil {
+				config.MimeTypes = make(map[string]string)
+			}
+			mimeTypesIn[ext] = path
+			config.MimeTypes[ext] = mimeType
+		}
+
+		for _, tool := range fileConfig.Tools {
+			if other, exists := definedIn[tool.Name]; exists && tool.Name != "" {
+				return Config{}, fmt.Errorf("tool %q is defined in both %s and %s", tool.Name, other, path)
+			}
+			definedIn[tool.Name] = path
+			config.Tools = append(config.Tools, tool)
+		}
+	}
+
+	return config, nil
+}
+
+// isConfigFile reports whether name has a supported config file extension
+func isConfigFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// isYAML reports whether path should be read and written as YAML
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// loadConfigFile reads a JSON or YAML config file, chosen by extension. The
+// file holds either a tools list with global settings or a single tool
+// definition.
+func loadConfigFile(path string) (Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Error closing config file: %v", err)
+		}
+	}()
+
+	var raw struct {
+		Tools              []Tool                `json:"tools" yaml:"tools"`
+		MaxTasksPerHost    int                   `json:"max_tasks_per_host" yaml:"max_tasks_per_host"`
+		MaxConcurrentTasks int                   `json:"max_concurrent_tasks" yaml:"max_concurrent_tasks"`
+		Scheduling         string                `json:"scheduling" yaml:"scheduling"`
+		OrganizeRoutes     []files.OrganizeRoute `json:"organize_routes" yaml:"organize_routes"`
+		MimeTypes          map[string]string     `json:"mime_types" yaml:"mime_types"`
+		Tool               `yaml:",inline"`
+	}
+	if isYAML(path) {
+		err = yaml.NewDecoder(file).Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			// An empty YAML document defines no tools
+			err = nil
+		}
+	} else {
+		err = json.NewDecoder(file).Decode(&raw)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to decode config %s: %w", path, err)
+	}
+
+	config := Config{
+		Tools:              raw.Tools,
+		MaxTasksPerHost:    raw.MaxTasksPerHost,
+		MaxConcurrentTasks: raw.MaxConcurrentTasks,
+		Scheduling:         raw.Scheduling,
+		OrganizeRoutes:     raw.OrganizeRoutes,
+		MimeTypes:          raw.MimeTypes,
+	}
+	if config.Tools == nil && (raw.Name != "" || raw.Command != "") {
+		config.Tools = []Tool{raw.Tool}
+	}
+	if err := expandConfigPaths(&config); err != nil {
+		return Config{}, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// expandConfigPaths expands "~" and environment variables, see
+// files.ExpandPath, in the config fields holding paths: each tool's
+// command, default args and output directory, and the directories of the
+// organize routes
+func expandConfigPaths(config *Config) error {
+	for i := range config.Tools {
+		tool := &config.Tools[i]
+
+		var err error
+		if tool.Command, err = files.ExpandPath(tool.Command); err != nil {
+			return fmt.Errorf("tool %q: %w", tool.Name, err)
+		}
+		if tool.OutputDir, err = files.ExpandPath(tool.OutputDir); err != nil {
+			return fmt.Errorf("tool %q: %w", tool.Name, err)
+		}
+		for j, arg := range tool.Args {
+			if tool.Args[j], err = files.ExpandPath(arg); err != nil {
+				return fmt.Errorf("tool %q: %w", tool.Name, err)
+			}
+		}
+	}
+	for i, route := range config.OrganizeRoutes {
+		expanded, err := files.ExpandPath(route.Directory)
+		if err != nil {
+			return fmt.Errorf("organize route for %s: %w", route.MimeType, err)
+		}
+		config.OrganizeRoutes[i].Directory = expanded
+	}
+	return nil
+}
+
+// writeConfig saves config to path as YAML or JSON depending on its extension
+func writeConfig(path string, config Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create config: %w", err)
+	}
+
+	if isYAML(path) {
+		encoder := yaml.NewEncoder(file)
+		encoder.SetIndent(2)
+		err = encoder.Encode(config)
+		if err == nil {
+			err = encoder.Close()
+		}
+	} else {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(config)
+	}
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lepinkainen/commander/internal/files"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		tools   []Tool
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			tools: []Tool{{Name: "wget", Command: "wget", Workers: 2, QueueSize: 50, Nice: 10, OrganizePattern: "{year}/{month}"}, {Name: "curl", Command: "curl"}},
+		},
+		{
+			name:    "no tools",
+			tools:   nil,
+			wantErr: "no tools configured",
+		},
+		{
+			name:    "empty name",
+			tools:   []Tool{{Name: "wget", Command: "wget"}, {Command: "curl"}},
+			wantErr: "tool #2: name is required",
+		},
+		{
+			name:    "duplicate name",
+			tools:   []Tool{{Name: "wget", Command: "wget"}, {Name: "wget", Command: "wget2"}},
+			wantErr: `tool "wget": duplicate name`,
+		},
+		{
+			name:    "empty command",
+			tools:   []Tool{{Name: "wget"}},
+			wantErr: `tool "wget": command is required`,
+		},
+		{
+			name:    "negative workers",
+			tools:   []Tool{{Name: "wget", Command: "wget", Workers: -1}},
+			wantErr: `tool "wget": workers must be between`,
+		},
+		{
+			name:    "too many workers",
+			tools:   []Tool{{Name: "wget", Command: "wget", Workers: maxWorkers + 1}},
+			wantErr: `tool "wget": workers must be between`,
+		},
+		{
+			name:    "negative queue size",
+			tools:   []Tool{{Name: "wget", Command: "wget", QueueSize: -5}},
+			wantErr: `tool "wget": queue_size must be between`,
+		},
+		{
+			name:    "negative nice",
+			tools:   []Tool{{Name: "wget", Command: "wget", Nice: -5}},
+			wantErr: `tool "wget": nice must be between`,
+		},
+		{
+			name:    "negative max runtime",
+			tools:   []Tool{{Name: "wget", Command: "wget", MaxRuntimeSeconds: -1}},
+			wantErr: `tool "wget": max_runtime_seconds must not be negative`,
+		},
+		{
+			name:    "output dir arg without dash",
+			tools:   []Tool{{Name: "gallery-dl", Command: "gallery-dl", OutputDirArg: "D"}},
+			wantErr: `tool "gallery-dl": output_dir_arg must be a flag`,
+		},
+		{
+			name:    "kill on stall without timeout",
+			tools:   []Tool{{Name: "wget", Command: "wget", KillOnStall: true}},
+			wantErr: `tool "wget": kill_on_stall needs stall_timeout_seconds`,
+		},
+		{
+			name:    "empty default tag",
+			tools:   []Tool{{Name: "gallery-dl", Command: "gallery-dl", DefaultTags: []string{"gallery", " "}}},
+			wantErr: `tool "gallery-dl": default_tags must not contain empty tags`,
+		},
+		{
+			name:    "unknown organize placeholder",
+			tools:   []Tool{{Name: "wget", Command: "wget", OrganizePattern: "{tool}/{week}"}},
+			wantErr: `tool "wget": organize_pattern "{tool}/{week}": unknown placeholder {week}`,
+		},
+		{
+			name:    "invalid redact pattern",
+			tools:   []Tool{{Name: "curl", Command: "curl", OutputFilters: []OutputFilterConfig{{Type: OutputFilterStripANSI}, {Type: OutputFilterRedact, Patterns: []string{"token=("}}}}},
+			wantErr: `tool "curl": output_filters #2: invalid pattern "token=("`,
+		},
+		{
+			name:    "unknown output filter",
+			tools:   []Tool{{Name: "curl", Command: "curl", OutputFilters: []OutputFilterConfig{{Type: "uppercase"}}}},
+			wantErr: `tool "curl": output_filters #1: type must be`,
+		},
+		{
+			name:    "unknown args position",
+			tools:   []Tool{{Name: "yt-dlp", Command: "yt-dlp", ArgsPosition: "before"}},
+			wantErr: `tool "yt-dlp": args_position must be "prepend" or "append", got "before"`,
+		},
+		{
+			name:    "negative output flush lines",
+			tools:   []Tool{{Name: "wget", Command: "wget", OutputFlushLines: -1}},
+			wantErr: `tool "wget": output_flush_lines must be between`,
+		},
+		{
+			name:    "output flush interval too long",
+			tools:   []Tool{{Name: "wget", Command: "wget", OutputFlushMs: maxOutputFlushMs + 1}},
+			wantErr: `tool "wget": output_flush_ms must be between`,
+		},
+		{
+			name:    "min version without number",
+			tools:   []Tool{{Name: "yt-dlp", Command: "yt-dlp", MinVersion: "latest"}},
+			wantErr: `tool "yt-dlp": min_version must contain a version number`,
+		},
+		{
+			name:    "require min version without min version",
+			tools:   []Tool{{Name: "yt-dlp", Command: "yt-dlp", RequireMinVersion: true}},
+			wantErr: `tool "yt-dlp": require_min_version needs min_version`,
+		},
+		{
+			name:    "nice too high",
+			tools:   []Tool{{Name: "wget", Command: "wget", Nice: maxNice + 1}},
+			wantErr: `tool "wget": nice must be between`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(Config{Tools: tt.tools})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected valid config, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigScheduling(t *testing.T) {
+	tools := []Tool{{Name: "wget", Command: "wget"}}
+
+	if err := validateConfig(Config{Tools: tools, MaxConcurrentTasks: 2, Scheduling: SchedulingRoundRobin}); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	if err := validateConfig(Config{Tools: tools, MaxConcurrentTasks: -1}); err == nil || !strings.Contains(err.Error(), "max_concurrent_tasks") {
+		t.Errorf("Expected max_concurrent_tasks error, got %v", err)
+	}
+	if err := validateConfig(Config{Tools: tools, Scheduling: "lifo"}); err == nil || !strings.Contains(err.Error(), "scheduling must be") {
+		t.Errorf("Expected scheduling error, got %v", err)
+	}
+}
+
+func TestValidateConfigOrganizeRoutes(t *testing.T) {
+	tools := []Tool{{Name: "wget", Command: "wget"}}
+
+	valid := []files.OrganizeRoute{{MimeType: "video/*", Directory: "/media/video"}, {MimeType: "image/png", Directory: "/media/images"}}
+	if err := validateConfig(Config{Tools: tools, OrganizeRoutes: valid}); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	invalid := []files.OrganizeRoute{{MimeType: "video/*", Directory: "/media/video"}, {MimeType: "video", Directory: "/media/video"}}
+	if err := validateConfig(Config{Tools: tools, OrganizeRoutes: invalid}); err == nil || !strings.Contains(err.Error(), "organize_routes #2: mime_type") {
+		t.Errorf("Expected organize_routes error, got %v", err)
+	}
+}
+
+func TestLoadConfigMimeTypes(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "a.json", `{"name": "wget", "command": "wget", "mime_types": {".ts": "video/mp2t"}}`)
+	writeConfigFile(t, dir, "b.yml", "name: yt-dlp\ncommand: yt-dlp\nmime_types:\n  .ts: video/mp2t\n  .webp: image/webp\n")
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	want := map[string]string{".ts": "video/mp2t", ".webp": "image/webp"}
+	if !reflect.DeepEqual(config.MimeTypes, want) {
+		t.Errorf("Expected merged MIME types %v, got %v", want, config.MimeTypes)
+	}
+
+	// Files may repeat an override, but not contradict it
+	writeConfigFile(t, dir, "c.json", `{"name": "curl", "command": "curl", "mime_types": {".ts": "text/x-typescript"}}`)
+	if _, err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "mime_types sets .ts") {
+		t.Errorf("Expected a conflicting mime_types error, got %v", err)
+	}
+
+	tools := []Tool{{Name: "wget", Command: "wget"}}
+	for _, invalid := range []map[string]string{{"ts": "video/mp2t"}, {".ts": "video"}, {".tar.gz": "application/gzip"}} {
+		if err := validateConfig(Config{Tools: tools, MimeTypes: invalid}); err == nil || !strings.Contains(err.Error(), "mime_types") {
+			t.Errorf("Expected a mime_types error for %v, got %v", invalid, err)
+		}
+	}
+}
+
+// writeConfigFile writes content to name inside dir
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("single file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeConfigFile(t, dir, "tools.json", `{"tools": [{"name": "wget", "command": "wget"}]}`)
+
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if len(config.Tools) != 1 || config.Tools[0].Name != "wget" {
+			t.Errorf("Expected wget tool, got %+v", config.Tools)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFile(t, dir, "10-downloaders.json", `{"tools": [{"name": "wget", "command": "wget"}, {"name": "curl", "command": "curl"}]}`)
+		writeConfigFile(t, dir, "20-yt-dlp.json", `{"name": "yt-dlp", "command": "yt-dlp", "workers": 2}`)
+		writeConfigFile(t, dir, "README.md", `not a config file`)
+
+		config, err := loadConfig(dir)
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+
+		var names []string
+		for _, tool := range config.Tools {
+			names = append(names, tool.Name)
+		}
+		if strings.Join(names, ",") != "wget,curl,yt-dlp" {
+			t.Errorf("Expected tools wget,curl,yt-dlp, got %v", names)
+		}
+		if config.Tools[2].Workers != 2 {
+			t.Errorf("Expected yt-dlp workers 2, got %d", config.Tools[2].Workers)
+		}
+	})
+
+	t.Run("directory name collision", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFile(t, dir, "a.json", `{"name": "wget", "command": "wget"}`)
+		writeConfigFile(t, dir, "b.json", `{"tools": [{"name": "wget", "command": "wget2"}]}`)
+
+		_, err := loadConfig(dir)
+		if err == nil || !strings.Contains(err.Error(), `tool "wget" is defined in both`) {
+			t.Errorf("Expected collision error, got %v", err)
+		}
+	})
+
+	t.Run("directory host limit", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFile(t, dir, "a.json", `{"tools": [{"name": "wget", "command": "wget"}], "max_tasks_per_host": 3}`)
+		writeConfigFile(t, dir, "b.json", `{"name": "curl", "command": "curl"}`)
+
+		config, err := loadConfig(dir)
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if config.MaxTasksPerHost != 3 {
+			t.Errorf("Expected max_tasks_per_host 3, got %d", config.MaxTasksPerHost)
+		}
+
+		writeConfigFile(t, dir, "c.json", `{"tools": [], "max_tasks_per_host": 1}`)
+		if _, err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "max_tasks_per_host") {
+			t.Errorf("Expected conflicting max_tasks_per_host error, got %v", err)
+		}
+	})
+
+	t.Run("directory scheduling", func(t *testing.T) {
+		dir := t.TempDir()
+		writeConfigFile(t, dir, "a.json", `{"tools": [{"name": "wget", "command": "wget"}], "max_concurrent_tasks": 4, "scheduling": "fifo"}`)
+		writeConfigFile(t, dir, "b.json", `{"name": "curl", "command": "curl"}`)
+
+		config, err := loadConfig(dir)
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if config.MaxConcurrentTasks != 4 || config.Scheduling != SchedulingFIFO {
+			t.Errorf("Expected 4 concurrent tasks scheduled fifo, got %d %q", config.MaxConcurrentTasks, config.Scheduling)
+		}
+
+		writeConfigFile(t, dir, "c.json", `{"tools": [], "scheduling": "round_robin"}`)
+		if _, err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "scheduling") {
+			t.Errorf("Expected conflicting scheduling error, got %v", err)
+		}
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		_, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected fs.ErrNotExist, got %v", err)
+		}
+	})
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	want := Config{
+		Tools: []Tool{
+			{Name: "yt-dlp", Command: "yt-dlp", Description: "Video downloader", Workers: 2, QueueSize: 20, Args: []string{"--newline", "-o", "%(title)s.%(ext)s"}},
+			{Name: "wget", Command: "wget", Description: "Web downloader"},
+		},
+		MaxTasksPerHost:    2,
+		MaxConcurrentTasks: 4,
+		Scheduling:         SchedulingFIFO,
+	}
+
+	loaded := make(map[string]Config)
+	for _, name := range []string{"tools.json", "tools.yaml", "tools.yml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := writeConfig(path, want); err != nil {
+			t.Fatalf("writeConfig(%s) error = %v", name, err)
+		}
+
+		got, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("loadConfig(%s) error = %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip mismatch\nwant %+v\ngot  %+v", name, want, got)
+		}
+		loaded[name] = got
+	}
+
+	if !reflect.DeepEqual(loaded["tools.json"], loaded["tools.yaml"]) {
+		t.Error("JSON and YAML configs should load identically")
+	}
+}
+
+func TestLoadConfigExpandsPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("MEDIA", "/srv/media")
+
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "tools.json", `{
+		"tools": [{
+			"name": "gallery-dl",
+			"command": "~/bin/gallery-dl",
+			"default_args": ["-d", "$MEDIA/gallery", "--config", "${HOME}/gallery.conf", "~user"],
+			"output_dir": "~/downloads"
+		}],
+		"organize_routes": [{"mime_type": "video/*", "directory": "$MEDIA/video"}]
+	}`)
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	tool := config.Tools[0]
+	if tool.Command != home+"/bin/gallery-dl" {
+		t.Errorf("Expected ~ expanded in the command, got %s", tool.Command)
+	}
+	wantArgs := []string{"-d", "/srv/media/gallery", "--config", home + "/gallery.conf", "~user"}
+	if !reflect.DeepEqual(tool.Args, wantArgs) {
+		t.Errorf("Expected args %v, got %v", wantArgs, tool.Args)
+	}
+	if tool.OutputDir != home+"/downloads" {
+		t.Errorf("Expected ~ expanded in output_dir, got %s", tool.OutputDir)
+	}
+	if len(config.OrganizeRoutes) != 1 || config.OrganizeRoutes[0].Directory != "/srv/media/video" {
+		t.Errorf("Expected the route directory expanded, got %+v", config.OrganizeRoutes)
+	}
+}
+
+func TestLoadConfigYAMLDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "gallery-dl.yml", `
+# Comments are the reason YAML is supported
+name: gallery-dl
+command: gallery-dl
+workers: 2
+default_args: ["-d", "~/Downloads/"]
+`)
+	writeConfigFile(t, dir, "wget.json", `{"name": "wget", "command": "wget"}`)
+
+	config, err := loadConfig(dir)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(config.Tools) != 2 || config.Tools[0].Name != "gallery-dl" || config.Tools[1].Name != "wget" {
+		t.Fatalf("Expected gallery-dl and wget, got %+v", config.Tools)
+	}
+	if len(config.Tools[0].Args) != 2 || config.Tools[0].Workers != 2 {
+		t.Errorf("Unexpected gallery-dl config: %+v", config.Tools[0])
+	}
+}
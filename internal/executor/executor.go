@@ -3,30 +3,177 @@ package executor
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/task"
 	"github.com/lepinkainen/commander/internal/types"
 )
 
+// DefaultMaxLineLength is the longest task output line kept in full, in
+// bytes, unless SetMaxLineLength changes it
+const DefaultMaxLineLength = 64 << 10
+
+// DefaultQueueSize is the number of tasks that can wait in a tool's queue
+// unless the tool sets queue_size
+const DefaultQueueSize = 100
+
 // Tool represents a CLI tool configuration
 type Tool struct {
-	Name        string   `json:"name"`
-	Command     string   `json:"command"`
-	Description string   `json:"description"`
-	Workers     int      `json:"workers,omitempty"`
-	Args        []string `json:"default_args,omitempty"`
+	Name        string   `json:"name" yaml:"name"`
+	Command     string   `json:"command" yaml:"command"`
+	Description string   `json:"description" yaml:"description"`
+	Workers     int      `json:"workers,omitempty" yaml:"workers,omitempty"`
+	QueueSize   int      `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	Args        []string `json:"default_args,omitempty" yaml:"default_args,omitempty"`
+
+	// ArgsPosition places the default args before (ArgsPrepend) or after
+	// (ArgsAppend) the task's own. Empty means ArgsPrepend.
+	ArgsPosition string `json:"args_position,omitempty" yaml:"args_position,omitempty"`
+
+	// Nice lowers the CPU priority of the tool's processes, from 0 (normal)
+	// to 19 (lowest). Negative values need privileges and are rejected.
+	Nice int `json:"nice,omitempty" yaml:"nice,omitempty"`
+
+	// Organize moves files discovered in the tool's output into the tool's
+	// directory by default. Tasks can override it; when off, files are
+	// registered where the tool wrote them.
+	Organize bool `json:"organize,omitempty" yaml:"organize,omitempty"`
+
+	// DefaultTags are added to every file discovered for the tool's tasks,
+	// together with any tags the task asks for
+	DefaultTags []string `json:"default_tags,omitempty" yaml:"default_tags,omitempty"`
+
+	// OrganizePattern is where discovered files are moved inside the tool's
+	// directory, e.g. "{year}/{month}". Empty means files.DefaultOrganizePattern.
+	OrganizePattern string `json:"organize_pattern,omitempty" yaml:"organize_pattern,omitempty"`
+
+	// OutputDirArg is the flag the tool takes its output directory with,
+	// e.g. "-D" for gallery-dl. When a task passes it, every file that is
+	// new or changed in that directory after a successful run is registered,
+	// instead of discovering files from the output.
+	OutputDirArg string `json:"output_dir_arg,omitempty" yaml:"output_dir_arg,omitempty"`
+
+	// OutputDir is a directory the tool always writes into, for tools that
+	// are configured to use it rather than told on the command line. Its
+	// files are registered like those of OutputDirArg, which takes
+	// precedence when a task passes it.
+	OutputDir string `json:"output_dir,omitempty" yaml:"output_dir,omitempty"`
+
+	// MaxRuntimeSeconds is a hard ceiling on how long the tool's processes
+	// may run before they are killed. Zero means no limit. A smaller
+	// per-task timeout takes precedence.
+	MaxRuntimeSeconds int `json:"max_runtime_seconds,omitempty" yaml:"max_runtime_seconds,omitempty"`
+
+	// StallTimeoutSeconds reports a running task as stalled when its process
+	// produces no output for that long, e.g. on a hung network socket. Zero
+	// disables the check. Unlike the runtime limit it only measures silence,
+	// so long tasks that keep making progress are never affected.
+	StallTimeoutSeconds int `json:"stall_timeout_seconds,omitempty" yaml:"stall_timeout_seconds,omitempty"`
+
+	// KillOnStall kills a stalled task's process and fails the task instead
+	// of only reporting the stall
+	KillOnStall bool `json:"kill_on_stall,omitempty" yaml:"kill_on_stall,omitempty"`
+
+	// Idempotent marks tools that can safely run a task again from scratch.
+	// Their tasks interrupted by a server restart are queued again instead
+	// of being marked failed.
+	Idempotent bool `json:"idempotent,omitempty" yaml:"idempotent,omitempty"`
+
+	// OutputFilters rewrite each output line in order before it is stored
+	// or broadcast, e.g. to redact secrets or strip colors
+	OutputFilters []OutputFilterConfig `json:"output_filters,omitempty" yaml:"output_filters,omitempty"`
+
+	// OutputFlushLines and OutputFlushMs tune how output is batched before
+	// it is stored and streamed: a batch is written once it holds that many
+	// lines or that long after its first line. Larger values mean fewer
+	// database writes for chatty tools but later updates on the WebSocket
+	// stream. Zero uses DefaultOutputFlushLines and
+	// DefaultOutputFlushInterval, one line writes every line right away.
+	OutputFlushLines int `json:"output_flush_lines,omitempty" yaml:"output_flush_lines,omitempty"`
+	OutputFlushMs    int `json:"output_flush_ms,omitempty" yaml:"output_flush_ms,omitempty"`
+
+	// MinVersion is the oldest version of the tool its configuration works
+	// with, e.g. "2023.07.06" for patterns matching yt-dlp's output. The
+	// version is detected on startup with VersionCommand, and an older one
+	// is reported.
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+
+	// VersionCommand is the command line printing the tool's version, the
+	// first number in its output is taken as the version. Empty means the
+	// tool's command with --version.
+	VersionCommand string `json:"version_command,omitempty" yaml:"version_command,omitempty"`
+
+	// RequireMinVersion disables the tool when it is older than MinVersion,
+	// instead of only reporting it
+	RequireMinVersion bool `json:"require_min_version,omitempty" yaml:"require_min_version,omitempty"`
 }
 
 // Config represents the tools configuration
 type Config struct {
-	Tools []Tool `json:"tools"`
+	Tools []Tool `json:"tools" yaml:"tools"`
+
+	// MaxTasksPerHost caps how many tasks may download from the same host at
+	// once across all tools. Zero means unlimited.
+	MaxTasksPerHost int `json:"max_tasks_per_host,omitempty" yaml:"max_tasks_per_host,omitempty"`
+
+	// MaxConcurrentTasks caps how many tasks may run at once across all
+	// tools, on top of each tool's workers. Zero means unlimited.
+	MaxConcurrentTasks int `json:"max_concurrent_tasks,omitempty" yaml:"max_concurrent_tasks,omitempty"`
+
+	// Scheduling decides which waiting task gets a free global slot,
+	// SchedulingFIFO or SchedulingRoundRobin. Empty means DefaultScheduling.
+	Scheduling string `json:"scheduling,omitempty" yaml:"scheduling,omitempty"`
+
+	// OrganizeRoutes send organized files to a base directory by MIME type,
+	// e.g. videos to /media/video. Files no route matches go to their
+	// tool's directory.
+	OrganizeRoutes []files.OrganizeRoute `json:"organize_routes,omitempty" yaml:"organize_routes,omitempty"`
+
+	// MimeTypes override the MIME type files are recorded with by
+	// extension, e.g. ".ts": "video/mp2t", for extensions the system's MIME
+	// database gets wrong or doesn't know
+	MimeTypes map[string]string `json:"mime_types,omitempty" yaml:"mime_types,omitempty"`
+}
+
+// Where a tool's default args go relative to a task's own args
+const (
+	ArgsPrepend = "prepend"
+	ArgsAppend  = "append"
+)
+
+// Errors returned by CheckTool
+var (
+	ErrUnknownTool    = errors.New("unknown tool")
+	ErrCommandMissing = errors.New("command not found")
+	ErrVersionTooOld  = errors.New("version is too old")
+)
+
+// CommandRunner creates the processes that run tasks
+type CommandRunner interface {
+	Command(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+// execRunner runs tasks with os/exec, killing them when ctx is done
+type execRunner struct{}
+
+// Command returns exec.CommandContext(ctx, name, args...)
+func (execRunner) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
 }
 
 // Executor manages command execution
@@ -34,42 +181,113 @@ type Executor struct {
 	config  Config
 	manager *task.Manager
 	workers int
+	hosts   *hostLimiter
+	slots   *slotScheduler
+	usage   *workerUsage
+	output  *outputActivity
+	runner  CommandRunner
 	ctx     context.Context
 	cancel  context.CancelFunc
 	wg      sync.WaitGroup
+
+	// Output lines are truncated beyond this many bytes, zero keeps them
+	maxLineLength int
+
+	// Output filter chains by tool name
+	filters map[string][]OutputFilter
+
+	// Running workers and runtime worker counts by tool name
+	poolsMu     sync.RWMutex
+	pools       map[string]*workerPool
+	overrides   map[string]int
+	workerStore storage.WorkerStore
+
+	// Tools whose command wasn't found or was older than required when the
+	// executor started, and the versions detected then
+	missingMu sync.RWMutex
+	missing   map[string]bool
+	outdated  map[string]bool
+	versions  map[string]string
+
+	// Pause state: paused is closed while paused and resumed while not,
+	// so workers can wait for either
+	pauseMu sync.Mutex
+	paused  chan struct{}
+	resumed chan struct{}
 }
 
 // NewExecutor creates a new executor
 func NewExecutor(configPath string, defaultWorkers int, manager *task.Manager) (*Executor, error) {
-	// Load configuration
-	file, err := os.Open(configPath)
+	if defaultWorkers < 1 || defaultWorkers > maxWorkers {
+		return nil, fmt.Errorf("default workers must be between 1 and %d, got %d", maxWorkers, defaultWorkers)
+	}
+
+	// Load configuration from a single file or a directory of tool files
+	config, err := loadConfig(configPath)
 	if err != nil {
-		// Create default config if file doesn't exist
-		if os.IsNotExist(err) {
+		// Create default config if nothing exists at the path
+		if errors.Is(err, fs.ErrNotExist) {
 			return createDefaultExecutor(configPath, defaultWorkers, manager)
 		}
-		return nil, fmt.Errorf("failed to open config: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			log.Printf("Error closing config file: %v", err)
-		}
-	}()
 
-	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return nil, fmt.Errorf("failed to decode config: %w", err)
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", configPath, err)
 	}
 
+	return newExecutor(config, defaultWorkers, manager), nil
+}
+
+// newExecutor creates an executor for an already validated configuration
+func newExecutor(config Config, defaultWorkers int, manager *task.Manager) *Executor {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Executor{
 		config:  config,
 		manager: manager,
 		workers: defaultWorkers,
+		hosts:   newHostLimiter(config.MaxTasksPerHost),
+		slots:   newSlotScheduler(config.MaxConcurrentTasks, config.Scheduling, toolNames(config.Tools)),
+		usage:   newWorkerUsage(),
+		output:  newOutputActivity(),
+		runner:  execRunner{},
 		ctx:     ctx,
 		cancel:  cancel,
-	}, nil
+
+		maxLineLength: DefaultMaxLineLength,
+		filters:       toolOutputFilters(config.Tools),
+		pools:         make(map[string]*workerPool),
+		overrides:     make(map[string]int),
+		paused:        make(chan struct{}),
+		resumed:       closedChan(),
+	}
+}
+
+// toolOutputFilters builds the output filter chain of every tool that has
+// one. The config has been validated, so a broken chain is only logged.
+func toolOutputFilters(tools []Tool) map[string][]OutputFilter {
+	filters := make(map[string][]OutputFilter)
+	for _, tool := range tools {
+		chain, err := buildOutputFilters(tool.OutputFilters)
+		if err != nil {
+			log.Printf("Warning: ignoring output filters of tool %s: %v", tool.Name, err)
+			continue
+		}
+		if len(chain) > 0 {
+			filters[tool.Name] = chain
+		}
+	}
+	return filters
+}
+
+// toolNames returns the names of tools in config order
+func toolNames(tools []Tool) []string {
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Name
+	}
+	return names
 }
 
 // createDefaultExecutor creates an executor with default configuration
@@ -81,6 +299,7 @@ func createDefaultExecutor(configPath string, defaultWorkers int, manager *task.
 				Command:     "yt-dlp",
 				Description: "YouTube downloader",
 				Workers:     2,
+				Nice:        10,
 			},
 			{
 				Name:        "gallery-dl",
@@ -99,6 +318,7 @@ func createDefaultExecutor(configPath string, defaultWorkers int, manager *task.
 				Command:     "ffmpeg",
 				Description: "Media converter",
 				Workers:     2,
+				Nice:        10,
 			},
 			{
 				Name:        "curl",
@@ -107,53 +327,37 @@ func createDefaultExecutor(configPath string, defaultWorkers int, manager *task.
 				Workers:     4,
 			},
 		},
+		MaxTasksPerHost: 2,
 	}
 
-	// Save default config
-	if err := os.MkdirAll("./config", 0o755); err != nil {
-		log.Printf("Warning: failed to create config directory: %v", err)
-	}
-	file, err := os.Create(configPath)
-	if err != nil {
+	// Save default config in the format the path implies
+	if err := writeConfig(configPath, config); err != nil {
 		log.Printf("Warning: failed to save default config: %v", err)
-	} else {
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(config); err != nil {
-			log.Printf("Warning: failed to encode config: %v", err)
-		}
-		if err := file.Close(); err != nil {
-			log.Printf("Warning: failed to close config file: %v", err)
-		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
-	return &Executor{
-		config:  config,
-		manager: manager,
-		workers: defaultWorkers,
-		ctx:     ctx,
-		cancel:  cancel,
-	}, nil
+	return newExecutor(config, defaultWorkers, manager), nil
 }
 
-// Start starts the executor workers
+// Start starts the executor workers. Tools whose command isn't installed or
+// is too old still get workers, but CheckTool reports them so no tasks are
+// accepted.
 func (e *Executor) Start() error {
+	e.checkCommands()
+	e.checkVersions()
+	e.loadWorkerOverrides(e.ctx)
+
 	for _, tool := range e.config.Tools {
-		workers := tool.Workers
-		if workers == 0 {
-			workers = e.workers
-		}
+		workers := e.WorkerCount(tool)
 
 		// Create queue for this tool
-		queue := e.manager.CreateQueue(tool.Name, 100)
+		e.manager.CreateQueue(tool.Name, e.QueueSize(tool))
 
 		// Start workers for this tool
-		for i := 0; i < workers; i++ {
-			e.wg.Add(1)
-			go e.worker(tool, queue)
-		}
+		pool := &workerPool{}
+		e.poolsMu.Lock()
+		e.pools[tool.Name] = pool
+		e.resizePool(tool, pool, workers)
+		e.poolsMu.Unlock()
 
 		log.Printf("Started %d workers for %s", workers, tool.Name)
 	}
@@ -161,49 +365,204 @@ func (e *Executor) Start() error {
 	return nil
 }
 
+// RecoverInterrupted requeues or fails the tasks left running by a previous
+// server process, depending on whether their tool is idempotent. It must be
+// called after Start so the queues exist.
+func (e *Executor) RecoverInterrupted(ctx context.Context) error {
+	requeued, failed, err := e.manager.RecoverInterrupted(ctx, func(toolName string) bool {
+		tool, ok := e.GetTool(toolName)
+		return ok && tool.Idempotent
+	})
+	if requeued > 0 || failed > 0 {
+		log.Printf("Recovered interrupted tasks: %d requeued, %d marked failed", requeued, failed)
+	}
+	return err
+}
+
 // Stop stops all workers
 func (e *Executor) Stop() {
+	// Taking the pools lock waits for a SetWorkers call adding workers
+	e.poolsMu.Lock()
 	e.cancel()
+	e.poolsMu.Unlock()
 	e.wg.Wait()
 }
 
-// worker processes tasks from a queue
-func (e *Executor) worker(tool Tool, queue chan *task.Task) {
+// worker processes tasks from the tool's queue until the executor stops or
+// stop is closed
+func (e *Executor) worker(tool Tool, stop <-chan struct{}) {
 	defer e.wg.Done()
 
+	queue, ok := e.manager.Queue(tool.Name)
+	if !ok {
+		return
+	}
+
 	for {
+		// Prefer draining over picking up another queued task
 		select {
-		case <-e.ctx.Done():
+		case <-stop:
 			return
-		case t := <-queue:
-			if t == nil {
+		default:
+		}
+
+		// Leave queued tasks alone while paused
+		paused, resumed := e.pauseState()
+		select {
+		case <-paused:
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-stop:
 				return
+			case <-resumed:
 			}
-			e.executeTask(tool, t)
+			continue
+		default:
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-paused:
+			continue
+		case t, open := <-queue:
+			if !open {
+				// The queue was recreated with another size, its waiting
+				// tasks were moved to the new one
+				if queue, ok = e.manager.Queue(tool.Name); !ok {
+					return
+				}
+				continue
+			}
+			e.runTask(tool, t)
 		}
 	}
 }
 
+// runTask executes a task, failing it if the execution panics so that the
+// worker survives a bug triggered by one task. Panics in the output reading
+// goroutines aren't caught here.
+func (e *Executor) runTask(tool Tool, t *task.Task) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("Task %s panicked: %v\n%s", t.ID, r, debug.Stack())
+		t.SetError(fmt.Sprintf("Task panicked: %v", r))
+		if err := e.manager.UpdateTaskStatus(context.Background(), t.ID, types.StatusFailed); err != nil {
+			log.Printf("Failed to update task status: %v", err)
+		}
+	}()
+
+	e.executeTask(tool, t)
+}
+
 // executeTask executes a single task
 func (e *Executor) executeTask(tool Tool, t *task.Task) {
+	// Tasks canceled while queued stay in the queue, drop them here.
+	// Claiming the task also stops further edits to it.
+	if !e.manager.ClaimTask(t) {
+		e.skipCanceledTask(t)
+		return
+	}
+
 	log.Printf("Executing task %s with %s", t.ID, tool.Name)
 
+	// The worker counts as busy from picking up the task until it's done,
+	// including any wait for a host slot
+	finish := e.usage.start(tool.Name)
+	defer finish()
+
+	// Bookkeeping writes must outlive executor shutdown so that a canceled
+	// task can still be recorded as such.
+	ctx := context.Background()
+
+	// Canceling the task or stopping the executor aborts the wait for a host
+	// slot and kills the process and any children it spawned
+	taskCtx, cancelTask := context.WithCancel(e.ctx)
+	defer cancelTask()
+	t.SetCancelFunc(cancelTask)
+
+	// Wait for a free slot if other tasks are already using this host
+	host := types.ExtractHost(t.Args)
+	release, err := e.hosts.acquire(taskCtx, host, func() {
+		e.manager.PublishEvent(task.TaskEvent{
+			TaskID: t.ID,
+			Type:   "waiting",
+			Data:   fmt.Sprintf("Waiting for host slot for %s", host),
+		})
+	})
+	if err != nil {
+		if e.ctx.Err() == nil {
+			// Canceled by the user while waiting
+			e.skipCanceledTask(t)
+			return
+		}
+		// The executor is shutting down
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusCanceled); updateErr != nil {
+			log.Printf("Failed to update task status: %v", updateErr)
+		}
+		return
+	}
+	defer release()
+
+	// Then wait for the scheduler to grant one of the global slots
+	releaseSlot, err := e.slots.acquire(taskCtx, tool.Name, t.CreatedAt, func() {
+		e.manager.PublishEvent(task.TaskEvent{
+			TaskID: t.ID,
+			Type:   "waiting",
+			Data:   "Waiting for a free task slot",
+		})
+	})
+	if err != nil {
+		if e.ctx.Err() == nil {
+			e.skipCanceledTask(t)
+			return
+		}
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusCanceled); updateErr != nil {
+			log.Printf("Failed to update task status: %v", updateErr)
+		}
+		return
+	}
+	defer releaseSlot()
+
+	// Record exactly what runs, it is saved with the running status
+	argv := append([]string{t.Command}, CommandArgs(tool, t.Args)...)
+	t.SetArgv(argv)
+
 	// Update status to running
-	if err := e.manager.UpdateTaskStatus(t.ID, types.StatusRunning); err != nil {
+	if err := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusRunning); err != nil {
 		log.Printf("Failed to update task status to running: %v", err)
 	}
 
+	// Limit the runtime from here on, time spent waiting doesn't count
+	limit := runtimeLimit(tool, t.TimeoutSeconds)
+	runCtx := taskCtx
+	if limit.duration > 0 {
+		var cancelRun context.CancelFunc
+		runCtx, cancelRun = context.WithTimeout(taskCtx, limit.duration)
+		defer cancelRun()
+	}
+
+	// A stalled task is killed through its own context, so that it can be
+	// told apart from hitting the runtime limit
+	procCtx, killProcess := context.WithCancel(runCtx)
+	defer killProcess()
+	var stalled atomic.Bool
+
 	// Prepare command
-	args := make([]string, len(tool.Args)+len(t.Args))
-	copy(args, tool.Args)
-	copy(args[len(tool.Args):], t.Args)
-	cmd := exec.CommandContext(e.ctx, t.Command, args...)
+	cmd := e.runner.Command(procCtx, argv[0], argv[1:]...)
+	setProcessGroup(cmd)
 
 	// Get stdout and stderr pipes
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		t.SetError(fmt.Sprintf("Failed to create stdout pipe: %v", err))
-		if updateErr := e.manager.UpdateTaskStatus(t.ID, types.StatusFailed); updateErr != nil {
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
 			log.Printf("Failed to update task status: %v", updateErr)
 		}
 		return
@@ -212,21 +571,57 @@ func (e *Executor) executeTask(tool Tool, t *task.Task) {
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		t.SetError(fmt.Sprintf("Failed to create stderr pipe: %v", err))
-		if updateErr := e.manager.UpdateTaskStatus(t.ID, types.StatusFailed); updateErr != nil {
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
 			log.Printf("Failed to update task status: %v", updateErr)
 		}
 		return
 	}
 
+	// Tools that write into a known directory have their files found by
+	// comparing its contents before and after the run
+	outputDir, before := snapshotOutputDir(tool, t)
+	processStart := time.Now()
+
 	// Start the command
 	if err = cmd.Start(); err != nil {
+		if e.ctx.Err() == nil && taskCtx.Err() != nil {
+			// Canceled by the user before the process started. Record it
+			// again in case the running status overwrote the cancellation.
+			if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusCanceled); updateErr != nil {
+				log.Printf("Failed to update task status: %v", updateErr)
+			}
+			return
+		}
 		t.SetError(fmt.Sprintf("Failed to start command: %v", err))
-		if updateErr := e.manager.UpdateTaskStatus(t.ID, types.StatusFailed); updateErr != nil {
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
 			log.Printf("Failed to update task status: %v", updateErr)
 		}
 		return
 	}
 
+	// Lower the process priority; failing to do so shouldn't fail the task
+	if tool.Nice != 0 {
+		if err := setNice(cmd.Process.Pid, tool.Nice); err != nil {
+			log.Printf("Failed to set nice %d for task %s: %v", tool.Nice, t.ID, err)
+		}
+	}
+
+	// Watch for the process going silent
+	finishOutput := e.output.start(t.ID, tool.Name)
+	defer finishOutput()
+	if tool.StallTimeoutSeconds > 0 {
+		go e.watchStall(procCtx, t.ID, tool, func() {
+			stalled.Store(true)
+			killProcess()
+		})
+	}
+
+	// Both readers share one writer, batching their lines in order
+	flushLines, flushInterval := outputFlushSettings(tool)
+	out := newOutputWriter(func(lines []string) error {
+		return e.manager.AppendTaskOutputLines(ctx, t.ID, lines)
+	}, flushLines, flushInterval)
+
 	// Create a wait group for output readers
 	var outputWg sync.WaitGroup
 	outputWg.Add(2)
@@ -234,60 +629,431 @@ func (e *Executor) executeTask(tool Tool, t *task.Task) {
 	// Read stdout
 	go func() {
 		defer outputWg.Done()
-		e.readOutput(t.ID, stdout, false)
+		e.readOutput(t.ID, stdout, false, e.filters[tool.Name], out)
 	}()
 
 	// Read stderr
 	go func() {
 		defer outputWg.Done()
-		e.readOutput(t.ID, stderr, true)
+		e.readOutput(t.ID, stderr, true, e.filters[tool.Name], out)
 	}()
 
-	// Wait for output readers to finish
+	// Wait for output readers to finish, and store what they left buffered
+	// before the task's status changes
 	outputWg.Wait()
+	out.flush()
 
 	// Wait for command to complete
 	err = cmd.Wait()
+	if cmd.ProcessState != nil {
+		t.SetResourceUsage(
+			cmd.ProcessState.UserTime().Milliseconds(),
+			cmd.ProcessState.SystemTime().Milliseconds(),
+			maxRSSBytes(cmd.ProcessState),
+		)
+	}
 	if err != nil {
-		if e.ctx.Err() != nil {
+		if e.ctx.Err() == nil && taskCtx.Err() != nil {
+			// Canceled by the user, the status has already been recorded
+			log.Printf("Task %s was canceled", t.ID)
+		} else if e.ctx.Err() != nil {
 			// Context was canceled
-			if updateErr := e.manager.UpdateTaskStatus(t.ID, types.StatusCanceled); updateErr != nil {
+			if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusCanceled); updateErr != nil {
+				log.Printf("Failed to update task status: %v", updateErr)
+			}
+		} else if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			// Killed for running too long
+			t.SetError(limit.message())
+			if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
+				log.Printf("Failed to update task status: %v", updateErr)
+			}
+		} else if stalled.Load() {
+			// Killed for producing no output
+			t.SetError(stallMessage(tool))
+			if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
 				log.Printf("Failed to update task status: %v", updateErr)
 			}
 		} else {
 			t.SetError(fmt.Sprintf("Command failed: %v", err))
-			if updateErr := e.manager.UpdateTaskStatus(t.ID, types.StatusFailed); updateErr != nil {
+			if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
 				log.Printf("Failed to update task status: %v", updateErr)
 			}
 		}
 		return
 	}
 
-	if err := e.manager.UpdateTaskStatus(t.ID, types.StatusComplete); err != nil {
+	// A task that didn't produce the files it promised has failed
+	if missing := missingOutputs(t.ExpectedOutput); len(missing) > 0 {
+		t.SetError(fmt.Sprintf("Expected output missing: %s", strings.Join(missing, ", ")))
+		if updateErr := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusFailed); updateErr != nil {
+			log.Printf("Failed to update task status: %v", updateErr)
+		}
+		return
+	}
+
+	if outputDir != "" {
+		after, err := snapshotDir(outputDir)
+		if err != nil {
+			log.Printf("Failed to scan output directory %s of task %s: %v", outputDir, t.ID, err)
+		} else {
+			t.SetOutputDirFiles(after.changedSince(before, processStart.Add(-changeTimeSlack)))
+		}
+	}
+
+	if err := e.manager.UpdateTaskStatus(ctx, t.ID, types.StatusComplete); err != nil {
 		log.Printf("Failed to update task status to complete: %v", err)
 	}
 	log.Printf("Task %s completed successfully", t.ID)
 }
 
-// readOutput reads output from a pipe and sends it to the manager
-func (e *Executor) readOutput(taskID string, pipe io.Reader, isError bool) {
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		line := scanner.Text()
+// runLimit is how long a task may run and where that limit comes from
+type runLimit struct {
+	duration time.Duration
+	fromTool bool
+}
+
+// runtimeLimit picks the limit for a task: the smaller of the task's timeout
+// and the tool's max runtime, ignoring whichever isn't set
+func runtimeLimit(tool Tool, timeoutSeconds int) runLimit {
+	toolLimit := time.Duration(tool.MaxRuntimeSeconds) * time.Second
+	taskLimit := time.Duration(timeoutSeconds) * time.Second
+
+	if taskLimit > 0 && (toolLimit == 0 || taskLimit <= toolLimit) {
+		return runLimit{duration: taskLimit}
+	}
+	return runLimit{duration: toolLimit, fromTool: toolLimit > 0}
+}
+
+// message describes the limit being exceeded, for the task's error
+func (l runLimit) message() string {
+	if l.fromTool {
+		return fmt.Sprintf("Tool max runtime exceeded (%s), process killed", l.duration)
+	}
+	return fmt.Sprintf("Task timeout exceeded (%s), process killed", l.duration)
+}
+
+// watchStall reports the task as stalled whenever its process goes the
+// tool's stall timeout without output, and calls kill if the tool asks for
+// stalled tasks to be killed. It returns when ctx is done.
+func (e *Executor) watchStall(ctx context.Context, taskID string, tool Tool, kill func()) {
+	timeout := time.Duration(tool.StallTimeoutSeconds) * time.Second
+	ticker := time.NewTicker(stallCheckInterval(timeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idle, stalled := e.output.checkStalled(taskID, timeout)
+		if !stalled {
+			continue
+		}
+		log.Printf("Task %s stalled: no output for %s", taskID, idle.Round(time.Second))
+		e.manager.PublishEvent(task.TaskEvent{
+			TaskID: taskID,
+			Type:   "stalled",
+			Data:   fmt.Sprintf("No output for %s", idle.Round(time.Second)),
+		})
+		if tool.KillOnStall {
+			kill()
+			return
+		}
+	}
+}
+
+// stallMessage describes a task killed for stalling, for the task's error
+func stallMessage(tool Tool) string {
+	timeout := time.Duration(tool.StallTimeoutSeconds) * time.Second
+	return fmt.Sprintf("Task stalled: no output for %s, process killed", timeout)
+}
+
+// skipCanceledTask reports that a task canceled before it started running
+// won't be executed. Its canceled status has already been recorded.
+func (e *Executor) skipCanceledTask(t *task.Task) {
+	log.Printf("Skipping canceled task %s", t.ID)
+	e.manager.PublishEvent(task.TaskEvent{
+		TaskID: t.ID,
+		Type:   "skipped",
+		Data:   "Skipped canceled task",
+	})
+}
+
+// snapshotOutputDir records the contents of the task's output directory:
+// the one passed with the tool's output_dir_arg, or else the tool's
+// output_dir. It returns an empty directory if there is none, the task has
+// expected output, or the directory can't be read; files are then
+// discovered as usual.
+func snapshotOutputDir(tool Tool, t *task.Task) (string, dirSnapshot) {
+	if len(t.ExpectedOutput) > 0 {
+		return "", nil
+	}
+	dir := OutputDir(tool, t.Args)
+	if dir == "" {
+		return "", nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Printf("Failed to resolve output directory of task %s: %v", t.ID, err)
+		return "", nil
+	}
+
+	snapshot, err := snapshotDir(dir)
+	if err != nil {
+		log.Printf("Failed to scan output directory %s of task %s: %v", dir, t.ID, err)
+		return "", nil
+	}
+	return dir, snapshot
+}
+
+// missingOutputs returns the paths that don't exist as regular files
+func missingOutputs(paths []string) []string {
+	var missing []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// CommandArgs returns the arguments a task for tool is run with: the tool's
+// default arguments followed by the task's own, or the other way around
+// when the tool's args_position is ArgsAppend
+func CommandArgs(tool Tool, args []string) []string {
+	first, second := tool.Args, args
+	if tool.ArgsPosition == ArgsAppend {
+		first, second = args, tool.Args
+	}
+	combined := make([]string, len(first)+len(second))
+	copy(combined, first)
+	copy(combined[len(first):], second)
+	return combined
+}
+
+// readOutput reads output from a pipe and passes it to out. Lines longer
+// than the executor's max line length are truncated first, then run through
+// the tool's filters.
+func (e *Executor) readOutput(taskID string, pipe io.Reader, isError bool, filters []OutputFilter, out *outputWriter) {
+	reader := bufio.NewReader(pipe)
+	for {
+		raw, dropped, err := readLine(reader, e.maxLineLength)
+		if err != nil {
+			return
+		}
+		line := applyOutputFilters(filters, string(raw))
+		if dropped > 0 {
+			line += fmt.Sprintf("…[truncated %d bytes]", dropped)
+		}
 		if isError {
-			line = "[ERROR] " + line
+			line = types.StderrPrefix + line
 		}
-		if err := e.manager.AppendTaskOutput(taskID, line); err != nil {
-			log.Printf("Failed to append task output: %v", err)
+		if e.output.touch(taskID) {
+			e.manager.PublishEvent(task.TaskEvent{
+				TaskID: taskID,
+				Type:   "resumed",
+				Data:   "Output resumed after stall",
+			})
 		}
+		out.add(line)
 	}
 }
 
+// readLine reads the next line from r without its line ending. Beyond
+// maxLength bytes the rest of the line is only counted as dropped, so a huge
+// line never has to fit in memory, and a multi-byte character cut in half is
+// dropped too. A maxLength of zero keeps full lines.
+func readLine(r *bufio.Reader, maxLength int) (line []byte, dropped int, err error) {
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			if len(line) > 0 || dropped > 0 {
+				// Return what was read, the error comes with the next call
+				break
+			}
+			return nil, 0, err
+		}
+
+		keep := len(chunk)
+		if maxLength > 0 {
+			keep = max(0, min(keep, maxLength-len(line)))
+		}
+		line = append(line, chunk[:keep]...)
+		dropped += len(chunk) - keep
+
+		if !isPrefix {
+			break
+		}
+	}
+
+	if dropped > 0 {
+		start := len(line) - 1
+		for start > 0 && !utf8.RuneStart(line[start]) {
+			start--
+		}
+		if start >= 0 && !utf8.FullRune(line[start:]) {
+			dropped += len(line) - start
+			line = line[:start]
+		}
+	}
+	return line, dropped, nil
+}
+
+// SetMaxLineLength sets how many bytes of a task output line are kept
+// before it is truncated. Zero keeps full lines. It must be called before
+// Start.
+func (e *Executor) SetMaxLineLength(length int) {
+	e.maxLineLength = length
+}
+
+// SetCommandRunner replaces how task processes are created, e.g. to wrap
+// them in a sandbox. It must be called before Start.
+func (e *Executor) SetCommandRunner(runner CommandRunner) {
+	e.runner = runner
+}
+
 // GetTools returns the configured tools
 func (e *Executor) GetTools() []Tool {
 	return e.config.Tools
 }
 
+// EffectiveConfig returns the configuration the executor runs with: the
+// loaded config with the defaults it falls back to filled in, and each
+// tool's current worker count
+func (e *Executor) EffectiveConfig() Config {
+	config := e.config
+	if config.Scheduling == "" {
+		config.Scheduling = DefaultScheduling
+	}
+	config.Tools = make([]Tool, len(e.config.Tools))
+	for i, tool := range e.config.Tools {
+		tool.Workers = e.WorkerCount(tool)
+		tool.QueueSize = e.QueueSize(tool)
+		if tool.ArgsPosition == "" {
+			tool.ArgsPosition = ArgsPrepend
+		}
+		if tool.OrganizePattern == "" {
+			tool.OrganizePattern = files.DefaultOrganizePattern
+		}
+		lines, interval := outputFlushSettings(tool)
+		tool.OutputFlushLines = lines
+		tool.OutputFlushMs = int(interval / time.Millisecond)
+		config.Tools[i] = tool
+	}
+	return config
+}
+
+// OrganizeRoutes returns the configured MIME type routes for organized files
+func (e *Executor) OrganizeRoutes() []files.OrganizeRoute {
+	return e.config.OrganizeRoutes
+}
+
+// MimeTypes returns the configured MIME type overrides by extension
+func (e *Executor) MimeTypes() map[string]string {
+	return e.config.MimeTypes
+}
+
+// WorkerCount returns the number of workers running for a tool: the count
+// set with SetWorkers, else the tool's own, falling back to the executor
+// default when the tool doesn't specify one
+func (e *Executor) WorkerCount(tool Tool) int {
+	e.poolsMu.RLock()
+	count, ok := e.overrides[tool.Name]
+	e.poolsMu.RUnlock()
+	if ok {
+		return count
+	}
+	if tool.Workers == 0 {
+		return e.workers
+	}
+	return tool.Workers
+}
+
+// QueueSize returns the capacity of a tool's queue, falling back to
+// DefaultQueueSize when the tool doesn't specify one
+func (e *Executor) QueueSize(tool Tool) int {
+	if tool.QueueSize == 0 {
+		return DefaultQueueSize
+	}
+	return tool.QueueSize
+}
+
+// BusyWorkers returns how many of a tool's workers are currently executing
+// a task
+func (e *Executor) BusyWorkers(toolName string) int {
+	return e.usage.busyWorkers(toolName)
+}
+
+// StalledTasks returns how many of a tool's running tasks currently produce
+// no output past the tool's stall timeout
+func (e *Executor) StalledTasks(toolName string) int {
+	return e.output.stalledTasks(toolName)
+}
+
+// GetTool returns the configuration of a tool by name
+func (e *Executor) GetTool(toolName string) (Tool, bool) {
+	for _, tool := range e.config.Tools {
+		if tool.Name == toolName {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// checkCommands looks up every tool's command, so a tool whose binary is
+// missing is reported by name instead of failing each of its tasks
+func (e *Executor) checkCommands() {
+	missing := make(map[string]bool)
+	for _, tool := range e.config.Tools {
+		if _, err := exec.LookPath(tool.Command); err != nil {
+			log.Printf("Warning: tool %s is unavailable, command %q not found: %v", tool.Name, tool.Command, err)
+			missing[tool.Name] = true
+		}
+	}
+
+	e.missingMu.Lock()
+	defer e.missingMu.Unlock()
+	e.missing = missing
+}
+
+// CheckTool returns the configuration of a tool that tasks can be run with.
+// It fails with ErrUnknownTool for tools that aren't configured, with
+// ErrCommandMissing for tools whose command wasn't found on startup and with
+// ErrVersionTooOld for tools disabled for being older than min_version.
+func (e *Executor) CheckTool(toolName string) (Tool, error) {
+	tool, ok := e.GetTool(toolName)
+	if !ok {
+		return Tool{}, fmt.Errorf("%w %q", ErrUnknownTool, toolName)
+	}
+
+	e.missingMu.RLock()
+	defer e.missingMu.RUnlock()
+	if e.missing[toolName] {
+		return Tool{}, fmt.Errorf("tool %q is configured but its %w on this server: %q", toolName, ErrCommandMissing, tool.Command)
+	}
+	if e.outdated[toolName] {
+		return Tool{}, fmt.Errorf("tool %q is disabled, its %w: %s is older than min_version %s", toolName, ErrVersionTooOld, e.versions[toolName], tool.MinVersion)
+	}
+	return tool, nil
+}
+
+// AvailableTools returns the names of the tools tasks can be run with
+func (e *Executor) AvailableTools() []string {
+	e.missingMu.RLock()
+	defer e.missingMu.RUnlock()
+
+	names := []string{}
+	for _, tool := range e.config.Tools {
+		if !e.missing[tool.Name] && !e.outdated[tool.Name] {
+			names = append(names, tool.Name)
+		}
+	}
+	return names
+}
+
 // IsToolAvailable checks if a tool is configured
 func (e *Executor) IsToolAvailable(toolName string) bool {
 	for _, tool := range e.config.Tools {
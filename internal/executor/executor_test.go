@@ -0,0 +1,545 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestCanceledQueuedTaskIsSkipped(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "echo", Command: "echo", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	sub := manager.EventBus().Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer manager.EventBus().Unsubscribe(sub)
+
+	// Queue two tasks before any worker runs and cancel the first
+	manager.CreateQueue("echo", DefaultQueueSize)
+	canceled := task.NewTask("echo", "echo", []string{"canceled"})
+	kept := task.NewTask("echo", "echo", []string{"kept"})
+	for _, tk := range []*task.Task{canceled, kept} {
+		if err := manager.AddTask(ctx, tk); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+	if err := manager.UpdateTaskStatus(ctx, canceled.ID, types.StatusCanceled); err != nil {
+		t.Fatalf("Failed to cancel task: %v", err)
+	}
+
+	// Release the workers
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	skipped := false
+	timeout := time.After(5 * time.Second)
+	for kept.GetStatus() != types.StatusComplete {
+		select {
+		case event := <-sub.C:
+			taskEvent := event.Payload.(task.TaskEvent)
+			if taskEvent.TaskID != canceled.ID {
+				continue
+			}
+			switch {
+			case taskEvent.Type == "skipped":
+				skipped = true
+			case taskEvent.Type == "status" && taskEvent.Data == string(types.StatusRunning):
+				t.Error("Canceled task was run")
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the next task, status %s", kept.GetStatus())
+		}
+	}
+
+	if !skipped {
+		t.Error("Expected a skipped event for the canceled task")
+	}
+	if status := canceled.GetStatus(); status != types.StatusCanceled {
+		t.Errorf("Expected canceled task to stay canceled, got %s", status)
+	}
+	if len(canceled.Output) != 0 {
+		t.Errorf("Expected no output from canceled task, got %v", canceled.Output)
+	}
+}
+
+func TestExpectedOutputVerification(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "touch", Command: "touch", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	manager.CreateQueue("touch", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	written := filepath.Join(dir, "written.mp4")
+	produced := task.NewTask("touch", "touch", []string{written})
+	produced.ExpectedOutput = []string{written}
+
+	missing := filepath.Join(dir, "missing.mp4")
+	unproduced := task.NewTask("touch", "touch", []string{filepath.Join(dir, "other.mp4")})
+	unproduced.ExpectedOutput = []string{missing}
+
+	for _, tk := range []*task.Task{produced, unproduced} {
+		if err := manager.AddTask(ctx, tk); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+
+	finished := func(tk *task.Task) bool {
+		status := tk.GetStatus()
+		return status == types.StatusComplete || status == types.StatusFailed
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !finished(produced) || !finished(unproduced) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, statuses %s and %s", produced.GetStatus(), unproduced.GetStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status := produced.GetStatus(); status != types.StatusComplete {
+		t.Errorf("Expected task with its output to complete, got %s", status)
+	}
+	if status := unproduced.GetStatus(); status != types.StatusFailed {
+		t.Errorf("Expected task with missing output to fail, got %s", status)
+	}
+	if data := unproduced.Clone(); !strings.Contains(data.Error, missing) {
+		t.Errorf("Expected error to name the missing output, got %q", data.Error)
+	}
+}
+
+func TestRuntimeLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		toolMax  int
+		timeout  int
+		want     time.Duration
+		fromTool bool
+	}{
+		{"unlimited", 0, 0, 0, false},
+		{"tool only", 60, 0, time.Minute, true},
+		{"task only", 0, 30, 30 * time.Second, false},
+		{"smaller task timeout wins", 60, 30, 30 * time.Second, false},
+		{"tool caps larger task timeout", 60, 90, time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := runtimeLimit(Tool{MaxRuntimeSeconds: tt.toolMax}, tt.timeout)
+			if limit.duration != tt.want || limit.fromTool != tt.fromTool {
+				t.Errorf("runtimeLimit() = %v (tool %v), want %v (tool %v)", limit.duration, limit.fromTool, tt.want, tt.fromTool)
+			}
+		})
+	}
+}
+
+func TestMaxRuntimeKillsTask(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "sleep", Command: "sleep", Workers: 2, MaxRuntimeSeconds: 1}}}
+	e := newExecutor(config, 2, manager)
+	ctx := context.Background()
+
+	manager.CreateQueue("sleep", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	// One task hits the tool's ceiling, the other its own longer timeout is
+	// capped by it, so both report the tool limit
+	capped := task.NewTask("sleep", "sleep", []string{"30"})
+	capped.TimeoutSeconds = 60
+	limited := task.NewTask("sleep", "sleep", []string{"30"})
+	for _, tk := range []*task.Task{capped, limited} {
+		if err := manager.AddTask(ctx, tk); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for capped.GetStatus() != types.StatusFailed || limited.GetStatus() != types.StatusFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, statuses %s and %s", capped.GetStatus(), limited.GetStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tk := range []*task.Task{capped, limited} {
+		if data := tk.Clone(); !strings.Contains(data.Error, "Tool max runtime exceeded") {
+			t.Errorf("Expected max runtime error, got %q", data.Error)
+		}
+	}
+}
+
+func TestStalledTask(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{
+		{Name: "watch", Command: "sh", Workers: 1, StallTimeoutSeconds: 1},
+		{Name: "kill", Command: "sh", Workers: 1, StallTimeoutSeconds: 1, KillOnStall: true},
+	}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+	for _, tool := range config.Tools {
+		manager.CreateQueue(tool.Name, DefaultQueueSize)
+	}
+
+	sub := manager.EventBus().Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer manager.EventBus().Unsubscribe(sub)
+
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	// Both tasks print once and then hang, well within any runtime limit
+	script := []string{"-c", "echo started; sleep 30"}
+	watched := task.NewTask("watch", "sh", script)
+	killed := task.NewTask("kill", "sh", script)
+	for _, tk := range []*task.Task{watched, killed} {
+		if err := manager.AddTask(ctx, tk); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+
+	stalled := make(map[string]bool)
+	deadline := time.After(10 * time.Second)
+	for !stalled[watched.ID] || !stalled[killed.ID] || killed.GetStatus() != types.StatusFailed {
+		select {
+		case event := <-sub.C:
+			if taskEvent := event.Payload.(task.TaskEvent); taskEvent.Type == "stalled" {
+				stalled[taskEvent.TaskID] = true
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("Timed out, stalled %v, status %s", stalled, killed.GetStatus())
+		}
+	}
+
+	if data := killed.Clone(); !strings.Contains(data.Error, "Task stalled: no output for 1s") {
+		t.Errorf("Expected stall error, got %q", data.Error)
+	}
+
+	// Without kill_on_stall the task keeps running and is only reported
+	if status := watched.GetStatus(); status != types.StatusRunning {
+		t.Errorf("Expected the watched task to keep running, got %s", status)
+	}
+	if n := e.StalledTasks("watch"); n != 1 {
+		t.Errorf("Expected 1 stalled watch task, got %d", n)
+	}
+	if n := e.StalledTasks("kill"); n != 0 {
+		t.Errorf("Expected the killed task to no longer count as stalled, got %d", n)
+	}
+}
+
+func TestOutputDirDiscovery(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "gallery", Command: "sh", Workers: 1, OutputDirArg: "-D"}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "earlier.jpg"), []byte("old"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	manager.CreateQueue("gallery", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	// The script receives "-D <dir>" as $1 and $2 and prints nothing useful
+	script := `mkdir -p "$2/album" && echo data > "$2/album/1.jpg" && echo done`
+	tk := task.NewTask("gallery", "sh", []string{"-c", script, "sh", "-D", dir})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, status %s: %s", tk.GetStatus(), tk.Clone().Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, scanned := tk.OutputDirFiles()
+	if want := []string{filepath.Join(dir, "album", "1.jpg")}; !scanned || !reflect.DeepEqual(files, want) {
+		t.Errorf("Expected only the new file %v, got %v (scanned %v)", want, files, scanned)
+	}
+
+	// A tool configured to always write into one directory needs no flag
+	fixed := t.TempDir()
+	tool := Tool{Name: "gallery", Command: "sh", OutputDirArg: "-D", OutputDir: fixed}
+	if dir, _ := snapshotOutputDir(tool, task.NewTask("gallery", "sh", []string{"-c", "true"})); dir != fixed {
+		t.Errorf("Expected the tool's output_dir %s, got %q", fixed, dir)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		maxLength   int
+		want        []string
+		wantDropped []int
+	}{
+		{"short lines", "one\ntwo\r\nthree", 10, []string{"one", "two", "three"}, []int{0, 0, 0}},
+		{"long line", "0123456789abcdef\nnext\n", 10, []string{"0123456789", "next"}, []int{6, 0}},
+		{"unlimited", strings.Repeat("x", 100000) + "\n", 0, []string{strings.Repeat("x", 100000)}, []int{0}},
+		{"beyond reader buffer", strings.Repeat("x", 100000) + "\nend", 5, []string{"xxxxx", "end"}, []int{99995, 0}},
+		{"split character", "ab€cd\n", 4, []string{"ab"}, []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(tt.input), 16)
+			var got []string
+			var dropped []int
+			for {
+				line, n, err := readLine(reader, tt.maxLength)
+				if err != nil {
+					break
+				}
+				got = append(got, string(line))
+				dropped = append(dropped, n)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("readLine() = %q dropping %v, want %q dropping %v", got, dropped, tt.want, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestLongOutputLineTruncated(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "sh", Command: "sh", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	e.SetMaxLineLength(100)
+	ctx := context.Background()
+
+	manager.CreateQueue("sh", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	// A 1 MB line followed by a normal one
+	tk := task.NewTask("sh", "sh", []string{"-c", "head -c 1000000 /dev/zero | tr '\\0' x; echo; echo done"})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, status %s: %s", tk.GetStatus(), tk.Clone().Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	output := tk.Clone().Output
+	want := []string{strings.Repeat("x", 100) + "…[truncated 999900 bytes]", "done"}
+	if !reflect.DeepEqual(output, want) {
+		t.Errorf("Expected the long line to be truncated, got %d lines starting %.120q", len(output), output)
+	}
+}
+
+func TestArgvRecorded(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := task.NewManager(repo)
+	config := Config{Tools: []Tool{{Name: "echo", Command: "echo", Workers: 1, Args: []string{"-n"}}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	manager.CreateQueue("echo", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	tk := task.NewTask("echo", "echo", []string{"hello"})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, status %s", tk.GetStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The tool's default arguments come first, and the record is saved
+	data, err := repo.GetByID(ctx, tk.ID)
+	if err != nil {
+		t.Fatalf("GetByID failed: %v", err)
+	}
+	if want := []string{"echo", "-n", "hello"}; !reflect.DeepEqual(data.Argv, want) {
+		t.Errorf("Expected argv %v, got %v", want, data.Argv)
+	}
+}
+
+// recordingRunner records the command lines it is asked for and runs
+// "true" instead
+type recordingRunner struct {
+	mu    sync.Mutex
+	argvs [][]string
+}
+
+func (r *recordingRunner) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	r.mu.Lock()
+	r.argvs = append(r.argvs, append([]string{name}, args...))
+	r.mu.Unlock()
+	return exec.CommandContext(ctx, "true")
+}
+
+func TestArgsPosition(t *testing.T) {
+	tests := []struct {
+		position string
+		want     []string
+	}{
+		{"", []string{"yt-dlp", "--no-warnings", "-q", "https://example.com/v"}},
+		{ArgsPrepend, []string{"yt-dlp", "--no-warnings", "-q", "https://example.com/v"}},
+		{ArgsAppend, []string{"yt-dlp", "https://example.com/v", "--no-warnings", "-q"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("position "+tt.position, func(t *testing.T) {
+			manager := task.NewManager(storage.NewMockRepository())
+			tool := Tool{Name: "yt-dlp", Command: "yt-dlp", Workers: 1, Args: []string{"--no-warnings", "-q"}, ArgsPosition: tt.position}
+			e := newExecutor(Config{Tools: []Tool{tool}}, 1, manager)
+			runner := &recordingRunner{}
+			e.SetCommandRunner(runner)
+			ctx := context.Background()
+
+			if err := e.Start(); err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+			defer e.Stop()
+
+			tk := task.NewTask("yt-dlp", "yt-dlp", []string{"https://example.com/v"})
+			if err := manager.AddTask(ctx, tk); err != nil {
+				t.Fatalf("AddTask failed: %v", err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for tk.GetStatus() != types.StatusComplete {
+				if time.Now().After(deadline) {
+					t.Fatalf("Timed out, status %s: %s", tk.GetStatus(), tk.Clone().Error)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			runner.mu.Lock()
+			defer runner.mu.Unlock()
+			if len(runner.argvs) != 1 || !reflect.DeepEqual(runner.argvs[0], tt.want) {
+				t.Errorf("Expected argv %v, got %v", tt.want, runner.argvs)
+			}
+			if got := tk.Clone().Argv; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected recorded argv %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// panicRunner panics when creating the first command and runs the rest
+type panicRunner struct {
+	calls atomic.Int32
+}
+
+func (r *panicRunner) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if r.calls.Add(1) == 1 {
+		panic("unexpected nil output parser")
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
+
+func TestWorkerSurvivesPanic(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "echo", Command: "echo", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	e.SetCommandRunner(&panicRunner{})
+	ctx := context.Background()
+
+	manager.CreateQueue("echo", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	// The only worker must still run the second task after the first panics
+	panicked := task.NewTask("echo", "echo", []string{"first"})
+	next := task.NewTask("echo", "echo", []string{"second"})
+	for _, tk := range []*task.Task{panicked, next} {
+		if err := manager.AddTask(ctx, tk); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for panicked.GetStatus() != types.StatusFailed || next.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, statuses %s and %s", panicked.GetStatus(), next.GetStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if data := panicked.Clone(); !strings.Contains(data.Error, "Task panicked: unexpected nil output parser") {
+		t.Errorf("Expected panic error, got %q", data.Error)
+	}
+	if e.BusyWorkers("echo") != 0 {
+		t.Errorf("Expected the worker to be released, got %d busy", e.BusyWorkers("echo"))
+	}
+}
+
+func TestCheckTool(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{
+		{Name: "echo", Command: "echo", Workers: 1},
+		{Name: "gone", Command: "commander-test-missing-binary", Workers: 1},
+	}}
+	e := newExecutor(config, 1, manager)
+	for _, tool := range config.Tools {
+		manager.CreateQueue(tool.Name, DefaultQueueSize)
+	}
+
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	if tool, err := e.CheckTool("echo"); err != nil || tool.Command != "echo" {
+		t.Errorf("CheckTool(echo) = %+v, %v", tool, err)
+	}
+	if _, err := e.CheckTool("gone"); !errors.Is(err, ErrCommandMissing) {
+		t.Errorf("Expected ErrCommandMissing for a missing binary, got %v", err)
+	}
+	if _, err := e.CheckTool("nope"); !errors.Is(err, ErrUnknownTool) {
+		t.Errorf("Expected ErrUnknownTool, got %v", err)
+	}
+
+	if got := e.AvailableTools(); len(got) != 1 || got[0] != "echo" {
+		t.Errorf("Expected only echo to be available, got %v", got)
+	}
+}
@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"context"
+	"sync"
+)
+
+// hostLimiter caps how many tasks may run against the same host at once,
+// across all tools
+type hostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// newHostLimiter creates a limiter allowing limit concurrent tasks per host.
+// A limit of zero or less disables limiting.
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until a slot for host is free or ctx is done. onWait is
+// called once if the caller has to wait. Tasks without a host are never
+// limited. The returned release function must be called when the task ends.
+func (h *hostLimiter) acquire(ctx context.Context, host string, onWait func()) (release func(), err error) {
+	if h.limit <= 0 || host == "" {
+		return func() {}, nil
+	}
+
+	h.mu.Lock()
+	sem, ok := h.slots[host]
+	if !ok {
+		sem = make(chan struct{}, h.limit)
+		h.slots[host] = sem
+	}
+	h.mu.Unlock()
+
+	release = func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	onWait()
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterBlocksSameHost(t *testing.T) {
+	limiter := newHostLimiter(1)
+	ctx := context.Background()
+
+	release, err := limiter.acquire(ctx, "example.com", func() {
+		t.Error("First acquire should not wait")
+	})
+	if err != nil {
+		t.Fatalf("Failed to acquire first slot: %v", err)
+	}
+
+	// A different host is not affected
+	otherRelease, err := limiter.acquire(ctx, "example.org", func() {
+		t.Error("Acquire for another host should not wait")
+	})
+	if err != nil {
+		t.Fatalf("Failed to acquire slot for another host: %v", err)
+	}
+	otherRelease()
+
+	waited := make(chan struct{})
+	acquired := make(chan struct{})
+	go func() {
+		second, err := limiter.acquire(ctx, "example.com", func() { close(waited) })
+		if err != nil {
+			t.Errorf("Failed to acquire second slot: %v", err)
+			return
+		}
+		close(acquired)
+		second()
+	}()
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Second acquire did not report waiting")
+	}
+
+	select {
+	case <-acquired:
+		t.Fatal("Second acquire succeeded while the slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Second acquire did not succeed after release")
+	}
+}
+
+func TestHostLimiterUnlimited(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		host  string
+	}{
+		{"no host", 1, ""},
+		{"limit disabled", 0, "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newHostLimiter(tt.limit)
+			for i := 0; i < 3; i++ {
+				if _, err := limiter.acquire(context.Background(), tt.host, func() {
+					t.Error("Acquire should not wait")
+				}); err != nil {
+					t.Fatalf("Failed to acquire: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHostLimiterContextCanceled(t *testing.T) {
+	limiter := newHostLimiter(1)
+
+	if _, err := limiter.acquire(context.Background(), "example.com", func() {}); err != nil {
+		t.Fatalf("Failed to acquire first slot: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.acquire(ctx, "example.com", func() {}); err == nil {
+		t.Error("Expected error when context is canceled")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package executor
+
+// setNice does nothing, process priority isn't supported on this platform
+func setNice(_, _ int) error {
+	return nil
+}
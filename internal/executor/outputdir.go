@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OutputDir returns the directory a task of tool running with args writes
+// into: the one passed with the tool's output_dir_arg, or else the tool's
+// output_dir. It returns "" if there is none.
+func OutputDir(tool Tool, args []string) string {
+	if tool.OutputDirArg != "" {
+		if dir := outputDirFromArgs(tool.OutputDirArg, CommandArgs(tool, args)); dir != "" {
+			return dir
+		}
+	}
+	return tool.OutputDir
+}
+
+// outputDirFromArgs returns the value of flag in args, given either as a
+// separate argument ("-D dir") or joined with "=" ("--directory=dir"). When
+// the flag is repeated the last value wins, as most CLI parsers do. It
+// returns "" if the flag isn't present.
+func outputDirFromArgs(flag string, args []string) string {
+	dir := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == flag && i+1 < len(args):
+			dir = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], flag+"="):
+			dir = strings.TrimPrefix(args[i], flag+"=")
+		}
+	}
+	return dir
+}
+
+// changeTimeSlack allows for file system timestamps lagging slightly behind
+// the clock when comparing them with a task's start time
+const changeTimeSlack = time.Second
+
+// fileState is what a directory snapshot records to tell changed files apart
+type fileState struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // See changeTime
+}
+
+// dirSnapshot maps the regular files below a directory to their state
+type dirSnapshot map[string]fileState
+
+// snapshotDir records every regular file below dir. A directory that doesn't
+// exist yet gives an empty snapshot, tools commonly create their output
+// directory themselves.
+func snapshotDir(dir string) (dirSnapshot, error) {
+	snapshot := make(dirSnapshot)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		snapshot[path] = fileState{size: info.Size(), modTime: info.ModTime(), changed: changeTime(info)}
+		return nil
+	})
+	return snapshot, err
+}
+
+// changedSince returns the files in s that are new or were modified
+// compared to before, sorted by path. Only files that last changed at or
+// after since count, so that files another task finished writing after
+// before was taken aren't attributed to this one.
+func (s dirSnapshot) changedSince(before dirSnapshot, since time.Time) []string {
+	changed := []string{}
+	for path, state := range s {
+		if old, ok := before[path]; ok && old.size == state.size && old.modTime.Equal(state.modTime) {
+			continue
+		}
+		if state.changed.Before(since) {
+			continue
+		}
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+	return changed
+}
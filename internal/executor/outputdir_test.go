@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOutputDirFromArgs(t *testing.T) {
+	tests := []struct {
+		flag string
+		args []string
+		want string
+	}{
+		{"-D", []string{"-D", "/srv/gallery", "https://example.com"}, "/srv/gallery"},
+		{"--directory", []string{"--directory=/srv/out", "https://example.com"}, "/srv/out"},
+		{"-D", []string{"-D", "first", "-D", "second"}, "second"},
+		{"-D", []string{"https://example.com", "-D"}, ""},
+		{"-D", []string{"-Dx", "https://example.com"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := outputDirFromArgs(tt.flag, tt.args); got != tt.want {
+			t.Errorf("outputDirFromArgs(%q, %v) = %q, want %q", tt.flag, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotDirChanges(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	write("kept.jpg", "old")
+	write("rewritten.jpg", "old")
+
+	// Back-dated modification times, as wget and yt-dlp set them, don't hide
+	// new files
+	start := time.Now().Add(-changeTimeSlack)
+	before, err := snapshotDir(dir)
+	if err != nil {
+		t.Fatalf("snapshotDir() error = %v", err)
+	}
+
+	write("album/new.jpg", "new")
+	published := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "album/new.jpg"), published, published); err != nil {
+		t.Fatalf("Failed to set times: %v", err)
+	}
+	write("rewritten.jpg", "new content")
+	after, err := snapshotDir(dir)
+	if err != nil {
+		t.Fatalf("snapshotDir() error = %v", err)
+	}
+
+	want := []string{filepath.Join(dir, "album/new.jpg"), filepath.Join(dir, "rewritten.jpg")}
+	if got := after.changedSince(before, start); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedSince() = %v, want %v", got, want)
+	}
+
+	// Files last changed before the task started belong to someone else,
+	// even if they weren't there when the snapshot was taken
+	if got := after.changedSince(dirSnapshot{}, time.Now().Add(time.Hour)); len(got) != 0 {
+		t.Errorf("Expected no files changed after the task started, got %v", got)
+	}
+
+	// A directory the tool hasn't created yet is empty
+	missing, err := snapshotDir(filepath.Join(dir, "missing"))
+	if err != nil || len(missing) != 0 {
+		t.Errorf("Expected an empty snapshot for a missing directory, got %v, %v", missing, err)
+	}
+}
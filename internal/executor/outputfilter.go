@@ -0,0 +1,156 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OutputFilter rewrites a task output line before it is stored or
+// broadcast
+type OutputFilter func(line string) string
+
+// Output filter types for a tool's output_filters
+const (
+	// OutputFilterRedact replaces every match of the filter's patterns with
+	// RedactedText, e.g. tokens in URLs printed by curl -v
+	OutputFilterRedact = "redact"
+
+	// OutputFilterStripANSI removes terminal escape sequences such as colors
+	// and cursor movement
+	OutputFilterStripANSI = "strip_ansi"
+
+	// OutputFilterCollapse keeps only the text after the last carriage
+	// return, so progress bars redrawn in place store their final state
+	// instead of every redraw
+	OutputFilterCollapse = "collapse"
+)
+
+// RedactedText replaces output matched by a redact filter
+const RedactedText = "***"
+
+// OutputFilterConfig is one step of a tool's output filter chain
+type OutputFilterConfig struct {
+	Type string `json:"type" yaml:"type"`
+
+	// Patterns are the regular expressions a redact filter replaces
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+}
+
+// ansiPattern matches CSI sequences (colors, cursor movement), OSC
+// sequences (window titles, hyperlinks) and the remaining two-byte escapes
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// buildOutputFilters turns a tool's filter configuration into a chain,
+// applied in the configured order
+func buildOutputFilters(configs []OutputFilterConfig) ([]OutputFilter, error) {
+	chain := make([]OutputFilter, 0, len(configs))
+	for i, config := range configs {
+		filter, err := buildOutputFilter(config)
+		if err != nil {
+			return nil, fmt.Errorf("output_filters #%d: %w", i+1, err)
+		}
+		chain = append(chain, filter)
+	}
+	return chain, nil
+}
+
+// buildOutputFilter creates the filter for a single configuration entry
+func buildOutputFilter(config OutputFilterConfig) (OutputFilter, error) {
+	if config.Type != OutputFilterRedact && len(config.Patterns) > 0 {
+		return nil, fmt.Errorf("patterns are only supported by %q filters", OutputFilterRedact)
+	}
+
+	switch config.Type {
+	case OutputFilterRedact:
+		return RedactFilter(config.Patterns)
+	case OutputFilterStripANSI:
+		return StripANSI, nil
+	case OutputFilterCollapse:
+		return CollapseCarriageReturns, nil
+	case "":
+		return nil, errors.New("type is required")
+	default:
+		return nil, fmt.Errorf("type must be %q, %q or %q, got %q", OutputFilterRedact, OutputFilterStripANSI, OutputFilterCollapse, config.Type)
+	}
+}
+
+// RedactFilter returns a filter replacing every match of patterns with
+// RedactedText. A pattern with capture groups only has its first group
+// replaced, so "token=([^&]+)" keeps the parameter name readable.
+func RedactFilter(patterns []string) (OutputFilter, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("redact needs at least one pattern")
+	}
+
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled[i] = re
+	}
+
+	return func(line string) string {
+		for _, re := range compiled {
+			line = redact(re, line)
+		}
+		return line
+	}, nil
+}
+
+// redact replaces the matches of re in line, or only their first capture
+// group when re has one
+func redact(re *regexp.Regexp, line string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(line, RedactedText)
+	}
+
+	matches := re.FindAllStringSubmatchIndex(line, -1)
+	if matches == nil {
+		return line
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		start, end := match[2], match[3]
+		if start < 0 {
+			// The group didn't take part in this match
+			continue
+		}
+		b.WriteString(line[last:start])
+		b.WriteString(RedactedText)
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// StripANSI removes terminal escape sequences from line
+func StripANSI(line string) string {
+	if !strings.Contains(line, "\x1b") {
+		return line
+	}
+	return ansiPattern.ReplaceAllLiteralString(line, "")
+}
+
+// CollapseCarriageReturns keeps the text after the last carriage return in
+// line, ignoring a trailing one, the way a terminal would show it
+func CollapseCarriageReturns(line string) string {
+	trimmed := strings.TrimRight(line, "\r")
+	if i := strings.LastIndexByte(trimmed, '\r'); i >= 0 {
+		return trimmed[i+1:]
+	}
+	return trimmed
+}
+
+// applyOutputFilters runs line through chain in order
+func applyOutputFilters(chain []OutputFilter, line string) string {
+	for _, filter := range chain {
+		line = filter(line)
+	}
+	return line
+}
@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestOutputFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []OutputFilterConfig
+		line    string
+		want    string
+	}{
+		{
+			name: "no filters",
+			line: "\x1b[32mdone\x1b[0m",
+			want: "\x1b[32mdone\x1b[0m",
+		},
+		{
+			name:    "redact whole match",
+			filters: []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{`Bearer \S+`}}},
+			line:    "> Authorization: Bearer abc.def",
+			want:    "> Authorization: ***",
+		},
+		{
+			name:    "redact capture group",
+			filters: []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{`[?&]token=([^&\s]+)`}}},
+			line:    "GET /file?token=s3cret&id=1 and ?token=other",
+			want:    "GET /file?token=***&id=1 and ?token=***",
+		},
+		{
+			name:    "redact several patterns",
+			filters: []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{`key=(\w+)`, `password=(\w+)`}}},
+			line:    "key=a password=b",
+			want:    "key=*** password=***",
+		},
+		{
+			name:    "strip ansi",
+			filters: []OutputFilterConfig{{Type: OutputFilterStripANSI}},
+			line:    "\x1b[1;31mERROR:\x1b[0m \x1b]0;title\x07failed\x1b[K",
+			want:    "ERROR: failed",
+		},
+		{
+			name:    "collapse progress",
+			filters: []OutputFilterConfig{{Type: OutputFilterCollapse}},
+			line:    "[download]  10.0%\r[download]  55.0%\r[download] 100.0%\r",
+			want:    "[download] 100.0%",
+		},
+		{
+			// Colors split the secret from its key until they are stripped
+			name:    "strip before redact",
+			filters: []OutputFilterConfig{{Type: OutputFilterStripANSI}, {Type: OutputFilterRedact, Patterns: []string{`secret=\w+`}}},
+			line:    "secret=\x1b[1mhunter2\x1b[0m",
+			want:    "***",
+		},
+		{
+			name:    "redact before strip",
+			filters: []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{`secret=\w+`}}, {Type: OutputFilterStripANSI}},
+			line:    "secret=\x1b[1mhunter2\x1b[0m",
+			want:    "secret=hunter2",
+		},
+		{
+			// A secret in an overwritten progress line is gone once collapsed
+			name:    "collapse before redact",
+			filters: []OutputFilterConfig{{Type: OutputFilterCollapse}, {Type: OutputFilterRedact, Patterns: []string{`^Fetching.*`}}},
+			line:    "Fetching https://u:p@host/\rDone",
+			want:    "Done",
+		},
+		{
+			name:    "redact before collapse",
+			filters: []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{`^Fetching.*`}}, {Type: OutputFilterCollapse}},
+			line:    "Fetching https://u:p@host/\rDone",
+			want:    "***",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := buildOutputFilters(tt.filters)
+			if err != nil {
+				t.Fatalf("buildOutputFilters failed: %v", err)
+			}
+			if got := applyOutputFilters(chain, tt.line); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOutputFilterConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []OutputFilterConfig
+	}{
+		{"missing type", []OutputFilterConfig{{}}},
+		{"unknown type", []OutputFilterConfig{{Type: "uppercase"}}},
+		{"redact without patterns", []OutputFilterConfig{{Type: OutputFilterRedact}}},
+		{"invalid pattern", []OutputFilterConfig{{Type: OutputFilterRedact, Patterns: []string{"("}}}},
+		{"patterns on another type", []OutputFilterConfig{{Type: OutputFilterStripANSI, Patterns: []string{"x"}}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := buildOutputFilters(tt.filters); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestOutputFiltersApplied(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{
+		Name:    "sh",
+		Command: "sh",
+		Workers: 1,
+		OutputFilters: []OutputFilterConfig{
+			{Type: OutputFilterStripANSI},
+			{Type: OutputFilterRedact, Patterns: []string{`token=(\w+)`}},
+		},
+	}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	manager.CreateQueue("sh", DefaultQueueSize)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	tk := task.NewTask("sh", "sh", []string{"-c", `printf 'GET /?token=\033[1mabc\033[0m\n'; echo 'token=def' >&2`})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out, status %s: %s", tk.GetStatus(), tk.Clone().Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Both streams are filtered before the stderr prefix is added
+	output := tk.Clone().Output
+	want := []string{"GET /?token=***", types.StderrPrefix + "token=***"}
+	if len(output) == 2 && output[0] != want[0] {
+		output[0], output[1] = output[1], output[0]
+	}
+	if !reflect.DeepEqual(output, want) {
+		t.Errorf("Expected filtered output %q, got %q", want, output)
+	}
+}
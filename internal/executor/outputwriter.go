@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Defaults for batching a task's output, used when a tool doesn't set
+// output_flush_lines or output_flush_ms. Output reaches the database and the
+// WebSocket stream at most this late, and a chatty task costs one write per
+// batch instead of one per line.
+const (
+	DefaultOutputFlushLines    = 64
+	DefaultOutputFlushInterval = 100 * time.Millisecond
+)
+
+// Upper bounds for the flush settings of a tool
+const (
+	maxOutputFlushLines = 10000
+	maxOutputFlushMs    = 60000
+)
+
+// outputFlushSettings returns how many lines and how long a tool's output is
+// buffered before it is written
+func outputFlushSettings(tool Tool) (lines int, interval time.Duration) {
+	lines, interval = DefaultOutputFlushLines, DefaultOutputFlushInterval
+	if tool.OutputFlushLines > 0 {
+		lines = tool.OutputFlushLines
+	}
+	if tool.OutputFlushMs > 0 {
+		interval = time.Duration(tool.OutputFlushMs) * time.Millisecond
+	}
+	return lines, interval
+}
+
+// outputWriter batches the output lines of a task, shared by its stdout and
+// stderr readers. Buffered lines are written once maxLines are pending or
+// interval after the first of them, whichever comes first, and in the order
+// they were written. A maxLines of one writes every line right away.
+type outputWriter struct {
+	write    func(lines []string) error
+	maxLines int
+	interval time.Duration
+
+	mu      sync.Mutex // Guards pending and timer
+	pending []string
+	timer   *time.Timer
+
+	flushMu sync.Mutex // Keeps concurrent flushes in order
+}
+
+// newOutputWriter creates a writer passing batches of lines to write
+func newOutputWriter(write func(lines []string) error, maxLines int, interval time.Duration) *outputWriter {
+	return &outputWriter{
+		write:    write,
+		maxLines: maxLines,
+		interval: interval,
+	}
+}
+
+// add buffers a line, flushing right away when the batch is full. A full
+// batch is written by the reader itself, so a task producing output faster
+// than it can be stored is slowed down instead of buffering without bound.
+func (w *outputWriter) add(line string) {
+	w.mu.Lock()
+	w.pending = append(w.pending, line)
+	full := len(w.pending) >= w.maxLines
+	if !full && w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	}
+	w.mu.Unlock()
+
+	if full {
+		w.flush()
+	}
+}
+
+// flush writes the buffered lines
+func (w *outputWriter) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	lines := w.pending
+	w.pending = nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mu.Unlock()
+
+	if len(lines) == 0 {
+		return
+	}
+	if err := w.write(lines); err != nil {
+		log.Printf("Failed to append task output: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingWriter collects the batches an outputWriter writes
+type recordingWriter struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (r *recordingWriter) write(lines []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, lines)
+	return nil
+}
+
+func (r *recordingWriter) get() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.batches...)
+}
+
+func TestOutputWriterFlushesFullBatches(t *testing.T) {
+	rec := &recordingWriter{}
+	w := newOutputWriter(rec.write, 3, time.Hour)
+
+	for i := 0; i < 7; i++ {
+		w.add(fmt.Sprintf("line %d", i))
+	}
+	want := [][]string{{"line 0", "line 1", "line 2"}, {"line 3", "line 4", "line 5"}}
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected full batches %v, got %v", want, got)
+	}
+
+	// The final flush writes the rest
+	w.flush()
+	want = append(want, []string{"line 6"})
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the remaining line after flush, got %v", got)
+	}
+
+	// Nothing left to write
+	w.flush()
+	if got := rec.get(); len(got) != 3 {
+		t.Errorf("Expected no empty batch, got %v", got)
+	}
+}
+
+func TestOutputWriterFlushesAfterInterval(t *testing.T) {
+	rec := &recordingWriter{}
+	w := newOutputWriter(rec.write, 100, 20*time.Millisecond)
+
+	w.add("first")
+	w.add("second")
+	if got := rec.get(); len(got) != 0 {
+		t.Fatalf("Expected lines to be buffered, got %v", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rec.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := [][]string{{"first", "second"}}
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected one batch after the interval, got %v", got)
+	}
+}
+
+func TestOutputWriterWriteThrough(t *testing.T) {
+	rec := &recordingWriter{}
+	w := newOutputWriter(rec.write, 1, time.Hour)
+
+	w.add("first")
+	w.add("second")
+	want := [][]string{{"first"}, {"second"}}
+	if got := rec.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected every line written right away, got %v", got)
+	}
+}
+
+func TestOutputFlushSettings(t *testing.T) {
+	lines, interval := outputFlushSettings(Tool{})
+	if lines != DefaultOutputFlushLines || interval != DefaultOutputFlushInterval {
+		t.Errorf("Expected the defaults, got %d lines and %v", lines, interval)
+	}
+	lines, interval = outputFlushSettings(Tool{OutputFlushLines: 1, OutputFlushMs: 500})
+	if lines != 1 || interval != 500*time.Millisecond {
+		t.Errorf("Expected 1 line and 500ms, got %d lines and %v", lines, interval)
+	}
+}
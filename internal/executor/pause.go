@@ -0,0 +1,61 @@
+package executor
+
+import "log"
+
+// Pause stops workers from picking up queued tasks. Running tasks, and
+// tasks already waiting for a host or global slot, carry on. Tasks can
+// still be queued while paused; they run once Resume is called.
+func (e *Executor) Pause() {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	if isClosed(e.paused) {
+		return
+	}
+	close(e.paused)
+	e.resumed = make(chan struct{})
+	log.Printf("Executor paused, queued tasks wait until it is resumed")
+}
+
+// Resume lets workers pick up queued tasks again after Pause
+func (e *Executor) Resume() {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	if isClosed(e.resumed) {
+		return
+	}
+	close(e.resumed)
+	e.paused = make(chan struct{})
+	log.Printf("Executor resumed")
+}
+
+// Paused reports whether workers are held back by Pause
+func (e *Executor) Paused() bool {
+	paused, _ := e.pauseState()
+	return isClosed(paused)
+}
+
+// pauseState returns the channels closed while the executor is paused and
+// while it isn't
+func (e *Executor) pauseState() (paused, resumed chan struct{}) {
+	e.pauseMu.Lock()
+	defer e.pauseMu.Unlock()
+	return e.paused, e.resumed
+}
+
+// closedChan returns a closed channel
+func closedChan() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// isClosed reports whether ch is closed. Nothing is ever sent on the
+// channels it is used with.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package executor
+
+import "os/exec"
+
+// setProcessGroup does nothing, cancellation only kills the tool's own
+// process on this platform
+func setProcessGroup(_ *exec.Cmd) {}
@@ -0,0 +1,50 @@
+//go:build unix
+
+package executor
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestProcessGroupKillsChildren(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The backgrounded sleep inherits stdout, so reading it only reaches EOF
+	// once the child is gone as well as the shell
+	cmd := exec.CommandContext(ctx, "sh", "-c", "sleep 60 & echo started; wait")
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+
+	line := make([]byte, len("started\n"))
+	if _, err := io.ReadFull(stdout, line); err != nil {
+		t.Fatalf("Failed to read from command: %v", err)
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, stdout)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Child process outlived the canceled command")
+	}
+
+	if err := cmd.Wait(); err == nil {
+		t.Error("Expected killed command to return an error")
+	}
+}
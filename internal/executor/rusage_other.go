@@ -0,0 +1,10 @@
+//go:build !unix
+
+package executor
+
+import "os"
+
+// maxRSSBytes returns nil, peak memory isn't reported on this platform
+func maxRSSBytes(_ *os.ProcessState) *int64 {
+	return nil
+}
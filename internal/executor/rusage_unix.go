@@ -0,0 +1,25 @@
+//go:build unix
+
+package executor
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSSBytes returns the peak resident set size of a finished process in
+// bytes, or nil if it isn't available
+func maxRSSBytes(state *os.ProcessState) *int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || usage == nil {
+		return nil
+	}
+
+	// ru_maxrss is reported in bytes on Darwin and in kilobytes elsewhere
+	rss := int64(usage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		rss *= 1024
+	}
+	return &rss
+}
@@ -0,0 +1,155 @@
+package executor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Policies for handing out global task slots when max_concurrent_tasks is
+// set
+const (
+	// SchedulingFIFO gives a free slot to the oldest waiting task, whatever
+	// its tool. A burst of tasks for one tool delays every later task.
+	SchedulingFIFO = "fifo"
+
+	// SchedulingRoundRobin gives free slots to the tools with waiting tasks
+	// in turn, oldest task first within a tool. A tool with a lone task gets
+	// the next turn even behind a long backlog of another tool.
+	SchedulingRoundRobin = "round_robin"
+)
+
+// DefaultScheduling is the policy used when the config doesn't set one
+const DefaultScheduling = SchedulingRoundRobin
+
+// slotScheduler caps how many tasks run at once across all tools and
+// decides which waiting task gets the next free slot
+type slotScheduler struct {
+	limit  int
+	policy string
+	tools  []string // Round-robin order
+
+	mu      sync.Mutex
+	running int
+	waiting []*slotWaiter // In arrival order
+	last    string        // Tool that got the last slot
+}
+
+// slotWaiter is a task waiting for a global slot
+type slotWaiter struct {
+	tool    string
+	created time.Time
+	ready   chan struct{} // Closed when the slot is granted
+}
+
+// newSlotScheduler creates a scheduler allowing limit concurrent tasks,
+// taking turns between tools in the given order. A limit of zero or less
+// disables limiting.
+func newSlotScheduler(limit int, policy string, tools []string) *slotScheduler {
+	if policy == "" {
+		policy = DefaultScheduling
+	}
+	return &slotScheduler{
+		limit:  limit,
+		policy: policy,
+		tools:  tools,
+	}
+}
+
+// acquire blocks until the scheduler grants a slot to a task of tool
+// created at created, or ctx is done. onWait is called once if the caller
+// has to wait. The returned release function must be called when the task
+// ends.
+func (s *slotScheduler) acquire(ctx context.Context, tool string, created time.Time, onWait func()) (release func(), err error) {
+	if s.limit <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.running < s.limit && len(s.waiting) == 0 {
+		s.running++
+		s.last = tool
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	w := &slotWaiter{tool: tool, created: created, ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.mu.Unlock()
+
+	onWait()
+	select {
+	case <-w.ready:
+		return s.release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.waiting {
+		if other == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return nil, ctx.Err()
+		}
+	}
+	// The slot was granted while giving up, pass it on
+	s.running--
+	s.grant()
+	return nil, ctx.Err()
+}
+
+// release frees a slot and grants it to the next waiting task
+func (s *slotScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.grant()
+}
+
+// grant hands free slots to waiting tasks according to the policy. The
+// caller must hold s.mu.
+func (s *slotScheduler) grant() {
+	for s.running < s.limit && len(s.waiting) > 0 {
+		i := s.next()
+		w := s.waiting[i]
+		s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+		s.running++
+		s.last = w.tool
+		close(w.ready)
+	}
+}
+
+// next returns the index of the waiting task to run next
+func (s *slotScheduler) next() int {
+	if s.policy == SchedulingRoundRobin {
+		// The first tool after the last one served that has waiting tasks
+		start := 0
+		for i, tool := range s.tools {
+			if tool == s.last {
+				start = i + 1
+				break
+			}
+		}
+		for n := 0; n < len(s.tools); n++ {
+			tool := s.tools[(start+n)%len(s.tools)]
+			if i := s.oldest(tool); i >= 0 {
+				return i
+			}
+		}
+	}
+	return s.oldest("")
+}
+
+// oldest returns the index of the oldest waiting task of tool, or of any
+// tool when tool is empty. It returns -1 if there is none.
+func (s *slotScheduler) oldest(tool string) int {
+	best := -1
+	for i, w := range s.waiting {
+		if tool != "" && w.tool != tool {
+			continue
+		}
+		if best < 0 || w.created.Before(s.waiting[best].created) {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlotSchedulerPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		// Oldest first, so the curl backlog runs before the later ffmpeg task
+		{SchedulingFIFO, []string{"curl-1", "curl-2", "curl-3", "ffmpeg-1"}},
+		// curl had the last turn, so ffmpeg goes next
+		{SchedulingRoundRobin, []string{"ffmpeg-1", "curl-1", "curl-2", "curl-3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			s := newSlotScheduler(1, tt.policy, []string{"curl", "ffmpeg"})
+			ctx := context.Background()
+			start := time.Now()
+
+			release, err := s.acquire(ctx, "curl", start, func() { t.Error("Expected a free slot") })
+			if err != nil {
+				t.Fatalf("acquire() error = %v", err)
+			}
+
+			var mu sync.Mutex
+			var order []string
+			var wg sync.WaitGroup
+			waiters := []struct {
+				name, tool string
+			}{{"curl-1", "curl"}, {"curl-2", "curl"}, {"curl-3", "curl"}, {"ffmpeg-1", "ffmpeg"}}
+			for i, w := range waiters {
+				waiting := make(chan struct{})
+				wg.Add(1)
+				go func(name, tool string, created time.Time) {
+					defer wg.Done()
+					release, err := s.acquire(ctx, tool, created, func() { close(waiting) })
+					if err != nil {
+						t.Errorf("acquire(%s) error = %v", name, err)
+						return
+					}
+					mu.Lock()
+					order = append(order, name)
+					mu.Unlock()
+					release()
+				}(w.name, w.tool, start.Add(time.Duration(i+1)*time.Second))
+				<-waiting
+			}
+
+			release()
+			wg.Wait()
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("Expected slots in order %v, got %v", tt.want, order)
+			}
+		})
+	}
+}
+
+func TestSlotSchedulerCanceledWait(t *testing.T) {
+	s := newSlotScheduler(1, SchedulingRoundRobin, []string{"curl"})
+	release, err := s.acquire(context.Background(), "curl", time.Now(), func() {})
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, "curl", time.Now(), func() { cancel() })
+		done <- err
+	}()
+	if err := <-done; err == nil {
+		t.Fatal("Expected a canceled wait to fail")
+	}
+
+	// The canceled waiter must not hold on to the slot
+	release()
+	if _, err := s.acquire(context.Background(), "curl", time.Now(), func() { t.Error("Expected the slot to be free") }); err != nil {
+		t.Errorf("acquire() error = %v", err)
+	}
+}
+
+func TestSlotSchedulerUnlimited(t *testing.T) {
+	s := newSlotScheduler(0, "", nil)
+	for i := 0; i < 10; i++ {
+		if _, err := s.acquire(context.Background(), "curl", time.Now(), func() { t.Error("Expected no waiting without a limit") }); err != nil {
+			t.Fatalf("acquire() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"sync"
+	"time"
+)
+
+// outputActivity tracks when each running task last produced output, to spot
+// tasks that hang without exiting
+type outputActivity struct {
+	mu    sync.Mutex
+	tasks map[string]*taskActivity
+}
+
+// taskActivity is the output state of a single running task
+type taskActivity struct {
+	tool       string
+	lastOutput time.Time
+	stalled    bool
+}
+
+// newOutputActivity creates a tracker with no running tasks
+func newOutputActivity() *outputActivity {
+	return &outputActivity{
+		tasks: make(map[string]*taskActivity),
+	}
+}
+
+// start begins tracking a task whose process was just started. The returned
+// function stops tracking it and must be called when the process exits.
+func (a *outputActivity) start(taskID, tool string) (finish func()) {
+	a.mu.Lock()
+	a.tasks[taskID] = &taskActivity{tool: tool, lastOutput: time.Now()}
+	a.mu.Unlock()
+
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.tasks, taskID)
+	}
+}
+
+// touch records output from a task. It reports whether the task had been
+// stalled and has now resumed.
+func (a *outputActivity) touch(taskID string) (resumed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ta, ok := a.tasks[taskID]
+	if !ok {
+		return false
+	}
+	ta.lastOutput = time.Now()
+	resumed = ta.stalled
+	ta.stalled = false
+	return resumed
+}
+
+// checkStalled marks a task stalled when it produced no output for timeout.
+// It reports how long the task has been silent and whether it just became
+// stalled, so each stall is reported once.
+func (a *outputActivity) checkStalled(taskID string, timeout time.Duration) (idle time.Duration, stalled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ta, ok := a.tasks[taskID]
+	if !ok {
+		return 0, false
+	}
+	idle = time.Since(ta.lastOutput)
+	if ta.stalled || idle < timeout {
+		return idle, false
+	}
+	ta.stalled = true
+	return idle, true
+}
+
+// stalledTasks returns the number of running tasks of tool that are stalled
+func (a *outputActivity) stalledTasks(tool string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	count := 0
+	for _, ta := range a.tasks {
+		if ta.tool == tool && ta.stalled {
+			count++
+		}
+	}
+	return count
+}
+
+// stallCheckInterval is how often a task is checked for a stall. Checking a
+// few times per timeout keeps the detection delay small without polling
+// constantly.
+func stallCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	return interval
+}
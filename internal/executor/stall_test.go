@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutputActivity(t *testing.T) {
+	a := newOutputActivity()
+	finish := a.start("task-1", "wget")
+
+	if _, stalled := a.checkStalled("task-1", time.Hour); stalled {
+		t.Error("Expected a fresh task not to be stalled")
+	}
+	if _, stalled := a.checkStalled("task-1", 0); !stalled {
+		t.Error("Expected the task to stall")
+	}
+	if _, stalled := a.checkStalled("task-1", 0); stalled {
+		t.Error("Expected a stall to be reported only once")
+	}
+	if a.stalledTasks("wget") != 1 {
+		t.Errorf("Expected 1 stalled task, got %d", a.stalledTasks("wget"))
+	}
+
+	if !a.touch("task-1") {
+		t.Error("Expected output after a stall to resume the task")
+	}
+	if a.touch("task-1") || a.stalledTasks("wget") != 0 {
+		t.Error("Expected the task to be active again")
+	}
+
+	finish()
+	if _, stalled := a.checkStalled("task-1", 0); stalled {
+		t.Error("Expected a finished task not to be tracked")
+	}
+}
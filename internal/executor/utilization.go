@@ -0,0 +1,37 @@
+package executor
+
+import "sync"
+
+// workerUsage counts how many workers of each tool are busy with a task
+type workerUsage struct {
+	mu   sync.Mutex
+	busy map[string]int
+}
+
+// newWorkerUsage creates a tracker with every worker idle
+func newWorkerUsage() *workerUsage {
+	return &workerUsage{
+		busy: make(map[string]int),
+	}
+}
+
+// start marks a worker of tool as busy. The returned function marks it idle
+// again and must be called when the task ends.
+func (u *workerUsage) start(tool string) (finish func()) {
+	u.mu.Lock()
+	u.busy[tool]++
+	u.mu.Unlock()
+
+	return func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		u.busy[tool]--
+	}
+}
+
+// busyWorkers returns the number of busy workers of tool
+func (u *workerUsage) busyWorkers(tool string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.busy[tool]
+}
@@ -0,0 +1,35 @@
+package executor
+
+import "testing"
+
+func TestWorkerUsage(t *testing.T) {
+	usage := newWorkerUsage()
+
+	finishFirst := usage.start("wget")
+	finishSecond := usage.start("wget")
+	finishOther := usage.start("curl")
+
+	if got := usage.busyWorkers("wget"); got != 2 {
+		t.Errorf("Expected 2 busy wget workers, got %d", got)
+	}
+	if got := usage.busyWorkers("curl"); got != 1 {
+		t.Errorf("Expected 1 busy curl worker, got %d", got)
+	}
+	if got := usage.busyWorkers("ffmpeg"); got != 0 {
+		t.Errorf("Expected no busy ffmpeg workers, got %d", got)
+	}
+
+	finishFirst()
+	finishOther()
+	if got := usage.busyWorkers("wget"); got != 1 {
+		t.Errorf("Expected 1 busy wget worker after finishing one, got %d", got)
+	}
+	if got := usage.busyWorkers("curl"); got != 0 {
+		t.Errorf("Expected no busy curl workers, got %d", got)
+	}
+
+	finishSecond()
+	if got := usage.busyWorkers("wget"); got != 0 {
+		t.Errorf("Expected no busy wget workers, got %d", got)
+	}
+}
@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionTimeout bounds how long a tool's version command may run on startup
+const versionTimeout = 10 * time.Second
+
+// Version numbers in a tool's output: dotted numbers are preferred, so that
+// "ffmpeg version 6.0 Copyright (c) 2000-2023" gives 6.0, with a lone number
+// ("v2") as the fallback
+var (
+	dottedVersionPattern = regexp.MustCompile(`\d+(?:\.\d+)+`)
+	plainVersionPattern  = regexp.MustCompile(`\d+`)
+)
+
+// parseVersion finds the first version number in s, such as "2023.07.06" in
+// "yt-dlp 2023.07.06" or "1.21.3" in "GNU Wget 1.21.3 built on linux-gnu.",
+// returning it as text and as its numeric components. It returns false if s
+// holds no number.
+func parseVersion(s string) (string, []int, bool) {
+	version := dottedVersionPattern.FindString(s)
+	if version == "" {
+		version = plainVersionPattern.FindString(s)
+	}
+	if version == "" {
+		return "", nil, false
+	}
+
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			// Too many digits to be a version component
+			return "", nil, false
+		}
+		numbers[i] = n
+	}
+	return version, numbers, true
+}
+
+// compareVersions compares versions component by component, missing
+// components counting as zero so that 1.2 equals 1.2.0. It returns -1, 0 or
+// 1 as a is older than, equal to or newer than b.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionArgv returns the command line printing a tool's version: its
+// version_command, or its command with --version
+func versionArgv(tool Tool) []string {
+	if argv := strings.Fields(tool.VersionCommand); len(argv) > 0 {
+		return argv
+	}
+	return []string{tool.Command, "--version"}
+}
+
+// detectVersion runs a tool's version command and parses its output
+func (e *Executor) detectVersion(ctx context.Context, tool Tool) (string, []int, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+
+	argv := versionArgv(tool)
+	output, err := e.runner.Command(ctx, argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("running %q: %w", strings.Join(argv, " "), err)
+	}
+	version, numbers, ok := parseVersion(string(output))
+	if !ok {
+		return "", nil, errors.New("no version number in its output")
+	}
+	return version, numbers, nil
+}
+
+// checkVersions detects the version of every installed tool that sets
+// min_version or version_command. A tool older than its min_version is
+// reported, and with require_min_version CheckTool refuses it. A version
+// that can't be detected is only reported, the tool may well work.
+func (e *Executor) checkVersions() {
+	versions := make(map[string]string)
+	outdated := make(map[string]bool)
+	for _, tool := range e.config.Tools {
+		if tool.MinVersion == "" && tool.VersionCommand == "" {
+			continue
+		}
+		e.missingMu.RLock()
+		missing := e.missing[tool.Name]
+		e.missingMu.RUnlock()
+		if missing {
+			continue
+		}
+
+		version, numbers, err := e.detectVersion(e.ctx, tool)
+		if err != nil {
+			log.Printf("Warning: failed to detect the version of tool %s: %v", tool.Name, err)
+			continue
+		}
+		versions[tool.Name] = version
+
+		// The config has been validated, min_version parses
+		_, minimum, ok := parseVersion(tool.MinVersion)
+		if !ok || compareVersions(numbers, minimum) >= 0 {
+			continue
+		}
+		if tool.RequireMinVersion {
+			log.Printf("Warning: tool %s is disabled, version %s is older than min_version %s", tool.Name, version, tool.MinVersion)
+			outdated[tool.Name] = true
+		} else {
+			log.Printf("Warning: tool %s version %s is older than min_version %s, some of its configuration may not work", tool.Name, version, tool.MinVersion)
+		}
+	}
+
+	e.missingMu.Lock()
+	defer e.missingMu.Unlock()
+	e.versions = versions
+	e.outdated = outdated
+}
+
+// ToolVersion returns the version of a tool detected on startup, or "" when
+// it wasn't checked or couldn't be detected
+func (e *Executor) ToolVersion(toolName string) string {
+	e.missingMu.RLock()
+	defer e.missingMu.RUnlock()
+	return e.versions[toolName]
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    string
+		numbers []int
+	}{
+		{"2023.07.06\n", "2023.07.06", []int{2023, 7, 6}},
+		{"yt-dlp 2024.08.06.232746", "2024.08.06.232746", []int{2024, 8, 6, 232746}},
+		{"GNU Wget 1.21.3 built on linux-gnu.", "1.21.3", []int{1, 21, 3}},
+		{"ffmpeg version 6.0-static Copyright (c) 2000-2023", "6.0", []int{6, 0}},
+		{"gallery-dl v1.26.1-dev", "1.26.1", []int{1, 26, 1}},
+		{"tool version 7", "7", []int{7}},
+	}
+	for _, tt := range tests {
+		version, numbers, ok := parseVersion(tt.output)
+		if !ok || version != tt.want || !reflect.DeepEqual(numbers, tt.numbers) {
+			t.Errorf("parseVersion(%q) = %q, %v, %v, want %q, %v", tt.output, version, numbers, ok, tt.want, tt.numbers)
+		}
+	}
+
+	if _, _, ok := parseVersion("unknown"); ok {
+		t.Error("Expected no version in output without numbers")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		want int
+	}{
+		{[]int{1, 2}, []int{1, 2, 0}, 0},
+		{[]int{1, 10}, []int{1, 9, 5}, 1},
+		{[]int{2023, 7, 6}, []int{2023, 11, 1}, -1},
+		{[]int{2}, []int{1, 99}, 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{
+		{Name: "current", Command: "echo", VersionCommand: "echo yt-dlp 2023.07.06", MinVersion: "2023.07.06", RequireMinVersion: true},
+		{Name: "old", Command: "echo", VersionCommand: "echo yt-dlp 2022.10.04", MinVersion: "2023.07.06", RequireMinVersion: true},
+		{Name: "warned", Command: "echo", VersionCommand: "echo 1.2", MinVersion: "1.3"},
+		{Name: "unversioned", Command: "echo", VersionCommand: "echo unknown", MinVersion: "1.0", RequireMinVersion: true},
+		{Name: "unchecked", Command: "echo"},
+	}}
+	e := newExecutor(config, 1, manager)
+	e.checkCommands()
+	e.checkVersions()
+
+	versions := map[string]string{"current": "2023.07.06", "old": "2022.10.04", "warned": "1.2", "unversioned": "", "unchecked": ""}
+	for name, want := range versions {
+		if got := e.ToolVersion(name); got != want {
+			t.Errorf("ToolVersion(%s) = %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err := e.CheckTool("old"); !errors.Is(err, ErrVersionTooOld) {
+		t.Errorf("Expected ErrVersionTooOld for an outdated tool, got %v", err)
+	}
+	// Without require_min_version, or when the version can't be detected,
+	// the tool stays usable
+	for _, name := range []string{"current", "warned", "unversioned", "unchecked"} {
+		if _, err := e.CheckTool(name); err != nil {
+			t.Errorf("CheckTool(%s) error = %v", name, err)
+		}
+	}
+	if got := e.AvailableTools(); len(got) != 4 {
+		t.Errorf("Expected the outdated tool to be left out, got %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lepinkainen/commander/internal/storage"
+)
+
+// ErrInvalidWorkerCount is returned by SetWorkers for counts outside
+// 1..maxWorkers
+var ErrInvalidWorkerCount = errors.New("invalid worker count")
+
+// workerPool is the set of workers running for one tool
+type workerPool struct {
+	stops []chan struct{} // One per worker, closed to drain it
+}
+
+// SetWorkerStore makes worker counts changed with SetWorkers persistent.
+// It must be called before Start, which applies the stored counts.
+func (e *Executor) SetWorkerStore(store storage.WorkerStore) {
+	e.workerStore = store
+}
+
+// SetWorkers changes how many workers a tool runs. New workers start right
+// away; surplus workers finish the task they are running and then exit.
+// The count is saved to the worker store first, so a failure to save it
+// leaves the pool unchanged.
+func (e *Executor) SetWorkers(ctx context.Context, toolName string, count int) error {
+	tool, ok := e.GetTool(toolName)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownTool, toolName)
+	}
+	if count < 1 || count > maxWorkers {
+		return fmt.Errorf("%w: must be between 1 and %d, got %d", ErrInvalidWorkerCount, maxWorkers, count)
+	}
+
+	// Holding the lock keeps Stop from waiting for workers while new ones
+	// are added
+	e.poolsMu.Lock()
+	defer e.poolsMu.Unlock()
+	if e.ctx.Err() != nil {
+		return errors.New("executor is stopped")
+	}
+
+	if e.workerStore != nil {
+		if err := e.workerStore.SetWorkerOverride(ctx, toolName, count); err != nil {
+			return err
+		}
+	}
+	e.overrides[toolName] = count
+	if pool, ok := e.pools[toolName]; ok {
+		e.resizePool(tool, pool, count)
+		log.Printf("Workers for %s set to %d", toolName, count)
+	}
+	return nil
+}
+
+// loadWorkerOverrides applies the worker counts saved by SetWorkers.
+// Counts for tools that are no longer configured or that are out of bounds
+// are ignored.
+func (e *Executor) loadWorkerOverrides(ctx context.Context) {
+	if e.workerStore == nil {
+		return
+	}
+	overrides, err := e.workerStore.WorkerOverrides(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to load worker overrides: %v", err)
+		return
+	}
+
+	e.poolsMu.Lock()
+	defer e.poolsMu.Unlock()
+	for toolName, count := range overrides {
+		if _, ok := e.GetTool(toolName); !ok {
+			continue
+		}
+		if count < 1 || count > maxWorkers {
+			log.Printf("Warning: ignoring saved worker count %d for %s", count, toolName)
+			continue
+		}
+		e.overrides[toolName] = count
+	}
+}
+
+// resizePool starts or drains workers until count are running. The caller
+// must hold e.poolsMu.
+func (e *Executor) resizePool(tool Tool, pool *workerPool, count int) {
+	for len(pool.stops) < count {
+		stop := make(chan struct{})
+		pool.stops = append(pool.stops, stop)
+		e.wg.Add(1)
+		go e.worker(tool, stop)
+	}
+	for len(pool.stops) > count {
+		last := len(pool.stops) - 1
+		close(pool.stops[last])
+		pool.stops = pool.stops[:last]
+	}
+}
@@ -0,0 +1,197 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/task"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// runningWorkers returns how many workers a tool's pool currently has
+func runningWorkers(e *Executor, toolName string) int {
+	e.poolsMu.RLock()
+	defer e.poolsMu.RUnlock()
+	return len(e.pools[toolName].stops)
+}
+
+func TestSetWorkers(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := task.NewManager(repo)
+	config := Config{Tools: []Tool{{Name: "sh", Command: "sh", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	e.SetWorkerStore(repo)
+	ctx := context.Background()
+
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	if err := e.SetWorkers(ctx, "sh", 3); err != nil {
+		t.Fatalf("SetWorkers failed: %v", err)
+	}
+	if got := e.WorkerCount(config.Tools[0]); got != 3 {
+		t.Errorf("Expected 3 workers, got %d", got)
+	}
+
+	// Three tasks run side by side instead of one after another
+	tasks := make([]*task.Task, 3)
+	for i := range tasks {
+		tasks[i] = task.NewTask("sh", "sh", []string{"-c", "sleep 0.3"})
+		if err := manager.AddTask(ctx, tasks[i]); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for e.BusyWorkers("sh") < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 busy workers, got %d", e.BusyWorkers("sh"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Draining lets the running tasks finish
+	if err := e.SetWorkers(ctx, "sh", 1); err != nil {
+		t.Fatalf("SetWorkers failed: %v", err)
+	}
+	if got := runningWorkers(e, "sh"); got != 1 {
+		t.Errorf("Expected 1 worker in the pool, got %d", got)
+	}
+	for _, tk := range tasks {
+		for tk.GetStatus() != types.StatusComplete {
+			if time.Now().After(deadline) {
+				t.Fatalf("Task %s not completed after draining, status %s", tk.ID, tk.GetStatus())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// The remaining worker keeps processing tasks
+	tk := task.NewTask("sh", "sh", []string{"-c", "true"})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Task not run after draining, status %s", tk.GetStatus())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if overrides, _ := repo.WorkerOverrides(ctx); overrides["sh"] != 1 {
+		t.Errorf("Expected the override to be saved, got %v", overrides)
+	}
+}
+
+func TestSetWorkersInvalid(t *testing.T) {
+	e := newExecutor(Config{Tools: []Tool{{Name: "sh", Command: "sh"}}}, 1, task.NewManager(storage.NewMockRepository()))
+	ctx := context.Background()
+
+	if err := e.SetWorkers(ctx, "missing", 2); !errors.Is(err, ErrUnknownTool) {
+		t.Errorf("Expected ErrUnknownTool, got %v", err)
+	}
+	for _, count := range []int{-1, 0, maxWorkers + 1} {
+		if err := e.SetWorkers(ctx, "sh", count); !errors.Is(err, ErrInvalidWorkerCount) {
+			t.Errorf("count %d: expected ErrInvalidWorkerCount, got %v", count, err)
+		}
+	}
+}
+
+func TestWorkerOverridesSurviveRestart(t *testing.T) {
+	repo := storage.NewMockRepository()
+	config := Config{Tools: []Tool{{Name: "sh", Command: "sh", Workers: 1}, {Name: "echo", Command: "echo"}}}
+	ctx := context.Background()
+
+	if err := repo.SetWorkerOverride(ctx, "sh", 4); err != nil {
+		t.Fatalf("SetWorkerOverride failed: %v", err)
+	}
+	// Out of bounds or for a tool that was removed from the config
+	if err := repo.SetWorkerOverride(ctx, "echo", maxWorkers+1); err != nil {
+		t.Fatalf("SetWorkerOverride failed: %v", err)
+	}
+	if err := repo.SetWorkerOverride(ctx, "wget", 2); err != nil {
+		t.Fatalf("SetWorkerOverride failed: %v", err)
+	}
+
+	e := newExecutor(config, 2, task.NewManager(repo))
+	e.SetWorkerStore(repo)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	if got := runningWorkers(e, "sh"); got != 4 {
+		t.Errorf("Expected the saved 4 workers for sh, got %d", got)
+	}
+	if got := runningWorkers(e, "echo"); got != 2 {
+		t.Errorf("Expected the default 2 workers for echo, got %d", got)
+	}
+}
+
+func TestWorkersFollowRecreatedQueue(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "sh", Command: "sh", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	manager.CreateQueue("sh", 5)
+	tk := task.NewTask("sh", "sh", []string{"-c", "true"})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Task not run from the recreated queue, status %s", tk.GetStatus())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	manager := task.NewManager(storage.NewMockRepository())
+	config := Config{Tools: []Tool{{Name: "sh", Command: "sh", Workers: 1}}}
+	e := newExecutor(config, 1, manager)
+	ctx := context.Background()
+
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer e.Stop()
+
+	e.Pause()
+	if !e.Paused() {
+		t.Fatal("Expected the executor to be paused")
+	}
+
+	// Queued tasks wait while paused
+	tk := task.NewTask("sh", "sh", []string{"-c", "true"})
+	if err := manager.AddTask(ctx, tk); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if status := tk.GetStatus(); status != types.StatusQueued {
+		t.Fatalf("Expected the task to stay queued while paused, got %s", status)
+	}
+
+	e.Resume()
+	if e.Paused() {
+		t.Fatal("Expected the executor to be resumed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tk.GetStatus() != types.StatusComplete {
+		if time.Now().After(deadline) {
+			t.Fatalf("Task not run after resuming, status %s", tk.GetStatus())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
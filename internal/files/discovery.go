@@ -2,28 +2,84 @@ package files
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/types"
 )
 
 // FileDiscovery handles automatic file discovery from task output
 type FileDiscovery struct {
-	fileManager *Manager
+	fileManager      *Manager
+	organizePatterns map[string]string
+	organizeRoutes   []OrganizeRoute
+	mu               sync.RWMutex
 }
 
 // NewFileDiscovery creates a new file discovery service
 func NewFileDiscovery(fileManager *Manager) *FileDiscovery {
 	return &FileDiscovery{
-		fileManager: fileManager,
+		fileManager:      fileManager,
+		organizePatterns: make(map[string]string),
 	}
 }
 
+// SetOrganizePattern sets the destination pattern used when organizing a
+// tool's files. An empty pattern restores DefaultOrganizePattern.
+func (fd *FileDiscovery) SetOrganizePattern(toolName, pattern string) error {
+	if err := ValidateOrganizePattern(pattern); err != nil {
+		return fmt.Errorf("invalid organize pattern %q: %w", pattern, err)
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if pattern == "" {
+		delete(fd.organizePatterns, toolName)
+	} else {
+		fd.organizePatterns[toolName] = pattern
+	}
+	return nil
+}
+
+// organizePattern returns the destination pattern for a tool
+func (fd *FileDiscovery) organizePattern(toolName string) string {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+	if pattern, ok := fd.organizePatterns[toolName]; ok {
+		return pattern
+	}
+	return DefaultOrganizePattern
+}
+
+// SetOrganizeRoutes sets the MIME type routes that send organized files to
+// other base directories than their tool's. The first matching route wins.
+func (fd *FileDiscovery) SetOrganizeRoutes(routes []OrganizeRoute) error {
+	for i, route := range routes {
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("invalid organize route #%d: %w", i+1, err)
+		}
+	}
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.organizeRoutes = append([]OrganizeRoute(nil), routes...)
+	return nil
+}
+
+// organizeRoute returns the route for files of mimeType, if any
+func (fd *FileDiscovery) organizeRoute(mimeType string) (OrganizeRoute, bool) {
+	fd.mu.RLock()
+	defer fd.mu.RUnlock()
+	return matchOrganizeRoute(fd.organizeRoutes, mimeType)
+}
+
 // FilePattern represents patterns for detecting files in task output
 type FilePattern struct {
 	Tool        string
@@ -139,11 +195,12 @@ func (fd *FileDiscovery) deduplicateFiles(files []string) []string {
 	return result
 }
 
-// RegisterDiscoveredFiles registers discovered files with the file manager
-func (fd *FileDiscovery) RegisterDiscoveredFiles(ctx context.Context, taskID string, filePaths []string) error {
+// RegisterDiscoveredFiles registers discovered files with the file manager,
+// tagged with tags
+func (fd *FileDiscovery) RegisterDiscoveredFiles(ctx context.Context, taskID string, filePaths, tags []string) error {
 	for _, filePath := range filePaths {
 		// Try to register with appropriate directory
-		if err := fd.fileManager.RegisterFileFromTask(ctx, taskID, filePath, nil); err != nil {
+		if err := fd.fileManager.RegisterFileFromTask(ctx, taskID, filePath, nil, tags); err != nil {
 			// Log error but continue with other files
 			fmt.Printf("Warning: failed to register file %s for task %s: %v\n", filePath, taskID, err)
 		}
@@ -154,49 +211,111 @@ func (fd *FileDiscovery) RegisterDiscoveredFiles(ctx context.Context, taskID str
 // GetOrCreateToolDirectory gets or creates a directory for a specific tool
 func (fd *FileDiscovery) GetOrCreateToolDirectory(ctx context.Context, toolName string) (*types.Directory, error) {
 	// Check if tool-specific directory exists
-	dirs, err := fd.fileManager.GetFileRepository().ListDirectories(ctx)
+	dirs, err := fd.fileManager.GetFileRepository().ListDirectories(ctx, types.DirectoryFilters{ToolName: toolName})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directories: %w", err)
 	}
-
-	for _, dir := range dirs {
-		if dir.ToolName != nil && *dir.ToolName == toolName {
-			return dir, nil
-		}
+	if len(dirs) > 0 {
+		return dirs[0], nil
 	}
 
 	// Create tool-specific directory
-	toolPath := filepath.Join("downloads", toolName)
+	toolPath := filepath.Join(fd.fileManager.DownloadsDir(), toolName)
 	// Capitalize first letter of tool name for display
 	displayName := strings.ToUpper(toolName[:1]) + toolName[1:]
 	return fd.fileManager.CreateDirectory(ctx, fmt.Sprintf("%s Downloads", displayName), toolPath, &toolName, false)
 }
 
-// OrganizeFilesByPattern organizes files using tool/date patterns
-func (fd *FileDiscovery) OrganizeFilesByPattern(ctx context.Context, taskID, toolName string, filePaths []string) error {
-	if len(filePaths) == 0 {
-		return nil
+// GetOrCreateRouteDirectory gets or creates the directory an organize route
+// sends files to
+func (fd *FileDiscovery) GetOrCreateRouteDirectory(ctx context.Context, route OrganizeRoute) (*types.Directory, error) {
+	path, err := filepath.Abs(route.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve directory path: %w", err)
 	}
 
-	// Get or create tool directory
-	toolDir, err := fd.GetOrCreateToolDirectory(ctx, toolName)
-	if err != nil {
-		return fmt.Errorf("failed to get/create tool directory: %w", err)
+	find := func() (*types.Directory, error) {
+		dirs, err := fd.fileManager.GetFileRepository().ListDirectories(ctx, types.DirectoryFilters{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directories: %w", err)
+		}
+		for _, dir := range dirs {
+			if dir.Path == path {
+				return dir, nil
+			}
+		}
+		return nil, nil
 	}
 
-	// Create date-based subdirectory
-	dateStr := time.Now().Format("2006-01-02")
-	datePath := filepath.Join(toolDir.Path, dateStr)
+	dir, err := find()
+	if dir != nil || err != nil {
+		return dir, err
+	}
+	dir, err = fd.fileManager.CreateDirectory(ctx, filepath.Base(path), path, nil, false)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		// Created by a task organizing files at the same time
+		if existing, findErr := find(); existing != nil || findErr != nil {
+			return existing, findErr
+		}
+	}
+	return dir, err
+}
 
-	// Ensure date directory exists
-	if err := os.MkdirAll(datePath, 0o755); err != nil {
-		return fmt.Errorf("failed to create date directory: %w", err)
+// OrganizeFilesByPattern moves files into the tool's directory, below the
+// subdirectory given by the tool's organize pattern, and registers them
+// tagged with tags. Files matching an organize route go below the route's
+// directory instead.
+func (fd *FileDiscovery) OrganizeFilesByPattern(ctx context.Context, taskID, toolName string, filePaths, tags []string) error {
+	if len(filePaths) == 0 {
+		return nil
+	}
+
+	// Resolve the pattern into a subdirectory, the same below every base
+	// directory
+	subdir := ResolveOrganizePattern(fd.organizePattern(toolName), toolName, taskID, time.Now())
+
+	// Base directories by route directory, "" for the tool's
+	baseDirs := make(map[string]*types.Directory)
+	baseDir := func(filePath string) (*types.Directory, error) {
+		route, routed := fd.organizeRoute(fd.fileManager.detectMimeType(filePath))
+		if dir, ok := baseDirs[route.Directory]; ok {
+			return dir, nil
+		}
+		var dir *types.Directory
+		var err error
+		if routed {
+			dir, err = fd.GetOrCreateRouteDirectory(ctx, route)
+		} else {
+			dir, err = fd.GetOrCreateToolDirectory(ctx, toolName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		baseDirs[route.Directory] = dir
+		return dir, nil
 	}
 
 	// Move files to organized structure
 	for _, filePath := range filePaths {
+		// Never move a file from outside the allowed roots into the library
+		if err := fd.fileManager.CheckPath(filePath); err != nil {
+			fmt.Printf("Warning: skipping file %s: %v\n", filePath, err)
+			continue
+		}
+
+		dir, err := baseDir(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get/create target directory: %w", err)
+		}
+		targetDir := filepath.Join(dir.Path, subdir)
+
+		// Ensure target directory exists
+		if err := os.MkdirAll(targetDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create target directory: %w", err)
+		}
+
 		filename := filepath.Base(filePath)
-		targetPath := filepath.Join(datePath, filename)
+		targetPath := filepath.Join(targetDir, filename)
 
 		// Only move if not already in the target location
 		if filePath != targetPath {
@@ -206,7 +325,7 @@ func (fd *FileDiscovery) OrganizeFilesByPattern(ctx context.Context, taskID, too
 			}
 
 			// Register the file in its new location
-			if err := fd.fileManager.RegisterFileFromTask(ctx, taskID, targetPath, &toolDir.ID); err != nil {
+			if err := fd.fileManager.RegisterFileFromTask(ctx, taskID, targetPath, &dir.ID, tags); err != nil {
 				fmt.Printf("Warning: failed to register moved file %s: %v\n", targetPath, err)
 			}
 		}
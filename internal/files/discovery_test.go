@@ -150,6 +150,8 @@ func TestFileDiscovery_DeduplicateFiles(t *testing.T) {
 func TestFileDiscovery_GetOrCreateToolDirectory(t *testing.T) {
 	repo := storage.NewMockRepository()
 	fileManager := NewManager(repo)
+	downloadsDir := t.TempDir()
+	fileManager.SetDownloadsDir(downloadsDir)
 	discovery := NewFileDiscovery(fileManager)
 	ctx := context.Background()
 
@@ -164,8 +166,7 @@ func TestFileDiscovery_GetOrCreateToolDirectory(t *testing.T) {
 		t.Errorf("Expected tool name %s, got %v", toolName, dir.ToolName)
 	}
 
-	expectedPath := filepath.Join("downloads", toolName)
-	if dir.Path != expectedPath {
+	if expectedPath := filepath.Join(downloadsDir, toolName); dir.Path != expectedPath {
 		t.Errorf("Expected path %s, got %s", expectedPath, dir.Path)
 	}
 
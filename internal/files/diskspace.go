@@ -0,0 +1,124 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrDiskUsageUnsupported is returned when free disk space can't be read on
+// this platform
+var ErrDiskUsageUnsupported = errors.New("disk usage not supported on this platform")
+
+// DefaultDiskCheckInterval is how often a DiskMonitor probes free space
+const DefaultDiskCheckInterval = 30 * time.Second
+
+// DiskStatus is the result of the latest disk space probe
+type DiskStatus struct {
+	Path         string    `json:"path"`
+	FreeBytes    uint64    `json:"free_bytes"`
+	TotalBytes   uint64    `json:"total_bytes"`
+	MinFreeBytes uint64    `json:"min_free_bytes"`
+	Ready        bool      `json:"ready"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// DiskMonitor periodically probes the free space of the file system holding
+// a directory, so readiness checks don't hit the disk on every request
+type DiskMonitor struct {
+	path    string
+	minFree uint64
+	usage   func(path string) (free, total uint64, err error)
+
+	mu     sync.RWMutex
+	status DiskStatus
+}
+
+// NewDiskMonitor creates a monitor for the file system holding path that
+// reports not ready once less than minFree bytes are available. A minFree
+// of zero only reports the free space.
+func NewDiskMonitor(path string, minFree uint64) *DiskMonitor {
+	return &DiskMonitor{
+		path:    path,
+		minFree: minFree,
+		usage:   diskUsage,
+	}
+}
+
+// Check probes the free space now and records the result
+func (d *DiskMonitor) Check() DiskStatus {
+	status := DiskStatus{
+		Path:         d.path,
+		MinFreeBytes: d.minFree,
+		CheckedAt:    time.Now(),
+	}
+
+	free, total, err := d.usage(existingParent(d.path))
+	switch {
+	case errors.Is(err, ErrDiskUsageUnsupported):
+		// Don't take the server out of rotation over a missing feature
+		status.Ready = true
+		status.Error = err.Error()
+	case err != nil:
+		status.Error = err.Error()
+	default:
+		status.FreeBytes = free
+		status.TotalBytes = total
+		status.Ready = free >= d.minFree
+	}
+
+	d.mu.Lock()
+	d.status = status
+	d.mu.Unlock()
+	return status
+}
+
+// Status returns the result of the latest probe, probing first if there
+// hasn't been one yet
+func (d *DiskMonitor) Status() DiskStatus {
+	d.mu.RLock()
+	status := d.status
+	d.mu.RUnlock()
+	if status.CheckedAt.IsZero() {
+		return d.Check()
+	}
+	return status
+}
+
+// Run probes the free space right away and then every interval until ctx is
+// done
+func (d *DiskMonitor) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultDiskCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// existingParent returns path or its closest ancestor that exists, the
+// downloads directory is only created once something is downloaded
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
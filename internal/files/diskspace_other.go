@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd
+
+package files
+
+// diskUsage isn't available on this platform
+func diskUsage(_ string) (free, total uint64, err error) {
+	return 0, 0, ErrDiskUsageUnsupported
+}
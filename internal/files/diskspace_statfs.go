@@ -0,0 +1,20 @@
+//go:build linux || darwin || dragonfly || freebsd
+
+package files
+
+import "syscall"
+
+// diskUsage returns the bytes available to unprivileged users and the total
+// size of the file system holding path
+func diskUsage(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	// Some platforms report negative available blocks once the reserved
+	// blocks are in use
+	if st.Bavail > 0 {
+		free = uint64(st.Bavail) * uint64(st.Bsize)
+	}
+	return free, uint64(st.Blocks) * uint64(st.Bsize), nil
+}
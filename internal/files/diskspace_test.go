@@ -0,0 +1,101 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskMonitorCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		free      uint64
+		err       error
+		wantReady bool
+	}{
+		{"above threshold", 2 << 30, nil, true},
+		{"at threshold", 1 << 30, nil, true},
+		{"below threshold", 1<<30 - 1, nil, false},
+		{"probe failed", 0, errors.New("no such device"), false},
+		{"unsupported", 0, ErrDiskUsageUnsupported, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := NewDiskMonitor(t.TempDir(), 1<<30)
+			monitor.usage = func(string) (uint64, uint64, error) {
+				return tt.free, 10 << 30, tt.err
+			}
+
+			status := monitor.Check()
+			if status.Ready != tt.wantReady {
+				t.Errorf("Expected ready %v, got %+v", tt.wantReady, status)
+			}
+			if (status.Error != "") != (tt.err != nil) {
+				t.Errorf("Expected error %v, got %q", tt.err, status.Error)
+			}
+			if tt.err == nil && (status.FreeBytes != tt.free || status.TotalBytes != 10<<30) {
+				t.Errorf("Expected %d of %d bytes free, got %+v", tt.free, 10<<30, status)
+			}
+			if got := monitor.Status(); got != status {
+				t.Errorf("Status() = %+v, want the last check %+v", got, status)
+			}
+		})
+	}
+}
+
+func TestDiskMonitorRun(t *testing.T) {
+	monitor := NewDiskMonitor(t.TempDir(), 1<<30)
+	free := make(chan uint64, 1)
+	free <- 2 << 30
+	monitor.usage = func(string) (uint64, uint64, error) {
+		select {
+		case f := <-free:
+			return f, 10 << 30, nil
+		default:
+			return 0, 10 << 30, nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		monitor.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	// The disk fills up after the first probe
+	deadline := time.Now().Add(5 * time.Second)
+	for monitor.Status().Ready || monitor.Status().FreeBytes != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Monitor never noticed the full disk: %+v", monitor.Status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
+
+func TestDiskMonitorMissingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	monitor := NewDiskMonitor(filepath.Join(dir, "downloads", "yt-dlp"), 0)
+
+	var probed string
+	monitor.usage = func(path string) (uint64, uint64, error) {
+		probed = path
+		return 1, 2, nil
+	}
+	if status := monitor.Check(); !status.Ready {
+		t.Errorf("Expected ready, got %+v", status)
+	}
+	if probed != dir {
+		t.Errorf("Expected the closest existing parent %s to be probed, got %s", dir, probed)
+	}
+}
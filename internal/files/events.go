@@ -0,0 +1,40 @@
+package files
+
+import "github.com/lepinkainen/commander/internal/events"
+
+// File event types published on events.TopicFiles
+const (
+	EventFileCreated = "file_created"
+	EventFileMoved   = "file_moved"
+	EventFileDeleted = "file_deleted"
+	EventFileTagged  = "file_tagged"
+)
+
+// FileEvent represents a change to a file in the library
+type FileEvent struct {
+	Type        string `json:"type"`
+	FileID      string `json:"file_id"`
+	DirectoryID string `json:"directory_id"`
+
+	// FromDirectoryID is the directory a moved file came from
+	FromDirectoryID string `json:"from_directory_id,omitempty"`
+
+	// Data holds the file path, or the file's tags for file_tagged
+	Data string `json:"data"`
+}
+
+// SetEventBus sets the bus file events are published on, so they can be
+// shared with other publishers
+func (m *Manager) SetEventBus(bus *events.Bus) {
+	m.bus = bus
+}
+
+// EventBus returns the bus file events are published on
+func (m *Manager) EventBus() *events.Bus {
+	return m.bus
+}
+
+// broadcastEvent publishes an event on the files topic
+func (m *Manager) broadcastEvent(event FileEvent) {
+	m.bus.Publish(events.TopicFiles, event)
+}
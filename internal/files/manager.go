@@ -2,33 +2,85 @@ package files
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"mime"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lepinkainen/commander/internal/events"
 	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/types"
 )
 
+// DefaultDownloadsDir is where default and per-tool directories are created
+// unless SetDownloadsDir is called
+const DefaultDownloadsDir = "./downloads"
+
 // Manager handles file and directory operations
 type Manager struct {
-	fileRepo storage.FileRepository
+	fileRepo     storage.FileRepository
+	bus          *events.Bus
+	downloadsDir string
+
+	rootsMu      sync.RWMutex
+	allowedRoots []string
+
+	// mimeTypes override the system's MIME types by lowercased extension
+	mimeMu    sync.RWMutex
+	mimeTypes map[string]string
+
+	// fileLocks serializes moves, copies, deletes and tag changes of the
+	// same file, so none of them works from a record another one changed
+	fileLocks keyedLocks
 }
 
 // NewManager creates a new file manager
 func NewManager(fileRepo storage.FileRepository) *Manager {
 	return &Manager{
-		fileRepo: fileRepo,
+		fileRepo:     fileRepo,
+		bus:          events.NewBus(),
+		downloadsDir: DefaultDownloadsDir,
 	}
 }
 
-// CreateDirectory creates a new download directory
+// SetDownloadsDir sets the root under which the default directory and the
+// per-tool directories are created
+func (m *Manager) SetDownloadsDir(dir string) {
+	m.downloadsDir = dir
+}
+
+// DownloadsDir returns the root for default and per-tool directories
+func (m *Manager) DownloadsDir() string {
+	return m.downloadsDir
+}
+
+// ErrDirectoryNotWritable is returned when a directory exists but files
+// can't be created in it
+var ErrDirectoryNotWritable = errors.New("directory not writable")
+
+// CreateDirectory creates a new download directory. The path is stored as
+// an absolute, clean path, after expanding "~" and environment variables
+// with ExpandPath.
 func (m *Manager) CreateDirectory(ctx context.Context, name, path string, toolName *string, defaultDir bool) (*types.Directory, error) {
+	path, err := ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.CheckPath(path); err != nil {
+		return nil, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve directory path: %w", err)
+	}
+
 	dir := &types.Directory{
 		ID:         uuid.New().String(),
 		Name:       name,
@@ -42,6 +94,9 @@ func (m *Manager) CreateDirectory(ctx context.Context, name, path string, toolNa
 	if err := os.MkdirAll(path, 0o755); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
+	if err := checkWritable(path); err != nil {
+		return nil, err
+	}
 
 	if err := m.fileRepo.CreateDirectory(ctx, dir); err != nil {
 		return nil, fmt.Errorf("failed to save directory: %w", err)
@@ -50,82 +105,55 @@ func (m *Manager) CreateDirectory(ctx context.Context, name, path string, toolNa
 	return dir, nil
 }
 
-// ScanDirectory scans a directory for files and adds them to the database
-func (m *Manager) ScanDirectory(ctx context.Context, directoryID string) error {
-	dir, err := m.fileRepo.GetDirectory(ctx, directoryID)
+// UpdateDirectory saves changes to a directory, storing its path as an
+// absolute, clean path like CreateDirectory does
+func (m *Manager) UpdateDirectory(ctx context.Context, dir *types.Directory) error {
+	path, err := ExpandPath(dir.Path)
 	if err != nil {
-		return fmt.Errorf("failed to get directory: %w", err)
+		return err
 	}
+	if err := m.CheckPath(path); err != nil {
+		return err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve directory path: %w", err)
+	}
+	dir.Path = path
 
-	return filepath.WalkDir(dir.Path, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if d.IsDir() {
-			return nil
-		}
-
-		// Check if file already exists in database
-		existingFiles, err := m.fileRepo.ListFiles(ctx, types.FileFilters{
-			DirectoryID: directoryID,
-		})
-		if err != nil {
-			return err
-		}
-
-		// Check if this file path already exists
-		for _, existing := range existingFiles {
-			if existing.FilePath == path {
-				return nil // File already tracked
-			}
-		}
-
-		// Get file info
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		// Detect MIME type
-		mimeType := mime.TypeByExtension(filepath.Ext(path))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
-		}
-
-		// Create file record
-		file := &types.File{
-			ID:          uuid.New().String(),
-			Filename:    d.Name(),
-			FilePath:    path,
-			DirectoryID: directoryID,
-			FileSize:    info.Size(),
-			MimeType:    mimeType,
-			CreatedAt:   info.ModTime(),
-			AccessedAt:  time.Now(),
-			Tags:        []string{},
-		}
-
-		return m.fileRepo.CreateFile(ctx, file)
-	})
+	if err := m.fileRepo.UpdateDirectory(ctx, dir); err != nil {
+		return fmt.Errorf("failed to save directory: %w", err)
+	}
+	return nil
 }
 
-// RegisterFileFromTask registers a file that was created by a task
-func (m *Manager) RegisterFileFromTask(ctx context.Context, taskID, filePath string, directoryID *string) error {
-	// Get file info
-	info, err := os.Stat(filePath)
+// checkWritable creates and removes a file in dir, so a directory that
+// tools can't write to is caught now rather than by a failed download
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".commander-write-check-*")
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return fmt.Errorf("%w: %s: %v", ErrDirectoryNotWritable, dir, err)
+	}
+	name := probe.Name()
+	if err := probe.Close(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrDirectoryNotWritable, dir, err)
 	}
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove write check file: %w", err)
+	}
+	return nil
+}
 
+// RegisterFileFromTask registers a file that was created by a task, tagged
+// with tags
+func (m *Manager) RegisterFileFromTask(ctx context.Context, taskID, filePath string, directoryID *string, tags []string) error {
 	// If no directory specified, use default or create one
 	var targetDirID string
 	if directoryID != nil {
 		targetDirID = *directoryID
 	} else {
 		// Find or create default directory
-		dirs, err := m.fileRepo.ListDirectories(ctx)
+		dirs, err := m.fileRepo.ListDirectories(ctx, types.DirectoryFilters{})
 		if err != nil {
 			return fmt.Errorf("failed to list directories: %w", err)
 		}
@@ -140,8 +168,7 @@ func (m *Manager) RegisterFileFromTask(ctx context.Context, taskID, filePath str
 
 		if defaultDir == nil {
 			// Create default directory
-			defaultPath := "./downloads"
-			defaultDir, err = m.CreateDirectory(ctx, "Default Downloads", defaultPath, nil, true)
+			defaultDir, err = m.CreateDirectory(ctx, "Default Downloads", m.downloadsDir, nil, true)
 			if err != nil {
 				return fmt.Errorf("failed to create default directory: %w", err)
 			}
@@ -150,31 +177,50 @@ func (m *Manager) RegisterFileFromTask(ctx context.Context, taskID, filePath str
 		targetDirID = defaultDir.ID
 	}
 
-	// Detect MIME type
-	mimeType := mime.TypeByExtension(filepath.Ext(filePath))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	_, err := m.registerFile(ctx, filePath, targetDirID, &taskID, tags)
+	return err
+}
+
+// registerFile creates the record for a file on disk in a directory and
+// announces it. taskID is nil for files that weren't created by a task.
+func (m *Manager) registerFile(ctx context.Context, filePath, directoryID string, taskID *string, tags []string) (*types.File, error) {
+	if err := m.CheckPath(filePath); err != nil {
+		return nil, err
+	}
+
+	// Get file info
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
+	mimeType := m.detectMimeType(filePath)
+
 	// Create file record
 	file := &types.File{
 		ID:          uuid.New().String(),
 		Filename:    filepath.Base(filePath),
 		FilePath:    filePath,
-		DirectoryID: targetDirID,
-		TaskID:      &taskID,
+		DirectoryID: directoryID,
+		TaskID:      taskID,
 		FileSize:    info.Size(),
 		MimeType:    mimeType,
 		CreatedAt:   info.ModTime(),
 		AccessedAt:  time.Now(),
-		Tags:        []string{},
+		Tags:        NormalizeTags(tags),
 	}
 
-	return m.fileRepo.CreateFile(ctx, file)
+	if err := m.fileRepo.CreateFile(ctx, file); err != nil {
+		return nil, err
+	}
+	m.broadcastEvent(FileEvent{Type: EventFileCreated, FileID: file.ID, DirectoryID: directoryID, Data: filePath})
+	return file, nil
 }
 
 // MoveFile moves a file from one directory to another
 func (m *Manager) MoveFile(ctx context.Context, fileID, targetDirID string) error {
+	defer m.fileLocks.lock(fileID)()
+
 	file, err := m.fileRepo.GetFile(ctx, fileID)
 	if err != nil {
 		return fmt.Errorf("failed to get file: %w", err)
@@ -186,35 +232,181 @@ func (m *Manager) MoveFile(ctx context.Context, fileID, targetDirID string) erro
 	}
 
 	// Calculate new file path
+	oldPath := file.FilePath
 	newPath := filepath.Join(targetDir.Path, file.Filename)
+	for _, path := range []string{oldPath, newPath} {
+		if err := m.CheckPath(path); err != nil {
+			return err
+		}
+	}
+
+	updated := *file
+	updated.DirectoryID = targetDirID
+	updated.FilePath = newPath
+	updated.AccessedAt = time.Now()
+
+	// Move the actual file as part of the database update, never over
+	// another file
+	moved := false
+	err = m.fileRepo.MoveFile(ctx, &updated, func() error {
+		if newPath != oldPath {
+			if _, err := os.Lstat(newPath); err == nil {
+				return fmt.Errorf("%s: %w", newPath, storage.ErrAlreadyExists)
+			}
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return fmt.Errorf("failed to move file: %w", err)
+		}
+		moved = true
+		return nil
+	})
+	if err != nil && moved {
+		// The database change didn't stick, put the file back where the record says it is
+		if rbErr := os.Rename(newPath, oldPath); rbErr != nil {
+			fmt.Printf("Warning: failed to restore %s after failed move: %v\n", oldPath, rbErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	m.broadcastEvent(FileEvent{
+		Type:            EventFileMoved,
+		FileID:          fileID,
+		DirectoryID:     targetDirID,
+		FromDirectoryID: file.DirectoryID,
+		Data:            newPath,
+	})
+	return nil
+}
+
+// CopyFile copies a file into another directory and registers the copy
+// with the same tags. The copy's record and tags are written in one
+// transaction, so they match the source's tags at the time of the copy.
+func (m *Manager) CopyFile(ctx context.Context, fileID, targetDirID string) (*types.File, error) {
+	defer m.fileLocks.lock(fileID)()
+
+	file, err := m.fileRepo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	targetDir, err := m.fileRepo.GetDirectory(ctx, targetDirID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get target directory: %w", err)
+	}
 
-	// Move the actual file
-	if err := os.Rename(file.FilePath, newPath); err != nil {
-		return fmt.Errorf("failed to move file: %w", err)
+	srcPath := file.FilePath
+	dstPath := filepath.Join(targetDir.Path, file.Filename)
+	for _, path := range []string{srcPath, dstPath} {
+		if err := m.CheckPath(path); err != nil {
+			return nil, err
+		}
 	}
 
-	// Update database record
-	file.DirectoryID = targetDirID
-	file.FilePath = newPath
-	file.AccessedAt = time.Now()
+	now := time.Now()
+	copied := &types.File{
+		ID:          uuid.New().String(),
+		Filename:    file.Filename,
+		FilePath:    dstPath,
+		DirectoryID: targetDirID,
+		TaskID:      file.TaskID,
+		FileSize:    file.FileSize,
+		MimeType:    file.MimeType,
+		CreatedAt:   now,
+		AccessedAt:  now,
+	}
 
-	return m.fileRepo.UpdateFile(ctx, file)
+	// Copy the actual file as part of the database insert
+	written := false
+	err = m.fileRepo.CopyFile(ctx, copied, fileID, func() error {
+		if err := copyFileContents(srcPath, dstPath); err != nil {
+			return err
+		}
+		written = true
+		return nil
+	})
+	if err != nil && written {
+		// The record wasn't created, don't leave an untracked copy behind
+		if rmErr := os.Remove(dstPath); rmErr != nil {
+			fmt.Printf("Warning: failed to remove %s after failed copy: %v\n", dstPath, rmErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	copied, err = m.fileRepo.GetFile(ctx, copied.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get copied file: %w", err)
+	}
+	m.broadcastEvent(FileEvent{Type: EventFileCreated, FileID: copied.ID, DirectoryID: targetDirID, Data: dstPath})
+	return copied, nil
+}
+
+// copyFileContents copies src to dst, which must not exist yet
+func copyFileContents(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s: %w", dst, storage.ErrAlreadyExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create copy: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
 }
 
 // DeleteFile removes a file from both filesystem and database
 func (m *Manager) DeleteFile(ctx context.Context, fileID string) error {
+	_, err := m.deleteFile(ctx, fileID, false)
+	return err
+}
+
+// deleteFile removes a file from both filesystem and database and returns
+// its record. With dryRun it stops after the checks a delete runs, leaving
+// the file and its record alone.
+func (m *Manager) deleteFile(ctx context.Context, fileID string, dryRun bool) (*types.File, error) {
+	defer m.fileLocks.lock(fileID)()
+
 	file, err := m.fileRepo.GetFile(ctx, fileID)
 	if err != nil {
-		return fmt.Errorf("failed to get file: %w", err)
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	if err := m.CheckPath(file.FilePath); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return file, nil
 	}
 
 	// Remove from filesystem
 	if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove file from filesystem: %w", err)
+		return nil, fmt.Errorf("failed to remove file from filesystem: %w", err)
 	}
 
 	// Remove from database
-	return m.fileRepo.DeleteFile(ctx, fileID)
+	if err := m.fileRepo.DeleteFile(ctx, fileID); err != nil {
+		return nil, err
+	}
+	m.broadcastEvent(FileEvent{Type: EventFileDeleted, FileID: fileID, DirectoryID: file.DirectoryID, Data: file.FilePath})
+	return file, nil
 }
 
 // FindDuplicateFiles finds files with the same content (by comparing file size and paths)
@@ -252,22 +444,169 @@ func (m *Manager) FindDuplicateFiles(ctx context.Context, directoryID string) ([
 	return duplicates, nil
 }
 
-// GetDirectoryUsage calculates storage usage for a directory
-func (m *Manager) GetDirectoryUsage(ctx context.Context, directoryID string) (totalSize int64, fileCount int, err error) {
-	fileList, err := m.fileRepo.ListFiles(ctx, types.FileFilters{
+// DuplicateGroup is a set of files believed to be copies of each other
+type DuplicateGroup struct {
+	Filename    string        `json:"filename"`
+	FileSize    int64         `json:"file_size"`
+	WastedBytes int64         `json:"wasted_bytes"`
+	Files       []*types.File `json:"files"`
+}
+
+// DuplicateReport summarizes duplicate files in a directory or the whole library
+type DuplicateReport struct {
+	DirectoryID      string           `json:"directory_id,omitempty"`
+	Method           string           `json:"method"`
+	Heuristic        bool             `json:"heuristic"`
+	Groups           []DuplicateGroup `json:"groups"`
+	TotalWastedBytes int64            `json:"total_wasted_bytes"`
+}
+
+// DuplicateMethodSizeName groups files that share both size and filename.
+// Files aren't hashed, so matches are likely but not guaranteed duplicates.
+const DuplicateMethodSizeName = "size_name"
+
+// GetDuplicateReport finds duplicate files and totals the space they waste.
+// An empty directoryID scans the whole library.
+func (m *Manager) GetDuplicateReport(ctx context.Context, directoryID string) (*DuplicateReport, error) {
+	duplicates, err := m.FindDuplicateFiles(ctx, directoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{
 		DirectoryID: directoryID,
+		Method:      DuplicateMethodSizeName,
+		Heuristic:   true,
+		Groups:      make([]DuplicateGroup, 0, len(duplicates)),
+	}
+
+	for _, files := range duplicates {
+		// Every copy beyond the first is wasted space
+		group := DuplicateGroup{
+			Filename:    files[0].Filename,
+			FileSize:    files[0].FileSize,
+			WastedBytes: files[0].FileSize * int64(len(files)-1),
+			Files:       files,
+		}
+		report.Groups = append(report.Groups, group)
+		report.TotalWastedBytes += group.WastedBytes
+	}
+
+	// Largest savings first
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].WastedBytes != report.Groups[j].WastedBytes {
+			return report.Groups[i].WastedBytes > report.Groups[j].WastedBytes
+		}
+		return report.Groups[i].Filename < report.Groups[j].Filename
 	})
+
+	return report, nil
+}
+
+// DirectorySummary is a directory with the usage of its tracked files
+type DirectorySummary struct {
+	*types.Directory
+	types.DirectoryUsage
+}
+
+// ListDirectories returns the directories matching filters with the count
+// and total size of their tracked files
+func (m *Manager) ListDirectories(ctx context.Context, filters types.DirectoryFilters) ([]DirectorySummary, error) {
+	dirs, err := m.fileRepo.ListDirectories(ctx, filters)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to list files: %w", err)
+		return nil, err
+	}
+	usage, err := m.fileRepo.DirectoryUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]DirectorySummary, len(dirs))
+	for i, dir := range dirs {
+		summaries[i] = DirectorySummary{Directory: dir, DirectoryUsage: usage[dir.ID]}
+	}
+	return summaries, nil
+}
+
+// UsageReport compares the files the library tracks in a directory with
+// the files actually on disk
+type UsageReport struct {
+	DirectoryID string               `json:"directory_id"`
+	Path        string               `json:"path"`
+	Tracked     types.DirectoryUsage `json:"tracked"`
+	OnDisk      types.DirectoryUsage `json:"on_disk"`
+	Untracked   int                  `json:"untracked"` // Files on disk that aren't tracked
+	Missing     int                  `json:"missing"`   // Tracked files no longer on disk
+}
+
+// GetDirectoryUsage walks a directory and compares its files with the ones
+// tracked for it. The walk skips what ScanDirectory skips, so untracked
+// files are those a scan would add. A directory missing from disk counts as
+// empty.
+func (m *Manager) GetDirectoryUsage(ctx context.Context, directoryID string) (*UsageReport, error) {
+	dir, err := m.fileRepo.GetDirectory(ctx, directoryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get directory: %w", err)
+	}
+	if err := m.CheckPath(dir.Path); err != nil {
+		return nil, err
 	}
 
-	fileCount = len(fileList)
+	fileList, err := m.fileRepo.ListFiles(ctx, types.FileFilters{DirectoryID: directoryID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
 
+	report := &UsageReport{DirectoryID: directoryID, Path: dir.Path}
+	tracked := make(map[string]bool, len(fileList))
 	for _, file := range fileList {
-		totalSize += file.FileSize
+		tracked[file.FilePath] = true
+		report.Tracked.FileCount++
+		report.Tracked.TotalSize += file.FileSize
+	}
+
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(dir.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir.Path && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == partialDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 && m.CheckPath(path) != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.OnDisk.FileCount++
+		report.OnDisk.TotalSize += info.Size()
+		seen[path] = true
+		if !tracked[path] {
+			report.Untracked++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
 	}
 
-	return totalSize, fileCount, nil
+	for path := range tracked {
+		if !seen[path] {
+			report.Missing++
+		}
+	}
+
+	return report, nil
 }
 
 // SearchFiles searches for files by name or content
@@ -275,72 +614,251 @@ func (m *Manager) SearchFiles(ctx context.Context, query string) ([]*types.File,
 	return m.fileRepo.SearchFiles(ctx, query)
 }
 
+// SearchFilesInDirectory searches for files by name within a single directory
+func (m *Manager) SearchFilesInDirectory(ctx context.Context, query, directoryID string) ([]*types.File, error) {
+	return m.fileRepo.SearchFilesInDirectory(ctx, query, directoryID)
+}
+
 // TagFile adds tags to a file
 func (m *Manager) TagFile(ctx context.Context, fileID string, tags []string) error {
+	defer m.fileLocks.lock(fileID)()
+
 	for _, tag := range tags {
 		if err := m.fileRepo.AddFileTag(ctx, fileID, strings.TrimSpace(tag)); err != nil {
 			return fmt.Errorf("failed to add tag %s: %w", tag, err)
 		}
 	}
+	m.publishTagged(ctx, fileID)
 	return nil
 }
 
 // UntagFile removes tags from a file
 func (m *Manager) UntagFile(ctx context.Context, fileID string, tags []string) error {
+	defer m.fileLocks.lock(fileID)()
+
 	for _, tag := range tags {
 		if err := m.fileRepo.RemoveFileTag(ctx, fileID, strings.TrimSpace(tag)); err != nil {
 			return fmt.Errorf("failed to remove tag %s: %w", tag, err)
 		}
 	}
+	m.publishTagged(ctx, fileID)
 	return nil
 }
 
-// BulkDeleteFiles deletes multiple files by their IDs
-func (m *Manager) BulkDeleteFiles(ctx context.Context, fileIDs []string) error {
+// NormalizeTags trims tags and drops empty and repeated ones, keeping the
+// order of first appearance
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// SetFileTags replaces a file's tags with the given set, adding and removing
+// tags as needed. Tags are trimmed and empty or repeated tags are ignored.
+func (m *Manager) SetFileTags(ctx context.Context, fileID string, tags []string) error {
+	defer m.fileLocks.lock(fileID)()
+
+	if err := m.fileRepo.SetFileTags(ctx, fileID, NormalizeTags(tags)); err != nil {
+		return fmt.Errorf("failed to set tags: %w", err)
+	}
+	m.publishTagged(ctx, fileID)
+	return nil
+}
+
+// publishTagged broadcasts a file's current tags after they changed
+func (m *Manager) publishTagged(ctx context.Context, fileID string) {
+	file, err := m.fileRepo.GetFile(ctx, fileID)
+	if err != nil {
+		fmt.Printf("Warning: failed to load file %s for tag event: %v\n", fileID, err)
+		return
+	}
+	m.broadcastEvent(FileEvent{
+		Type:        EventFileTagged,
+		FileID:      fileID,
+		DirectoryID: file.DirectoryID,
+		Data:        strings.Join(file.Tags, ","),
+	})
+}
+
+// BulkDeleteFiles deletes multiple files by their IDs and returns how many
+// were deleted. A file listed more than once is deleted once.
+func (m *Manager) BulkDeleteFiles(ctx context.Context, fileIDs []string) (int, error) {
 	var failures []string
+	deleted := 0
 
-	for _, fileID := range fileIDs {
+	for _, fileID := range uniqueIDs(fileIDs) {
 		if err := m.DeleteFile(ctx, fileID); err != nil {
 			failures = append(failures, fmt.Sprintf("file %s: %v", fileID, err))
+			continue
 		}
+		deleted++
 	}
 
 	if len(failures) > 0 {
-		return fmt.Errorf("failed to delete some files: %s", strings.Join(failures, "; "))
+		return deleted, fmt.Errorf("failed to delete some files: %s", strings.Join(failures, "; "))
 	}
 
-	return nil
+	return deleted, nil
+}
+
+// DeletePlan reports what a bulk delete would do: the files it would remove
+// with their total size, and the ones it would fail on and why
+type DeletePlan struct {
+	Files      []PlannedDelete `json:"files"`
+	TotalBytes int64           `json:"total_bytes"`
+	Failures   []DeleteFailure `json:"failures"`
+}
+
+// PlannedDelete is a file a delete would remove
+type PlannedDelete struct {
+	FileID   string `json:"file_id"`
+	FilePath string `json:"file_path"`
+	FileSize int64  `json:"file_size"`
+}
+
+// DeleteFailure is a file that couldn't be deleted and why
+type DeleteFailure struct {
+	FileID string `json:"file_id"`
+	Error  string `json:"error"`
+}
+
+// PlanBulkDelete reports what BulkDeleteFiles would remove for fileIDs,
+// running the same checks without touching the disk or the database
+func (m *Manager) PlanBulkDelete(ctx context.Context, fileIDs []string) DeletePlan {
+	plan := DeletePlan{Files: []PlannedDelete{}, Failures: []DeleteFailure{}}
+	for _, fileID := range uniqueIDs(fileIDs) {
+		file, err := m.deleteFile(ctx, fileID, true)
+		if err != nil {
+			plan.Failures = append(plan.Failures, DeleteFailure{FileID: fileID, Error: err.Error()})
+			continue
+		}
+		plan.Files = append(plan.Files, PlannedDelete{FileID: file.ID, FilePath: file.FilePath, FileSize: file.FileSize})
+		plan.TotalBytes += file.FileSize
+	}
+	return plan
+}
+
+// uniqueIDs returns ids without repetitions, in the order they first appear
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
 }
 
 // BulkMoveFiles moves multiple files to a target directory
 func (m *Manager) BulkMoveFiles(ctx context.Context, fileIDs []string, targetDirID string) error {
-	var failures []string
+	result := m.moveFiles(ctx, fileIDs, targetDirID)
+	if len(result.Failures) == 0 {
+		return nil
+	}
 
+	failures := make([]string, len(result.Failures))
+	for i, failure := range result.Failures {
+		failures[i] = fmt.Sprintf("file %s: %s", failure.FileID, failure.Error)
+	}
+	return fmt.Errorf("failed to move some files: %s", strings.Join(failures, "; "))
+}
+
+// ErrSameDirectory is returned when files would be moved into the
+// directory they are already in
+var ErrSameDirectory = errors.New("source and target directory are the same")
+
+// MoveResult reports which files of a bulk move were moved
+type MoveResult struct {
+	Moved    int           `json:"moved"`
+	Failures []MoveFailure `json:"failures"`
+}
+
+// MoveFailure is a file that couldn't be moved and why
+type MoveFailure struct {
+	FileID string `json:"file_id"`
+	Error  string `json:"error"`
+}
+
+// MoveAllFiles moves every file tracked in a directory into another one,
+// e.g. to empty it before deleting it. Files that fail to move, such as
+// those whose name is taken in the target, stay where they are and are
+// reported, the others are moved regardless.
+func (m *Manager) MoveAllFiles(ctx context.Context, directoryID, targetDirID string) (MoveResult, error) {
+	if directoryID == targetDirID {
+		return MoveResult{}, ErrSameDirectory
+	}
+	if _, err := m.fileRepo.GetDirectory(ctx, directoryID); err != nil {
+		return MoveResult{}, fmt.Errorf("failed to get directory: %w", err)
+	}
+	if _, err := m.fileRepo.GetDirectory(ctx, targetDirID); err != nil {
+		return MoveResult{}, fmt.Errorf("failed to get target directory: %w", err)
+	}
+
+	files, err := m.fileRepo.ListFiles(ctx, types.FileFilters{DirectoryID: directoryID})
+	if err != nil {
+		return MoveResult{}, fmt.Errorf("failed to list files: %w", err)
+	}
+	fileIDs := make([]string, len(files))
+	for i, file := range files {
+		fileIDs[i] = file.ID
+	}
+
+	return m.moveFiles(ctx, fileIDs, targetDirID), nil
+}
+
+// moveFiles moves each file to a target directory, carrying on past
+// failures
+func (m *Manager) moveFiles(ctx context.Context, fileIDs []string, targetDirID string) MoveResult {
+	result := MoveResult{Failures: []MoveFailure{}}
 	for _, fileID := range fileIDs {
 		if err := m.MoveFile(ctx, fileID, targetDirID); err != nil {
+			result.Failures = append(result.Failures, MoveFailure{FileID: fileID, Error: err.Error()})
+			continue
+		}
+		result.Moved++
+	}
+	return result
+}
+
+// BulkTagFiles adds tags to multiple files
+func (m *Manager) BulkTagFiles(ctx context.Context, fileIDs []string, tags []string) error {
+	var failures []string
+
+	for _, fileID := range fileIDs {
+		if err := m.TagFile(ctx, fileID, tags); err != nil {
 			failures = append(failures, fmt.Sprintf("file %s: %v", fileID, err))
 		}
 	}
 
 	if len(failures) > 0 {
-		return fmt.Errorf("failed to move some files: %s", strings.Join(failures, "; "))
+		return fmt.Errorf("failed to tag some files: %s", strings.Join(failures, "; "))
 	}
 
 	return nil
 }
 
-// BulkTagFiles adds tags to multiple files
-func (m *Manager) BulkTagFiles(ctx context.Context, fileIDs []string, tags []string) error {
+// BulkUntagFiles removes tags from multiple files
+func (m *Manager) BulkUntagFiles(ctx context.Context, fileIDs []string, tags []string) error {
 	var failures []string
 
 	for _, fileID := range fileIDs {
-		if err := m.TagFile(ctx, fileID, tags); err != nil {
+		if err := m.UntagFile(ctx, fileID, tags); err != nil {
 			failures = append(failures, fmt.Sprintf("file %s: %v", fileID, err))
 		}
 	}
 
 	if len(failures) > 0 {
-		return fmt.Errorf("failed to tag some files: %s", strings.Join(failures, "; "))
+		return fmt.Errorf("failed to untag some files: %s", strings.Join(failures, "; "))
 	}
 
 	return nil
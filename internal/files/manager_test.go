@@ -2,12 +2,15 @@ package files
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/lepinkainen/commander/internal/events"
 	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/types"
 )
@@ -46,6 +49,76 @@ func TestCreateDirectory(t *testing.T) {
 	_ = os.RemoveAll(path)
 }
 
+func TestCreateDirectoryNormalizesPath(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	root := t.TempDir()
+
+	dir, err := manager.CreateDirectory(ctx, "Media", root+"//media/./", nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if want := filepath.Join(root, "media"); dir.Path != want {
+		t.Errorf("Expected path %s, got %s", want, dir.Path)
+	}
+
+	entries, err := os.ReadDir(dir.Path)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected write check to leave no files behind, got %d entries", len(entries))
+	}
+}
+
+func TestCreateDirectoryDuplicatePath(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	root := t.TempDir()
+
+	if _, err := manager.CreateDirectory(ctx, "Media", root+"/media/.", nil, false); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if _, err := manager.CreateDirectory(ctx, "Media again", filepath.Join(root, "media"), nil, false); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for the same absolute path, got %v", err)
+	}
+
+	other, err := manager.CreateDirectory(ctx, "Other", filepath.Join(root, "other"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	other.Path = root + "//media/"
+	if err := manager.UpdateDirectory(ctx, other); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists moving a directory onto another, got %v", err)
+	}
+}
+
+func TestCreateDirectoryNotWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks don't apply to root")
+	}
+
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(path, 0o555); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	if _, err := manager.CreateDirectory(ctx, "Read only", path, nil, false); !errors.Is(err, ErrDirectoryNotWritable) {
+		t.Errorf("Expected ErrDirectoryNotWritable, got %v", err)
+	}
+	dirs, err := repo.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		t.Fatalf("Failed to list directories: %v", err)
+	}
+	if len(dirs) != 0 {
+		t.Errorf("Expected no directory record, got %d", len(dirs))
+	}
+}
+
 func TestRegisterFileFromTask(t *testing.T) {
 	// Setup
 	repo := storage.NewMockRepository()
@@ -68,13 +141,46 @@ func TestRegisterFileFromTask(t *testing.T) {
 
 	// Register file from task
 	taskID := "test-task-123"
-	err = manager.RegisterFileFromTask(ctx, taskID, testFile, &dir.ID)
+	err = manager.RegisterFileFromTask(ctx, taskID, testFile, &dir.ID, []string{"gallery", " gallery ", "art"})
 	if err != nil {
 		t.Fatalf("Failed to register file from task: %v", err)
 	}
 
-	// Verify the file was registered (this would need the mock to be more sophisticated)
-	// For now, we just verify no error occurred
+	// Verify the file was registered with the normalized tags
+	registered, err := manager.GetTaskFiles(ctx, taskID)
+	if err != nil {
+		t.Fatalf("Failed to get task files: %v", err)
+	}
+	if len(registered) != 1 {
+		t.Fatalf("Expected 1 registered file, got %d", len(registered))
+	}
+	if tags := registered[0].Tags; len(tags) != 2 || tags[0] != "gallery" || tags[1] != "art" {
+		t.Errorf("Expected tags [gallery art], got %v", tags)
+	}
+}
+
+func TestRegisterFileFromTaskDefaultDirectory(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	downloadsDir := filepath.Join(t.TempDir(), "downloads")
+	manager.SetDownloadsDir(downloadsDir)
+	ctx := context.Background()
+
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("test content"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Without a default directory one is created under the downloads root
+	if err := manager.RegisterFileFromTask(ctx, "task", testFile, nil, nil); err != nil {
+		t.Fatalf("Failed to register file from task: %v", err)
+	}
+	dirs, err := manager.GetFileRepository().ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		t.Fatalf("Failed to list directories: %v", err)
+	}
+	if len(dirs) != 1 || !dirs[0].DefaultDir || dirs[0].Path != downloadsDir {
+		t.Errorf("Expected a default directory at %s, got %+v", downloadsDir, dirs)
+	}
 }
 
 func TestFormatFileSize(t *testing.T) {
@@ -174,6 +280,37 @@ func TestManager_BulkOperations(t *testing.T) {
 		// Verify tags were added (note: this would need actual tag implementation in mock)
 	})
 
+	t.Run("BulkUntagFiles", func(t *testing.T) {
+		if err := manager.BulkUntagFiles(ctx, fileIDs[:2], []string{"bulk"}); err != nil {
+			t.Fatalf("BulkUntagFiles() error = %v", err)
+		}
+
+		for i, fileID := range fileIDs {
+			tags, err := repo.GetFileTags(ctx, fileID)
+			if err != nil {
+				t.Fatalf("Failed to get tags: %v", err)
+			}
+			hasBulk := false
+			for _, tag := range tags {
+				if tag == "bulk" {
+					hasBulk = true
+				}
+			}
+			if wantBulk := i >= 2; hasBulk != wantBulk {
+				t.Errorf("File %s: expected bulk tag %v, got tags %v", fileID, wantBulk, tags)
+			}
+		}
+
+		// Missing files are reported without stopping the rest
+		err := manager.BulkUntagFiles(ctx, []string{"nonexistent", fileIDs[2]}, []string{"bulk"})
+		if err == nil {
+			t.Error("Expected error for untagging a non-existent file")
+		}
+		if tags, _ := repo.GetFileTags(ctx, fileIDs[2]); len(tags) != 1 || tags[0] != "test" {
+			t.Errorf("Expected remaining tags [test], got %v", tags)
+		}
+	})
+
 	t.Run("BulkMoveFiles", func(t *testing.T) {
 		// Create target directory
 		targetDir, err := manager.CreateDirectory(ctx, "Target Dir", "./target", nil, false)
@@ -192,7 +329,7 @@ func TestManager_BulkOperations(t *testing.T) {
 	t.Run("BulkDeleteFiles", func(t *testing.T) {
 		// Test with non-existent files to verify error handling
 		nonExistentIDs := []string{"nonexistent1", "nonexistent2"}
-		err := manager.BulkDeleteFiles(ctx, nonExistentIDs)
+		_, err := manager.BulkDeleteFiles(ctx, nonExistentIDs)
 		if err == nil {
 			t.Error("Expected error for deleting non-existent files, but got none")
 		}
@@ -276,3 +413,537 @@ func TestManager_GetTaskFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestManager_MoveFile(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+
+	src, err := manager.CreateDirectory(ctx, "Source", srcDir, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	dst, err := manager.CreateDirectory(ctx, "Target", dstDir, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	srcPath := filepath.Join(srcDir, "movie.mp4")
+	if err := os.WriteFile(srcPath, []byte("data"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	file := &types.File{
+		ID:          "move-me",
+		Filename:    "movie.mp4",
+		FilePath:    srcPath,
+		DirectoryID: src.ID,
+		CreatedAt:   time.Now(),
+		AccessedAt:  time.Now(),
+	}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		if err := manager.MoveFile(ctx, file.ID, dst.ID); err != nil {
+			t.Fatalf("MoveFile() error = %v", err)
+		}
+
+		moved, err := repo.GetFile(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+		wantPath := filepath.Join(dstDir, "movie.mp4")
+		if moved.FilePath != wantPath || moved.DirectoryID != dst.ID {
+			t.Errorf("Expected record at %s in %s, got %s in %s", wantPath, dst.ID, moved.FilePath, moved.DirectoryID)
+		}
+		if _, err := os.Stat(wantPath); err != nil {
+			t.Errorf("Expected file on disk at %s: %v", wantPath, err)
+		}
+	})
+
+	t.Run("FailedMoveKeepsRecord", func(t *testing.T) {
+		// Remove the file from disk so the rename fails
+		current, err := repo.GetFile(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+		before := *current
+		if err := os.Remove(current.FilePath); err != nil {
+			t.Fatalf("Failed to remove test file: %v", err)
+		}
+
+		if err := manager.MoveFile(ctx, file.ID, src.ID); err == nil {
+			t.Fatal("Expected error moving a missing file")
+		}
+
+		after, err := repo.GetFile(ctx, file.ID)
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+		if after.FilePath != before.FilePath || after.DirectoryID != before.DirectoryID {
+			t.Errorf("Record changed after failed move: %s in %s", after.FilePath, after.DirectoryID)
+		}
+	})
+}
+
+func TestManager_SearchFilesInDirectory(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	for i, dirID := range []string{"dir-a", "dir-a", "dir-b"} {
+		file := &types.File{
+			ID:          fmt.Sprintf("file%d", i),
+			Filename:    fmt.Sprintf("concert%d.mp4", i),
+			FilePath:    fmt.Sprintf("/%s/concert%d.mp4", dirID, i),
+			DirectoryID: dirID,
+		}
+		if err := repo.CreateFile(ctx, file); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		directoryID string
+		want        int
+	}{
+		{"whole library", "", 3},
+		{"single directory", "dir-a", 2},
+		{"other directory", "dir-b", 1},
+		{"unknown directory", "dir-c", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := manager.SearchFilesInDirectory(ctx, "concert", tt.directoryID)
+			if err != nil {
+				t.Fatalf("SearchFilesInDirectory() error = %v", err)
+			}
+			if len(files) != tt.want {
+				t.Errorf("Expected %d files, got %d", tt.want, len(files))
+			}
+		})
+	}
+}
+
+func TestManager_GetDuplicateReport(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	files := []*types.File{
+		{ID: "a1", Filename: "song.mp3", DirectoryID: "dir-a", FileSize: 100},
+		{ID: "a2", Filename: "song.mp3", DirectoryID: "dir-a", FileSize: 100},
+		{ID: "b1", Filename: "song.mp3", DirectoryID: "dir-b", FileSize: 100},
+		{ID: "b2", Filename: "video.mp4", DirectoryID: "dir-b", FileSize: 1000},
+		{ID: "b3", Filename: "video.mp4", DirectoryID: "dir-b", FileSize: 1000},
+		{ID: "b4", Filename: "other.mp4", DirectoryID: "dir-b", FileSize: 1000},
+	}
+	for _, f := range files {
+		if err := repo.CreateFile(ctx, f); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	t.Run("Library", func(t *testing.T) {
+		report, err := manager.GetDuplicateReport(ctx, "")
+		if err != nil {
+			t.Fatalf("GetDuplicateReport() error = %v", err)
+		}
+
+		if !report.Heuristic || report.Method != DuplicateMethodSizeName {
+			t.Errorf("Expected heuristic %s report, got %s (heuristic=%v)", DuplicateMethodSizeName, report.Method, report.Heuristic)
+		}
+		if len(report.Groups) != 2 {
+			t.Fatalf("Expected 2 duplicate groups, got %d", len(report.Groups))
+		}
+		if report.Groups[0].Filename != "video.mp4" || report.Groups[0].WastedBytes != 1000 {
+			t.Errorf("Expected largest group video.mp4 wasting 1000 bytes, got %s wasting %d",
+				report.Groups[0].Filename, report.Groups[0].WastedBytes)
+		}
+		if report.TotalWastedBytes != 1200 {
+			t.Errorf("Expected 1200 wasted bytes, got %d", report.TotalWastedBytes)
+		}
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		report, err := manager.GetDuplicateReport(ctx, "dir-a")
+		if err != nil {
+			t.Fatalf("GetDuplicateReport() error = %v", err)
+		}
+
+		if len(report.Groups) != 1 || len(report.Groups[0].Files) != 2 {
+			t.Fatalf("Expected one group of two files, got %+v", report.Groups)
+		}
+		if report.TotalWastedBytes != 100 {
+			t.Errorf("Expected 100 wasted bytes, got %d", report.TotalWastedBytes)
+		}
+	})
+}
+
+func TestManager_DirectoryUsage(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	media, err := manager.CreateDirectory(ctx, "Media", filepath.Join(root, "media"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	empty, err := manager.CreateDirectory(ctx, "Empty", filepath.Join(root, "empty"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// One tracked file on disk, one tracked file that was deleted and one
+	// file nobody registered
+	kept := filepath.Join(media.Path, "kept.mp4")
+	if err := os.WriteFile(kept, make([]byte, 100), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(media.Path, "stray.mp4"), make([]byte, 50), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, f := range []*types.File{
+		{ID: "kept", Filename: "kept.mp4", FilePath: kept, DirectoryID: media.ID, FileSize: 100},
+		{ID: "gone", Filename: "gone.mp4", FilePath: filepath.Join(media.Path, "gone.mp4"), DirectoryID: media.ID, FileSize: 1000},
+	} {
+		if err := repo.CreateFile(ctx, f); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	summaries, err := manager.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		t.Fatalf("ListDirectories() error = %v", err)
+	}
+	usage := make(map[string]types.DirectoryUsage)
+	for _, summary := range summaries {
+		usage[summary.ID] = summary.DirectoryUsage
+	}
+	if want := (types.DirectoryUsage{FileCount: 2, TotalSize: 1100}); usage[media.ID] != want {
+		t.Errorf("Expected media usage %+v, got %+v", want, usage[media.ID])
+	}
+	if u, ok := usage[empty.ID]; !ok || u.FileCount != 0 {
+		t.Errorf("Expected empty directory to be listed without files, got %+v (listed %v)", u, ok)
+	}
+
+	report, err := manager.GetDirectoryUsage(ctx, media.ID)
+	if err != nil {
+		t.Fatalf("GetDirectoryUsage() error = %v", err)
+	}
+	if report.OnDisk != (types.DirectoryUsage{FileCount: 2, TotalSize: 150}) {
+		t.Errorf("Expected 2 files with 150 bytes on disk, got %+v", report.OnDisk)
+	}
+	if report.Untracked != 1 || report.Missing != 1 {
+		t.Errorf("Expected 1 untracked and 1 missing file, got %d and %d", report.Untracked, report.Missing)
+	}
+}
+
+func TestManager_CopyFile(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	src, err := manager.CreateDirectory(ctx, "Source", filepath.Join(root, "src"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	dst, err := manager.CreateDirectory(ctx, "Target", filepath.Join(root, "dst"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	path := filepath.Join(src.Path, "clip.mp4")
+	if err := os.WriteFile(path, []byte("clip"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	original := &types.File{ID: "clip", Filename: "clip.mp4", FilePath: path, DirectoryID: src.ID, FileSize: 4, Tags: []string{"keep"}}
+	if err := repo.CreateFile(ctx, original); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	copied, err := manager.CopyFile(ctx, "clip", dst.ID)
+	if err != nil {
+		t.Fatalf("CopyFile() error = %v", err)
+	}
+	if copied.ID == "clip" || copied.DirectoryID != dst.ID || len(copied.Tags) != 1 || copied.Tags[0] != "keep" {
+		t.Errorf("Expected a tagged copy in the target directory, got %+v", copied)
+	}
+	if data, err := os.ReadFile(copied.FilePath); err != nil || string(data) != "clip" {
+		t.Errorf("Expected the copy on disk, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the original to stay in place: %v", err)
+	}
+
+	// Copying onto an existing file fails without touching it
+	if _, err := manager.CopyFile(ctx, "clip", dst.ID); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists copying over an existing file, got %v", err)
+	}
+}
+
+func TestManager_PlanBulkDelete(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	dir, err := manager.CreateDirectory(ctx, "Videos", t.TempDir(), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		path := filepath.Join(dir.Path, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := repo.CreateFile(ctx, &types.File{ID: name, Filename: name, FilePath: path, DirectoryID: dir.ID, FileSize: 100}); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	plan := manager.PlanBulkDelete(ctx, []string{"a.mp4", "b.mp4", "a.mp4", "missing"})
+	if len(plan.Files) != 2 || plan.Files[0].FileID != "a.mp4" || plan.Files[1].FilePath != filepath.Join(dir.Path, "b.mp4") {
+		t.Errorf("Expected both files planned once, got %+v", plan.Files)
+	}
+	if plan.TotalBytes != 200 {
+		t.Errorf("Expected 200 bytes, got %d", plan.TotalBytes)
+	}
+	if len(plan.Failures) != 1 || plan.Failures[0].FileID != "missing" {
+		t.Errorf("Expected the missing file as a failure, got %+v", plan.Failures)
+	}
+
+	// Nothing is removed from disk or the database
+	for _, file := range plan.Files {
+		if _, err := os.Stat(file.FilePath); err != nil {
+			t.Errorf("Expected %s to stay on disk: %v", file.FilePath, err)
+		}
+		if _, err := repo.GetFile(ctx, file.FileID); err != nil {
+			t.Errorf("Expected %s to stay in the database: %v", file.FileID, err)
+		}
+	}
+
+	// The real delete agrees with the plan, repeated files included
+	if deleted, err := manager.BulkDeleteFiles(ctx, []string{"a.mp4", "b.mp4", "a.mp4"}); err != nil || deleted != 2 {
+		t.Errorf("BulkDeleteFiles() = %d, %v; want 2 deleted", deleted, err)
+	}
+	for _, file := range plan.Files {
+		if _, err := os.Stat(file.FilePath); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", file.FilePath, err)
+		}
+	}
+}
+
+func TestManager_MoveAllFiles(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	src, err := manager.CreateDirectory(ctx, "Source", filepath.Join(root, "src"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	dst, err := manager.CreateDirectory(ctx, "Target", filepath.Join(root, "dst"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// taken.mp4 already exists in the target and must not be overwritten
+	for _, name := range []string{"a.mp4", "b.mp4", "taken.mp4"} {
+		path := filepath.Join(src.Path, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		file := &types.File{ID: name, Filename: name, FilePath: path, DirectoryID: src.ID}
+		if err := repo.CreateFile(ctx, file); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dst.Path, "taken.mp4"), []byte("other"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	result, err := manager.MoveAllFiles(ctx, src.ID, dst.ID)
+	if err != nil {
+		t.Fatalf("MoveAllFiles() error = %v", err)
+	}
+	if result.Moved != 2 || len(result.Failures) != 1 || result.Failures[0].FileID != "taken.mp4" {
+		t.Fatalf("Expected 2 files moved and taken.mp4 failed, got %+v", result)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst.Path, "taken.mp4")); err != nil || string(data) != "other" {
+		t.Errorf("Expected the existing target file to be kept, got %q, %v", data, err)
+	}
+	left, err := repo.ListFiles(ctx, types.FileFilters{DirectoryID: src.ID})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(left) != 1 || left[0].ID != "taken.mp4" {
+		t.Errorf("Expected only taken.mp4 left in the source, got %v", left)
+	}
+
+	if _, err := manager.MoveAllFiles(ctx, src.ID, src.ID); !errors.Is(err, ErrSameDirectory) {
+		t.Errorf("Expected ErrSameDirectory, got %v", err)
+	}
+	if _, err := manager.MoveAllFiles(ctx, src.ID, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing target, got %v", err)
+	}
+}
+
+func TestManager_MoveAndTagConcurrently(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	var dirs []*types.Directory
+	for _, name := range []string{"a", "b"} {
+		dir, err := manager.CreateDirectory(ctx, name, filepath.Join(root, name), nil, false)
+		if err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	path := filepath.Join(dirs[0].Path, "song.mp3")
+	if err := os.WriteFile(path, []byte("song"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	now := time.Now()
+	file := &types.File{ID: "song", Filename: "song.mp3", FilePath: path, DirectoryID: dirs[0].ID, FileSize: 4, CreatedAt: now, AccessedAt: now}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	const rounds = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*rounds)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			errs <- manager.MoveFile(ctx, "song", dirs[(i+1)%2].ID)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			errs <- manager.TagFile(ctx, "song", []string{fmt.Sprintf("tag-%02d", i)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			errs <- manager.SetFileTags(ctx, "song", []string{fmt.Sprintf("tag-%02d", i), "set"})
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent operation failed: %v", err)
+		}
+	}
+
+	got, err := repo.GetFile(ctx, "song")
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if got.DirectoryID != dirs[0].ID || got.FilePath != path {
+		t.Errorf("Expected the file back in its first directory, got %s at %s", got.DirectoryID, got.FilePath)
+	}
+	if _, err := os.Stat(got.FilePath); err != nil {
+		t.Errorf("Expected the file on disk where the record says: %v", err)
+	}
+
+	// Moves never drop tags, so the tags are whatever the last tag change
+	// left: the final set plus any tags added after it
+	tags := make(map[string]bool)
+	for _, tag := range got.Tags {
+		tags[tag] = true
+	}
+	if !tags["set"] {
+		t.Errorf("Expected the tags of the last SetFileTags call to survive, got %v", got.Tags)
+	}
+}
+
+func TestManager_FileEvents(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+	tempDir := t.TempDir()
+
+	sub := manager.EventBus().Subscribe(events.DefaultBufferSize, events.TopicFiles)
+	defer manager.EventBus().Unsubscribe(sub)
+
+	source, err := manager.CreateDirectory(ctx, "Source", filepath.Join(tempDir, "source"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	target, err := manager.CreateDirectory(ctx, "Target", filepath.Join(tempDir, "target"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create target directory: %v", err)
+	}
+
+	filePath := filepath.Join(source.Path, "video.mp4")
+	if err := os.WriteFile(filePath, []byte("test content"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// next returns the next event, failing the test if none arrives
+	next := func() FileEvent {
+		t.Helper()
+		select {
+		case event := <-sub.C:
+			return event.Payload.(FileEvent)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for file event")
+			return FileEvent{}
+		}
+	}
+
+	if err := manager.RegisterFileFromTask(ctx, "task-1", filePath, &source.ID, nil); err != nil {
+		t.Fatalf("Failed to register file: %v", err)
+	}
+	created := next()
+	if created.Type != EventFileCreated || created.FileID == "" || created.DirectoryID != source.ID || created.Data != filePath {
+		t.Errorf("Unexpected created event: %+v", created)
+	}
+	fileID := created.FileID
+
+	if err := manager.MoveFile(ctx, fileID, target.ID); err != nil {
+		t.Fatalf("Failed to move file: %v", err)
+	}
+	moved := next()
+	if moved.Type != EventFileMoved || moved.FileID != fileID || moved.DirectoryID != target.ID || moved.FromDirectoryID != source.ID {
+		t.Errorf("Unexpected moved event: %+v", moved)
+	}
+
+	if err := manager.TagFile(ctx, fileID, []string{"music"}); err != nil {
+		t.Fatalf("Failed to tag file: %v", err)
+	}
+	tagged := next()
+	if tagged.Type != EventFileTagged || tagged.FileID != fileID || tagged.DirectoryID != target.ID || tagged.Data != "music" {
+		t.Errorf("Unexpected tagged event: %+v", tagged)
+	}
+
+	if err := manager.DeleteFile(ctx, fileID); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	deleted := next()
+	if deleted.Type != EventFileDeleted || deleted.FileID != fileID || deleted.DirectoryID != target.ID {
+		t.Errorf("Unexpected deleted event: %+v", deleted)
+	}
+}
@@ -0,0 +1,71 @@
+package files
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateMimeTypes checks MIME type overrides keyed by file extension, such
+// as ".ts": "video/mp2t"
+func ValidateMimeTypes(overrides map[string]string) error {
+	for ext, mimeType := range overrides {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], `./\ `) {
+			return fmt.Errorf("extension %q must be a dot followed by the extension, e.g. \".ts\"", ext)
+		}
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil || !strings.Contains(mimeType, "/") {
+			return fmt.Errorf("extension %q: invalid MIME type %q", ext, mimeType)
+		}
+	}
+	return nil
+}
+
+// SetMimeTypes sets the MIME types files with the given extensions are
+// recorded with, in place of what the system's MIME database says.
+// Extensions match ignoring case.
+func (m *Manager) SetMimeTypes(overrides map[string]string) error {
+	if err := ValidateMimeTypes(overrides); err != nil {
+		return err
+	}
+
+	mimeTypes := make(map[string]string, len(overrides))
+	for ext, mimeType := range overrides {
+		mimeTypes[strings.ToLower(ext)] = mimeType
+	}
+
+	m.mimeMu.Lock()
+	defer m.mimeMu.Unlock()
+	m.mimeTypes = mimeTypes
+	return nil
+}
+
+// MimeTypes returns the MIME type overrides by lowercased extension
+func (m *Manager) MimeTypes() map[string]string {
+	m.mimeMu.RLock()
+	defer m.mimeMu.RUnlock()
+
+	overrides := make(map[string]string, len(m.mimeTypes))
+	for ext, mimeType := range m.mimeTypes {
+		overrides[ext] = mimeType
+	}
+	return overrides
+}
+
+// detectMimeType guesses a file's MIME type from its extension, from the
+// overrides first and then the system's MIME database
+func (m *Manager) detectMimeType(path string) string {
+	ext := filepath.Ext(path)
+
+	m.mimeMu.RLock()
+	mimeType, ok := m.mimeTypes[strings.ToLower(ext)]
+	m.mimeMu.RUnlock()
+	if ok {
+		return mimeType
+	}
+
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
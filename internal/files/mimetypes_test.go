@@ -0,0 +1,65 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestMimeTypeOverrides(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	if err := manager.SetMimeTypes(map[string]string{"ts": "video/mp2t"}); err == nil {
+		t.Error("Expected an extension without a dot to be rejected")
+	}
+	if err := manager.SetMimeTypes(map[string]string{".TS": "video/mp2t", ".png": "image/x-custom"}); err != nil {
+		t.Fatalf("SetMimeTypes failed: %v", err)
+	}
+
+	dir, err := manager.CreateDirectory(ctx, "Library", filepath.Join(t.TempDir(), "library"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"stream.ts", "clip.TS", "notes.txt", "produced.png"} {
+		if err := os.WriteFile(filepath.Join(dir.Path, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	// Files registered for a task use the overrides as well as scanned ones
+	if err := manager.RegisterFileFromTask(ctx, "task", filepath.Join(dir.Path, "produced.png"), &dir.ID, nil); err != nil {
+		t.Fatalf("RegisterFileFromTask failed: %v", err)
+	}
+	if _, err := manager.ScanDirectory(ctx, dir.ID); err != nil {
+		t.Fatalf("ScanDirectory failed: %v", err)
+	}
+
+	files, err := repo.ListFiles(ctx, types.FileFilters{DirectoryID: dir.ID})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	want := map[string]string{
+		"stream.ts":    "video/mp2t",
+		"clip.TS":      "video/mp2t",
+		"notes.txt":    "text/plain; charset=utf-8",
+		"produced.png": "image/x-custom",
+	}
+	if len(files) != len(want) {
+		t.Fatalf("Expected %d files, got %d", len(want), len(files))
+	}
+	for _, file := range files {
+		if file.MimeType != want[file.Filename] {
+			t.Errorf("Expected %s to be %s, got %s", file.Filename, want[file.Filename], file.MimeType)
+		}
+	}
+
+	if got := manager.MimeTypes(); len(got) != 2 || got[".ts"] != "video/mp2t" {
+		t.Errorf("Expected the overrides by lowercased extension, got %v", got)
+	}
+}
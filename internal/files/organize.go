@@ -0,0 +1,120 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultOrganizePattern places files in a dated subdirectory of the tool's
+// directory, e.g. downloads/yt-dlp/2024-05-01
+const DefaultOrganizePattern = "{date}"
+
+// organizePlaceholder matches a {name} placeholder in an organize pattern
+var organizePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// organizePlaceholders lists the supported placeholders and how to resolve them
+var organizePlaceholders = map[string]func(tool, taskID string, now time.Time) string{
+	"tool":  func(tool, _ string, _ time.Time) string { return tool },
+	"task":  func(_, taskID string, _ time.Time) string { return taskID },
+	"date":  func(_, _ string, now time.Time) string { return now.Format("2006-01-02") },
+	"year":  func(_, _ string, now time.Time) string { return now.Format("2006") },
+	"month": func(_, _ string, now time.Time) string { return now.Format("01") },
+	"day":   func(_, _ string, now time.Time) string { return now.Format("02") },
+}
+
+// ValidateOrganizePattern checks that pattern only uses known placeholders
+// and stays inside the tool's directory. "." keeps files directly in the
+// tool's directory and an empty pattern means DefaultOrganizePattern.
+func ValidateOrganizePattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+
+	for _, match := range organizePlaceholder.FindAllStringSubmatch(pattern, -1) {
+		if _, ok := organizePlaceholders[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder {%s}", match[1])
+		}
+	}
+
+	literal := organizePlaceholder.ReplaceAllString(pattern, "x")
+	if strings.ContainsAny(literal, "{}") {
+		return errors.New("unbalanced braces")
+	}
+	if filepath.IsAbs(literal) {
+		return errors.New("must be a relative path")
+	}
+	if cleaned := filepath.Clean(literal); cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return errors.New("must not point outside the tool directory")
+	}
+
+	return nil
+}
+
+// ResolveOrganizePattern fills in the placeholders of a validated pattern,
+// returning a path relative to the tool's directory
+func ResolveOrganizePattern(pattern, tool, taskID string, now time.Time) string {
+	if pattern == "" {
+		pattern = DefaultOrganizePattern
+	}
+
+	resolved := organizePlaceholder.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+		value := organizePlaceholders[strings.Trim(placeholder, "{}")](tool, taskID, now)
+		// Values become single path elements, never separators
+		return strings.ReplaceAll(value, string(filepath.Separator), "_")
+	})
+	return filepath.Clean(resolved)
+}
+
+// OrganizeRoute sends organized files of matching MIME types to their own
+// base directory instead of the tool's, e.g. videos to /media/video. The
+// organize pattern still applies below it.
+type OrganizeRoute struct {
+	// MimeType is a full type like "image/png", a "video/*" wildcard or
+	// "*/*" for any type
+	MimeType  string `json:"mime_type" yaml:"mime_type"`
+	Directory string `json:"directory" yaml:"directory"`
+}
+
+// Validate checks that the route has a directory and a well-formed MIME type
+func (r OrganizeRoute) Validate() error {
+	if strings.TrimSpace(r.Directory) == "" {
+		return errors.New("directory is required")
+	}
+	major, minor, ok := strings.Cut(r.MimeType, "/")
+	if !ok || major == "" || minor == "" || strings.ContainsAny(r.MimeType, " ;") || strings.Count(r.MimeType, "/") != 1 {
+		return fmt.Errorf("mime_type must look like \"video/*\" or \"image/png\", got %q", r.MimeType)
+	}
+	if major == "*" && minor != "*" {
+		return fmt.Errorf("mime_type %q: only \"*/*\" may use a wildcard type", r.MimeType)
+	}
+	if strings.Contains(minor, "*") && minor != "*" {
+		return fmt.Errorf("mime_type %q: a wildcard must be the whole subtype", r.MimeType)
+	}
+	return nil
+}
+
+// Matches reports whether mimeType, which may carry parameters such as a
+// charset, falls under the route's MIME type
+func (r OrganizeRoute) Matches(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	major, minor, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mimeType)), "/")
+	if !ok {
+		return false
+	}
+	routeMajor, routeMinor, _ := strings.Cut(strings.ToLower(r.MimeType), "/")
+	return (routeMajor == "*" || routeMajor == major) && (routeMinor == "*" || routeMinor == minor)
+}
+
+// matchOrganizeRoute returns the first route matching mimeType
+func matchOrganizeRoute(routes []OrganizeRoute, mimeType string) (OrganizeRoute, bool) {
+	for _, route := range routes {
+		if route.Matches(mimeType) {
+			return route, true
+		}
+	}
+	return OrganizeRoute{}, false
+}
@@ -0,0 +1,233 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestValidateOrganizePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr string
+	}{
+		{pattern: ""},
+		{pattern: "."},
+		{pattern: "{date}"},
+		{pattern: "{tool}/{year}/{month}"},
+		{pattern: "archive/{year}-{month}-{day}/{task}"},
+		{pattern: "{week}", wantErr: "unknown placeholder {week}"},
+		{pattern: "{year", wantErr: "unbalanced braces"},
+		{pattern: "year}/x", wantErr: "unbalanced braces"},
+		{pattern: "/srv/{tool}", wantErr: "must be a relative path"},
+		{pattern: "../{tool}", wantErr: "must not point outside"},
+		{pattern: "{year}/../..", wantErr: "must not point outside"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := ValidateOrganizePattern(tt.pattern)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected valid pattern, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestResolveOrganizePattern(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "", want: "2024-05-01"},
+		{pattern: ".", want: "."},
+		{pattern: "{tool}/{year}/{month}", want: filepath.Join("yt-dlp", "2024", "05")},
+		{pattern: "{year}{month}{day}/{task}", want: filepath.Join("20240501", "task-1")},
+	}
+
+	for _, tt := range tests {
+		got := ResolveOrganizePattern(tt.pattern, "yt-dlp", "task-1", now)
+		if got != tt.want {
+			t.Errorf("ResolveOrganizePattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFileDiscovery_OrganizeFilesByPattern(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	source := filepath.Join(tempDir, "video.mp4")
+	if err := os.WriteFile(source, []byte("test content"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	repo := storage.NewMockRepository()
+	fileManager := NewManager(repo)
+	discovery := NewFileDiscovery(fileManager)
+
+	tool := "yt-dlp"
+	toolPath := filepath.Join(tempDir, "videos")
+	if _, err := fileManager.CreateDirectory(ctx, "Videos", toolPath, &tool, false); err != nil {
+		t.Fatalf("CreateDirectory() error = %v", err)
+	}
+	if err := discovery.SetOrganizePattern("yt-dlp", "{tool}/{task}"); err != nil {
+		t.Fatalf("SetOrganizePattern() error = %v", err)
+	}
+	if err := discovery.SetOrganizePattern("yt-dlp", "{nope}"); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+
+	if err := discovery.OrganizeFilesByPattern(ctx, "task-1", "yt-dlp", []string{source}, nil); err != nil {
+		t.Fatalf("OrganizeFilesByPattern() error = %v", err)
+	}
+
+	target := filepath.Join(toolPath, "yt-dlp", "task-1", "video.mp4")
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("Expected file to be moved to %s: %v", target, err)
+	}
+}
+
+func TestFileDiscovery_OrganizeFilesOutsideAllowedRoots(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "id_rsa")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	inside := filepath.Join(root, "video.mp4")
+	if err := os.WriteFile(inside, []byte("test content"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	repo := storage.NewMockRepository()
+	fileManager := NewManager(repo)
+	fileManager.SetDownloadsDir(filepath.Join(root, "downloads"))
+	if err := fileManager.SetAllowedRoots([]string{root}); err != nil {
+		t.Fatalf("SetAllowedRoots() error = %v", err)
+	}
+	discovery := NewFileDiscovery(fileManager)
+
+	if err := discovery.OrganizeFilesByPattern(ctx, "task-1", "yt-dlp", []string{outside, inside}, nil); err != nil {
+		t.Fatalf("OrganizeFilesByPattern() error = %v", err)
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Expected the file outside the roots to stay in place: %v", err)
+	}
+	files, err := repo.ListFiles(ctx, types.FileFilters{})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || filepath.Base(files[0].FilePath) != "video.mp4" || !strings.HasPrefix(files[0].FilePath, root) {
+		t.Errorf("Expected only video.mp4 to be organized, got %+v", files)
+	}
+}
+
+func TestOrganizeRoute(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		valid    bool
+		matches  []string
+		misses   []string
+	}{
+		{"video/*", true, []string{"video/mp4", "Video/WebM"}, []string{"image/png", "audio/mpeg"}},
+		{"image/png", true, []string{"image/png"}, []string{"image/jpeg"}},
+		{"*/*", true, []string{"text/plain; charset=utf-8", "application/octet-stream"}, nil},
+		{"text/*", true, []string{"text/plain; charset=utf-8"}, []string{"application/json"}},
+		{"video", false, nil, nil},
+		{"*/mp4", false, nil, nil},
+		{"video/mp*", false, nil, nil},
+		{"video/mp4/x", false, nil, nil},
+	}
+
+	for _, tt := range tests {
+		route := OrganizeRoute{MimeType: tt.mimeType, Directory: "/media"}
+		if err := route.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) error = %v, want valid %v", tt.mimeType, err, tt.valid)
+		}
+		for _, mimeType := range tt.matches {
+			if !route.Matches(mimeType) {
+				t.Errorf("Expected %q to match %q", tt.mimeType, mimeType)
+			}
+		}
+		for _, mimeType := range tt.misses {
+			if route.Matches(mimeType) {
+				t.Errorf("Expected %q not to match %q", tt.mimeType, mimeType)
+			}
+		}
+	}
+
+	if err := (OrganizeRoute{MimeType: "video/*"}).Validate(); err == nil {
+		t.Error("Expected a route without a directory to be rejected")
+	}
+}
+
+func TestFileDiscovery_OrganizeFilesByRoute(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	repo := storage.NewMockRepository()
+	fileManager := NewManager(repo)
+	fileManager.SetDownloadsDir(filepath.Join(tempDir, "downloads"))
+	discovery := NewFileDiscovery(fileManager)
+
+	// Only types Go knows without the system MIME database
+	imageDir := filepath.Join(tempDir, "media", "images")
+	if err := discovery.SetOrganizeRoutes([]OrganizeRoute{{MimeType: "image/*", Directory: imageDir}}); err != nil {
+		t.Fatalf("SetOrganizeRoutes() error = %v", err)
+	}
+	if err := discovery.SetOrganizeRoutes([]OrganizeRoute{{MimeType: "image", Directory: imageDir}}); err == nil {
+		t.Error("Expected invalid route to be rejected")
+	}
+	if err := discovery.SetOrganizePattern("yt-dlp", "{task}"); err != nil {
+		t.Fatalf("SetOrganizePattern() error = %v", err)
+	}
+
+	var sources []string
+	for _, name := range []string{"cover.png", "photo.jpg", "notes.txt"} {
+		source := filepath.Join(tempDir, name)
+		if err := os.WriteFile(source, []byte("test content"), 0o644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		sources = append(sources, source)
+	}
+
+	if err := discovery.OrganizeFilesByPattern(ctx, "task-1", "yt-dlp", sources, nil); err != nil {
+		t.Fatalf("OrganizeFilesByPattern() error = %v", err)
+	}
+
+	// Images follow the route, below the tool's organize pattern, the rest
+	// go to the tool's directory
+	for _, target := range []string{
+		filepath.Join(imageDir, "task-1", "cover.png"),
+		filepath.Join(imageDir, "task-1", "photo.jpg"),
+		filepath.Join(tempDir, "downloads", "yt-dlp", "task-1", "notes.txt"),
+	} {
+		if _, err := os.Stat(target); err != nil {
+			t.Errorf("Expected file to be moved to %s: %v", target, err)
+		}
+	}
+
+	dirs, err := fileManager.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		t.Fatalf("ListDirectories() error = %v", err)
+	}
+	if len(dirs) != 2 {
+		t.Errorf("Expected the route and tool directories to be registered once each, got %d", len(dirs))
+	}
+}
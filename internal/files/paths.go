@@ -0,0 +1,207 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// ErrPathNotAllowed is returned for paths that contain ".." or resolve to a
+// location outside the allowed roots
+var ErrPathNotAllowed = errors.New("path not allowed")
+
+// SetAllowedRoots restricts the directories and files the manager works
+// with to the given roots. With no roots every path is allowed, except
+// ones that contain "..".
+func (m *Manager) SetAllowedRoots(roots []string) error {
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		abs, err := resolvePath(root)
+		if err != nil {
+			return fmt.Errorf("invalid allowed root %q: %w", root, err)
+		}
+		resolved = append(resolved, abs)
+	}
+
+	m.rootsMu.Lock()
+	defer m.rootsMu.Unlock()
+	m.allowedRoots = resolved
+	return nil
+}
+
+// AllowedRoots returns the resolved allowed roots, empty if unrestricted
+func (m *Manager) AllowedRoots() []string {
+	m.rootsMu.RLock()
+	defer m.rootsMu.RUnlock()
+	return append([]string(nil), m.allowedRoots...)
+}
+
+// CheckPath returns an ErrPathNotAllowed error unless path is free of ".."
+// elements and, following symlinks, lies inside one of the allowed roots
+func (m *Manager) CheckPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: path is empty", ErrPathNotAllowed)
+	}
+	for _, element := range strings.FieldsFunc(path, isPathSeparator) {
+		if element == ".." {
+			return fmt.Errorf("%w: %s contains ..", ErrPathNotAllowed, path)
+		}
+	}
+
+	roots := m.AllowedRoots()
+	if len(roots) == 0 {
+		return nil
+	}
+
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	for _, root := range roots {
+		if withinRoot(resolved, root) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is outside the allowed roots", ErrPathNotAllowed, path)
+}
+
+// CheckInDirectory returns an ErrPathNotAllowed error unless path,
+// following symlinks, lies inside one of the registered directories, so a
+// tampered file record can't be used to read arbitrary files
+func (m *Manager) CheckInDirectory(ctx context.Context, path string) error {
+	dirs, err := m.fileRepo.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		return fmt.Errorf("failed to list directories: %w", err)
+	}
+
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = dir.Path
+	}
+	within, err := inDirectories(path, paths)
+	if err != nil {
+		return err
+	}
+	if !within {
+		return fmt.Errorf("%w: %s is outside the library directories", ErrPathNotAllowed, path)
+	}
+	return nil
+}
+
+// ToolDirectories returns the paths of the directories linked to a tool, or
+// the directory its files would be organized into when none is linked yet,
+// see FileDiscovery.GetOrCreateToolDirectory
+func (m *Manager) ToolDirectories(ctx context.Context, toolName string) ([]string, error) {
+	dirs, err := m.fileRepo.ListDirectories(ctx, types.DirectoryFilters{ToolName: toolName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directories: %w", err)
+	}
+	if len(dirs) == 0 {
+		return []string{filepath.Join(m.DownloadsDir(), toolName)}, nil
+	}
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = dir.Path
+	}
+	return paths, nil
+}
+
+// CheckInDirectories returns an ErrPathNotAllowed error unless path,
+// following symlinks, lies inside one of dirs
+func CheckInDirectories(path string, dirs []string) error {
+	within, err := inDirectories(path, dirs)
+	if err != nil {
+		return err
+	}
+	if !within {
+		return fmt.Errorf("%w: %s is outside %s", ErrPathNotAllowed, path, strings.Join(dirs, ", "))
+	}
+	return nil
+}
+
+// inDirectories reports whether path, following symlinks, lies inside one
+// of dirs. Directories that can't be resolved are skipped.
+func inDirectories(path string, dirs []string) (bool, error) {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	for _, dir := range dirs {
+		root, err := resolvePath(dir)
+		if err != nil {
+			continue
+		}
+		if withinRoot(resolved, root) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ExpandPath replaces a leading "~" in path with the user's home directory
+// and $VAR or ${VAR} with the value of the environment variable, empty if
+// it isn't set. "~user" is left as it is.
+func ExpandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand %s: %w", path, err)
+		}
+		path = home + path[1:]
+	}
+	return os.ExpandEnv(path), nil
+}
+
+// resolvePath returns the absolute form of path with symlinks evaluated.
+// Elements that don't exist yet are appended to their nearest existing
+// parent, so paths that are about to be created can be checked too.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	existing := abs
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+}
+
+// withinRoot reports whether path is root or lies below it. Both must be
+// absolute and clean.
+func withinRoot(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// isPathSeparator reports whether r separates path elements. Backslashes
+// count on every platform so Windows-style traversal is caught too.
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
@@ -0,0 +1,166 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestCheckPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	// A symlink inside the root that leads out of it
+	escape := filepath.Join(root, "escape")
+	if err := os.Symlink(outside, escape); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	manager := NewManager(storage.NewMockRepository())
+	if err := manager.SetAllowedRoots([]string{root}); err != nil {
+		t.Fatalf("SetAllowedRoots() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		allowed bool
+	}{
+		{"root itself", root, true},
+		{"file in root", filepath.Join(root, "video.mp4"), true},
+		{"missing subdirectory", filepath.Join(root, "new", "dir"), true},
+		{"dot dot escape", root + "/../etc/passwd", false},
+		{"dot dot inside root", root + "/a/../b", false},
+		{"backslash dot dot", root + `\..\secret`, false},
+		{"absolute path elsewhere", "/etc/passwd", false},
+		{"sibling with common prefix", root + "-other/file", false},
+		{"other directory", filepath.Join(outside, "file"), false},
+		{"symlink out of root", filepath.Join(escape, "file"), false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := manager.CheckPath(tt.path)
+			if tt.allowed && err != nil {
+				t.Errorf("CheckPath(%q) error = %v, want allowed", tt.path, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrPathNotAllowed) {
+				t.Errorf("CheckPath(%q) error = %v, want ErrPathNotAllowed", tt.path, err)
+			}
+		})
+	}
+}
+
+func TestCheckPathUnrestricted(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+
+	if err := manager.CheckPath("/srv/media/video.mp4"); err != nil {
+		t.Errorf("Expected any path to be allowed without roots, got %v", err)
+	}
+	if err := manager.CheckPath("downloads/../../etc"); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected .. to be rejected without roots, got %v", err)
+	}
+}
+
+func TestManager_AllowedRoots(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := manager.SetAllowedRoots([]string{root}); err != nil {
+		t.Fatalf("SetAllowedRoots() error = %v", err)
+	}
+
+	if _, err := manager.CreateDirectory(ctx, "Escape", root+"/../escape", nil, false); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected ErrPathNotAllowed creating a directory with .., got %v", err)
+	}
+	if _, err := manager.CreateDirectory(ctx, "Outside", outside, nil, false); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected ErrPathNotAllowed creating a directory outside the roots, got %v", err)
+	}
+
+	inside, err := manager.CreateDirectory(ctx, "Inside", filepath.Join(root, "media"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// A record pointing outside the roots, as a tampered database might hold
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	file := &types.File{
+		ID:          "crafted",
+		Filename:    "secret.txt",
+		FilePath:    secret,
+		DirectoryID: inside.ID,
+		CreatedAt:   time.Now(),
+		AccessedAt:  time.Now(),
+	}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file record: %v", err)
+	}
+
+	if err := manager.MoveFile(ctx, file.ID, inside.ID); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected ErrPathNotAllowed moving a file from outside the roots, got %v", err)
+	}
+	if err := manager.DeleteFile(ctx, file.ID); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected ErrPathNotAllowed deleting a file outside the roots, got %v", err)
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("Expected file outside the roots to be untouched: %v", err)
+	}
+	if err := manager.RegisterFileFromTask(ctx, "task-1", secret, &inside.ID, nil); !errors.Is(err, ErrPathNotAllowed) {
+		t.Errorf("Expected ErrPathNotAllowed registering a file outside the roots, got %v", err)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("LIBRARY", "/srv/library")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", home},
+		{"~/downloads", home + "/downloads"},
+		{"$HOME/downloads", home + "/downloads"},
+		{"${LIBRARY}/music", "/srv/library/music"},
+		{"$UNSET_COMMANDER_VAR/music", "/music"},
+		{"~user/downloads", "~user/downloads"},
+		{"/data/~/x", "/data/~/x"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := ExpandPath(tt.path)
+		if err != nil {
+			t.Fatalf("ExpandPath(%q) error = %v", tt.path, err)
+		}
+		if got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// Directories are created at the expanded path, not literally "$HOME"
+	manager := NewManager(storage.NewMockRepository())
+	dir, err := manager.CreateDirectory(context.Background(), "Library", "$HOME/library", nil, false)
+	if err != nil {
+		t.Fatalf("CreateDirectory() error = %v", err)
+	}
+	if dir.Path != filepath.Join(home, "library") {
+		t.Errorf("Expected the directory in the home directory, got %s", dir.Path)
+	}
+	if _, err := os.Stat(dir.Path); err != nil {
+		t.Errorf("Expected the directory to exist: %v", err)
+	}
+}
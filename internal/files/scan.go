@@ -0,0 +1,283 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// scanBatchSize is how many files a scan saves per transaction. Memory use
+// of a scan stays bounded by it however many files the directory holds.
+const scanBatchSize = 500
+
+// EventScanProgress is published on events.TopicFiles as a ScanProgress
+// after every batch of a directory scan
+const EventScanProgress = "scan_progress"
+
+// ScanResult counts what a directory scan found
+type ScanResult struct {
+	DirectoryID string `json:"directory_id"`
+	Scanned     int    `json:"scanned"` // Files seen on disk
+	Added       int    `json:"added"`   // Files that weren't tracked yet
+}
+
+// ScanProgress reports a running scan, Done is set on the last one
+type ScanProgress struct {
+	Type string `json:"type"`
+	ScanResult
+	Done bool `json:"done"`
+}
+
+// ScanDirectory scans a directory for files and adds the untracked ones to
+// the database. Files are saved in batches, and the scan stops between
+// batches when ctx is done, keeping the batches already saved.
+func (m *Manager) ScanDirectory(ctx context.Context, directoryID string) (ScanResult, error) {
+	result := ScanResult{DirectoryID: directoryID}
+
+	dir, err := m.fileRepo.GetDirectory(ctx, directoryID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get directory: %w", err)
+	}
+
+	if err := m.CheckPath(dir.Path); err != nil {
+		return result, err
+	}
+
+	batch := make([]*types.File, 0, scanBatchSize)
+	flush := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		// Files that are already tracked are skipped by the repository, so
+		// the existing records never have to be loaded
+		created, err := m.fileRepo.CreateFiles(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to save files: %w", err)
+		}
+		for _, file := range created {
+			m.broadcastEvent(FileEvent{Type: EventFileCreated, FileID: file.ID, DirectoryID: directoryID, Data: file.FilePath})
+		}
+		result.Scanned += len(batch)
+		result.Added += len(created)
+		batch = batch[:0]
+
+		m.bus.Publish(events.TopicFiles, ScanProgress{Type: EventScanProgress, ScanResult: result})
+		return nil
+	}
+
+	err = filepath.WalkDir(dir.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories, and unfinished uploads entirely. Check for
+		// cancellation on every directory too, a tree of mostly empty
+		// directories may not fill a batch for a long time.
+		if d.IsDir() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.Name() == partialDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Skip symlinks leading out of the allowed roots
+		if d.Type()&fs.ModeSymlink != 0 && m.CheckPath(path) != nil {
+			return nil
+		}
+
+		// Get file info
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, &types.File{
+			ID:          uuid.New().String(),
+			Filename:    d.Name(),
+			FilePath:    path,
+			DirectoryID: directoryID,
+			FileSize:    info.Size(),
+			MimeType:    m.detectMimeType(path),
+			CreatedAt:   info.ModTime(),
+			AccessedAt:  time.Now(),
+			Tags:        []string{},
+		})
+		if len(batch) < scanBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return result, err
+	}
+
+	m.bus.Publish(events.TopicFiles, ScanProgress{Type: EventScanProgress, ScanResult: result, Done: true})
+	return result, nil
+}
+
+// ScanAllResult reports a scan of every registered directory, with totals
+// over all of them
+type ScanAllResult struct {
+	Directories []DirectoryScan `json:"directories"`
+	Scanned     int             `json:"scanned"`
+	Added       int             `json:"added"`
+	Removed     int             `json:"removed"`
+	Updated     int             `json:"updated"`
+	Failed      int             `json:"failed"` // Directories that couldn't be scanned
+}
+
+// DirectoryScan is one directory's part of a ScanAllResult. Error is set
+// when the directory couldn't be scanned, the counts then cover the batches
+// saved before it failed.
+type DirectoryScan struct {
+	ScanResult
+	Removed int    `json:"removed"` // Records of files gone from disk
+	Updated int    `json:"updated"` // Records of files whose size changed
+	Path    string `json:"path"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ScanAll scans every registered directory one after another, see
+// ScanDirectory, and then refreshes the records of the files it tracks,
+// see refreshDirectory. A directory that can't be scanned, e.g. because it
+// is gone from disk, is reported and the others are scanned regardless; its
+// records are left alone. The same goes for a directory found empty while
+// it has records, which is what the mount point of an unmounted drive
+// looks like, so that doesn't empty the library either.
+// When ctx is done the scan stops and returns the directories scanned so
+// far together with the context's error.
+func (m *Manager) ScanAll(ctx context.Context) (ScanAllResult, error) {
+	result := ScanAllResult{Directories: []DirectoryScan{}}
+
+	dirs, err := m.fileRepo.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		return result, fmt.Errorf("failed to list directories: %w", err)
+	}
+
+	for _, dir := range dirs {
+		scanned, err := m.ScanDirectory(ctx, dir.ID)
+		scan := DirectoryScan{ScanResult: scanned, Path: dir.Path}
+		if err == nil {
+			scan.Removed, scan.Updated, err = m.refreshDirectory(ctx, dir.ID, scanned.Scanned)
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return result, ctxErr
+			}
+			scan.Error = err.Error()
+			result.Failed++
+		}
+		result.Directories = append(result.Directories, scan)
+		result.Scanned += scanned.Scanned
+		result.Added += scanned.Added
+		result.Removed += scan.Removed
+		result.Updated += scan.Updated
+	}
+	return result, nil
+}
+
+// refreshDirectory brings the records of the files tracked in a directory
+// in line with the disk: records of files that are gone are removed, and
+// the size of files that changed is updated. Files that can't be checked
+// for another reason are left alone. The records are read first and the
+// stale ones changed afterwards, each under its file's lock and after
+// checking it again, so a file moved meanwhile is not mistaken for gone.
+// seen is the number of files the scan found on disk; when it is zero
+// nothing is changed and an error is returned if there are records.
+func (m *Manager) refreshDirectory(ctx context.Context, directoryID string, seen int) (removed, updated int, err error) {
+	var stale []string
+	tracked := 0
+	err = m.fileRepo.EachFile(ctx, types.FileFilters{DirectoryID: directoryID}, func(file *types.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tracked++
+		if gone, changed := checkOnDisk(file); gone || changed {
+			stale = append(stale, file.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list files: %w", err)
+	}
+	if seen == 0 && tracked > 0 {
+		return 0, 0, fmt.Errorf("directory is empty, keeping its %d file records in case its drive isn't mounted", tracked)
+	}
+
+	for _, fileID := range stale {
+		if err := ctx.Err(); err != nil {
+			return removed, updated, err
+		}
+		gone, changed, err := m.refreshFile(ctx, fileID)
+		if err != nil {
+			return removed, updated, err
+		}
+		if gone {
+			removed++
+		} else if changed {
+			updated++
+		}
+	}
+	return removed, updated, nil
+}
+
+// refreshFile removes the record of a file that is gone from disk, or
+// updates its size when it changed
+func (m *Manager) refreshFile(ctx context.Context, fileID string) (gone, changed bool, err error) {
+	defer m.fileLocks.lock(fileID)()
+
+	file, err := m.fileRepo.GetFile(ctx, fileID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	gone, changed = checkOnDisk(file)
+	switch {
+	case gone:
+		if err := m.fileRepo.DeleteFile(ctx, fileID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return false, false, fmt.Errorf("failed to remove record of %s: %w", file.FilePath, err)
+		}
+		m.broadcastEvent(FileEvent{Type: EventFileDeleted, FileID: fileID, DirectoryID: file.DirectoryID, Data: file.FilePath})
+	case changed:
+		if err := m.fileRepo.UpdateFile(ctx, file); err != nil {
+			return false, false, fmt.Errorf("failed to update record of %s: %w", file.FilePath, err)
+		}
+	}
+	return gone, changed, nil
+}
+
+// checkOnDisk reports whether a tracked file is gone from disk, or else
+// whether its size changed, setting file.FileSize to the current one
+func checkOnDisk(file *types.File) (gone, changed bool) {
+	info, err := os.Stat(file.FilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, false
+	}
+	if err != nil || info.Size() == file.FileSize {
+		return false, false
+	}
+	file.FileSize = info.Size()
+	return false, true
+}
@@ -0,0 +1,243 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestScanDirectory(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	dir, err := manager.CreateDirectory(ctx, "Library", filepath.Join(t.TempDir(), "library"), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// More files than fit in two batches, spread over subdirectories
+	total := 2*scanBatchSize + 10
+	for i := 0; i < total; i++ {
+		path := filepath.Join(dir.Path, fmt.Sprintf("sub%d", i%3), fmt.Sprintf("file%d.mp3", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create subdirectory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir.Path, partialDir), 0o755); err != nil {
+		t.Fatalf("Failed to create partial directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir.Path, partialDir, "upload"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial upload: %v", err)
+	}
+
+	// A file that is already tracked is counted but not added again
+	now := time.Now()
+	tracked := &types.File{ID: "tracked", Filename: "file0.mp3", FilePath: filepath.Join(dir.Path, "sub0", "file0.mp3"), DirectoryID: dir.ID, FileSize: 1, CreatedAt: now, AccessedAt: now}
+	if err := repo.CreateFile(ctx, tracked); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	sub := manager.EventBus().Subscribe(2*total, events.TopicFiles)
+	defer manager.EventBus().Unsubscribe(sub)
+
+	result, err := manager.ScanDirectory(ctx, dir.ID)
+	if err != nil {
+		t.Fatalf("ScanDirectory failed: %v", err)
+	}
+	if want := (ScanResult{DirectoryID: dir.ID, Scanned: total, Added: total - 1}); result != want {
+		t.Errorf("Expected %+v, got %+v", want, result)
+	}
+
+	created, progress := 0, []ScanProgress{}
+	for len(sub.C) > 0 {
+		switch event := (<-sub.C).Payload.(type) {
+		case FileEvent:
+			created++
+		case ScanProgress:
+			progress = append(progress, event)
+		}
+	}
+	if created != total-1 {
+		t.Errorf("Expected %d file_created events, got %d", total-1, created)
+	}
+	if len(progress) != 4 || !progress[3].Done || progress[0].Scanned != scanBatchSize || progress[3].ScanResult != result {
+		t.Errorf("Expected progress after every batch and a final report, got %+v", progress)
+	}
+
+	files, err := repo.ListFiles(ctx, types.FileFilters{DirectoryID: dir.ID})
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != total {
+		t.Errorf("Expected %d tracked files, got %d", total, len(files))
+	}
+
+	// A second scan finds nothing new
+	result, err = manager.ScanDirectory(ctx, dir.ID)
+	if err != nil {
+		t.Fatalf("ScanDirectory failed: %v", err)
+	}
+	if result.Scanned != total || result.Added != 0 {
+		t.Errorf("Expected a rescan to add nothing, got %+v", result)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := manager.ScanDirectory(canceled, dir.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestScanAll(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	root := t.TempDir()
+	for i, name := range []string{"music", "videos", "gone"} {
+		dir, err := manager.CreateDirectory(ctx, name, filepath.Join(root, name), nil, false)
+		if err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		for j := 0; j <= i; j++ {
+			if err := os.WriteFile(filepath.Join(dir.Path, fmt.Sprintf("file%d", j)), []byte("x"), 0o644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+	}
+
+	// A directory removed from disk fails without stopping the others
+	if err := os.RemoveAll(filepath.Join(root, "gone")); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+
+	result, err := manager.ScanAll(ctx)
+	if err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+	if len(result.Directories) != 3 || result.Scanned != 3 || result.Added != 3 || result.Failed != 1 {
+		t.Errorf("Expected 3 files added from 2 of 3 directories, got %+v", result)
+	}
+	for _, scan := range result.Directories {
+		if failed := scan.Path == filepath.Join(root, "gone"); failed != (scan.Error != "") {
+			t.Errorf("Unexpected result for %s: %+v", scan.Path, scan)
+		}
+	}
+
+	// After moving things around, records of files that are gone are
+	// removed and changed sizes updated
+	if err := os.Remove(filepath.Join(root, "videos", "file0")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "videos", "file1"), []byte("longer"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// Records in a directory that can't be scanned are kept
+	gone := &types.File{ID: "gone", Filename: "file0", FilePath: filepath.Join(root, "gone", "file0")}
+	for _, scan := range result.Directories {
+		if scan.Path == filepath.Join(root, "gone") {
+			gone.DirectoryID = scan.DirectoryID
+		}
+	}
+	if err := repo.CreateFile(ctx, gone); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	result, err = manager.ScanAll(ctx)
+	if err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+	if result.Scanned != 2 || result.Added != 0 || result.Removed != 1 || result.Updated != 1 || result.Failed != 1 {
+		t.Errorf("Expected one record removed and one updated, got %+v", result)
+	}
+	for _, scan := range result.Directories {
+		if scan.Path == filepath.Join(root, "videos") && (scan.Removed != 1 || scan.Updated != 1) {
+			t.Errorf("Expected one videos record removed and one updated, got %+v", scan)
+		}
+	}
+	files, err := repo.ListFiles(ctx, types.FileFilters{})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	sizes := map[string]int64{}
+	for _, file := range files {
+		sizes[file.FilePath] = file.FileSize
+	}
+	if _, ok := sizes[filepath.Join(root, "videos", "file0")]; ok {
+		t.Error("Expected the record of the removed file to be gone")
+	}
+	if got := sizes[filepath.Join(root, "videos", "file1")]; got != 6 {
+		t.Errorf("Expected the updated size 6, got %d", got)
+	}
+	if _, ok := sizes[gone.FilePath]; !ok || len(files) != 3 {
+		t.Errorf("Expected 3 records including the unscannable directory's, got %v", sizes)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := manager.ScanAll(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestScanAllEmptyDirectory(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+	manager := NewManager(repo)
+	ctx := context.Background()
+
+	dir, err := manager.CreateDirectory(ctx, "drive", t.TempDir(), nil, false)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		if err := os.WriteFile(filepath.Join(dir.Path, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if _, err := manager.ScanAll(ctx); err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+
+	// An unmounted drive leaves its mount point behind, existing but empty
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		if err := os.Remove(filepath.Join(dir.Path, name)); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+	}
+
+	result, err := manager.ScanAll(ctx)
+	if err != nil {
+		t.Fatalf("ScanAll failed: %v", err)
+	}
+	if result.Removed != 0 || result.Failed != 1 || result.Directories[0].Error == "" {
+		t.Errorf("Expected the empty directory to be reported and its records kept, got %+v", result)
+	}
+	files, err := repo.ListFiles(ctx, types.FileFilters{DirectoryID: dir.ID})
+	if err != nil || len(files) != 2 {
+		t.Errorf("Expected both records to be kept, got %d (%v)", len(files), err)
+	}
+}
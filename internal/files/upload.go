@@ -0,0 +1,420 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// Errors returned for rejected uploads
+var (
+	ErrUploadTooLarge  = errors.New("upload exceeds the maximum size")
+	ErrInvalidFilename = errors.New("invalid filename")
+	ErrUploadOffset    = errors.New("upload offset does not match")
+)
+
+// maxFilenameLength is the longest file name most filesystems accept
+const maxFilenameLength = 255
+
+// partialDir holds the data of unfinished resumable uploads inside the
+// upload directory
+const partialDir = ".partial"
+
+// DefaultUploadSessionTTL is how long a resumable upload may go without a
+// chunk before it is dropped
+const DefaultUploadSessionTTL = 24 * time.Hour
+
+// SanitizeFilename reduces a client-supplied name to a plain file name, so
+// an upload can never be written outside the upload directory
+func SanitizeFilename(name string) (string, error) {
+	// Treat both separators as path separators and keep the last element
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Leading dots would allow "..", "." or hidden files, and path.Base
+	// returns "/" for a bare root
+	name = strings.TrimLeft(strings.TrimSpace(name), "./")
+	if name == "" {
+		return "", fmt.Errorf("%w: name is empty", ErrInvalidFilename)
+	}
+	if len(name) > maxFilenameLength {
+		return "", fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidFilename, maxFilenameLength)
+	}
+	return name, nil
+}
+
+// UploadSession tracks a resumable upload sent in chunks
+type UploadSession struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+
+	lastActive time.Time // Start or latest chunk, see ExpireSessions
+	writing    bool      // A chunk is being written
+}
+
+// Uploader stores files uploaded by clients in a directory and registers
+// them in the library. Resumable sessions are kept in memory, so only their
+// partial data survives a restart; it is removed once it expires.
+type Uploader struct {
+	manager    *Manager
+	dir        string
+	maxBytes   int64
+	sessionTTL time.Duration
+
+	// mu guards the sessions map and the sessions' fields, sessionLocks
+	// serialize the chunks of each upload
+	mu           sync.Mutex
+	sessions     map[string]*UploadSession
+	sessionLocks keyedLocks
+}
+
+// NewUploader creates an uploader writing to dir and accepting files of up
+// to maxBytes
+func NewUploader(manager *Manager, dir string, maxBytes int64) *Uploader {
+	return &Uploader{
+		manager:    manager,
+		dir:        dir,
+		maxBytes:   maxBytes,
+		sessionTTL: DefaultUploadSessionTTL,
+		sessions:   make(map[string]*UploadSession),
+	}
+}
+
+// SetSessionTTL sets how long a resumable upload may go without a chunk
+// before ExpireSessions drops it. Zero keeps sessions forever.
+func (u *Uploader) SetSessionTTL(ttl time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sessionTTL = ttl
+}
+
+// MaxBytes returns the largest accepted upload size
+func (u *Uploader) MaxBytes() int64 {
+	return u.maxBytes
+}
+
+// Upload stores the content of r under the sanitized filename and registers
+// it. Name clashes are resolved by adding a number to the name. The file is
+// removed again if it can't be registered.
+func (u *Uploader) Upload(ctx context.Context, filename string, r io.Reader) (*types.File, error) {
+	name, err := SanitizeFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	file, filePath, err := u.createUnique(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read one byte past the limit to detect oversized uploads
+	written, err := io.Copy(file, io.LimitReader(r, u.maxBytes+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > u.maxBytes {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(filePath)
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+
+	return u.registerOrRemove(ctx, filePath)
+}
+
+// StartSession begins a resumable upload of size bytes
+func (u *Uploader) StartSession(filename string, size int64) (UploadSession, error) {
+	name, err := SanitizeFilename(filename)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	if size <= 0 {
+		return UploadSession{}, fmt.Errorf("%w: size must be positive", ErrInvalidFilename)
+	}
+	if size > u.maxBytes {
+		return UploadSession{}, ErrUploadTooLarge
+	}
+
+	session := &UploadSession{ID: uuid.New().String(), Filename: name, Size: size, lastActive: time.Now()}
+	if err := os.MkdirAll(filepath.Join(u.dir, partialDir), 0o755); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	file, err := os.Create(u.partialPath(session.ID))
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return UploadSession{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sessions[session.ID] = session
+	return *session, nil
+}
+
+// Session returns the state of a resumable upload
+func (u *Uploader) Session(id string) (UploadSession, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	session, ok := u.sessions[id]
+	if !ok {
+		return UploadSession{}, fmt.Errorf("upload %s: %w", id, storage.ErrNotFound)
+	}
+	return *session, nil
+}
+
+// WriteChunk appends the content of r to a resumable upload. offset must
+// equal the number of bytes received so far. When the last byte arrives the
+// upload is moved into place, registered and returned. If registering
+// fails the file is removed and the upload has to start over.
+func (u *Uploader) WriteChunk(ctx context.Context, id string, offset int64, r io.Reader) (UploadSession, *types.File, error) {
+	// Chunks of one upload are written one at a time, other uploads and
+	// Session calls go on meanwhile
+	defer u.sessionLocks.lock(id)()
+
+	u.mu.Lock()
+	session, ok := u.sessions[id]
+	if !ok {
+		u.mu.Unlock()
+		return UploadSession{}, nil, fmt.Errorf("upload %s: %w", id, storage.ErrNotFound)
+	}
+	state := *session
+	if offset != state.Offset {
+		u.mu.Unlock()
+		return state, nil, fmt.Errorf("%w: expected %d, got %d", ErrUploadOffset, state.Offset, offset)
+	}
+	session.writing = true
+	session.lastActive = time.Now()
+	u.mu.Unlock()
+
+	written, err := u.appendChunk(id, state, r)
+
+	u.mu.Lock()
+	session.writing = false
+	session.lastActive = time.Now()
+	session.Offset += written
+	state = *session
+	u.mu.Unlock()
+	if err != nil || state.Offset < state.Size {
+		return state, nil, err
+	}
+
+	// Complete: move the data to its final name
+	target, filePath, err := u.createUnique(state.Filename)
+	if err != nil {
+		return state, nil, err
+	}
+	_ = target.Close()
+	if err := os.Rename(u.partialPath(id), filePath); err != nil {
+		_ = os.Remove(filePath)
+		return state, nil, fmt.Errorf("failed to finish upload: %w", err)
+	}
+	u.mu.Lock()
+	delete(u.sessions, id)
+	u.mu.Unlock()
+
+	registered, err := u.registerOrRemove(ctx, filePath)
+	return state, registered, err
+}
+
+// appendChunk writes the content of r to the partial data of an upload in
+// state and returns how many bytes were kept. A failed chunk is dropped
+// entirely so it can be resent.
+func (u *Uploader) appendChunk(id string, state UploadSession, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(u.partialPath(id), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload: %w", err)
+	}
+
+	remaining := state.Size - state.Offset
+	written, err := io.Copy(file, io.LimitReader(r, remaining+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > remaining {
+		err = ErrUploadTooLarge
+	}
+	if err == nil {
+		return written, nil
+	}
+
+	if truncErr := os.Truncate(u.partialPath(id), state.Offset); truncErr != nil {
+		return 0, fmt.Errorf("failed to reset upload after %v: %w", err, truncErr)
+	}
+	if errors.Is(err, ErrUploadTooLarge) {
+		return 0, err
+	}
+	return 0, fmt.Errorf("failed to store chunk: %w", err)
+}
+
+// ExpireSessions drops the resumable uploads that haven't received a chunk
+// within the session TTL, and partial data older than that which belongs to
+// no session, such as uploads left over from before a restart. It returns
+// how many partial files were removed.
+func (u *Uploader) ExpireSessions(now time.Time) int {
+	u.mu.Lock()
+	ttl := u.sessionTTL
+	if ttl <= 0 {
+		u.mu.Unlock()
+		return 0
+	}
+	cutoff := now.Add(-ttl)
+	var expired []string
+	for id, session := range u.sessions {
+		if !session.writing && session.lastActive.Before(cutoff) {
+			delete(u.sessions, id)
+			expired = append(expired, id)
+		}
+	}
+	active := make(map[string]bool, len(u.sessions))
+	for id := range u.sessions {
+		active[id] = true
+	}
+	u.mu.Unlock()
+
+	removed := 0
+	for _, id := range expired {
+		if err := os.Remove(u.partialPath(id)); err == nil || os.IsNotExist(err) {
+			removed++
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(u.dir, partialDir))
+	if err != nil {
+		return removed
+	}
+	for _, entry := range entries {
+		if active[entry.Name()] || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(u.dir, partialDir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunExpiry calls ExpireSessions every interval until ctx is done
+func (u *Uploader) RunExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if removed := u.ExpireSessions(now); removed > 0 {
+				log.Printf("Removed %d expired uploads", removed)
+			}
+		}
+	}
+}
+
+// partialPath returns where the data of a resumable upload is kept
+func (u *Uploader) partialPath(id string) string {
+	return filepath.Join(u.dir, partialDir, id)
+}
+
+// createUnique creates a new file for name in the upload directory, adding
+// " (n)" before the extension if the name is taken
+func (u *Uploader) createUnique(name string) (*os.File, string, error) {
+	if err := os.MkdirAll(u.dir, 0o755); err != nil {
+		return nil, "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		filePath := filepath.Join(u.dir, candidate)
+
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return file, filePath, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", fmt.Errorf("failed to create upload: %w", err)
+		}
+	}
+	return nil, "", fmt.Errorf("failed to find a free name for %s", name)
+}
+
+// register adds an uploaded file to the library in the uploads directory
+func (u *Uploader) register(ctx context.Context, filePath string) (*types.File, error) {
+	dir, err := u.directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := u.manager.registerFile(ctx, filePath, dir.ID, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register upload: %w", err)
+	}
+	return file, nil
+}
+
+// registerOrRemove registers an uploaded file, removing it when that fails
+// so it isn't left in the uploads directory without a record
+func (u *Uploader) registerOrRemove(ctx context.Context, filePath string) (*types.File, error) {
+	file, err := u.register(ctx, filePath)
+	if err != nil {
+		_ = os.Remove(filePath)
+		return nil, err
+	}
+	return file, nil
+}
+
+// directory returns the library directory for uploads, creating it on first use
+func (u *Uploader) directory(ctx context.Context) (*types.Directory, error) {
+	dirs, err := u.manager.fileRepo.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directories: %w", err)
+	}
+
+	// Directory paths are stored absolute
+	uploadDir, err := filepath.Abs(u.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve upload directory: %w", err)
+	}
+	for _, dir := range dirs {
+		if filepath.Clean(dir.Path) == uploadDir {
+			return dir, nil
+		}
+	}
+	return u.manager.CreateDirectory(ctx, "Uploads", u.dir, nil, false)
+}
@@ -0,0 +1,262 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"movie.mp4", "movie.mp4", false},
+		{"../../etc/passwd", "passwd", false},
+		{"..\\..\\windows\\system.ini", "system.ini", false},
+		{"/abs/path/clip.mkv", "clip.mkv", false},
+		{"  .hidden  ", "hidden", false},
+		{"bad\x00name\n.mp3", "badname.mp3", false},
+		{"..", "", true},
+		{"", "", true},
+		{"dir/", "dir", false},
+		{"/", "", true},
+		{strings.Repeat("a", 256), "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := SanitizeFilename(tt.input)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidFilename) {
+				t.Errorf("SanitizeFilename(%q) error = %v, want ErrInvalidFilename", tt.input, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("SanitizeFilename(%q) = %q, %v; want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestUploader_Upload(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+	dir := t.TempDir()
+	uploader := NewUploader(manager, dir, 10)
+
+	first, err := uploader.Upload(ctx, "../input.mp4", strings.NewReader("data"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if first.FilePath != filepath.Join(dir, "input.mp4") || first.FileSize != 4 || first.TaskID != nil {
+		t.Errorf("Unexpected file record: %+v", first)
+	}
+
+	// A second upload with the same name gets a new one
+	second, err := uploader.Upload(ctx, "input.mp4", strings.NewReader("more"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if second.FilePath != filepath.Join(dir, "input (1).mp4") {
+		t.Errorf("Expected renamed upload, got %s", second.FilePath)
+	}
+	if second.DirectoryID != first.DirectoryID {
+		t.Errorf("Expected both uploads in one directory, got %s and %s", first.DirectoryID, second.DirectoryID)
+	}
+
+	if _, err := uploader.Upload(ctx, "big.mp4", strings.NewReader(strings.Repeat("x", 11))); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("Expected ErrUploadTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.mp4")); !os.IsNotExist(err) {
+		t.Errorf("Expected oversized upload to be removed, got %v", err)
+	}
+}
+
+func TestUploader_RemovesUnregistered(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+	dir := t.TempDir()
+	uploader := NewUploader(manager, dir, 100)
+
+	// The uploads directory is outside the allowed roots, so it can't be
+	// added to the library
+	if err := manager.SetAllowedRoots([]string{t.TempDir()}); err != nil {
+		t.Fatalf("SetAllowedRoots() error = %v", err)
+	}
+
+	if _, err := uploader.Upload(ctx, "input.mp4", strings.NewReader("data")); !errors.Is(err, ErrPathNotAllowed) {
+		t.Fatalf("Expected ErrPathNotAllowed, got %v", err)
+	}
+
+	session, err := uploader.StartSession("clip.mp4", 5)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	if _, _, err := uploader.WriteChunk(ctx, session.ID, 0, strings.NewReader("hello")); !errors.Is(err, ErrPathNotAllowed) {
+		t.Fatalf("Expected ErrPathNotAllowed, got %v", err)
+	}
+
+	for _, name := range []string{"input.mp4", "clip.mp4", filepath.Join(partialDir, session.ID)} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected unregistered upload %s to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestUploader_Chunks(t *testing.T) {
+	repo := storage.NewMockRepository()
+	manager := NewManager(repo)
+	ctx := context.Background()
+	dir := t.TempDir()
+	uploader := NewUploader(manager, dir, 100)
+
+	if _, err := uploader.StartSession("huge.mp4", 101); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("Expected ErrUploadTooLarge, got %v", err)
+	}
+
+	session, err := uploader.StartSession("clip.mp4", 10)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	session, file, err := uploader.WriteChunk(ctx, session.ID, 0, strings.NewReader("hello"))
+	if err != nil || file != nil || session.Offset != 5 {
+		t.Fatalf("WriteChunk() = %+v, %v, %v; want offset 5", session, file, err)
+	}
+
+	// Resending from a stale offset is rejected
+	if _, _, err := uploader.WriteChunk(ctx, session.ID, 0, strings.NewReader("hello")); !errors.Is(err, ErrUploadOffset) {
+		t.Fatalf("Expected ErrUploadOffset, got %v", err)
+	}
+
+	// Writing past the announced size leaves the upload where it was
+	if _, _, err := uploader.WriteChunk(ctx, session.ID, 5, strings.NewReader("world!")); !errors.Is(err, ErrUploadTooLarge) {
+		t.Fatalf("Expected ErrUploadTooLarge, got %v", err)
+	}
+	if current, _ := uploader.Session(session.ID); current.Offset != 5 {
+		t.Fatalf("Expected offset 5 after rejected chunk, got %d", current.Offset)
+	}
+
+	_, file, err = uploader.WriteChunk(ctx, session.ID, 5, strings.NewReader("world"))
+	if err != nil || file == nil {
+		t.Fatalf("WriteChunk() = %v, %v; want registered file", file, err)
+	}
+	content, err := os.ReadFile(file.FilePath)
+	if err != nil || string(content) != "helloworld" {
+		t.Errorf("Expected helloworld at %s, got %q (%v)", file.FilePath, content, err)
+	}
+
+	if _, err := uploader.Session(session.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected finished session to be gone, got %v", err)
+	}
+}
+
+func TestUploader_ChunksDontBlockOtherUploads(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	uploader := NewUploader(manager, t.TempDir(), 100)
+
+	slow, err := uploader.StartSession("slow.mp4", 10)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	fast, err := uploader.StartSession("fast.mp4", 10)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	// A client that stops sending halfway through a chunk
+	body, client := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := uploader.WriteChunk(ctx, slow.ID, 0, body)
+		done <- err
+	}()
+	if _, err := client.Write([]byte("slow")); err != nil {
+		t.Fatalf("Failed to write chunk: %v", err)
+	}
+
+	if _, err := uploader.Session(slow.ID); err != nil {
+		t.Errorf("Session() error = %v", err)
+	}
+	if _, file, err := uploader.WriteChunk(ctx, fast.ID, 0, strings.NewReader("0123456789")); err != nil || file == nil {
+		t.Errorf("WriteChunk() = %v, %v; want the other upload finished", file, err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Failed to close chunk: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteChunk() error = %v", err)
+	}
+	if current, _ := uploader.Session(slow.ID); current.Offset != 4 {
+		t.Errorf("Expected offset 4, got %d", current.Offset)
+	}
+}
+
+func TestUploader_ExpireSessions(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	dir := t.TempDir()
+	uploader := NewUploader(manager, dir, 100)
+	uploader.SetSessionTTL(time.Hour)
+
+	stale, err := uploader.StartSession("stale.mp4", 10)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+	active, err := uploader.StartSession("active.mp4", 10)
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	// Partial data of an upload from before a restart
+	orphan := filepath.Join(dir, partialDir, "orphan")
+	if err := os.WriteFile(orphan, []byte("data"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial data: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatalf("Failed to age partial data: %v", err)
+	}
+
+	// Nothing has been idle for an hour yet, apart from the orphan
+	if removed := uploader.ExpireSessions(time.Now()); removed != 1 {
+		t.Errorf("Expected the orphaned partial data to be removed, got %d", removed)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", orphan, err)
+	}
+
+	// Both sessions go idle for two hours, then a chunk revives one
+	uploader.mu.Lock()
+	uploader.sessions[stale.ID].lastActive = old
+	uploader.sessions[active.ID].lastActive = old
+	uploader.mu.Unlock()
+	if _, _, err := uploader.WriteChunk(ctx, active.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("WriteChunk() error = %v", err)
+	}
+
+	if removed := uploader.ExpireSessions(time.Now()); removed != 1 {
+		t.Errorf("Expected one expired upload, got %d", removed)
+	}
+	if _, err := uploader.Session(stale.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the stale upload to be gone, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, partialDir, stale.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale upload's data to be removed, got %v", err)
+	}
+	if _, err := uploader.Session(active.ID); err != nil {
+		t.Errorf("Expected the active upload to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// Notification describes a task that finished, failed or was canceled
+type Notification struct {
+	TaskID string       `json:"task_id"`
+	Tool   string       `json:"tool"`
+	Status types.Status `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Files  int          `json:"files"` // Files registered from the task's output
+}
+
+// Message formats the notification as a single human readable line
+func (n Notification) Message() string {
+	msg := fmt.Sprintf("%s task %s %s", n.Tool, n.TaskID, n.Status)
+	if n.Error != "" {
+		msg += ": " + n.Error
+	}
+	if n.Files > 0 {
+		msg += fmt.Sprintf(" (%d files)", n.Files)
+	}
+	return msg
+}
+
+// Notifier delivers notifications to an external service
+type Notifier interface {
+	// Name identifies the notifier in logs
+	Name() string
+
+	// Notify sends a notification. Errors are logged by the caller and
+	// never affect the task.
+	Notify(ctx context.Context, n Notification) error
+}
@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout bounds how long a webhook request may take
+const DefaultTimeout = 10 * time.Second
+
+// Webhook posts notifications as JSON to a URL. The body is either the
+// Notification itself or a chat service's message payload.
+type Webhook struct {
+	name   string
+	url    string
+	client *http.Client
+	body   func(n Notification) interface{}
+}
+
+// NewWebhook creates a notifier that posts the Notification as JSON
+func NewWebhook(url string) *Webhook {
+	return newWebhook("webhook", url, func(n Notification) interface{} {
+		return struct {
+			Notification
+			Message string `json:"message"`
+		}{n, n.Message()}
+	})
+}
+
+// NewDiscord creates a notifier for a Discord webhook URL
+func NewDiscord(url string) *Webhook {
+	return newWebhook("discord", url, func(n Notification) interface{} {
+		return map[string]string{"content": n.Message()}
+	})
+}
+
+// NewSlack creates a notifier for a Slack incoming webhook URL
+func NewSlack(url string) *Webhook {
+	return newWebhook("slack", url, func(n Notification) interface{} {
+		return map[string]string{"text": n.Message()}
+	})
+}
+
+// newWebhook creates a webhook notifier that posts what body returns
+func newWebhook(name, url string, body func(n Notification) interface{}) *Webhook {
+	return &Webhook{
+		name:   name,
+		url:    url,
+		client: &http.Client{Timeout: DefaultTimeout},
+		body:   body,
+	}
+}
+
+// Name identifies the notifier in logs
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+// Notify posts the notification and fails on any non-2xx response
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	payload, err := json.Marshal(w.body(n))
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Error closing %s response: %v", w.name, err)
+		}
+	}()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification rejected with status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestWebhookPayloads(t *testing.T) {
+	n := Notification{TaskID: "abc", Tool: "yt-dlp", Status: types.StatusComplete, Files: 3}
+	wantMessage := "yt-dlp task abc complete (3 files)"
+
+	tests := []struct {
+		notifier func(url string) *Webhook
+		field    string
+	}{
+		{NewWebhook, "message"},
+		{NewDiscord, "content"},
+		{NewSlack, "text"},
+	}
+
+	for _, tt := range tests {
+		var body map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected JSON content type, got %s", ct)
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("Failed to decode body: %v", err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		notifier := tt.notifier(server.URL)
+		if err := notifier.Notify(context.Background(), n); err != nil {
+			t.Errorf("%s: Notify() error = %v", notifier.Name(), err)
+		}
+		if body[tt.field] != wantMessage {
+			t.Errorf("%s: expected %s %q, got %v", notifier.Name(), tt.field, wantMessage, body)
+		}
+		server.Close()
+	}
+}
+
+func TestWebhookRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL).Notify(context.Background(), Notification{TaskID: "abc", Tool: "wget", Status: types.StatusFailed})
+	if err == nil {
+		t.Error("Expected error for a rejected notification")
+	}
+}
+
+func TestNotificationMessage(t *testing.T) {
+	n := Notification{TaskID: "abc", Tool: "wget", Status: types.StatusFailed, Error: "Command failed: exit status 8"}
+	if got, want := n.Message(), "wget task abc failed: Command failed: exit status 8"; got != want {
+		t.Errorf("Message() = %q, want %q", got, want)
+	}
+}
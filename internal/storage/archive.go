@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// compressLines encodes output lines as a gzip compressed JSON array
+func compressLines(lines []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(lines); err != nil {
+		return nil, fmt.Errorf("failed to compress output: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress output: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressLines decodes output lines encoded by compressLines
+func decompressLines(blob []byte) ([]string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress output: %w", err)
+	}
+	var lines []string
+	if err := json.NewDecoder(zr).Decode(&lines); err != nil {
+		return nil, fmt.Errorf("failed to decompress output: %w", err)
+	}
+	return lines, nil
+}
+
+// containsFold reports whether any line contains query, ignoring case like
+// SQLite's LIKE does
+func containsFold(lines []string, query string) bool {
+	query = strings.ToLower(query)
+	for _, line := range lines {
+		if strings.Contains(strings.ToLower(line), query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+//go:build !cgo
+
+package storage
+
+import "strings"
+
+// Without cgo go-sqlite3 has no error codes, so constraint violations are
+// recognized by SQLite's messages instead
+
+// isForeignKeyError reports whether err is a foreign key constraint violation
+func isForeignKeyError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "FOREIGN KEY constraint failed")
+}
+
+// isUniqueError reports whether err is a unique constraint violation
+func isUniqueError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
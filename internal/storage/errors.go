@@ -0,0 +1,18 @@
+package storage
+
+import "errors"
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInUse is returned when a record can't be deleted because other records
+// still reference it
+var ErrInUse = errors.New("still in use")
+
+// ErrAlreadyExists is returned when a record would duplicate a unique value
+// held by another record
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrInvalidSearch is returned for output searches whose query is empty,
+// malformed or too expensive to run
+var ErrInvalidSearch = errors.New("invalid search")
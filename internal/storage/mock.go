@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lepinkainen/commander/internal/types"
 )
@@ -15,6 +18,8 @@ type MockRepository struct {
 	directories map[string]*types.Directory
 	files       map[string]*types.File
 	fileTags    map[string][]string
+	workers     map[string]int
+	audit       []types.AuditEntry
 	mu          sync.RWMutex
 }
 
@@ -25,6 +30,7 @@ func NewMockRepository() *MockRepository {
 		directories: make(map[string]*types.Directory),
 		files:       make(map[string]*types.File),
 		fileTags:    make(map[string][]string),
+		workers:     make(map[string]int),
 	}
 }
 
@@ -41,6 +47,19 @@ func (m *MockRepository) Create(ctx context.Context, data types.TaskData) error
 	return nil
 }
 
+// Import adds a task exported from another instance together with its
+// output. It returns ErrAlreadyExists if a task with the same ID exists.
+func (m *MockRepository) Import(ctx context.Context, data types.TaskData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.tasks[data.ID]; exists {
+		return fmt.Errorf("task %s: %w", data.ID, ErrAlreadyExists)
+	}
+	m.tasks[data.ID] = data
+	return nil
+}
+
 // GetByID retrieves a task by its ID
 func (m *MockRepository) GetByID(ctx context.Context, id string) (types.TaskData, error) {
 	m.mu.RLock()
@@ -48,38 +67,161 @@ func (m *MockRepository) GetByID(ctx context.Context, id string) (types.TaskData
 
 	data, exists := m.tasks[id]
 	if !exists {
-		return types.TaskData{}, fmt.Errorf("task %s not found", id)
+		return types.TaskData{}, fmt.Errorf("task %s: %w", id, ErrNotFound)
 	}
 
 	return data, nil
 }
 
-// List retrieves all tasks
-func (m *MockRepository) List(ctx context.Context) ([]types.TaskData, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// ListTasks returns the tasks matching filters
+func (m *MockRepository) ListTasks(ctx context.Context, filters types.TaskFilters) ([]types.TaskData, error) {
+	return m.filterTasks(filters), nil
+}
 
-	var tasks []types.TaskData
+// Each calls fn with every task matching filters. The tasks are copied first
+// so fn runs without holding the lock.
+func (m *MockRepository) Each(ctx context.Context, filters types.TaskFilters, fn func(data types.TaskData) error) error {
+	for _, data := range m.filterTasks(filters) {
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterTasks returns the sorted page of tasks matching filters
+func (m *MockRepository) filterTasks(filters types.TaskFilters) []types.TaskData {
+	m.mu.RLock()
+	tasks := []types.TaskData{}
 	for _, data := range m.tasks {
+		if filters.Tool != "" && data.Tool != filters.Tool {
+			continue
+		}
+		if len(filters.Statuses) > 0 && !slices.Contains(filters.Statuses, data.Status) {
+			continue
+		}
+		if filters.CreatedFrom != nil && data.CreatedAt.Before(*filters.CreatedFrom) {
+			continue
+		}
+		if filters.CreatedTo != nil && !data.CreatedAt.Before(*filters.CreatedTo) {
+			continue
+		}
+		if host := strings.ToLower(strings.TrimSpace(filters.Host)); host != "" && data.SourceHost != host && !strings.HasSuffix(data.SourceHost, "."+host) {
+			continue
+		}
+		if filters.Query != "" && !matchesQuery(data, filters.Query, filters.QueryOutput) {
+			continue
+		}
+		if !filters.WithOutput {
+			data.Output = nil
+		}
 		tasks = append(tasks, data)
 	}
+	m.mu.RUnlock()
 
-	return tasks, nil
+	sort.Slice(tasks, func(i, j int) bool {
+		if filters.Sort == types.TaskSortOldest {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+	})
+	if filters.Offset >= len(tasks) {
+		return []types.TaskData{}
+	}
+	tasks = tasks[filters.Offset:]
+	if filters.Limit > 0 && len(tasks) > filters.Limit {
+		tasks = tasks[:filters.Limit]
+	}
+	return tasks
 }
 
-// ListByTool retrieves tasks for a specific tool
-func (m *MockRepository) ListByTool(ctx context.Context, tool string) ([]types.TaskData, error) {
+// matchesQuery reports whether query appears in a task's tool, command,
+// notes or arguments, or in its output when includeOutput is set
+func matchesQuery(data types.TaskData, query string, includeOutput bool) bool {
+	fields := append([]string{data.Tool, data.Command, data.Notes}, data.Args...)
+	if includeOutput {
+		fields = append(fields, data.Output...)
+	}
+	for _, field := range fields {
+		if containsIgnoreCase(field, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolFailures returns the failure rate of a tool's tasks that ended at or
+// after since and up to limit of its failed tasks, most recently ended first
+func (m *MockRepository) ToolFailures(ctx context.Context, tool string, since time.Time, limit int) (types.ToolFailures, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	var tasks []types.TaskData
+	result := types.ToolFailures{Tool: tool, Since: since, Failures: []types.TaskFailure{}}
+	var failed []types.TaskData
 	for _, data := range m.tasks {
-		if data.Tool == tool {
-			tasks = append(tasks, data)
+		if data.Tool != tool || (!since.IsZero() && (data.EndedAt.IsZero() || data.EndedAt.Before(since))) {
+			continue
+		}
+		switch data.Status {
+		case types.StatusComplete:
+			result.Finished++
+		case types.StatusFailed:
+			result.Finished++
+			result.Failed++
+			failed = append(failed, data)
+		}
+	}
+	finishToolFailures(&result)
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].EndedAt.After(failed[j].EndedAt)
+	})
+	for i, data := range failed {
+		if limit > 0 && i >= limit {
+			break
+		}
+		result.Failures = append(result.Failures, types.TaskFailure{
+			TaskID:    data.ID,
+			Error:     data.Error,
+			CreatedAt: data.CreatedAt,
+			EndedAt:   data.EndedAt,
+		})
+	}
+	return result, nil
+}
+
+// ToolStats aggregates the history of a tool's tasks created at or after since
+func (m *MockRepository) ToolStats(ctx context.Context, tool string, since time.Time) (types.ToolStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := types.ToolStats{Tool: tool, Since: since}
+	taskIDs := make(map[string]bool)
+	var durations, waits []float64
+
+	for _, data := range m.tasks {
+		if data.Tool != tool || data.CreatedAt.Before(since) {
+			continue
+		}
+		taskIDs[data.ID] = true
+		addStatusCount(&stats, data.Status, 1)
+		if data.Status == types.StatusComplete && !data.StartedAt.IsZero() && !data.EndedAt.IsZero() {
+			durations = append(durations, data.EndedAt.Sub(data.StartedAt).Seconds())
+		}
+		if !data.StartedAt.IsZero() {
+			waits = append(waits, float64(data.StartedAt.Sub(data.CreatedAt).Milliseconds()))
+		}
+	}
+	finishToolStats(&stats, durations, waits)
+
+	for _, file := range m.files {
+		if file.TaskID != nil && taskIDs[*file.TaskID] {
+			stats.FilesDiscovered++
+			stats.BytesDiscovered += file.FileSize
 		}
 	}
 
-	return tasks, nil
+	return stats, nil
 }
 
 // Update updates an existing task
@@ -88,7 +230,7 @@ func (m *MockRepository) Update(ctx context.Context, data types.TaskData) error
 	defer m.mu.Unlock()
 
 	if _, exists := m.tasks[data.ID]; !exists {
-		return fmt.Errorf("task %s not found", data.ID)
+		return fmt.Errorf("task %s: %w", data.ID, ErrNotFound)
 	}
 
 	m.tasks[data.ID] = data
@@ -102,7 +244,7 @@ func (m *MockRepository) AppendOutput(ctx context.Context, taskID string, output
 
 	data, exists := m.tasks[taskID]
 	if !exists {
-		return fmt.Errorf("task %s not found", taskID)
+		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
 	}
 
 	data.Output = append(data.Output, output)
@@ -110,6 +252,72 @@ func (m *MockRepository) AppendOutput(ctx context.Context, taskID string, output
 	return nil
 }
 
+// AppendOutputLines adds several lines of output to a task
+func (m *MockRepository) AppendOutputLines(ctx context.Context, taskID string, lines []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, exists := m.tasks[taskID]
+	if !exists {
+		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+
+	data.Output = append(data.Output, lines...)
+	m.tasks[taskID] = data
+	return nil
+}
+
+// GetOutput returns a range of a task's output lines, only those of stream
+// unless it is empty
+func (m *MockRepository) GetOutput(ctx context.Context, taskID string, stream types.Stream, offset, limit int) (types.OutputPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, exists := m.tasks[taskID]
+	if !exists {
+		return types.OutputPage{}, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+
+	return pageLines(taskID, stream, data.Output, offset, limit), nil
+}
+
+// SearchOutput returns the lines of a task's output matching search
+func (m *MockRepository) SearchOutput(ctx context.Context, taskID string, search types.OutputSearch) (types.OutputSearchResult, error) {
+	result := types.OutputSearchResult{TaskID: taskID, Matches: []types.OutputMatch{}}
+	matcher, err := newOutputMatcher(search)
+	if err != nil {
+		return result, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, exists := m.tasks[taskID]
+	if !exists {
+		return result, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	matcher.searchLines(&result, data.Output, 0, search.Limit)
+	return result, nil
+}
+
+// DeleteTask removes a task and unlinks the files it created
+func (m *MockRepository) DeleteTask(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.tasks[id]; !exists {
+		return fmt.Errorf("task %s: %w", id, ErrNotFound)
+	}
+	delete(m.tasks, id)
+
+	for _, file := range m.files {
+		if file.TaskID != nil && *file.TaskID == id {
+			file.TaskID = nil
+		}
+	}
+	return nil
+}
+
 // Close closes the storage connection
 func (m *MockRepository) Close() error {
 	return nil
@@ -125,6 +333,9 @@ func (m *MockRepository) CreateDirectory(ctx context.Context, dir *types.Directo
 	if _, exists := m.directories[dir.ID]; exists {
 		return fmt.Errorf("directory %s already exists", dir.ID)
 	}
+	if err := m.checkDirectoryPath(dir); err != nil {
+		return err
+	}
 
 	m.directories[dir.ID] = dir
 	return nil
@@ -137,45 +348,83 @@ func (m *MockRepository) GetDirectory(ctx context.Context, id string) (*types.Di
 
 	dir, exists := m.directories[id]
 	if !exists {
-		return nil, fmt.Errorf("directory %s not found", id)
+		return nil, fmt.Errorf("directory %s: %w", id, ErrNotFound)
 	}
 
 	return dir, nil
 }
 
-// ListDirectories retrieves all directories
-func (m *MockRepository) ListDirectories(ctx context.Context) ([]*types.Directory, error) {
+// ListDirectories retrieves the directories matching filters
+func (m *MockRepository) ListDirectories(ctx context.Context, filters types.DirectoryFilters) ([]*types.Directory, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var dirs []*types.Directory
 	for _, dir := range m.directories {
+		if filters.ToolName != "" && (dir.ToolName == nil || *dir.ToolName != filters.ToolName) {
+			continue
+		}
 		dirs = append(dirs, dir)
 	}
 
 	return dirs, nil
 }
 
+// DirectoryUsage counts and sums the files of each directory
+func (m *MockRepository) DirectoryUsage(ctx context.Context) (map[string]types.DirectoryUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usage := make(map[string]types.DirectoryUsage)
+	for _, file := range m.files {
+		u := usage[file.DirectoryID]
+		u.FileCount++
+		u.TotalSize += file.FileSize
+		usage[file.DirectoryID] = u
+	}
+
+	return usage, nil
+}
+
 // UpdateDirectory updates an existing directory
 func (m *MockRepository) UpdateDirectory(ctx context.Context, dir *types.Directory) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.directories[dir.ID]; !exists {
-		return fmt.Errorf("directory %s not found", dir.ID)
+		return fmt.Errorf("directory %s: %w", dir.ID, ErrNotFound)
+	}
+	if err := m.checkDirectoryPath(dir); err != nil {
+		return err
 	}
 
 	m.directories[dir.ID] = dir
 	return nil
 }
 
+// checkDirectoryPath mirrors the unique index on directory paths. Callers
+// must hold the lock.
+func (m *MockRepository) checkDirectoryPath(dir *types.Directory) error {
+	for id, other := range m.directories {
+		if id != dir.ID && other.Path == dir.Path {
+			return fmt.Errorf("directory for %s: %w", dir.Path, ErrAlreadyExists)
+		}
+	}
+	return nil
+}
+
 // DeleteDirectory removes a directory from storage
 func (m *MockRepository) DeleteDirectory(ctx context.Context, id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.directories[id]; !exists {
-		return fmt.Errorf("directory %s not found", id)
+		return fmt.Errorf("directory %s: %w", id, ErrNotFound)
+	}
+	for _, file := range m.files {
+		if file.DirectoryID == id {
+			return fmt.Errorf("directory %s has files: %w", id, ErrInUse)
+		}
 	}
 
 	delete(m.directories, id)
@@ -198,22 +447,52 @@ func (m *MockRepository) CreateFile(ctx context.Context, file *types.File) error
 	return nil
 }
 
+// CreateFiles adds files, skipping files whose path is already tracked, and
+// returns the files it added
+func (m *MockRepository) CreateFiles(ctx context.Context, files []*types.File) ([]*types.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tracked := make(map[string]bool, len(m.files))
+	for _, file := range m.files {
+		tracked[file.FilePath] = true
+	}
+
+	var created []*types.File
+	for _, file := range files {
+		if tracked[file.FilePath] {
+			continue
+		}
+		if _, exists := m.files[file.ID]; exists {
+			return nil, fmt.Errorf("file %s already exists", file.ID)
+		}
+		tracked[file.FilePath] = true
+		m.files[file.ID] = file
+		if len(file.Tags) > 0 {
+			m.fileTags[file.ID] = file.Tags
+		}
+		created = append(created, file)
+	}
+	return created, nil
+}
+
 // GetFile retrieves a file by its ID
 func (m *MockRepository) GetFile(ctx context.Context, id string) (*types.File, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	file, exists := m.files[id]
+	stored, exists := m.files[id]
 	if !exists {
-		return nil, fmt.Errorf("file %s not found", id)
+		return nil, fmt.Errorf("file %s: %w", id, ErrNotFound)
 	}
 
-	// Populate tags
+	// Populate tags on a copy, the stored file may be read concurrently
+	file := *stored
 	if tags, ok := m.fileTags[id]; ok {
-		file.Tags = tags
+		file.Tags = append([]string(nil), tags...)
 	}
 
-	return file, nil
+	return &file, nil
 }
 
 // ListFiles retrieves files based on filters
@@ -236,6 +515,23 @@ func (m *MockRepository) ListFiles(ctx context.Context, filters types.FileFilter
 		if filters.MaxSize > 0 && file.FileSize > filters.MaxSize {
 			continue
 		}
+		if filters.CreatedFrom != nil && file.CreatedAt.Before(*filters.CreatedFrom) {
+			continue
+		}
+		if filters.CreatedTo != nil && file.CreatedAt.After(*filters.CreatedTo) {
+			continue
+		}
+		if filters.Query != "" && !containsIgnoreCase(file.Filename, filters.Query) && !containsIgnoreCase(file.FilePath, filters.Query) {
+			continue
+		}
+		if !hasAllTags(m.fileTags[file.ID], filters.Tags) {
+			continue
+		}
+		if filters.Orphaned && file.TaskID != nil {
+			if _, exists := m.tasks[*file.TaskID]; exists {
+				continue
+			}
+		}
 
 		// Populate tags
 		if tags, ok := m.fileTags[file.ID]; ok {
@@ -250,26 +546,81 @@ func (m *MockRepository) ListFiles(ctx context.Context, filters types.FileFilter
 	return files, nil
 }
 
+// EachFile calls fn with every file matching filters. The files are
+// collected first so fn runs without holding the lock.
+func (m *MockRepository) EachFile(ctx context.Context, filters types.FileFilters, fn func(file *types.File) error) error {
+	files, err := m.ListFiles(ctx, filters)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UpdateFile updates an existing file
 func (m *MockRepository) UpdateFile(ctx context.Context, file *types.File) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.files[file.ID]; !exists {
-		return fmt.Errorf("file %s not found", file.ID)
+		return fmt.Errorf("file %s: %w", file.ID, ErrNotFound)
 	}
 
 	m.files[file.ID] = file
 	return nil
 }
 
+// MoveFile updates a file's location if move succeeds
+func (m *MockRepository) MoveFile(ctx context.Context, file *types.File, move func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.files[file.ID]; !exists {
+		return fmt.Errorf("file %s: %w", file.ID, ErrNotFound)
+	}
+
+	if err := move(); err != nil {
+		return err
+	}
+
+	m.files[file.ID] = file
+	return nil
+}
+
+// CopyFile creates a file with the tags of sourceID
+func (m *MockRepository) CopyFile(ctx context.Context, file *types.File, sourceID string, copy func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.files[file.ID]; exists {
+		return fmt.Errorf("file %s already exists", file.ID)
+	}
+	if _, exists := m.files[sourceID]; !exists {
+		return fmt.Errorf("file %s: %w", sourceID, ErrNotFound)
+	}
+
+	if err := copy(); err != nil {
+		return err
+	}
+
+	m.files[file.ID] = file
+	if tags := m.fileTags[sourceID]; len(tags) > 0 {
+		m.fileTags[file.ID] = append([]string(nil), tags...)
+	}
+	return nil
+}
+
 // DeleteFile removes a file from storage
 func (m *MockRepository) DeleteFile(ctx context.Context, id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.files[id]; !exists {
-		return fmt.Errorf("file %s not found", id)
+		return fmt.Errorf("file %s: %w", id, ErrNotFound)
 	}
 
 	delete(m.files, id)
@@ -283,7 +634,7 @@ func (m *MockRepository) AddFileTag(ctx context.Context, fileID, tag string) err
 	defer m.mu.Unlock()
 
 	if _, exists := m.files[fileID]; !exists {
-		return fmt.Errorf("file %s not found", fileID)
+		return fmt.Errorf("file %s: %w", fileID, ErrNotFound)
 	}
 
 	tags := m.fileTags[fileID]
@@ -303,7 +654,7 @@ func (m *MockRepository) RemoveFileTag(ctx context.Context, fileID, tag string)
 	defer m.mu.Unlock()
 
 	if _, exists := m.files[fileID]; !exists {
-		return fmt.Errorf("file %s not found", fileID)
+		return fmt.Errorf("file %s: %w", fileID, ErrNotFound)
 	}
 
 	tags := m.fileTags[fileID]
@@ -317,13 +668,26 @@ func (m *MockRepository) RemoveFileTag(ctx context.Context, fileID, tag string)
 	return nil // Tag not found, but not an error
 }
 
+// SetFileTags replaces all tags for a file
+func (m *MockRepository) SetFileTags(ctx context.Context, fileID string, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.files[fileID]; !exists {
+		return fmt.Errorf("file %s: %w", fileID, ErrNotFound)
+	}
+
+	m.fileTags[fileID] = append([]string(nil), tags...)
+	return nil
+}
+
 // GetFileTags retrieves all tags for a file
 func (m *MockRepository) GetFileTags(ctx context.Context, fileID string) ([]string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if _, exists := m.files[fileID]; !exists {
-		return nil, fmt.Errorf("file %s not found", fileID)
+		return nil, fmt.Errorf("file %s: %w", fileID, ErrNotFound)
 	}
 
 	tags := m.fileTags[fileID]
@@ -336,25 +700,12 @@ func (m *MockRepository) GetFileTags(ctx context.Context, fileID string) ([]stri
 
 // SearchFiles searches for files by filename
 func (m *MockRepository) SearchFiles(ctx context.Context, query string) ([]*types.File, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	var files []*types.File
-	for _, file := range m.files {
-		// Simple substring search
-		if containsIgnoreCase(file.Filename, query) || containsIgnoreCase(file.FilePath, query) {
-			// Populate tags
-			if tags, ok := m.fileTags[file.ID]; ok {
-				fileCopy := *file
-				fileCopy.Tags = tags
-				files = append(files, &fileCopy)
-			} else {
-				files = append(files, file)
-			}
-		}
-	}
+	return m.SearchFilesInDirectory(ctx, query, "")
+}
 
-	return files, nil
+// SearchFilesInDirectory searches for files by filename within a directory
+func (m *MockRepository) SearchFilesInDirectory(ctx context.Context, query, directoryID string) ([]*types.File, error) {
+	return m.ListFiles(ctx, types.FileFilters{Query: query, DirectoryID: directoryID})
 }
 
 func containsIgnoreCase(s, substr string) bool {
@@ -362,3 +713,75 @@ func containsIgnoreCase(s, substr string) bool {
 	substr = strings.ToLower(substr)
 	return strings.Contains(s, substr)
 }
+
+// hasAllTags reports whether tags contains every tag in required
+func hasAllTags(tags, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, tag := range tags {
+			if tag == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WorkerOverrides returns the worker counts changed at runtime by tool name
+func (m *MockRepository) WorkerOverrides(ctx context.Context) (map[string]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	overrides := make(map[string]int, len(m.workers))
+	for tool, workers := range m.workers {
+		overrides[tool] = workers
+	}
+	return overrides, nil
+}
+
+// SetWorkerOverride records the worker count of a tool
+func (m *MockRepository) SetWorkerOverride(ctx context.Context, tool string, workers int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[tool] = workers
+	return nil
+}
+
+// RecordAudit appends an entry to the audit log
+func (m *MockRepository) RecordAudit(ctx context.Context, entry *types.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, *entry)
+	return nil
+}
+
+// ListAudit returns a page of audit entries matching filters, newest first
+func (m *MockRepository) ListAudit(ctx context.Context, filters types.AuditFilters) (types.AuditPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	page := types.AuditPage{Entries: []types.AuditEntry{}, Offset: filters.Offset, Limit: filters.Limit}
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		entry := m.audit[i]
+		if filters.Actor != "" && entry.Actor != filters.Actor {
+			continue
+		}
+		if filters.Action != "" && entry.Action != filters.Action {
+			continue
+		}
+		if filters.Since != nil && entry.CreatedAt.Before(*filters.Since) {
+			continue
+		}
+		if page.Total >= filters.Offset && len(page.Entries) < filters.Limit {
+			page.Entries = append(page.Entries, entry)
+		}
+		page.Total++
+	}
+	return page, nil
+}
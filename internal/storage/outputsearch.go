@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// Bounds keeping output searches cheap. Go regular expressions run in time
+// linear in the input, so only the size of the compiled pattern needs a cap.
+const (
+	maxSearchQueryLength  = 256
+	maxSearchInstructions = 2000
+
+	// Lines longer than this are cut down to the text around the match
+	maxSnippetLength = 200
+	snippetContext   = 80
+)
+
+// outputMatcher finds a search's matches in output lines
+type outputMatcher struct {
+	re *regexp.Regexp
+
+	// like is a LIKE pattern or, for case-sensitive searches, an instr()
+	// argument that every matching line satisfies. It is empty when the
+	// query can't be narrowed down in SQL, such as a regular expression.
+	like          string
+	caseSensitive bool
+}
+
+// newOutputMatcher validates and compiles a search. Plain text queries are
+// matched as escaped regular expressions, so both kinds share the code
+// that locates matches for snippets.
+func newOutputMatcher(search types.OutputSearch) (*outputMatcher, error) {
+	if search.Query == "" {
+		return nil, fmt.Errorf("%w: query is empty", ErrInvalidSearch)
+	}
+	if len(search.Query) > maxSearchQueryLength {
+		return nil, fmt.Errorf("%w: query is longer than %d bytes", ErrInvalidSearch, maxSearchQueryLength)
+	}
+
+	pattern := search.Query
+	if !search.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !search.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	if len(prog.Inst) > maxSearchInstructions {
+		return nil, fmt.Errorf("%w: pattern is too complex", ErrInvalidSearch)
+	}
+
+	m := &outputMatcher{re: regexp.MustCompile(pattern), caseSensitive: search.CaseSensitive}
+	// SQLite's LIKE only folds ASCII letters, so other queries are matched
+	// in Go alone
+	if !search.Regex && isASCII(search.Query) {
+		m.like = search.Query
+		if !search.CaseSensitive {
+			m.like = "%" + escapeLike(search.Query) + "%"
+		}
+	}
+	return m, nil
+}
+
+// sqlFilter returns the condition on the output column narrowing down the
+// lines to check and its argument, or "" if every line has to be checked
+func (m *outputMatcher) sqlFilter() (string, interface{}) {
+	switch {
+	case m.like == "":
+		return "", nil
+	case m.caseSensitive:
+		return "instr(output, ?) > 0", m.like
+	default:
+		return `output LIKE ? ESCAPE '\'`, m.like
+	}
+}
+
+// match returns the match of line numbered index, if it matches
+func (m *outputMatcher) match(index int, line string) (types.OutputMatch, bool) {
+	loc := m.re.FindStringIndex(line)
+	if loc == nil {
+		return types.OutputMatch{}, false
+	}
+	return types.OutputMatch{
+		Line:    index,
+		Stream:  types.OutputStream(line),
+		Snippet: snippet(line, loc[0], loc[1]),
+	}, true
+}
+
+// searchLines collects the matches in lines, whose first line has index
+// first, into result. It reports whether the limit was reached.
+func (m *outputMatcher) searchLines(result *types.OutputSearchResult, lines []string, first, limit int) bool {
+	for i, line := range lines {
+		match, ok := m.match(first+i, line)
+		if !ok {
+			continue
+		}
+		if len(result.Matches) == limit {
+			result.Truncated = true
+			return true
+		}
+		result.Matches = append(result.Matches, match)
+	}
+	return false
+}
+
+// snippet returns line, or for long lines the text around the match from
+// start to end, marking cut ends with "…"
+func snippet(line string, start, end int) string {
+	if len(line) <= maxSnippetLength {
+		return line
+	}
+
+	from := max(0, start-snippetContext)
+	to := min(len(line), max(end, start+1)+snippetContext)
+	// Don't cut a multi-byte character in half
+	for from > 0 && !utf8.RuneStart(line[from]) {
+		from--
+	}
+	for to < len(line) && !utf8.RuneStart(line[to]) {
+		to++
+	}
+
+	s := line[from:to]
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(line) {
+		s += "…"
+	}
+	return s
+}
+
+// escapeLike escapes the LIKE wildcards in s, using \ as escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// isASCII reports whether s consists of ASCII characters only
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
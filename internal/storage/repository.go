@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/lepinkainen/commander/internal/types"
 )
@@ -11,46 +12,142 @@ type TaskRepository interface {
 	// Create adds a new task to storage
 	Create(ctx context.Context, data types.TaskData) error
 
+	// Import adds a task exported from another instance together with its
+	// output, atomically. It returns ErrAlreadyExists if a task with the
+	// same ID exists.
+	Import(ctx context.Context, data types.TaskData) error
+
 	// GetByID retrieves a task by its ID
 	GetByID(ctx context.Context, id string) (types.TaskData, error)
 
-	// List retrieves all tasks
-	List(ctx context.Context) ([]types.TaskData, error)
+	// ListTasks returns the tasks matching filters, in the order and page
+	// they ask for
+	ListTasks(ctx context.Context, filters types.TaskFilters) ([]types.TaskData, error)
+
+	// Each calls fn with every task ListTasks would return for filters.
+	// Tasks are passed on as they are read instead of being collected in
+	// memory. An error from fn stops the iteration and is returned.
+	Each(ctx context.Context, filters types.TaskFilters, fn func(data types.TaskData) error) error
+
+	// ToolStats aggregates the history of a tool's tasks created at or after
+	// since. A zero since covers all tasks.
+	ToolStats(ctx context.Context, tool string, since time.Time) (types.ToolStats, error)
 
-	// ListByTool retrieves tasks for a specific tool
-	ListByTool(ctx context.Context, tool string) ([]types.TaskData, error)
+	// ToolFailures returns the failure rate of a tool's tasks that ended at
+	// or after since and up to limit of its failed tasks, most recently
+	// ended first. A zero since covers all tasks.
+	ToolFailures(ctx context.Context, tool string, since time.Time, limit int) (types.ToolFailures, error)
 
 	// Update updates an existing task
 	Update(ctx context.Context, data types.TaskData) error
 
-	// AppendOutput adds output to a task
+	// AppendOutput adds output to a task. It returns ErrNotFound if the
+	// task no longer exists.
 	AppendOutput(ctx context.Context, taskID string, output string) error
 
+	// AppendOutputLines adds several lines of output to a task in one
+	// write. It returns ErrNotFound if the task doesn't exist.
+	AppendOutputLines(ctx context.Context, taskID string, lines []string) error
+
+	// GetOutput returns up to limit output lines of a task starting at
+	// offset, in the order they were appended. A negative offset counts back
+	// from the last line.
+	GetOutput(ctx context.Context, taskID string, stream types.Stream, offset, limit int) (types.OutputPage, error)
+
+	// SearchOutput returns up to search.Limit lines of a task's output
+	// matching search, in output order
+	SearchOutput(ctx context.Context, taskID string, search types.OutputSearch) (types.OutputSearchResult, error)
+
+	// DeleteTask removes a task and its output, keeping its files
+	DeleteTask(ctx context.Context, id string) error
+
 	// Close closes the storage connection
 	Close() error
 }
 
+// OutputCompressor compacts the output of finished tasks to save space
+type OutputCompressor interface {
+	// CompressOutput stores a task's output lines compressed. Reading the
+	// output is unaffected; lines appended later are stored uncompressed
+	// and read after the compressed ones.
+	CompressOutput(ctx context.Context, taskID string) error
+}
+
+// WorkerStore keeps worker counts changed at runtime, so they survive a
+// restart
+type WorkerStore interface {
+	// WorkerOverrides returns the worker count of every tool that was
+	// changed at runtime
+	WorkerOverrides(ctx context.Context) (map[string]int, error)
+
+	// SetWorkerOverride records the worker count of a tool
+	SetWorkerOverride(ctx context.Context, tool string, workers int) error
+}
+
+// AuditLog records mutating API requests for accountability
+type AuditLog interface {
+	// RecordAudit appends an entry, setting its ID
+	RecordAudit(ctx context.Context, entry *types.AuditEntry) error
+
+	// ListAudit returns a page of entries matching filters, newest first
+	ListAudit(ctx context.Context, filters types.AuditFilters) (types.AuditPage, error)
+}
+
+// Maintainer defines database maintenance operations
+type Maintainer interface {
+	// Backup writes a consistent copy of the database to destPath
+	Backup(ctx context.Context, destPath string) error
+
+	// Vacuum reclaims unused space in the database
+	Vacuum(ctx context.Context) error
+}
+
 // FileRepository defines the interface for file and directory management
 type FileRepository interface {
 	// Directory operations
 	CreateDirectory(ctx context.Context, dir *types.Directory) error
 	GetDirectory(ctx context.Context, id string) (*types.Directory, error)
-	ListDirectories(ctx context.Context) ([]*types.Directory, error)
+	ListDirectories(ctx context.Context, filters types.DirectoryFilters) ([]*types.Directory, error)
+
+	// DirectoryUsage returns the tracked files' usage of each directory,
+	// keyed by directory ID. Directories without files are left out.
+	DirectoryUsage(ctx context.Context) (map[string]types.DirectoryUsage, error)
 	UpdateDirectory(ctx context.Context, dir *types.Directory) error
 	DeleteDirectory(ctx context.Context, id string) error
 
 	// File operations
 	CreateFile(ctx context.Context, file *types.File) error
+	// CreateFiles adds files in one transaction, skipping files whose path
+	// is already tracked, and returns the files it added
+	CreateFiles(ctx context.Context, files []*types.File) ([]*types.File, error)
 	GetFile(ctx context.Context, id string) (*types.File, error)
 	ListFiles(ctx context.Context, filters types.FileFilters) ([]*types.File, error)
+
+	// EachFile calls fn with every file ListFiles would return for filters,
+	// passing them on as they are read. An error from fn stops the iteration
+	// and is returned.
+	EachFile(ctx context.Context, filters types.FileFilters, fn func(file *types.File) error) error
 	UpdateFile(ctx context.Context, file *types.File) error
 	DeleteFile(ctx context.Context, id string) error
 
+	// MoveFile updates a file's directory and path, calling move to relocate
+	// it on disk. The change is only persisted if move succeeds.
+	MoveFile(ctx context.Context, file *types.File, move func() error) error
+
+	// CopyFile creates file with the tags sourceID has, calling copy to
+	// create it on disk. The record and tags are only persisted if copy
+	// succeeds.
+	CopyFile(ctx context.Context, file *types.File, sourceID string, copy func() error) error
+
 	// File tag operations
 	AddFileTag(ctx context.Context, fileID, tag string) error
 	RemoveFileTag(ctx context.Context, fileID, tag string) error
 	GetFileTags(ctx context.Context, fileID string) ([]string, error)
 
+	// SetFileTags replaces a file's tags with exactly the given set
+	SetFileTags(ctx context.Context, fileID string, tags []string) error
+
 	// Search operations
 	SearchFiles(ctx context.Context, query string) ([]*types.File, error)
+	SearchFilesInDirectory(ctx context.Context, query, directoryID string) ([]*types.File, error)
 }
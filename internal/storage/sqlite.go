@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
@@ -18,15 +21,26 @@ type SQLiteRepository struct {
 	db *sql.DB
 }
 
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // NewSQLiteRepository creates a new SQLite repository
 func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", buildDSN(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	repo := &SQLiteRepository{db: db}
 
+	if err := repo.checkForeignKeys(); err != nil {
+		return nil, err
+	}
+
 	if err := repo.createTables(); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
@@ -34,6 +48,37 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 	return repo, nil
 }
 
+// busyTimeoutMs is how long a connection waits for a lock held by another
+// connection (e.g. during backup or vacuum) before failing with SQLITE_BUSY
+const busyTimeoutMs = 5000
+
+// buildDSN appends the connection options every connection needs to dbPath.
+// Foreign keys are only enforced when enabled on each connection.
+func buildDSN(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d&_foreign_keys=1", dbPath, separator, busyTimeoutMs)
+}
+
+// checkForeignKeys makes sure the DSN option took effect, as SQLite ignores
+// the declared foreign keys otherwise
+func (r *SQLiteRepository) checkForeignKeys() error {
+	if _, err := r.db.Exec("PRAGMA foreign_keys=ON"); err != nil {
+		return fmt.Errorf("failed to enable foreign keys: %w", err)
+	}
+
+	var enabled bool
+	if err := r.db.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	if !enabled {
+		return errors.New("foreign keys are not supported by this SQLite build")
+	}
+	return nil
+}
+
 // createTables creates the necessary database tables
 func (r *SQLiteRepository) createTables() error {
 	schema := `
@@ -46,25 +91,63 @@ func (r *SQLiteRepository) createTables() error {
 		error TEXT,
 		created_at DATETIME NOT NULL,
 		started_at DATETIME,
-		ended_at DATETIME
+		ended_at DATETIME,
+		cpu_user_ms INTEGER,
+		cpu_system_ms INTEGER,
+		max_rss_bytes INTEGER,
+		organize INTEGER NOT NULL DEFAULT 0,
+		tags TEXT, -- JSON array, NULL when the task has no tags
+		expected_output TEXT, -- JSON array, NULL when outputs are discovered
+		timeout_seconds INTEGER NOT NULL DEFAULT 0,
+		options TEXT, -- JSON object, NULL when the task has no structured options
+		positional_args TEXT, -- JSON array, set together with options
+		notes TEXT NOT NULL DEFAULT '',
+		cancel_reason TEXT NOT NULL DEFAULT '',
+		source_host TEXT NOT NULL DEFAULT '', -- host of the first URL in args
+		argv TEXT, -- JSON array, NULL until the task runs
+		rerun_of TEXT NOT NULL DEFAULT '' -- ID of the task this one reruns
 	);
 
 	CREATE TABLE IF NOT EXISTS task_outputs (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		task_id TEXT NOT NULL,
 		output TEXT NOT NULL,
+		stream TEXT NOT NULL DEFAULT 'stdout', -- 'stdout' or 'stderr'
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (task_id) REFERENCES tasks (id)
 	);
 
+	-- Output of finished tasks compacted into one record, see CompressOutput
+	CREATE TABLE IF NOT EXISTS task_output_archives (
+		task_id TEXT PRIMARY KEY,
+		output BLOB NOT NULL, -- gzip compressed JSON array of lines
+		FOREIGN KEY (task_id) REFERENCES tasks (id)
+	);
+
+	-- Worker counts changed at runtime, overriding the tools config
+	CREATE TABLE IF NOT EXISTS tool_workers (
+		tool TEXT PRIMARY KEY,
+		workers INTEGER NOT NULL
+	);
+
+	-- Mutating API requests, newest last
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		actor TEXT NOT NULL,
+		remote_addr TEXT NOT NULL,
+		action TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		status INTEGER NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS download_directories (
 		id TEXT PRIMARY KEY,
 		name TEXT NOT NULL,
 		path TEXT NOT NULL,
 		tool_name TEXT,
 		default_dir BOOLEAN DEFAULT false,
-		created_at DATETIME NOT NULL,
-		FOREIGN KEY (tool_name) REFERENCES tools(name)
+		created_at DATETIME NOT NULL
 	);
 
 	CREATE TABLE IF NOT EXISTS files (
@@ -91,28 +174,449 @@ func (r *SQLiteRepository) createTables() error {
 
 	CREATE INDEX IF NOT EXISTS idx_tasks_tool ON tasks(tool);
 	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+	CREATE INDEX IF NOT EXISTS idx_tasks_tool_status_ended ON tasks(tool, status, ended_at);
 	CREATE INDEX IF NOT EXISTS idx_task_outputs_task_id ON task_outputs(task_id);
 	CREATE INDEX IF NOT EXISTS idx_files_directory_id ON files(directory_id);
 	CREATE INDEX IF NOT EXISTS idx_files_task_id ON files(task_id);
 	CREATE INDEX IF NOT EXISTS idx_files_created_at ON files(created_at);
 	CREATE UNIQUE INDEX IF NOT EXISTS idx_files_path ON files(file_path);
 	CREATE INDEX IF NOT EXISTS idx_file_tags_file_id ON file_tags(file_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
 	`
 
-	_, err := r.db.Exec(schema)
-	return err
+	if _, err := r.db.Exec(schema); err != nil {
+		return err
+	}
+
+	return r.migrate()
+}
+
+// migrate brings databases created by older versions up to the current schema
+func (r *SQLiteRepository) migrate() error {
+	// backfill runs once when the column is added, to fill it in for
+	// existing rows
+	columns := []struct {
+		table, column, definition, backfill string
+	}{
+		{"tasks", "cpu_user_ms", "INTEGER", ""},
+		{"tasks", "cpu_system_ms", "INTEGER", ""},
+		{"tasks", "max_rss_bytes", "INTEGER", ""},
+		{"tasks", "organize", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"tasks", "tags", "TEXT", ""},
+		{"tasks", "expected_output", "TEXT", ""},
+		{"tasks", "timeout_seconds", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"tasks", "options", "TEXT", ""},
+		{"tasks", "positional_args", "TEXT", ""},
+		{"tasks", "notes", "TEXT NOT NULL DEFAULT ''", ""},
+		{"tasks", "cancel_reason", "TEXT NOT NULL DEFAULT ''", ""},
+		{"tasks", "argv", "TEXT", ""},
+		{"tasks", "rerun_of", "TEXT NOT NULL DEFAULT ''", ""},
+		// stderr lines were only told apart by their prefix before
+		{"task_outputs", "stream", "TEXT NOT NULL DEFAULT 'stdout'",
+			fmt.Sprintf(`UPDATE task_outputs SET stream = 'stderr' WHERE substr(output, 1, %d) = '%s'`, len(types.StderrPrefix), types.StderrPrefix)},
+	}
+
+	for _, c := range columns {
+		added, err := r.addColumnIfMissing(c.table, c.column, c.definition)
+		if err != nil {
+			return err
+		}
+		if added && c.backfill != "" {
+			if _, err := r.db.Exec(c.backfill); err != nil {
+				return fmt.Errorf("failed to fill in %s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
+	if err := r.addSourceHosts(); err != nil {
+		return err
+	}
+	if err := r.dropToolsForeignKey(); err != nil {
+		return err
+	}
+	return r.uniqueDirectoryPaths()
+}
+
+// addSourceHosts adds the source_host column and fills it in for existing
+// tasks. The host is parsed from the arguments in Go, SQL can't do it.
+func (r *SQLiteRepository) addSourceHosts() error {
+	added, err := r.addColumnIfMissing("tasks", "source_host", "TEXT NOT NULL DEFAULT ''")
+	if err != nil || !added {
+		return err
+	}
+
+	return r.WithTx(context.Background(), func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id, args FROM tasks`)
+		if err != nil {
+			return fmt.Errorf("failed to list tasks: %w", err)
+		}
+		hosts := make(map[string]string) // task ID -> host
+		for rows.Next() {
+			var id, argsJSON string
+			if err := rows.Scan(&id, &argsJSON); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to scan task: %w", err)
+			}
+			var args []string
+			if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
+				continue // Left without a host, like tasks without a URL
+			}
+			if host := types.ExtractHost(args); host != "" {
+				hosts[id] = host
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("failed to list tasks: %w", err)
+		}
+
+		for id, host := range hosts {
+			if _, err := tx.Exec(`UPDATE tasks SET source_host = ? WHERE id = ?`, host, id); err != nil {
+				return fmt.Errorf("failed to fill in tasks.source_host: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// uniqueDirectoryPaths adds the unique index on directory paths. Older
+// versions stored paths as given, so first every path is made absolute and
+// clean, and directories that turn out to share a path are merged into the
+// oldest one.
+func (r *SQLiteRepository) uniqueDirectoryPaths() error {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_download_directories_path'`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect download_directories indexes: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return r.WithTx(context.Background(), func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id, path FROM download_directories ORDER BY created_at, id`)
+		if err != nil {
+			return fmt.Errorf("failed to list directories: %w", err)
+		}
+		type directory struct{ id, path string }
+		var dirs []directory
+		for rows.Next() {
+			var dir directory
+			if err := rows.Scan(&dir.id, &dir.path); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("failed to scan directory: %w", err)
+			}
+			dirs = append(dirs, dir)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("failed to list directories: %w", err)
+		}
+
+		kept := make(map[string]string) // normalized path -> directory ID
+		for _, dir := range dirs {
+			path, err := filepath.Abs(dir.path)
+			if err != nil {
+				return fmt.Errorf("failed to resolve directory path %s: %w", dir.path, err)
+			}
+
+			keepID, duplicate := kept[path]
+			if !duplicate {
+				kept[path] = dir.id
+				if path != dir.path {
+					if _, err := tx.Exec(`UPDATE download_directories SET path = ? WHERE id = ?`, path, dir.id); err != nil {
+						return fmt.Errorf("failed to normalize directory path: %w", err)
+					}
+				}
+				continue
+			}
+
+			log.Printf("Merging directory %s into %s, both point at %s", dir.id, keepID, path)
+			if _, err := tx.Exec(`UPDATE files SET directory_id = ? WHERE directory_id = ?`, keepID, dir.id); err != nil {
+				return fmt.Errorf("failed to move files to directory %s: %w", keepID, err)
+			}
+			if _, err := tx.Exec(`DELETE FROM download_directories WHERE id = ?`, dir.id); err != nil {
+				return fmt.Errorf("failed to delete duplicate directory: %w", err)
+			}
+		}
+
+		if _, err := tx.Exec(`CREATE UNIQUE INDEX idx_download_directories_path ON download_directories(path)`); err != nil {
+			return fmt.Errorf("failed to create directory path index: %w", err)
+		}
+		return nil
+	})
+}
+
+// dropToolsForeignKey rebuilds download_directories in databases created
+// before its foreign key on the never-created tools table was removed.
+// With foreign keys enforced that key would reject every directory with a
+// tool name.
+func (r *SQLiteRepository) dropToolsForeignKey() error {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_list('download_directories') WHERE "table" = 'tools'`).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect download_directories: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	// Dropping the old table must not trip the files foreign key, and the
+	// pragma only takes effect outside a transaction on this connection
+	ctx := context.Background()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		return fmt.Errorf("failed to disable foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=ON"); err != nil {
+			log.Printf("Failed to re-enable foreign keys: %v", err)
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	statements := []string{
+		`CREATE TABLE download_directories_new (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			path TEXT NOT NULL,
+			tool_name TEXT,
+			default_dir BOOLEAN DEFAULT false,
+			created_at DATETIME NOT NULL
+		)`,
+		`INSERT INTO download_directories_new (id, name, path, tool_name, default_dir, created_at)
+			SELECT id, name, path, tool_name, default_dir, created_at FROM download_directories`,
+		`DROP TABLE download_directories`,
+		`ALTER TABLE download_directories_new RENAME TO download_directories`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to rebuild download_directories: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists. It
+// reports whether the column was added.
+func (r *SQLiteRepository) addColumnIfMissing(table, column, definition string) (bool, error) {
+	rows, err := r.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("failed to scan %s columns: %w", table, err)
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+
+	if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return false, fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+// taskColumns lists the tasks columns read by scanTask, in order
+const taskColumns = `id, tool, command, args, status, error, created_at, started_at, ended_at,
+		cpu_user_ms, cpu_system_ms, max_rss_bytes, organize, tags, expected_output, timeout_seconds,
+		options, positional_args, notes, argv, cancel_reason, source_host, rerun_of`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask reads a task selected with taskColumns. Output is not loaded.
+func scanTask(row rowScanner) (types.TaskData, error) {
+	var data types.TaskData
+	var argsJSON string
+	var startedAt, endedAt sql.NullTime
+	var cpuUser, cpuSystem, maxRSS sql.NullInt64
+	var tagsJSON, expectedJSON, optionsJSON, positionalJSON, argvJSON sql.NullString
+
+	err := row.Scan(&data.ID, &data.Tool, &data.Command, &argsJSON, &data.Status,
+		&data.Error, &data.CreatedAt, &startedAt, &endedAt,
+		&cpuUser, &cpuSystem, &maxRSS, &data.Organize, &tagsJSON, &expectedJSON, &data.TimeoutSeconds,
+		&optionsJSON, &positionalJSON, &data.Notes, &argvJSON, &data.CancelReason, &data.SourceHost, &data.RerunOf)
+	if err != nil {
+		return types.TaskData{}, err
+	}
+
+	if err := json.Unmarshal([]byte(argsJSON), &data.Args); err != nil {
+		return types.TaskData{}, fmt.Errorf("failed to unmarshal args: %w", err)
+	}
+	if tagsJSON.Valid {
+		if err := json.Unmarshal([]byte(tagsJSON.String), &data.Tags); err != nil {
+			return types.TaskData{}, fmt.Errorf("failed to unmarshal tags: %w", err)
+		}
+	}
+	if expectedJSON.Valid {
+		if err := json.Unmarshal([]byte(expectedJSON.String), &data.ExpectedOutput); err != nil {
+			return types.TaskData{}, fmt.Errorf("failed to unmarshal expected output: %w", err)
+		}
+	}
+	if optionsJSON.Valid {
+		if err := json.Unmarshal([]byte(optionsJSON.String), &data.Options); err != nil {
+			return types.TaskData{}, fmt.Errorf("failed to unmarshal options: %w", err)
+		}
+	}
+	if positionalJSON.Valid {
+		if err := json.Unmarshal([]byte(positionalJSON.String), &data.PositionalArgs); err != nil {
+			return types.TaskData{}, fmt.Errorf("failed to unmarshal positional args: %w", err)
+		}
+	}
+	if argvJSON.Valid {
+		if err := json.Unmarshal([]byte(argvJSON.String), &data.Argv); err != nil {
+			return types.TaskData{}, fmt.Errorf("failed to unmarshal argv: %w", err)
+		}
+	}
+
+	if startedAt.Valid {
+		data.StartedAt = startedAt.Time
+	}
+	if endedAt.Valid {
+		data.EndedAt = endedAt.Time
+	}
+	data.CPUUserMs = nullInt64Ptr(cpuUser)
+	data.CPUSystemMs = nullInt64Ptr(cpuSystem)
+	data.MaxRSSBytes = nullInt64Ptr(maxRSS)
+
+	return data, nil
+}
+
+// listValue encodes a list for a nullable JSON array column, NULL when the
+// list is empty
+func listValue(name string, values []string) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return string(valuesJSON), nil
+}
+
+// mapValue encodes a map as a JSON column value, NULL when empty
+func mapValue(name string, values map[string]string) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return string(valuesJSON), nil
+}
+
+// nullInt64Ptr converts a nullable integer column to a pointer
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
 }
 
 // Create adds a new task to storage
 func (r *SQLiteRepository) Create(ctx context.Context, data types.TaskData) error {
+	if err := insertTask(ctx, r.db, data); err != nil {
+		return err
+	}
+
+	// Insert existing output if any
+	for _, output := range data.Output {
+		if err := r.AppendOutput(ctx, data.ID, output); err != nil {
+			return fmt.Errorf("failed to insert existing output: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Import adds a task exported from another instance together with its
+// output in one transaction, so a failed import leaves nothing behind. It
+// returns ErrAlreadyExists if a task with the same ID exists.
+func (r *SQLiteRepository) Import(ctx context.Context, data types.TaskData) error {
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = ?)`, data.ID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get task: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("task %s: %w", data.ID, ErrAlreadyExists)
+		}
+
+		if err := insertTask(ctx, tx, data); err != nil {
+			return err
+		}
+		for _, output := range data.Output {
+			// Skip empty output like AppendOutput does
+			if strings.TrimSpace(output) == "" {
+				continue
+			}
+			query := `INSERT INTO task_outputs (task_id, output, stream) VALUES (?, ?, ?)`
+			if _, err := tx.ExecContext(ctx, query, data.ID, output, types.OutputStream(output)); err != nil {
+				return fmt.Errorf("failed to insert output: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// insertTask inserts a task's row, without its output
+func insertTask(ctx context.Context, db execer, data types.TaskData) error {
 	argsJSON, err := json.Marshal(data.Args)
 	if err != nil {
 		return fmt.Errorf("failed to marshal args: %w", err)
 	}
+	tags, err := listValue("tags", data.Tags)
+	if err != nil {
+		return err
+	}
+	expectedOutput, err := listValue("expected output", data.ExpectedOutput)
+	if err != nil {
+		return err
+	}
+	options, err := mapValue("options", data.Options)
+	if err != nil {
+		return err
+	}
+	positionalArgs, err := listValue("positional args", data.PositionalArgs)
+	if err != nil {
+		return err
+	}
+	argv, err := listValue("argv", data.Argv)
+	if err != nil {
+		return err
+	}
 
 	query := `
-		INSERT INTO tasks (id, tool, command, args, status, error, created_at, started_at, ended_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tasks (id, tool, command, args, status, error, created_at, started_at, ended_at,
+		                   cpu_user_ms, cpu_system_ms, max_rss_bytes, organize, tags, expected_output, timeout_seconds,
+		                   options, positional_args, notes, argv, cancel_reason, source_host, rerun_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	var startedAt, endedAt interface{}
@@ -123,67 +627,214 @@ func (r *SQLiteRepository) Create(ctx context.Context, data types.TaskData) erro
 		endedAt = data.EndedAt
 	}
 
-	_, err = r.db.ExecContext(ctx, query,
+	_, err = db.ExecContext(ctx, query,
 		data.ID, data.Tool, data.Command, string(argsJSON), string(data.Status),
-		data.Error, data.CreatedAt, startedAt, endedAt)
+		data.Error, data.CreatedAt, startedAt, endedAt,
+		data.CPUUserMs, data.CPUSystemMs, data.MaxRSSBytes, data.Organize, tags, expectedOutput, data.TimeoutSeconds,
+		options, positionalArgs, data.Notes, argv, data.CancelReason, data.SourceHost, data.RerunOf)
 
 	if err != nil {
 		return fmt.Errorf("failed to create task: %w", err)
 	}
-
-	// Insert existing output if any
-	for _, output := range data.Output {
-		if err := r.AppendOutput(ctx, data.ID, output); err != nil {
-			return fmt.Errorf("failed to insert existing output: %w", err)
-		}
-	}
-
 	return nil
 }
 
 // GetByID retrieves a task by its ID
 func (r *SQLiteRepository) GetByID(ctx context.Context, id string) (types.TaskData, error) {
 	query := `
-		SELECT id, tool, command, args, status, error, created_at, started_at, ended_at
+		SELECT ` + taskColumns + `
 		FROM tasks WHERE id = ?
 	`
 
-	row := r.db.QueryRowContext(ctx, query, id)
+	data, err := scanTask(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.TaskData{}, fmt.Errorf("task %s: %w", id, ErrNotFound)
+		}
+		return types.TaskData{}, fmt.Errorf("failed to get task: %w", err)
+	}
 
-	var data types.TaskData
-	var argsJSON string
-	var startedAt, endedAt sql.NullTime
+	data.Output, err = r.taskOutput(ctx, id)
+	if err != nil {
+		return types.TaskData{}, err
+	}
 
-	err := row.Scan(&data.ID, &data.Tool, &data.Command, &argsJSON, &data.Status,
-		&data.Error, &data.CreatedAt, &startedAt, &endedAt)
+	return data, nil
+}
+
+// ListTasks returns the tasks matching filters
+func (r *SQLiteRepository) ListTasks(ctx context.Context, filters types.TaskFilters) ([]types.TaskData, error) {
+	tasks := []types.TaskData{}
+	err := r.Each(ctx, filters, func(data types.TaskData) error {
+		tasks = append(tasks, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Each calls fn with every task matching filters, reading them from the
+// database one at a time
+func (r *SQLiteRepository) Each(ctx context.Context, filters types.TaskFilters, fn func(data types.TaskData) error) error {
+	where, args, err := r.taskConditions(ctx, filters)
+	if err != nil {
+		return err
+	}
+	query := `SELECT ` + taskColumns + ` FROM tasks` + where
+	if filters.Sort == types.TaskSortOldest {
+		query += ` ORDER BY created_at`
+	} else {
+		query += ` ORDER BY created_at DESC`
+	}
+	if filters.Limit > 0 || filters.Offset > 0 {
+		limit := filters.Limit
+		if limit <= 0 {
+			limit = -1 // No limit
+		}
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, filters.Offset)
+	}
 
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return types.TaskData{}, fmt.Errorf("task %s not found", id)
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
 		}
-		return types.TaskData{}, fmt.Errorf("failed to get task: %w", err)
+	}()
+
+	for rows.Next() {
+		data, err := scanTask(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan task: %w", err)
+		}
+		if filters.WithOutput {
+			data.Output, err = r.taskOutput(ctx, data.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
 	}
 
-	if unmarshalErr := json.Unmarshal([]byte(argsJSON), &data.Args); unmarshalErr != nil {
-		return types.TaskData{}, fmt.Errorf("failed to unmarshal args: %w", unmarshalErr)
+	return nil
+}
+
+// taskConditions returns the WHERE clause selecting the tasks matching
+// filters, empty when nothing is filtered, and its arguments
+func (r *SQLiteRepository) taskConditions(ctx context.Context, filters types.TaskFilters) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	if filters.Tool != "" {
+		conditions = append(conditions, "tool = ?")
+		args = append(args, filters.Tool)
+	}
+	if len(filters.Statuses) > 0 {
+		conditions = append(conditions, "status IN (?"+strings.Repeat(", ?", len(filters.Statuses)-1)+")")
+		for _, status := range filters.Statuses {
+			args = append(args, string(status))
+		}
+	}
+	// Timestamps are stored as text in local time, so the bounds must be
+	// in the same zone to compare correctly
+	if filters.CreatedFrom != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filters.CreatedFrom.Local())
+	}
+	if filters.CreatedTo != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filters.CreatedTo.Local())
+	}
+	if host := strings.ToLower(strings.TrimSpace(filters.Host)); host != "" {
+		conditions = append(conditions, "(source_host = ? OR source_host LIKE ? ESCAPE '\\')")
+		args = append(args, host, "%."+escapeLike(host))
+	}
+	if filters.Query != "" {
+		match := `tool LIKE ? OR command LIKE ? OR notes LIKE ?
+		   OR EXISTS (SELECT 1 FROM json_each(tasks.args) WHERE value LIKE ?)`
+		searchTerm := "%" + filters.Query + "%"
+		args = append(args, searchTerm, searchTerm, searchTerm, searchTerm)
+		if filters.QueryOutput {
+			match += " OR id IN (SELECT task_id FROM task_outputs WHERE output LIKE ?)"
+			args = append(args, searchTerm)
+
+			// Compressed output can't be searched in SQL
+			archived, err := r.searchArchivedOutput(ctx, filters.Query)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(archived) > 0 {
+				match += " OR id IN (?" + strings.Repeat(", ?", len(archived)-1) + ")"
+				for _, id := range archived {
+					args = append(args, id)
+				}
+			}
+		}
+		conditions = append(conditions, "("+match+")")
 	}
 
-	if startedAt.Valid {
-		data.StartedAt = startedAt.Time
+	if len(conditions) == 0 {
+		return "", args, nil
 	}
-	if endedAt.Valid {
-		data.EndedAt = endedAt.Time
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// outputQuerier is implemented by both *sql.DB and *sql.Tx
+type outputQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// taskOutput returns all of a task's output lines: the compressed ones
+// first, then any appended since
+func (r *SQLiteRepository) taskOutput(ctx context.Context, taskID string) ([]string, error) {
+	output, _, err := archivedOutput(ctx, r.db, taskID)
+	if err != nil {
+		return nil, err
+	}
+	live, err := liveOutput(ctx, r.db, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return append(output, live...), nil
+}
+
+// archivedOutput returns a task's compressed output lines, and whether it
+// has any
+func archivedOutput(ctx context.Context, q outputQuerier, taskID string) ([]string, bool, error) {
+	var blob []byte
+	err := q.QueryRowContext(ctx, `SELECT output FROM task_output_archives WHERE task_id = ?`, taskID).Scan(&blob)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get task output: %w", err)
+	}
+	lines, err := decompressLines(blob)
+	if err != nil {
+		return nil, false, err
 	}
+	return lines, true, nil
+}
 
-	// Get output
-	outputQuery := `SELECT output FROM task_outputs WHERE task_id = ? ORDER BY timestamp`
-	rows, err := r.db.QueryContext(ctx, outputQuery, id)
+// liveOutput returns a task's uncompressed output lines
+func liveOutput(ctx context.Context, q outputQuerier, taskID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT output FROM task_outputs WHERE task_id = ? ORDER BY id`, taskID)
 	if err != nil {
-		return types.TaskData{}, fmt.Errorf("failed to get task output: %w", err)
+		return nil, fmt.Errorf("failed to get task output: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
+			log.Printf("Error closing output rows: %v", err)
 		}
 	}()
 
@@ -191,25 +842,50 @@ func (r *SQLiteRepository) GetByID(ctx context.Context, id string) (types.TaskDa
 	for rows.Next() {
 		var line string
 		if err := rows.Scan(&line); err != nil {
-			return types.TaskData{}, fmt.Errorf("failed to scan output: %w", err)
+			return nil, fmt.Errorf("failed to scan output: %w", err)
 		}
 		output = append(output, line)
 	}
-	data.Output = output
-
-	return data, nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get task output: %w", err)
+	}
+	return output, nil
 }
 
-// List retrieves all tasks
-func (r *SQLiteRepository) List(ctx context.Context) ([]types.TaskData, error) {
-	query := `
-		SELECT id, tool, command, args, status, error, created_at, started_at, ended_at
-		FROM tasks ORDER BY created_at DESC
+// ToolFailures returns the failure rate of a tool's tasks that ended at or
+// after since and up to limit of its failed tasks, most recently ended first
+func (r *SQLiteRepository) ToolFailures(ctx context.Context, tool string, since time.Time, limit int) (types.ToolFailures, error) {
+	result := types.ToolFailures{Tool: tool, Since: since, Failures: []types.TaskFailure{}}
+
+	where := "tool = ?"
+	args := []interface{}{tool}
+	if !since.IsZero() {
+		where += " AND ended_at >= ?"
+		args = append(args, since)
+	}
+
+	countQuery := `
+		SELECT COUNT(*), COALESCE(SUM(status = ?), 0) FROM tasks
+		WHERE ` + where + ` AND status IN (?, ?)
 	`
+	countArgs := append([]interface{}{string(types.StatusFailed)}, args...)
+	countArgs = append(countArgs, string(types.StatusComplete), string(types.StatusFailed))
+	if err := r.db.QueryRowContext(ctx, countQuery, countArgs...).Scan(&result.Finished, &result.Failed); err != nil {
+		return result, fmt.Errorf("failed to count failures: %w", err)
+	}
+	finishToolFailures(&result)
 
-	rows, err := r.db.QueryContext(ctx, query)
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, error, created_at, ended_at FROM tasks
+		WHERE `+where+` AND status = ?
+		ORDER BY ended_at DESC
+		LIMIT ?
+	`, append(args, string(types.StatusFailed), limit)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list tasks: %w", err)
+		return result, fmt.Errorf("failed to query failures: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -217,69 +893,394 @@ func (r *SQLiteRepository) List(ctx context.Context) ([]types.TaskData, error) {
 		}
 	}()
 
-	var tasks []types.TaskData
 	for rows.Next() {
-		var data types.TaskData
-		var argsJSON string
-		var startedAt, endedAt sql.NullTime
+		var failure types.TaskFailure
+		var endedAt sql.NullTime
+		if err := rows.Scan(&failure.TaskID, &failure.Error, &failure.CreatedAt, &endedAt); err != nil {
+			return result, fmt.Errorf("failed to scan failure: %w", err)
+		}
+		if endedAt.Valid {
+			failure.EndedAt = endedAt.Time
+		}
+		result.Failures = append(result.Failures, failure)
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("failed to read failures: %w", err)
+	}
+	return result, nil
+}
 
-		err := rows.Scan(&data.ID, &data.Tool, &data.Command, &argsJSON, &data.Status,
-			&data.Error, &data.CreatedAt, &startedAt, &endedAt)
+// ToolStats aggregates the history of a tool's tasks created at or after since
+func (r *SQLiteRepository) ToolStats(ctx context.Context, tool string, since time.Time) (types.ToolStats, error) {
+	stats := types.ToolStats{Tool: tool, Since: since}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
+	filters := types.TaskFilters{Tool: tool}
+	if !since.IsZero() {
+		filters.CreatedFrom = &since
+	}
+	where, args, err := r.taskConditions(ctx, filters)
+	if err != nil {
+		return stats, err
+	}
+
+	// Task counts per status
+	rows, err := r.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM tasks`+where+` GROUP BY status`, args...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	for rows.Next() {
+		var status types.Status
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				log.Printf("Error closing rows: %v", closeErr)
+			}
+			return stats, fmt.Errorf("failed to scan task count: %w", err)
 		}
+		addStatusCount(&stats, status, count)
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
 
-		if unmarshalErr := json.Unmarshal([]byte(argsJSON), &data.Args); unmarshalErr != nil {
-			return nil, fmt.Errorf("failed to unmarshal args: %w", unmarshalErr)
+	// Durations of completed tasks, summarized in Go since SQLite has no percentiles
+	rows, err = r.db.QueryContext(ctx, `
+		SELECT started_at, ended_at FROM tasks`+where+`
+		AND status = ? AND started_at IS NOT NULL AND ended_at IS NOT NULL
+	`, append(args, string(types.StatusComplete))...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query task durations: %w", err)
+	}
+	var durations []float64
+	for rows.Next() {
+		var startedAt, endedAt time.Time
+		if err := rows.Scan(&startedAt, &endedAt); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				log.Printf("Error closing rows: %v", closeErr)
+			}
+			return stats, fmt.Errorf("failed to scan task duration: %w", err)
 		}
+		durations = append(durations, endedAt.Sub(startedAt).Seconds())
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
 
-		if startedAt.Valid {
-			data.StartedAt = startedAt.Time
+	// Queue waits of tasks that started
+	rows, err = r.db.QueryContext(ctx, `
+		SELECT created_at, started_at FROM tasks`+where+`
+		AND started_at IS NOT NULL
+	`, args...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query task queue waits: %w", err)
+	}
+	var waits []float64
+	for rows.Next() {
+		var createdAt, startedAt time.Time
+		if err := rows.Scan(&createdAt, &startedAt); err != nil {
+			if closeErr := rows.Close(); closeErr != nil {
+				log.Printf("Error closing rows: %v", closeErr)
+			}
+			return stats, fmt.Errorf("failed to scan task queue wait: %w", err)
 		}
-		if endedAt.Valid {
-			data.EndedAt = endedAt.Time
+		waits = append(waits, float64(startedAt.Sub(createdAt).Milliseconds()))
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Error closing rows: %v", err)
+	}
+	finishToolStats(&stats, durations, waits)
+
+	// Files discovered from the tool's tasks
+	fileQuery := `
+		SELECT COUNT(*), COALESCE(SUM(f.file_size), 0)
+		FROM files f JOIN tasks t ON f.task_id = t.id
+		WHERE t.tool = ?
+	`
+	fileArgs := []interface{}{tool}
+	if !since.IsZero() {
+		fileQuery += " AND t.created_at >= ?"
+		fileArgs = append(fileArgs, since)
+	}
+	if err := r.db.QueryRowContext(ctx, fileQuery, fileArgs...).Scan(&stats.FilesDiscovered, &stats.BytesDiscovered); err != nil {
+		return stats, fmt.Errorf("failed to sum discovered files: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Update updates an existing task
+func (r *SQLiteRepository) Update(ctx context.Context, data types.TaskData) error {
+	argsJSON, err := json.Marshal(data.Args)
+	if err != nil {
+		return fmt.Errorf("failed to marshal args: %w", err)
+	}
+	tags, err := listValue("tags", data.Tags)
+	if err != nil {
+		return err
+	}
+	expectedOutput, err := listValue("expected output", data.ExpectedOutput)
+	if err != nil {
+		return err
+	}
+	options, err := mapValue("options", data.Options)
+	if err != nil {
+		return err
+	}
+	positionalArgs, err := listValue("positional args", data.PositionalArgs)
+	if err != nil {
+		return err
+	}
+	argv, err := listValue("argv", data.Argv)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE tasks 
+		SET tool = ?, command = ?, args = ?, status = ?, error = ?, 
+		    created_at = ?, started_at = ?, ended_at = ?,
+		    cpu_user_ms = ?, cpu_system_ms = ?, max_rss_bytes = ?, organize = ?, tags = ?, expected_output = ?,
+		    timeout_seconds = ?, options = ?, positional_args = ?, notes = ?, argv = ?, cancel_reason = ?,
+		    source_host = ?, rerun_of = ?
+		WHERE id = ?
+	`
+
+	var startedAt, endedAt interface{}
+	if !data.StartedAt.IsZero() {
+		startedAt = data.StartedAt
+	}
+	if !data.EndedAt.IsZero() {
+		endedAt = data.EndedAt
+	}
+
+	result, err := r.db.ExecContext(ctx, query,
+		data.Tool, data.Command, string(argsJSON), string(data.Status),
+		data.Error, data.CreatedAt, startedAt, endedAt,
+		data.CPUUserMs, data.CPUSystemMs, data.MaxRSSBytes, data.Organize, tags, expectedOutput,
+		data.TimeoutSeconds, options, positionalArgs, data.Notes, argv, data.CancelReason, data.SourceHost, data.RerunOf, data.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update task: %w", err)
+	}
+
+	return requireAffected(result, "task", data.ID)
+}
+
+// AppendOutput adds output to a task
+func (r *SQLiteRepository) AppendOutput(ctx context.Context, taskID string, output string) error {
+	// Skip empty output
+	if strings.TrimSpace(output) == "" {
+		return nil
+	}
+
+	query := `INSERT INTO task_outputs (task_id, output, stream) VALUES (?, ?, ?)`
+	_, err := r.db.ExecContext(ctx, query, taskID, output, types.OutputStream(output))
+	if isForeignKeyError(err) {
+		// The task was deleted while its output was still being written
+		return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to append output: %w", err)
+	}
+
+	return nil
+}
+
+// AppendOutputLines adds several lines of output to a task in one
+// transaction, skipping empty lines like AppendOutput
+func (r *SQLiteRepository) AppendOutputLines(ctx context.Context, taskID string, lines []string) error {
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO task_outputs (task_id, output, stream) VALUES (?, ?, ?)`
+		for _, output := range lines {
+			if strings.TrimSpace(output) == "" {
+				continue
+			}
+			_, err := tx.ExecContext(ctx, query, taskID, output, types.OutputStream(output))
+			if isForeignKeyError(err) {
+				// The task was deleted while its output was still being written
+				return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to append output: %w", err)
+			}
 		}
+		return nil
+	})
+}
+
+// GetOutput returns a range of a task's output lines, only those of stream
+// unless it is empty
+func (r *SQLiteRepository) GetOutput(ctx context.Context, taskID string, stream types.Stream, offset, limit int) (types.OutputPage, error) {
+	page := types.OutputPage{TaskID: taskID, Stream: stream, Lines: []string{}}
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = ?)`, taskID).Scan(&exists)
+	if err != nil {
+		return page, fmt.Errorf("failed to get task: %w", err)
+	}
+	if !exists {
+		return page, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
 
-		// Get output for this task
-		outputQuery := `SELECT output FROM task_outputs WHERE task_id = ? ORDER BY timestamp`
-		outputRows, err := r.db.QueryContext(ctx, outputQuery, data.ID)
+	// Compressed output is paged in memory, it only exists for finished
+	// tasks so it doesn't grow
+	archived, ok, err := archivedOutput(ctx, r.db, taskID)
+	if err != nil {
+		return page, err
+	}
+	if ok {
+		live, err := liveOutput(ctx, r.db, taskID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get task output: %w", err)
+			return page, err
 		}
+		return pageLines(taskID, stream, append(archived, live...), offset, limit), nil
+	}
 
-		var output []string
-		for outputRows.Next() {
-			var line string
-			if err := outputRows.Scan(&line); err != nil {
-				if closeErr := outputRows.Close(); closeErr != nil {
-					log.Printf("Error closing output rows: %v", closeErr)
-				}
-				return nil, fmt.Errorf("failed to scan output: %w", err)
-			}
-			output = append(output, line)
+	where := `task_id = ?`
+	args := []interface{}{taskID}
+	if stream != "" {
+		where += ` AND stream = ?`
+		args = append(args, stream)
+	}
+
+	err = r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM task_outputs WHERE `+where, args...).Scan(&page.Total)
+	if err != nil {
+		return page, fmt.Errorf("failed to count task output: %w", err)
+	}
+	page.Offset = outputOffset(offset, page.Total)
+
+	query := `SELECT output FROM task_outputs WHERE ` + where + ` ORDER BY id LIMIT ? OFFSET ?`
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit, page.Offset)...)
+	if err != nil {
+		return page, fmt.Errorf("failed to get task output: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
 		}
-		if err := outputRows.Close(); err != nil {
-			log.Printf("Error closing output rows: %v", err)
+	}()
+
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return page, fmt.Errorf("failed to scan output: %w", err)
 		}
-		data.Output = output
+		page.Lines = append(page.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return page, fmt.Errorf("failed to get task output: %w", err)
+	}
+	return page, nil
+}
 
-		tasks = append(tasks, data)
+// SearchOutput returns the lines of a task's output matching search. Plain
+// text queries are narrowed down in SQL first, compressed output is searched
+// in memory.
+func (r *SQLiteRepository) SearchOutput(ctx context.Context, taskID string, search types.OutputSearch) (types.OutputSearchResult, error) {
+	result := types.OutputSearchResult{TaskID: taskID, Matches: []types.OutputMatch{}}
+	matcher, err := newOutputMatcher(search)
+	if err != nil {
+		return result, err
 	}
 
-	return tasks, nil
+	var exists bool
+	err = r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM tasks WHERE id = ?)`, taskID).Scan(&exists)
+	if err != nil {
+		return result, fmt.Errorf("failed to get task: %w", err)
+	}
+	if !exists {
+		return result, fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+	}
+
+	archived, _, err := archivedOutput(ctx, r.db, taskID)
+	if err != nil {
+		return result, err
+	}
+	if matcher.searchLines(&result, archived, 0, search.Limit) {
+		return result, nil
+	}
+
+	// Lines are numbered before filtering so they match GetOutput offsets
+	query := `
+		SELECT line, output FROM (
+			SELECT ROW_NUMBER() OVER (ORDER BY id) - 1 AS line, output
+			FROM task_outputs WHERE task_id = ?
+		)`
+	args := []interface{}{taskID}
+	if condition, arg := matcher.sqlFilter(); condition != "" {
+		query += ` WHERE ` + condition
+		args = append(args, arg)
+	}
+	query += ` ORDER BY line`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to search task output: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var line int
+		var output string
+		if err := rows.Scan(&line, &output); err != nil {
+			return result, fmt.Errorf("failed to scan output: %w", err)
+		}
+		if matcher.searchLines(&result, []string{output}, len(archived)+line, search.Limit) {
+			return result, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("failed to search task output: %w", err)
+	}
+	return result, nil
+}
+
+// CompressOutput moves a task's output lines into a single gzip compressed
+// record. Lines compressed earlier are kept in front, so it can run again
+// when more output arrived afterwards.
+func (r *SQLiteRepository) CompressOutput(ctx context.Context, taskID string) error {
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		live, err := liveOutput(ctx, tx, taskID)
+		if err != nil || len(live) == 0 {
+			return err
+		}
+		lines, _, err := archivedOutput(ctx, tx, taskID)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, live...)
+
+		blob, err := compressLines(lines)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO task_output_archives (task_id, output) VALUES (?, ?)
+			ON CONFLICT (task_id) DO UPDATE SET output = excluded.output`,
+			taskID, blob)
+		if isForeignKeyError(err) {
+			return fmt.Errorf("task %s: %w", taskID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store compressed output: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM task_outputs WHERE task_id = ?`, taskID); err != nil {
+			return fmt.Errorf("failed to delete compressed output lines: %w", err)
+		}
+		return nil
+	})
 }
 
-// ListByTool retrieves tasks for a specific tool
-func (r *SQLiteRepository) ListByTool(ctx context.Context, tool string) ([]types.TaskData, error) {
-	query := `
-		SELECT id, tool, command, args, status, error, created_at, started_at, ended_at
-		FROM tasks WHERE tool = ? ORDER BY created_at DESC
-	`
-
-	rows, err := r.db.QueryContext(ctx, query, tool)
+// searchArchivedOutput returns the IDs of tasks whose compressed output
+// contains query
+func (r *SQLiteRepository) searchArchivedOutput(ctx context.Context, query string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT task_id, output FROM task_output_archives`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list tasks by tool: %w", err)
+		return nil, fmt.Errorf("failed to search task output: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -287,111 +1288,196 @@ func (r *SQLiteRepository) ListByTool(ctx context.Context, tool string) ([]types
 		}
 	}()
 
-	var tasks []types.TaskData
+	var ids []string
 	for rows.Next() {
-		var data types.TaskData
-		var argsJSON string
-		var startedAt, endedAt sql.NullTime
-
-		err := rows.Scan(&data.ID, &data.Tool, &data.Command, &argsJSON, &data.Status,
-			&data.Error, &data.CreatedAt, &startedAt, &endedAt)
-
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return nil, fmt.Errorf("failed to scan task output: %w", err)
+		}
+		lines, err := decompressLines(blob)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan task: %w", err)
+			return nil, err
 		}
-
-		if unmarshalErr := json.Unmarshal([]byte(argsJSON), &data.Args); unmarshalErr != nil {
-			return nil, fmt.Errorf("failed to unmarshal args: %w", unmarshalErr)
+		if containsFold(lines, query) {
+			ids = append(ids, id)
 		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search task output: %w", err)
+	}
+	return ids, nil
+}
 
-		if startedAt.Valid {
-			data.StartedAt = startedAt.Time
+// DeleteTask removes a task and its output. Files the task created are
+// kept but no longer linked to it.
+func (r *SQLiteRepository) DeleteTask(ctx context.Context, id string) error {
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM task_outputs WHERE task_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete task output: %w", err)
 		}
-		if endedAt.Valid {
-			data.EndedAt = endedAt.Time
+		if _, err := tx.ExecContext(ctx, `DELETE FROM task_output_archives WHERE task_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete task output: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE files SET task_id = NULL WHERE task_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to unlink task files: %w", err)
 		}
 
-		// Get output for this task
-		outputQuery := `SELECT output FROM task_outputs WHERE task_id = ? ORDER BY timestamp`
-		outputRows, err := r.db.QueryContext(ctx, outputQuery, data.ID)
+		result, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id = ?`, id)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get task output: %w", err)
+			return fmt.Errorf("failed to delete task: %w", err)
 		}
+		return requireAffected(result, "task", id)
+	})
+}
 
-		var output []string
-		for outputRows.Next() {
-			var line string
-			if err := outputRows.Scan(&line); err != nil {
-				if closeErr := outputRows.Close(); closeErr != nil {
-					log.Printf("Error closing output rows: %v", closeErr)
-				}
-				return nil, fmt.Errorf("failed to scan output: %w", err)
-			}
-			output = append(output, line)
+// Close closes the database connection
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
+// WithTx runs fn inside a transaction, committing if it returns nil and
+// rolling back otherwise
+func (r *SQLiteRepository) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
 		}
-		if err := outputRows.Close(); err != nil {
-			log.Printf("Error closing output rows: %v", err)
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("Error rolling back transaction: %v", rbErr)
 		}
-		data.Output = output
+		return err
+	}
 
-		tasks = append(tasks, data)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
 
-	return tasks, nil
+// Backup writes a consistent copy of the database to destPath using
+// VACUUM INTO. The copy is taken inside a single read transaction, so task
+// writes only wait for the duration of the copy itself.
+func (r *SQLiteRepository) Backup(ctx context.Context, destPath string) error {
+	if _, err := r.db.ExecContext(ctx, "VACUUM INTO ?", destPath); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
 }
 
-// Update updates an existing task
-func (r *SQLiteRepository) Update(ctx context.Context, data types.TaskData) error {
-	argsJSON, err := json.Marshal(data.Args)
+// Vacuum rebuilds the database file to reclaim unused space
+func (r *SQLiteRepository) Vacuum(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// WorkerOverrides returns the worker counts changed at runtime by tool name
+func (r *SQLiteRepository) WorkerOverrides(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT tool, workers FROM tool_workers")
 	if err != nil {
-		return fmt.Errorf("failed to marshal args: %w", err)
+		return nil, fmt.Errorf("failed to query worker overrides: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	overrides := make(map[string]int)
+	for rows.Next() {
+		var tool string
+		var workers int
+		if err := rows.Scan(&tool, &workers); err != nil {
+			return nil, fmt.Errorf("failed to scan worker override: %w", err)
+		}
+		overrides[tool] = workers
 	}
+	return overrides, rows.Err()
+}
 
+// SetWorkerOverride records the worker count of a tool, replacing any
+// earlier override
+func (r *SQLiteRepository) SetWorkerOverride(ctx context.Context, tool string, workers int) error {
 	query := `
-		UPDATE tasks 
-		SET tool = ?, command = ?, args = ?, status = ?, error = ?, 
-		    created_at = ?, started_at = ?, ended_at = ?
-		WHERE id = ?
+		INSERT INTO tool_workers (tool, workers) VALUES (?, ?)
+		ON CONFLICT (tool) DO UPDATE SET workers = excluded.workers
 	`
-
-	var startedAt, endedAt interface{}
-	if !data.StartedAt.IsZero() {
-		startedAt = data.StartedAt
-	}
-	if !data.EndedAt.IsZero() {
-		endedAt = data.EndedAt
+	if _, err := r.db.ExecContext(ctx, query, tool, workers); err != nil {
+		return fmt.Errorf("failed to save worker override: %w", err)
 	}
+	return nil
+}
 
-	_, err = r.db.ExecContext(ctx, query,
-		data.Tool, data.Command, string(argsJSON), string(data.Status),
-		data.Error, data.CreatedAt, startedAt, endedAt, data.ID)
-
+// RecordAudit appends an entry to the audit log
+func (r *SQLiteRepository) RecordAudit(ctx context.Context, entry *types.AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (created_at, actor, remote_addr, action, summary, status)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	result, err := r.db.ExecContext(ctx, query, entry.CreatedAt, entry.Actor, entry.RemoteAddr, entry.Action, entry.Summary, entry.Status)
 	if err != nil {
-		return fmt.Errorf("failed to update task: %w", err)
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	entry.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get audit entry id: %w", err)
 	}
-
 	return nil
 }
 
-// AppendOutput adds output to a task
-func (r *SQLiteRepository) AppendOutput(ctx context.Context, taskID string, output string) error {
-	// Skip empty output
-	if strings.TrimSpace(output) == "" {
-		return nil
+// ListAudit returns a page of audit entries matching filters, newest first
+func (r *SQLiteRepository) ListAudit(ctx context.Context, filters types.AuditFilters) (types.AuditPage, error) {
+	page := types.AuditPage{Entries: []types.AuditEntry{}, Offset: filters.Offset, Limit: filters.Limit}
+
+	where := " WHERE 1=1"
+	args := []interface{}{}
+	if filters.Actor != "" {
+		where += " AND actor = ?"
+		args = append(args, filters.Actor)
+	}
+	if filters.Action != "" {
+		where += " AND action = ?"
+		args = append(args, filters.Action)
+	}
+	if filters.Since != nil {
+		where += " AND created_at >= ?"
+		args = append(args, *filters.Since)
 	}
 
-	query := `INSERT INTO task_outputs (task_id, output) VALUES (?, ?)`
-	_, err := r.db.ExecContext(ctx, query, taskID, output)
-	if err != nil {
-		return fmt.Errorf("failed to append output: %w", err)
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&page.Total); err != nil {
+		return page, fmt.Errorf("failed to count audit entries: %w", err)
 	}
 
-	return nil
-}
+	query := "SELECT id, created_at, actor, remote_addr, action, summary, status FROM audit_log" + where + " ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.QueryContext(ctx, query, append(args, filters.Limit, filters.Offset)...)
+	if err != nil {
+		return page, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
 
-// Close closes the database connection
-func (r *SQLiteRepository) Close() error {
-	return r.db.Close()
+	for rows.Next() {
+		var entry types.AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.Actor, &entry.RemoteAddr, &entry.Action, &entry.Summary, &entry.Status); err != nil {
+			return page, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, rows.Err()
 }
 
 // Directory operations
@@ -403,6 +1489,9 @@ func (r *SQLiteRepository) CreateDirectory(ctx context.Context, dir *types.Direc
 		VALUES (?, ?, ?, ?, ?, ?)
 	`
 	_, err := r.db.ExecContext(ctx, query, dir.ID, dir.Name, dir.Path, dir.ToolName, dir.DefaultDir, dir.CreatedAt)
+	if isUniqueError(err) {
+		return fmt.Errorf("directory for %s: %w", dir.Path, ErrAlreadyExists)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
@@ -422,8 +1511,8 @@ func (r *SQLiteRepository) GetDirectory(ctx context.Context, id string) (*types.
 
 	err := row.Scan(&dir.ID, &dir.Name, &dir.Path, &toolName, &dir.DefaultDir, &dir.CreatedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("directory %s not found", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("directory %s: %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get directory: %w", err)
 	}
@@ -435,13 +1524,17 @@ func (r *SQLiteRepository) GetDirectory(ctx context.Context, id string) (*types.
 	return &dir, nil
 }
 
-// ListDirectories retrieves all directories
-func (r *SQLiteRepository) ListDirectories(ctx context.Context) ([]*types.Directory, error) {
-	query := `
-		SELECT id, name, path, tool_name, default_dir, created_at
-		FROM download_directories ORDER BY name
-	`
-	rows, err := r.db.QueryContext(ctx, query)
+// ListDirectories retrieves the directories matching filters
+func (r *SQLiteRepository) ListDirectories(ctx context.Context, filters types.DirectoryFilters) ([]*types.Directory, error) {
+	query := `SELECT id, name, path, tool_name, default_dir, created_at FROM download_directories`
+	var args []interface{}
+	if filters.ToolName != "" {
+		query += ` WHERE tool_name = ?`
+		args = append(args, filters.ToolName)
+	}
+	query += ` ORDER BY name`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directories: %w", err)
 	}
@@ -471,6 +1564,37 @@ func (r *SQLiteRepository) ListDirectories(ctx context.Context) ([]*types.Direct
 	return directories, nil
 }
 
+// DirectoryUsage counts and sums the files of each directory
+func (r *SQLiteRepository) DirectoryUsage(ctx context.Context) (map[string]types.DirectoryUsage, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT directory_id, COUNT(*), COALESCE(SUM(file_size), 0)
+		FROM files GROUP BY directory_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum directory usage: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error closing rows: %v", err)
+		}
+	}()
+
+	usage := make(map[string]types.DirectoryUsage)
+	for rows.Next() {
+		var directoryID string
+		var u types.DirectoryUsage
+		if err := rows.Scan(&directoryID, &u.FileCount, &u.TotalSize); err != nil {
+			return nil, fmt.Errorf("failed to scan directory usage: %w", err)
+		}
+		usage[directoryID] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read directory usage: %w", err)
+	}
+
+	return usage, nil
+}
+
 // UpdateDirectory updates an existing directory
 func (r *SQLiteRepository) UpdateDirectory(ctx context.Context, dir *types.Directory) error {
 	query := `
@@ -478,45 +1602,146 @@ func (r *SQLiteRepository) UpdateDirectory(ctx context.Context, dir *types.Direc
 		SET name = ?, path = ?, tool_name = ?, default_dir = ?
 		WHERE id = ?
 	`
-	_, err := r.db.ExecContext(ctx, query, dir.Name, dir.Path, dir.ToolName, dir.DefaultDir, dir.ID)
+	result, err := r.db.ExecContext(ctx, query, dir.Name, dir.Path, dir.ToolName, dir.DefaultDir, dir.ID)
+	if isUniqueError(err) {
+		return fmt.Errorf("directory for %s: %w", dir.Path, ErrAlreadyExists)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update directory: %w", err)
 	}
-	return nil
+	return requireAffected(result, "directory", dir.ID)
 }
 
 // DeleteDirectory removes a directory from storage
 func (r *SQLiteRepository) DeleteDirectory(ctx context.Context, id string) error {
 	query := `DELETE FROM download_directories WHERE id = ?`
-	_, err := r.db.ExecContext(ctx, query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
+	if isForeignKeyError(err) {
+		return fmt.Errorf("directory %s has files: %w", id, ErrInUse)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete directory: %w", err)
 	}
-	return nil
+	return requireAffected(result, "directory", id)
 }
 
 // File operations
 
-// CreateFile adds a new file to storage
+// CreateFile adds a new file and its tags to storage in a single transaction
 func (r *SQLiteRepository) CreateFile(ctx context.Context, file *types.File) error {
 	query := `
 		INSERT INTO files (id, filename, file_path, directory_id, task_id, file_size, mime_type, created_at, accessed_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := r.db.ExecContext(ctx, query, file.ID, file.Filename, file.FilePath, file.DirectoryID,
-		file.TaskID, file.FileSize, file.MimeType, file.CreatedAt, file.AccessedAt)
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, file.ID, file.Filename, file.FilePath, file.DirectoryID,
+			file.TaskID, file.FileSize, file.MimeType, file.CreatedAt, file.AccessedAt)
+		if isForeignKeyError(err) {
+			return fmt.Errorf("directory %s or task of file %s: %w", file.DirectoryID, file.ID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+
+		// Add tags if any
+		for _, tag := range file.Tags {
+			if err := addFileTag(ctx, tx, file.ID, tag); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// CreateFiles adds files in one transaction, skipping files whose path is
+// already tracked, and returns the files it added. The unique path index
+// does the lookup, so callers don't need to load the tracked files first.
+func (r *SQLiteRepository) CreateFiles(ctx context.Context, files []*types.File) ([]*types.File, error) {
+	query := `
+		INSERT INTO files (id, filename, file_path, directory_id, task_id, file_size, mime_type, created_at, accessed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (file_path) DO NOTHING
+	`
+	var created []*types.File
+	err := r.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, file := range files {
+			result, err := tx.ExecContext(ctx, query, file.ID, file.Filename, file.FilePath, file.DirectoryID,
+				file.TaskID, file.FileSize, file.MimeType, file.CreatedAt, file.AccessedAt)
+			if isForeignKeyError(err) {
+				return fmt.Errorf("directory %s or task of file %s: %w", file.DirectoryID, file.ID, ErrNotFound)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			if affected == 0 {
+				continue
+			}
+
+			for _, tag := range file.Tags {
+				if err := addFileTag(ctx, tx, file.ID, tag); err != nil {
+					return err
+				}
+			}
+			created = append(created, file)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
+	return created, nil
+}
 
-	// Add tags if any
-	for _, tag := range file.Tags {
-		if err := r.AddFileTag(ctx, file.ID, tag); err != nil {
-			return fmt.Errorf("failed to add file tag: %w", err)
+// MoveFile updates a file's location and runs move before committing, so the
+// record only changes if the move on disk succeeds
+func (r *SQLiteRepository) MoveFile(ctx context.Context, file *types.File, move func() error) error {
+	query := `UPDATE files SET directory_id = ?, file_path = ?, accessed_at = ? WHERE id = ?`
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, file.DirectoryID, file.FilePath, file.AccessedAt, file.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update file: %w", err)
 		}
-	}
+		if err := requireAffected(result, "file", file.ID); err != nil {
+			return err
+		}
+		return move()
+	})
+}
 
-	return nil
+// CopyFile inserts a copy of a file and copies the source's tags in the same
+// transaction, running copy before committing. Reading the tags inside the
+// transaction means a concurrent tag change is either fully included or
+// not at all.
+func (r *SQLiteRepository) CopyFile(ctx context.Context, file *types.File, sourceID string, copy func() error) error {
+	query := `
+		INSERT INTO files (id, filename, file_path, directory_id, task_id, file_size, mime_type, created_at, accessed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, file.ID, file.Filename, file.FilePath, file.DirectoryID,
+			file.TaskID, file.FileSize, file.MimeType, file.CreatedAt, file.AccessedAt)
+		if isForeignKeyError(err) {
+			return fmt.Errorf("directory %s or task of file %s: %w", file.DirectoryID, file.ID, ErrNotFound)
+		}
+		if isUniqueError(err) {
+			return fmt.Errorf("file %s: %w", file.FilePath, ErrAlreadyExists)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO file_tags (file_id, tag) SELECT ?, tag FROM file_tags WHERE file_id = ?`, file.ID, sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to copy file tags: %w", err)
+		}
+
+		return copy()
+	})
 }
 
 // GetFile retrieves a file by its ID
@@ -533,8 +1758,8 @@ func (r *SQLiteRepository) GetFile(ctx context.Context, id string) (*types.File,
 	err := row.Scan(&file.ID, &file.Filename, &file.FilePath, &file.DirectoryID, &taskID,
 		&file.FileSize, &file.MimeType, &file.CreatedAt, &file.AccessedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("file %s not found", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("file %s: %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get file: %w", err)
 	}
@@ -555,6 +1780,20 @@ func (r *SQLiteRepository) GetFile(ctx context.Context, id string) (*types.File,
 
 // ListFiles retrieves files based on filters
 func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilters) ([]*types.File, error) {
+	var files []*types.File
+	err := r.EachFile(ctx, filters, func(file *types.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// EachFile calls fn with every file matching filters, reading them from the
+// database one at a time
+func (r *SQLiteRepository) EachFile(ctx context.Context, filters types.FileFilters, fn func(file *types.File) error) error {
 	query := `
 		SELECT id, filename, file_path, directory_id, task_id, file_size, mime_type, created_at, accessed_at
 		FROM files
@@ -586,6 +1825,28 @@ func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilt
 		conditions = append(conditions, "created_at <= ?")
 		args = append(args, *filters.CreatedTo)
 	}
+	if filters.Query != "" {
+		searchTerm := "%" + filters.Query + "%"
+		conditions = append(conditions, "(filename LIKE ? OR file_path LIKE ?)")
+		args = append(args, searchTerm, searchTerm)
+	}
+	if len(filters.Tags) > 0 {
+		// Files must carry every requested tag
+		tags := uniqueStrings(filters.Tags)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+		conditions = append(conditions, `id IN (
+			SELECT file_id FROM file_tags WHERE tag IN (`+placeholders+`)
+			GROUP BY file_id HAVING COUNT(DISTINCT tag) = ?
+		)`)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(tags))
+	}
+	if filters.Orphaned {
+		// Also true for files without a task, NULL never matches
+		conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.id = files.task_id)")
+	}
 
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
@@ -594,7 +1855,7 @@ func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilt
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
+		return fmt.Errorf("failed to list files: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -602,7 +1863,6 @@ func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilt
 		}
 	}()
 
-	var files []*types.File
 	for rows.Next() {
 		var file types.File
 		var taskID sql.NullString
@@ -610,7 +1870,7 @@ func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilt
 		err := rows.Scan(&file.ID, &file.Filename, &file.FilePath, &file.DirectoryID, &taskID,
 			&file.FileSize, &file.MimeType, &file.CreatedAt, &file.AccessedAt)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan file: %w", err)
+			return fmt.Errorf("failed to scan file: %w", err)
 		}
 
 		if taskID.Valid {
@@ -620,14 +1880,19 @@ func (r *SQLiteRepository) ListFiles(ctx context.Context, filters types.FileFilt
 		// Get tags for this file
 		tags, err := r.GetFileTags(ctx, file.ID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get file tags: %w", err)
+			return fmt.Errorf("failed to get file tags: %w", err)
 		}
 		file.Tags = tags
 
-		files = append(files, &file)
+		if err := fn(&file); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
 	}
 
-	return files, nil
+	return nil
 }
 
 // UpdateFile updates an existing file
@@ -637,36 +1902,46 @@ func (r *SQLiteRepository) UpdateFile(ctx context.Context, file *types.File) err
 		SET filename = ?, file_path = ?, directory_id = ?, task_id = ?, file_size = ?, mime_type = ?, accessed_at = ?
 		WHERE id = ?
 	`
-	_, err := r.db.ExecContext(ctx, query, file.Filename, file.FilePath, file.DirectoryID,
+	result, err := r.db.ExecContext(ctx, query, file.Filename, file.FilePath, file.DirectoryID,
 		file.TaskID, file.FileSize, file.MimeType, file.AccessedAt, file.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update file: %w", err)
 	}
-	return nil
+	return requireAffected(result, "file", file.ID)
 }
 
 // DeleteFile removes a file from storage
 func (r *SQLiteRepository) DeleteFile(ctx context.Context, id string) error {
-	// Delete file tags first (due to foreign key constraint)
-	if _, err := r.db.ExecContext(ctx, "DELETE FROM file_tags WHERE file_id = ?", id); err != nil {
-		return fmt.Errorf("failed to delete file tags: %w", err)
-	}
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		// Delete file tags first (due to foreign key constraint)
+		if _, err := tx.ExecContext(ctx, "DELETE FROM file_tags WHERE file_id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete file tags: %w", err)
+		}
 
-	// Delete the file record
-	query := `DELETE FROM files WHERE id = ?`
-	_, err := r.db.ExecContext(ctx, query, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete file: %w", err)
-	}
-	return nil
+		// Delete the file record
+		query := `DELETE FROM files WHERE id = ?`
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		return requireAffected(result, "file", id)
+	})
 }
 
 // File tag operations
 
 // AddFileTag adds a tag to a file
 func (r *SQLiteRepository) AddFileTag(ctx context.Context, fileID, tag string) error {
+	return addFileTag(ctx, r.db, fileID, tag)
+}
+
+// addFileTag adds a tag to a file using either the database or a transaction
+func addFileTag(ctx context.Context, ex execer, fileID, tag string) error {
 	query := `INSERT OR IGNORE INTO file_tags (file_id, tag) VALUES (?, ?)`
-	_, err := r.db.ExecContext(ctx, query, fileID, tag)
+	_, err := ex.ExecContext(ctx, query, fileID, tag)
+	if isForeignKeyError(err) {
+		return fmt.Errorf("file %s: %w", fileID, ErrNotFound)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to add file tag: %w", err)
 	}
@@ -675,18 +1950,70 @@ func (r *SQLiteRepository) AddFileTag(ctx context.Context, fileID, tag string) e
 
 // RemoveFileTag removes a tag from a file
 func (r *SQLiteRepository) RemoveFileTag(ctx context.Context, fileID, tag string) error {
+	return removeFileTag(ctx, r.db, fileID, tag)
+}
+
+// removeFileTag removes a tag from a file using either the database or a transaction
+func removeFileTag(ctx context.Context, ex execer, fileID, tag string) error {
 	query := `DELETE FROM file_tags WHERE file_id = ? AND tag = ?`
-	_, err := r.db.ExecContext(ctx, query, fileID, tag)
+	_, err := ex.ExecContext(ctx, query, fileID, tag)
 	if err != nil {
 		return fmt.Errorf("failed to remove file tag: %w", err)
 	}
 	return nil
 }
 
+// SetFileTags replaces a file's tags, adding and removing only the tags that
+// changed in a single transaction
+func (r *SQLiteRepository) SetFileTags(ctx context.Context, fileID string, tags []string) error {
+	return r.WithTx(ctx, func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM files WHERE id = ?`, fileID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check file: %w", err)
+		}
+		if count == 0 {
+			return fmt.Errorf("file %s: %w", fileID, ErrNotFound)
+		}
+
+		existing, err := getFileTags(ctx, tx, fileID)
+		if err != nil {
+			return err
+		}
+
+		wanted := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			wanted[tag] = true
+		}
+		current := make(map[string]bool, len(existing))
+		for _, tag := range existing {
+			current[tag] = true
+			if !wanted[tag] {
+				if err := removeFileTag(ctx, tx, fileID, tag); err != nil {
+					return err
+				}
+			}
+		}
+		for _, tag := range tags {
+			if !current[tag] {
+				if err := addFileTag(ctx, tx, fileID, tag); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
 // GetFileTags retrieves all tags for a file
 func (r *SQLiteRepository) GetFileTags(ctx context.Context, fileID string) ([]string, error) {
+	return getFileTags(ctx, r.db, fileID)
+}
+
+// getFileTags retrieves all tags for a file using either the database or a transaction
+func getFileTags(ctx context.Context, ex execer, fileID string) ([]string, error) {
 	query := `SELECT tag FROM file_tags WHERE file_id = ? ORDER BY tag`
-	rows, err := r.db.QueryContext(ctx, query, fileID)
+	rows, err := ex.QueryContext(ctx, query, fileID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file tags: %w", err)
 	}
@@ -710,47 +2037,65 @@ func (r *SQLiteRepository) GetFileTags(ctx context.Context, fileID string) ([]st
 
 // SearchFiles searches for files by filename
 func (r *SQLiteRepository) SearchFiles(ctx context.Context, query string) ([]*types.File, error) {
-	searchQuery := `
-		SELECT id, filename, file_path, directory_id, task_id, file_size, mime_type, created_at, accessed_at
-		FROM files 
-		WHERE filename LIKE ? OR file_path LIKE ?
-		ORDER BY created_at DESC
-	`
-	searchTerm := "%" + query + "%"
-	rows, err := r.db.QueryContext(ctx, searchQuery, searchTerm, searchTerm)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search files: %w", err)
-	}
-	defer func() {
-		if err := rows.Close(); err != nil {
-			log.Printf("Error closing rows: %v", err)
-		}
-	}()
+	return r.SearchFilesInDirectory(ctx, query, "")
+}
 
-	var files []*types.File
-	for rows.Next() {
-		var file types.File
-		var taskID sql.NullString
+// SearchFilesInDirectory searches for files by filename, limited to a single
+// directory when directoryID is not empty
+func (r *SQLiteRepository) SearchFilesInDirectory(ctx context.Context, query, directoryID string) ([]*types.File, error) {
+	return r.ListFiles(ctx, types.FileFilters{Query: query, DirectoryID: directoryID})
+}
 
-		err := rows.Scan(&file.ID, &file.Filename, &file.FilePath, &file.DirectoryID, &taskID,
-			&file.FileSize, &file.MimeType, &file.CreatedAt, &file.AccessedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan file: %w", err)
-		}
+// outputOffset resolves an output offset against the number of lines,
+// counting negative offsets back from the end and clamping to the range
+func outputOffset(offset, total int) int {
+	if offset < 0 {
+		offset += total
+	}
+	return max(0, min(offset, total))
+}
 
-		if taskID.Valid {
-			file.TaskID = &taskID.String
+// pageLines returns the page of a task's output lines a GetOutput call asks
+// for, counting only stream's lines unless it is empty
+func pageLines(taskID string, stream types.Stream, lines []string, offset, limit int) types.OutputPage {
+	if stream != "" {
+		var filtered []string
+		for _, line := range lines {
+			if types.OutputStream(line) == stream {
+				filtered = append(filtered, line)
+			}
 		}
+		lines = filtered
+	}
 
-		// Get tags for this file
-		tags, err := r.GetFileTags(ctx, file.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get file tags: %w", err)
-		}
-		file.Tags = tags
+	page := types.OutputPage{TaskID: taskID, Stream: stream, Total: len(lines)}
+	page.Offset = outputOffset(offset, page.Total)
+	end := min(page.Offset+limit, page.Total)
+	page.Lines = append([]string{}, lines[page.Offset:end]...)
+	return page
+}
 
-		files = append(files, &file)
+// requireAffected returns ErrNotFound when a statement matched no rows
+func requireAffected(result sql.Result, kind, id string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
 	}
+	if n == 0 {
+		return fmt.Errorf("%s %s: %w", kind, id, ErrNotFound)
+	}
+	return nil
+}
 
-	return files, nil
+// uniqueStrings returns values with duplicates removed, preserving order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
 }
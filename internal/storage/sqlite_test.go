@@ -0,0 +1,1672 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// fullRepository is implemented by both the SQLite and mock repositories
+type fullRepository interface {
+	TaskRepository
+	FileRepository
+}
+
+// newTestRepository creates a SQLite repository in a temporary directory
+func newTestRepository(t *testing.T) *SQLiteRepository {
+	t.Helper()
+
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	})
+	return repo
+}
+
+// seedFilterFiles creates a small library used by the filter tests
+func seedFilterFiles(t *testing.T, repo FileRepository) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, dirID := range []string{"videos", "other"} {
+		dir := &types.Directory{ID: dirID, Name: dirID, Path: "/data/" + dirID, CreatedAt: time.Now()}
+		if err := repo.CreateDirectory(ctx, dir); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	const mb = 1024 * 1024
+	files := []*types.File{
+		{ID: "big-concert", Filename: "concert-live.mp4", DirectoryID: "videos", FileSize: 500 * mb, MimeType: "video/mp4", Tags: []string{"keep", "music"}},
+		{ID: "small-concert", Filename: "concert-clip.mp4", DirectoryID: "videos", FileSize: 10 * mb, MimeType: "video/mp4", Tags: []string{"keep"}},
+		{ID: "untagged-concert", Filename: "concert-full.mp4", DirectoryID: "videos", FileSize: 800 * mb, MimeType: "video/mp4"},
+		{ID: "big-lecture", Filename: "lecture.mp4", DirectoryID: "videos", FileSize: 300 * mb, MimeType: "video/mp4", Tags: []string{"keep"}},
+		{ID: "concert-poster", Filename: "concert.jpg", DirectoryID: "other", FileSize: 200 * mb, MimeType: "image/jpeg", Tags: []string{"keep"}},
+	}
+	for _, f := range files {
+		f.FilePath = "/data/" + f.DirectoryID + "/" + f.Filename
+		f.CreatedAt = time.Now()
+		f.AccessedAt = time.Now()
+		if err := repo.CreateFile(ctx, f); err != nil {
+			t.Fatalf("Failed to create file %s: %v", f.ID, err)
+		}
+	}
+}
+
+func TestListFilesCombinedFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters types.FileFilters
+		want    []string
+	}{
+		{
+			name:    "query only",
+			filters: types.FileFilters{Query: "concert"},
+			want:    []string{"big-concert", "concert-poster", "small-concert", "untagged-concert"},
+		},
+		{
+			name:    "query with mime type and size",
+			filters: types.FileFilters{Query: "concert", MimeType: "video/mp4", MinSize: 100 * 1024 * 1024},
+			want:    []string{"big-concert", "untagged-concert"},
+		},
+		{
+			name:    "query with size and tag",
+			filters: types.FileFilters{Query: "concert", MimeType: "video/mp4", MinSize: 100 * 1024 * 1024, Tags: []string{"keep"}},
+			want:    []string{"big-concert"},
+		},
+		{
+			name:    "all tags required",
+			filters: types.FileFilters{Tags: []string{"keep", "music"}},
+			want:    []string{"big-concert"},
+		},
+		{
+			name:    "query scoped to directory",
+			filters: types.FileFilters{Query: "concert", DirectoryID: "other"},
+			want:    []string{"concert-poster"},
+		},
+	}
+
+	repos := map[string]func(t *testing.T) FileRepository{
+		"sqlite": func(t *testing.T) FileRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) FileRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		for _, tt := range tests {
+			t.Run(repoName+"/"+tt.name, func(t *testing.T) {
+				repo := newRepo(t)
+				seedFilterFiles(t, repo)
+
+				files, err := repo.ListFiles(context.Background(), tt.filters)
+				if err != nil {
+					t.Fatalf("ListFiles() error = %v", err)
+				}
+
+				got := make([]string, 0, len(files))
+				for _, f := range files {
+					got = append(got, f.ID)
+				}
+				sort.Strings(got)
+
+				if len(got) != len(tt.want) {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("Expected %v, got %v", tt.want, got)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestListOrphanedFiles(t *testing.T) {
+	// prune removes a task while leaving its files pointing at it, as
+	// pruning with foreign keys off or older versions did
+	type pruner func(t *testing.T, id string)
+	repos := map[string]func(t *testing.T) (FileRepository, TaskRepository, pruner){
+		"sqlite": func(t *testing.T) (FileRepository, TaskRepository, pruner) {
+			repo := newTestRepository(t)
+			return repo, repo, func(t *testing.T, id string) {
+				ctx := context.Background()
+				conn, err := repo.db.Conn(ctx)
+				if err != nil {
+					t.Fatalf("Failed to get connection: %v", err)
+				}
+				defer func() {
+					if err := conn.Close(); err != nil {
+						t.Errorf("Failed to close connection: %v", err)
+					}
+				}()
+				for _, stmt := range []string{"PRAGMA foreign_keys=OFF", "DELETE FROM tasks WHERE id = '" + id + "'", "PRAGMA foreign_keys=ON"} {
+					if _, err := conn.ExecContext(ctx, stmt); err != nil {
+						t.Fatalf("Failed to prune task: %v", err)
+					}
+				}
+			}
+		},
+		"mock": func(t *testing.T) (FileRepository, TaskRepository, pruner) {
+			repo := NewMockRepository()
+			return repo, repo, func(t *testing.T, id string) {
+				repo.mu.Lock()
+				defer repo.mu.Unlock()
+				delete(repo.tasks, id)
+			}
+		},
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			files, tasks, prune := newRepo(t)
+			ctx := context.Background()
+
+			for _, id := range []string{"kept", "pruned"} {
+				if err := tasks.Create(ctx, types.TaskData{ID: id, Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusComplete, CreatedAt: time.Now()}); err != nil {
+					t.Fatalf("Failed to create task: %v", err)
+				}
+			}
+			if err := files.CreateDirectory(ctx, &types.Directory{ID: "dir", Name: "Downloads", Path: "/data/dir", CreatedAt: time.Now()}); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			kept, pruned := "kept", "pruned"
+			taskIDs := map[string]*string{"linked": &kept, "untracked": nil, "dangling": &pruned}
+			for id, taskID := range taskIDs {
+				file := &types.File{ID: id, Filename: id, FilePath: "/data/dir/" + id, DirectoryID: "dir", TaskID: taskID, CreatedAt: time.Now(), AccessedAt: time.Now()}
+				if err := files.CreateFile(ctx, file); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+			}
+			prune(t, "pruned")
+
+			list, err := files.ListFiles(ctx, types.FileFilters{Orphaned: true})
+			if err != nil {
+				t.Fatalf("ListFiles() error = %v", err)
+			}
+			var got []string
+			for _, f := range list {
+				got = append(got, f.ID)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, []string{"dangling", "untracked"}) {
+				t.Errorf("Expected the untracked and dangling files, got %v", got)
+			}
+		})
+	}
+}
+
+func TestSetFileTags(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	dir := &types.Directory{ID: "dir", Name: "dir", Path: "/data", CreatedAt: time.Now()}
+	if err := repo.CreateDirectory(ctx, dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	file := &types.File{ID: "file", Filename: "a.mp4", FilePath: "/data/a.mp4", DirectoryID: "dir", Tags: []string{"keep", "old"}}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if err := repo.SetFileTags(ctx, "file", []string{"keep", "new"}); err != nil {
+		t.Fatalf("SetFileTags() error = %v", err)
+	}
+
+	tags, err := repo.GetFileTags(ctx, "file")
+	if err != nil {
+		t.Fatalf("GetFileTags() error = %v", err)
+	}
+	if len(tags) != 2 || tags[0] != "keep" || tags[1] != "new" {
+		t.Errorf("Expected tags [keep new], got %v", tags)
+	}
+
+	if err := repo.SetFileTags(ctx, "file", nil); err != nil {
+		t.Fatalf("SetFileTags() error = %v", err)
+	}
+	if tags, _ := repo.GetFileTags(ctx, "file"); len(tags) != 0 {
+		t.Errorf("Expected no tags, got %v", tags)
+	}
+
+	if err := repo.SetFileTags(ctx, "missing", []string{"keep"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for missing file, got %v", err)
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		includeOutput bool
+		want          []string
+	}{
+		{"by tool", "wget", false, []string{"iso"}},
+		{"by argument", "ubuntu", false, []string{"iso"}},
+		{"by command newest first", "yt-dlp", false, []string{"video2", "video1"}},
+		{"output ignored by default", "Destination", false, []string{}},
+		{"output included", "Destination", true, []string{"video1"}},
+		{"by notes", "480P", false, []string{"video2"}},
+	}
+
+	for repoName, newRepo := range repos {
+		for _, tt := range tests {
+			t.Run(repoName+"/"+tt.name, func(t *testing.T) {
+				repo := newRepo(t)
+				ctx := context.Background()
+				now := time.Now()
+
+				tasks := []types.TaskData{
+					{ID: "iso", Tool: "wget", Command: "wget", Args: []string{"https://releases.ubuntu.com/linux.iso"}, CreatedAt: now.Add(-2 * time.Hour)},
+					{ID: "video1", Tool: "yt-dlp", Command: "yt-dlp", Args: []string{"https://example.com/a"}, CreatedAt: now.Add(-time.Hour)},
+					{ID: "video2", Tool: "yt-dlp", Command: "yt-dlp", Args: []string{"https://example.com/b"}, CreatedAt: now, Notes: "re-download, first one was 480p"},
+				}
+				for _, task := range tasks {
+					task.Status = types.StatusComplete
+					if err := repo.Create(ctx, task); err != nil {
+						t.Fatalf("Failed to create task: %v", err)
+					}
+				}
+				if err := repo.AppendOutput(ctx, "video1", "[download] Destination: a.mp4"); err != nil {
+					t.Fatalf("Failed to append output: %v", err)
+				}
+
+				results, err := repo.ListTasks(ctx, types.TaskFilters{Query: tt.query, QueryOutput: tt.includeOutput, Limit: 10})
+				if err != nil {
+					t.Fatalf("ListTasks() error = %v", err)
+				}
+
+				got := make([]string, 0, len(results))
+				for _, r := range results {
+					got = append(got, r.ID)
+					if len(r.Output) != 0 {
+						t.Errorf("Expected summaries without output, got %v", r.Output)
+					}
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("Expected %v, got %v", tt.want, got)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestToolStats(t *testing.T) {
+	repos := map[string]func(t *testing.T) fullRepository{
+		"sqlite": func(t *testing.T) fullRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) fullRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			tasks := []types.TaskData{
+				{ID: "fast", Status: types.StatusComplete, StartedAt: now.Add(-10 * time.Second), EndedAt: now},
+				{ID: "slow", Status: types.StatusComplete, StartedAt: now.Add(-30 * time.Second), EndedAt: now},
+				{ID: "broken", Status: types.StatusFailed, StartedAt: now.Add(-5 * time.Second), EndedAt: now},
+				{ID: "waiting", Status: types.StatusQueued},
+				{ID: "ancient", Status: types.StatusFailed, CreatedAt: now.AddDate(0, 0, -60)},
+			}
+			for _, task := range tasks {
+				task.Tool = "wget"
+				task.Command = "wget"
+				if task.CreatedAt.IsZero() {
+					task.CreatedAt = now.Add(-time.Minute)
+				}
+				if err := repo.Create(ctx, task); err != nil {
+					t.Fatalf("Failed to create task: %v", err)
+				}
+			}
+			other := types.TaskData{ID: "other", Tool: "yt-dlp", Command: "yt-dlp", Status: types.StatusComplete, CreatedAt: now}
+			if err := repo.Create(ctx, other); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+
+			dir := &types.Directory{ID: "dir", Name: "dir", Path: "/data", CreatedAt: now}
+			if err := repo.CreateDirectory(ctx, dir); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			for i, taskID := range []string{"fast", "slow", "other"} {
+				id := taskID
+				file := &types.File{ID: id, Filename: id, FilePath: "/data/" + id, DirectoryID: "dir", TaskID: &id, FileSize: int64(100 * (i + 1))}
+				if err := repo.CreateFile(ctx, file); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+			}
+
+			stats, err := repo.ToolStats(ctx, "wget", now.AddDate(0, 0, -30))
+			if err != nil {
+				t.Fatalf("ToolStats() error = %v", err)
+			}
+
+			if stats.Total != 4 || stats.Completed != 2 || stats.Failed != 1 || stats.Queued != 1 {
+				t.Errorf("Unexpected counts: %+v", stats)
+			}
+			if want := 2.0 / 3.0; math.Abs(stats.SuccessRate-want) > 1e-9 {
+				t.Errorf("Expected success rate %.3f, got %.3f", want, stats.SuccessRate)
+			}
+			if math.Abs(stats.AvgDurationSeconds-20) > 0.01 {
+				t.Errorf("Expected average duration 20s, got %.3f", stats.AvgDurationSeconds)
+			}
+			if math.Abs(stats.P50DurationSeconds-10) > 0.01 || math.Abs(stats.P95DurationSeconds-30) > 0.01 {
+				t.Errorf("Expected p50 10s and p95 30s, got %.3f and %.3f", stats.P50DurationSeconds, stats.P95DurationSeconds)
+			}
+			// Tasks were created a minute ago, so they waited 50s, 30s and 55s
+			if stats.AvgQueueWaitMs != 45000 || stats.P95QueueWaitMs != 55000 {
+				t.Errorf("Expected average queue wait 45000ms and p95 55000ms, got %d and %d", stats.AvgQueueWaitMs, stats.P95QueueWaitMs)
+			}
+			if stats.FilesDiscovered != 2 || stats.BytesDiscovered != 300 {
+				t.Errorf("Expected 2 files and 300 bytes, got %d files and %d bytes", stats.FilesDiscovered, stats.BytesDiscovered)
+			}
+
+			allTime, err := repo.ToolStats(ctx, "wget", time.Time{})
+			if err != nil {
+				t.Fatalf("ToolStats() error = %v", err)
+			}
+			if allTime.Total != 5 || allTime.Failed != 2 {
+				t.Errorf("Expected all-time totals to include old tasks, got %+v", allTime)
+			}
+		})
+	}
+}
+
+func TestMigrateAddsResourceUsageColumns(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	// Create a tasks table as older versions did, without the usage columns
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE tasks (
+		id TEXT PRIMARY KEY, tool TEXT NOT NULL, command TEXT NOT NULL, args TEXT NOT NULL,
+		status TEXT NOT NULL, error TEXT, created_at DATETIME NOT NULL,
+		started_at DATETIME, ended_at DATETIME
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO tasks VALUES ('old', 'wget', 'wget', '[]', 'complete', '', ?, NULL, NULL)`, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to insert old task: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open migrated repository: %v", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	}()
+	ctx := context.Background()
+
+	data, err := repo.GetByID(ctx, "old")
+	if err != nil {
+		t.Fatalf("Failed to read old task: %v", err)
+	}
+	if data.CPUUserMs != nil || data.CPUSystemMs != nil || data.MaxRSSBytes != nil {
+		t.Errorf("Expected nil usage for old task, got %v %v %v", data.CPUUserMs, data.CPUSystemMs, data.MaxRSSBytes)
+	}
+	if data.Organize {
+		t.Error("Expected old task not to organize files")
+	}
+	if data.Tags != nil {
+		t.Errorf("Expected no tags for old task, got %v", data.Tags)
+	}
+
+	user, system, rss := int64(1500), int64(250), int64(64<<20)
+	data.CPUUserMs, data.CPUSystemMs, data.MaxRSSBytes = &user, &system, &rss
+	data.Organize = true
+	data.Tags = []string{"gallery", "art"}
+	data.ExpectedOutput = []string{"/tmp/out.mp4"}
+	data.TimeoutSeconds = 120
+	data.Options = map[string]string{"f": "best"}
+	data.PositionalArgs = []string{"https://example.com/a"}
+	data.Notes = "mirror of the release"
+	data.CancelReason = "wrong format"
+	data.RerunOf = "older"
+	data.Argv = []string{"yt-dlp", "-f", "best", "https://example.com/a"}
+	if err := repo.Update(ctx, data); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+
+	data, err = repo.GetByID(ctx, "old")
+	if err != nil {
+		t.Fatalf("Failed to read updated task: %v", err)
+	}
+	if data.CPUUserMs == nil || *data.CPUUserMs != user || data.MaxRSSBytes == nil || *data.MaxRSSBytes != rss {
+		t.Errorf("Resource usage not persisted: %+v", data)
+	}
+	if !data.Organize {
+		t.Error("Organize flag not persisted")
+	}
+	if !reflect.DeepEqual(data.Tags, []string{"gallery", "art"}) {
+		t.Errorf("Tags not persisted: %v", data.Tags)
+	}
+	if !reflect.DeepEqual(data.ExpectedOutput, []string{"/tmp/out.mp4"}) {
+		t.Errorf("Expected output not persisted: %v", data.ExpectedOutput)
+	}
+	if data.TimeoutSeconds != 120 {
+		t.Errorf("Timeout not persisted: %d", data.TimeoutSeconds)
+	}
+	if !reflect.DeepEqual(data.Options, map[string]string{"f": "best"}) || !reflect.DeepEqual(data.PositionalArgs, []string{"https://example.com/a"}) {
+		t.Errorf("Structured args not persisted: %v %v", data.Options, data.PositionalArgs)
+	}
+	if data.Notes != "mirror of the release" {
+		t.Errorf("Notes not persisted: %q", data.Notes)
+	}
+	if data.CancelReason != "wrong format" {
+		t.Errorf("Cancel reason not persisted: %q", data.CancelReason)
+	}
+	if data.RerunOf != "older" {
+		t.Errorf("Rerun link not persisted: %q", data.RerunOf)
+	}
+	if !reflect.DeepEqual(data.Argv, []string{"yt-dlp", "-f", "best", "https://example.com/a"}) {
+		t.Errorf("Argv not persisted: %v", data.Argv)
+	}
+}
+
+func TestAppendOutputDuringDeleteTask(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	task := types.TaskData{ID: "racing", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusRunning, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	// Keep writing output while the task is deleted underneath the writer
+	errs := make(chan error, 1)
+	deleted := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			err := repo.AppendOutput(ctx, task.ID, fmt.Sprintf("line %d", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if i == 10 {
+				close(deleted)
+			}
+			// Output arrives line by line, not in a tight loop that would
+			// starve the deleting connection of the write lock
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	<-deleted
+	if err := repo.DeleteTask(ctx, task.ID); err != nil {
+		t.Fatalf("DeleteTask() error = %v", err)
+	}
+
+	if err := <-errs; !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound appending to a deleted task, got %v", err)
+	}
+
+	var orphans int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM task_outputs WHERE task_id = ?`, task.ID).Scan(&orphans); err != nil {
+		t.Fatalf("Failed to count output: %v", err)
+	}
+	if orphans != 0 {
+		t.Errorf("Expected no output rows for the deleted task, got %d", orphans)
+	}
+
+	if err := repo.DeleteTask(ctx, task.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing task, got %v", err)
+	}
+}
+
+func TestMigrateDropsToolsForeignKey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	// Older versions referenced a tools table that was never created
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE download_directories (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL, path TEXT NOT NULL, tool_name TEXT,
+		default_dir BOOLEAN DEFAULT false, created_at DATETIME NOT NULL,
+		FOREIGN KEY (tool_name) REFERENCES tools(name)
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO download_directories VALUES ('old', 'Old', '/tmp/old', 'wget', false, ?)`, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to insert old directory: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open migrated repository: %v", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	}()
+	ctx := context.Background()
+
+	if _, err := repo.GetDirectory(ctx, "old"); err != nil {
+		t.Fatalf("Failed to read old directory: %v", err)
+	}
+
+	tool := "yt-dlp"
+	dir := &types.Directory{ID: "new", Name: "New", Path: "/tmp/new", ToolName: &tool, CreatedAt: time.Now()}
+	if err := repo.CreateDirectory(ctx, dir); err != nil {
+		t.Fatalf("Failed to create directory with a tool name: %v", err)
+	}
+}
+
+func TestMigrateMergesDuplicateDirectoryPaths(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	// Older versions had no unique index and stored paths as given
+	if _, err := repo.db.Exec(`DROP INDEX idx_download_directories_path`); err != nil {
+		t.Fatalf("Failed to drop index: %v", err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	for i, path := range []string{"/tmp/media", "/tmp//media/"} {
+		dir := &types.Directory{ID: fmt.Sprintf("dir%d", i), Name: "Media", Path: path, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := repo.CreateDirectory(ctx, dir); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	file := &types.File{ID: "file", Filename: "a.mp4", FilePath: "/tmp/media/a.mp4", DirectoryID: "dir1", CreatedAt: now, AccessedAt: now}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Failed to close repository: %v", err)
+	}
+
+	repo, err = NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open migrated repository: %v", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	}()
+
+	dirs, err := repo.ListDirectories(ctx, types.DirectoryFilters{})
+	if err != nil {
+		t.Fatalf("Failed to list directories: %v", err)
+	}
+	if len(dirs) != 1 || dirs[0].ID != "dir0" || dirs[0].Path != "/tmp/media" {
+		t.Fatalf("Expected only the oldest directory to remain, got %+v", dirs)
+	}
+	moved, err := repo.GetFile(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if moved.DirectoryID != "dir0" {
+		t.Errorf("Expected file to move to dir0, got %s", moved.DirectoryID)
+	}
+
+	dup := &types.Directory{ID: "dup", Name: "Dup", Path: "/tmp/media", CreatedAt: now}
+	if err := repo.CreateDirectory(ctx, dup); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for a duplicate path, got %v", err)
+	}
+}
+
+func TestForeignKeysPreventOrphans(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	var enabled bool
+	if err := repo.db.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil || !enabled {
+		t.Fatalf("Expected foreign keys to be enforced, got %v (%v)", enabled, err)
+	}
+
+	missingTask := "missing-task"
+	orphan := &types.File{ID: "orphan", Filename: "a.mp4", FilePath: "/tmp/a.mp4", DirectoryID: "missing-dir", CreatedAt: time.Now(), AccessedAt: time.Now()}
+	if err := repo.CreateFile(ctx, orphan); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound creating a file in a missing directory, got %v", err)
+	}
+
+	dir := &types.Directory{ID: "dir", Name: "Dir", Path: "/tmp/dir", CreatedAt: time.Now()}
+	if err := repo.CreateDirectory(ctx, dir); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	orphan.DirectoryID = dir.ID
+	orphan.TaskID = &missingTask
+	if err := repo.CreateFile(ctx, orphan); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound creating a file for a missing task, got %v", err)
+	}
+	if err := repo.AddFileTag(ctx, "missing-file", "tag"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound tagging a missing file, got %v", err)
+	}
+	if err := repo.AppendOutput(ctx, missingTask, "output"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound appending output to a missing task, got %v", err)
+	}
+
+	// Deleting a task keeps its files but unlinks them
+	task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusComplete, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	file := &types.File{ID: "file", Filename: "b.mp4", FilePath: "/tmp/b.mp4", DirectoryID: dir.ID, TaskID: &task.ID, Tags: []string{"video"}, CreatedAt: time.Now(), AccessedAt: time.Now()}
+	if err := repo.CreateFile(ctx, file); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := repo.DeleteTask(ctx, task.ID); err != nil {
+		t.Fatalf("DeleteTask() error = %v", err)
+	}
+	kept, err := repo.GetFile(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("Expected file to outlive its task: %v", err)
+	}
+	if kept.TaskID != nil {
+		t.Errorf("Expected file to be unlinked from the deleted task, got %v", *kept.TaskID)
+	}
+
+	// Deleting a file removes its tags with it
+	if err := repo.DeleteFile(ctx, file.ID); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	var tags int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM file_tags WHERE file_id = ?`, file.ID).Scan(&tags); err != nil {
+		t.Fatalf("Failed to count tags: %v", err)
+	}
+	if tags != 0 {
+		t.Errorf("Expected no tags left for the deleted file, got %d", tags)
+	}
+}
+
+func TestDeleteDirectoryWithFiles(t *testing.T) {
+	repos := map[string]func(t *testing.T) FileRepository{
+		"sqlite": func(t *testing.T) FileRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) FileRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			dir := &types.Directory{ID: "dir", Name: "Dir", Path: "/tmp/dir", CreatedAt: time.Now()}
+			if err := repo.CreateDirectory(ctx, dir); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			file := &types.File{ID: "file", Filename: "a.mp4", FilePath: "/tmp/dir/a.mp4", DirectoryID: dir.ID, CreatedAt: time.Now(), AccessedAt: time.Now()}
+			if err := repo.CreateFile(ctx, file); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+
+			if err := repo.DeleteDirectory(ctx, dir.ID); !errors.Is(err, ErrInUse) {
+				t.Fatalf("Expected ErrInUse deleting a directory with files, got %v", err)
+			}
+
+			if err := repo.DeleteFile(ctx, file.ID); err != nil {
+				t.Fatalf("DeleteFile() error = %v", err)
+			}
+			if err := repo.DeleteDirectory(ctx, dir.ID); err != nil {
+				t.Errorf("Expected empty directory to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDirectoryUsage(t *testing.T) {
+	repos := map[string]func(t *testing.T) FileRepository{
+		"sqlite": func(t *testing.T) FileRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) FileRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			for _, id := range []string{"full", "empty"} {
+				dir := &types.Directory{ID: id, Name: id, Path: "/tmp/" + id, CreatedAt: now}
+				if err := repo.CreateDirectory(ctx, dir); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+			}
+			for i, size := range []int64{100, 250} {
+				file := &types.File{ID: fmt.Sprint(i), Filename: fmt.Sprint(i), FilePath: fmt.Sprintf("/tmp/full/%d", i), DirectoryID: "full", FileSize: size, CreatedAt: now, AccessedAt: now}
+				if err := repo.CreateFile(ctx, file); err != nil {
+					t.Fatalf("Failed to create file: %v", err)
+				}
+			}
+
+			usage, err := repo.DirectoryUsage(ctx)
+			if err != nil {
+				t.Fatalf("DirectoryUsage() error = %v", err)
+			}
+			want := map[string]types.DirectoryUsage{"full": {FileCount: 2, TotalSize: 350}}
+			if !reflect.DeepEqual(usage, want) {
+				t.Errorf("Expected usage %v, got %v", want, usage)
+			}
+		})
+	}
+}
+
+func TestGetOutput(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusRunning, CreatedAt: time.Now()}
+			if err := repo.Create(ctx, task); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+			for i := 0; i < 10; i++ {
+				if err := repo.AppendOutput(ctx, task.ID, fmt.Sprintf("line %d", i)); err != nil {
+					t.Fatalf("Failed to append output: %v", err)
+				}
+			}
+
+			tests := []struct {
+				offset, limit int
+				wantOffset    int
+				want          []string
+			}{
+				{0, 2, 0, []string{"line 0", "line 1"}},
+				{8, 5, 8, []string{"line 8", "line 9"}},
+				{-3, 2, 7, []string{"line 7", "line 8"}},
+				{-20, 1, 0, []string{"line 0"}},
+				{15, 5, 10, []string{}},
+			}
+			for _, tt := range tests {
+				page, err := repo.GetOutput(ctx, task.ID, "", tt.offset, tt.limit)
+				if err != nil {
+					t.Fatalf("GetOutput(%d, %d) error = %v", tt.offset, tt.limit, err)
+				}
+				if page.Total != 10 || page.Offset != tt.wantOffset || !reflect.DeepEqual(page.Lines, tt.want) {
+					t.Errorf("GetOutput(%d, %d) = %+v, want offset %d and lines %v", tt.offset, tt.limit, page, tt.wantOffset, tt.want)
+				}
+			}
+
+			if _, err := repo.GetOutput(ctx, "missing", "", 0, 10); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a missing task, got %v", err)
+			}
+		})
+	}
+}
+
+func TestListTasks(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			statuses := []types.Status{types.StatusComplete, types.StatusFailed, types.StatusQueued, types.StatusFailed, types.StatusCanceled}
+			for i, status := range statuses {
+				tool, host := "wget", "example.com"
+				if i%2 == 1 {
+					tool, host = "yt-dlp", "cdn.example.org"
+				}
+				task := types.TaskData{ID: fmt.Sprintf("task-%d", i), Tool: tool, Command: tool, Args: []string{fmt.Sprintf("https://%s/%d", host, i)}, Status: status, CreatedAt: now.Add(time.Duration(i) * time.Minute), SourceHost: host}
+				if err := repo.Create(ctx, task); err != nil {
+					t.Fatalf("Failed to create task: %v", err)
+				}
+			}
+			if err := repo.AppendOutput(ctx, "task-1", "saved"); err != nil {
+				t.Fatalf("Failed to append output: %v", err)
+			}
+
+			from := now.Add(30 * time.Second)
+			tests := []struct {
+				name    string
+				filters types.TaskFilters
+				want    []string
+			}{
+				{"all newest first", types.TaskFilters{}, []string{"task-4", "task-3", "task-2", "task-1", "task-0"}},
+				{"oldest first", types.TaskFilters{Sort: types.TaskSortOldest}, []string{"task-0", "task-1", "task-2", "task-3", "task-4"}},
+				{"several statuses", types.TaskFilters{Statuses: []types.Status{types.StatusFailed, types.StatusCanceled}}, []string{"task-4", "task-3", "task-1"}},
+				{"tool and status", types.TaskFilters{Tool: "yt-dlp", Statuses: []types.Status{types.StatusFailed}}, []string{"task-3", "task-1"}},
+				{"query and window", types.TaskFilters{Query: "example", CreatedFrom: &from}, []string{"task-4", "task-3", "task-2", "task-1"}},
+				{"query in output", types.TaskFilters{Query: "SAVED", QueryOutput: true}, []string{"task-1"}},
+				{"host", types.TaskFilters{Host: "example.com"}, []string{"task-4", "task-2", "task-0"}},
+				{"host with subdomains", types.TaskFilters{Host: "Example.ORG"}, []string{"task-3", "task-1"}},
+				{"partial host", types.TaskFilters{Host: "ample.com"}, []string{}},
+				{"page", types.TaskFilters{Offset: 1, Limit: 2}, []string{"task-3", "task-2"}},
+				{"offset only", types.TaskFilters{Sort: types.TaskSortOldest, Offset: 3}, []string{"task-3", "task-4"}},
+				{"offset past the end", types.TaskFilters{Offset: 10}, []string{}},
+			}
+			for _, tt := range tests {
+				tasks, err := repo.ListTasks(ctx, tt.filters)
+				if err != nil {
+					t.Fatalf("%s: ListTasks() error = %v", tt.name, err)
+				}
+				ids := []string{}
+				for _, data := range tasks {
+					ids = append(ids, data.ID)
+					if len(data.Output) != 0 {
+						t.Errorf("%s: Expected no output unless requested, got %v", tt.name, data.Output)
+					}
+				}
+				if !reflect.DeepEqual(ids, tt.want) {
+					t.Errorf("%s: Expected %v, got %v", tt.name, tt.want, ids)
+				}
+			}
+
+			tasks, err := repo.ListTasks(ctx, types.TaskFilters{Statuses: []types.Status{types.StatusFailed}, WithOutput: true, Sort: types.TaskSortOldest})
+			if err != nil || len(tasks) != 2 || !reflect.DeepEqual(tasks[0].Output, []string{"saved"}) {
+				t.Errorf("Expected the failed tasks with their output, got %+v: %v", tasks, err)
+			}
+		})
+	}
+}
+
+func TestEach(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+			now := time.Now()
+
+			statuses := []types.Status{types.StatusComplete, types.StatusComplete, types.StatusFailed}
+			for i, tool := range []string{"wget", "yt-dlp", "wget"} {
+				task := types.TaskData{ID: fmt.Sprintf("task-%d", i), Tool: tool, Command: tool, Args: []string{}, Status: statuses[i], CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+				if err := repo.Create(ctx, task); err != nil {
+					t.Fatalf("Failed to create task: %v", err)
+				}
+			}
+			if err := repo.AppendOutput(ctx, "task-2", "saved"); err != nil {
+				t.Fatalf("Failed to append output: %v", err)
+			}
+
+			var ids []string
+			err := repo.Each(ctx, types.TaskFilters{Tool: "wget", WithOutput: true}, func(data types.TaskData) error {
+				ids = append(ids, data.ID)
+				if data.ID == "task-2" && !reflect.DeepEqual(data.Output, []string{"saved"}) {
+					t.Errorf("Expected output to be loaded, got %v", data.Output)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Each() error = %v", err)
+			}
+			if !reflect.DeepEqual(ids, []string{"task-2", "task-0"}) {
+				t.Errorf("Expected wget tasks newest first, got %v", ids)
+			}
+
+			// Tasks created within the window only, the end is exclusive
+			from, to := now.Add(30*time.Second), now.Add(2*time.Minute)
+			ids = nil
+			err = repo.Each(ctx, types.TaskFilters{CreatedFrom: &from, CreatedTo: &to}, func(data types.TaskData) error {
+				ids = append(ids, data.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Each() error = %v", err)
+			}
+			if !reflect.DeepEqual(ids, []string{"task-1"}) {
+				t.Errorf("Expected only the task within the window, got %v", ids)
+			}
+
+			ids = nil
+			err = repo.Each(ctx, types.TaskFilters{Statuses: []types.Status{types.StatusFailed}}, func(data types.TaskData) error {
+				ids = append(ids, data.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Each() error = %v", err)
+			}
+			if !reflect.DeepEqual(ids, []string{"task-2"}) {
+				t.Errorf("Expected only the failed task, got %v", ids)
+			}
+
+			// An error from the callback stops the iteration
+			stop := errors.New("stop")
+			calls := 0
+			err = repo.Each(ctx, types.TaskFilters{}, func(types.TaskData) error {
+				calls++
+				return stop
+			})
+			if !errors.Is(err, stop) || calls != 1 {
+				t.Errorf("Expected iteration to stop after one task, got %d calls and %v", calls, err)
+			}
+		})
+	}
+}
+
+func TestGetOutputStream(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusRunning, CreatedAt: time.Now()}
+			if err := repo.Create(ctx, task); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+			for i := 0; i < 6; i++ {
+				line := fmt.Sprintf("progress %d%%", i*20)
+				if i%3 == 2 {
+					line = types.StderrPrefix + fmt.Sprintf("retry %d", i)
+				}
+				if err := repo.AppendOutput(ctx, task.ID, line); err != nil {
+					t.Fatalf("Failed to append output: %v", err)
+				}
+			}
+
+			page, err := repo.GetOutput(ctx, task.ID, types.StreamStderr, 0, 10)
+			if err != nil {
+				t.Fatalf("GetOutput(stderr) error = %v", err)
+			}
+			want := []string{"[ERROR] retry 2", "[ERROR] retry 5"}
+			if page.Total != 2 || page.Stream != types.StreamStderr || !reflect.DeepEqual(page.Lines, want) {
+				t.Errorf("Expected only stderr lines %v, got %+v", want, page)
+			}
+
+			// Offsets count within the stream
+			page, err = repo.GetOutput(ctx, task.ID, types.StreamStdout, -1, 10)
+			if err != nil {
+				t.Fatalf("GetOutput(stdout) error = %v", err)
+			}
+			if page.Total != 4 || page.Offset != 3 || !reflect.DeepEqual(page.Lines, []string{"progress 80%"}) {
+				t.Errorf("Expected the last stdout line, got %+v", page)
+			}
+		})
+	}
+}
+
+func TestAppendOutputLines(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusRunning, CreatedAt: time.Now()}
+			if err := repo.Create(ctx, task); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+			if err := repo.AppendOutput(ctx, task.ID, "Resolving example.com"); err != nil {
+				t.Fatalf("Failed to append output: %v", err)
+			}
+			if err := repo.AppendOutputLines(ctx, task.ID, []string{"Connecting", types.StderrPrefix + "Connection reset", "Saved README.md"}); err != nil {
+				t.Fatalf("AppendOutputLines() error = %v", err)
+			}
+
+			page, err := repo.GetOutput(ctx, task.ID, "", 0, 10)
+			if err != nil {
+				t.Fatalf("GetOutput() error = %v", err)
+			}
+			want := []string{"Resolving example.com", "Connecting", "[ERROR] Connection reset", "Saved README.md"}
+			if !reflect.DeepEqual(page.Lines, want) {
+				t.Errorf("Expected lines %v in order, got %v", want, page.Lines)
+			}
+
+			if err := repo.AppendOutputLines(ctx, "missing", []string{"line"}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a missing task, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressOutput(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusComplete, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	lines := []string{"Resolving example.com", types.StderrPrefix + "Connection reset", "Saved README.md"}
+	for _, line := range lines {
+		if err := repo.AppendOutput(ctx, task.ID, line); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+
+	if err := repo.CompressOutput(ctx, task.ID); err != nil {
+		t.Fatalf("CompressOutput() error = %v", err)
+	}
+	var rows int
+	if err := repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM task_outputs`).Scan(&rows); err != nil || rows != 0 {
+		t.Errorf("Expected the output lines to be compacted, %d left: %v", rows, err)
+	}
+
+	// Reading is unaffected, and lines appended later follow the compressed ones
+	if err := repo.AppendOutput(ctx, task.ID, "late line"); err != nil {
+		t.Fatalf("Failed to append output: %v", err)
+	}
+	want := append(append([]string{}, lines...), "late line")
+	data, err := repo.GetByID(ctx, task.ID)
+	if err != nil || !reflect.DeepEqual(data.Output, want) {
+		t.Errorf("Expected output %v, got %v: %v", want, data.Output, err)
+	}
+	page, err := repo.GetOutput(ctx, task.ID, types.StreamStdout, -2, 10)
+	if err != nil || page.Total != 3 || !reflect.DeepEqual(page.Lines, []string{"Saved README.md", "late line"}) {
+		t.Errorf("Expected the last stdout lines, got %+v: %v", page, err)
+	}
+	found, err := repo.ListTasks(ctx, types.TaskFilters{Query: "connection RESET", QueryOutput: true})
+	if err != nil || len(found) != 1 {
+		t.Errorf("Expected to find the task by its compressed output, got %d: %v", len(found), err)
+	}
+
+	// Compressing again folds the late line in
+	if err := repo.CompressOutput(ctx, task.ID); err != nil {
+		t.Fatalf("CompressOutput() error = %v", err)
+	}
+	if data, err := repo.GetByID(ctx, task.ID); err != nil || !reflect.DeepEqual(data.Output, want) {
+		t.Errorf("Expected output %v after compressing again, got %v: %v", want, data.Output, err)
+	}
+
+	if err := repo.DeleteTask(ctx, task.ID); err != nil {
+		t.Fatalf("DeleteTask() error = %v", err)
+	}
+	if err := repo.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM task_output_archives`).Scan(&rows); err != nil || rows != 0 {
+		t.Errorf("Expected the compressed output to be deleted with the task, %d left: %v", rows, err)
+	}
+}
+
+func TestMigrateTaskOutputStream(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	// Older versions stored stderr lines only with their prefix
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE tasks (
+		id TEXT PRIMARY KEY, tool TEXT NOT NULL, command TEXT NOT NULL, args TEXT NOT NULL,
+		status TEXT NOT NULL, error TEXT, created_at DATETIME NOT NULL,
+		started_at DATETIME, ended_at DATETIME
+	);
+	CREATE TABLE task_outputs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, task_id TEXT NOT NULL, output TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO tasks VALUES ('old', 'wget', 'wget', '[]', 'complete', '', ?, NULL, NULL)`, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to insert old task: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO task_outputs (task_id, output) VALUES ('old', 'saving'), ('old', '[ERROR] timed out'), ('old', 'done')`)
+	if err != nil {
+		t.Fatalf("Failed to insert old output: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open migrated repository: %v", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	}()
+
+	page, err := repo.GetOutput(context.Background(), "old", types.StreamStderr, 0, 10)
+	if err != nil {
+		t.Fatalf("GetOutput() error = %v", err)
+	}
+	if !reflect.DeepEqual(page.Lines, []string{"[ERROR] timed out"}) {
+		t.Errorf("Expected existing stderr lines to be backfilled, got %v", page.Lines)
+	}
+}
+
+func TestMigrateSourceHost(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE tasks (
+		id TEXT PRIMARY KEY, tool TEXT NOT NULL, command TEXT NOT NULL, args TEXT NOT NULL,
+		status TEXT NOT NULL, error TEXT, created_at DATETIME NOT NULL,
+		started_at DATETIME, ended_at DATETIME
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	_, err = old.Exec(`INSERT INTO tasks VALUES
+		('download', 'yt-dlp', 'yt-dlp', '["-f", "best", "https://WWW.YouTube.com/watch?v=1"]', 'complete', '', ?, NULL, NULL),
+		('convert', 'ffmpeg', 'ffmpeg', '["-i", "in.mkv", "out.mp4"]', 'complete', '', ?, NULL, NULL)`, time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("Failed to insert old tasks: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	repo, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open migrated repository: %v", err)
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			t.Errorf("Failed to close repository: %v", err)
+		}
+	}()
+
+	ctx := context.Background()
+	for id, want := range map[string]string{"download": "www.youtube.com", "convert": ""} {
+		data, err := repo.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if data.SourceHost != want {
+			t.Errorf("Expected task %s to get source host %q, got %q", id, want, data.SourceHost)
+		}
+	}
+}
+
+func TestWorkerOverrides(t *testing.T) {
+	repos := map[string]func(t *testing.T) WorkerStore{
+		"sqlite": func(t *testing.T) WorkerStore { return newTestRepository(t) },
+		"mock":   func(t *testing.T) WorkerStore { return NewMockRepository() },
+	}
+	ctx := context.Background()
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+
+			overrides, err := repo.WorkerOverrides(ctx)
+			if err != nil {
+				t.Fatalf("WorkerOverrides failed: %v", err)
+			}
+			if len(overrides) != 0 {
+				t.Errorf("Expected no overrides, got %v", overrides)
+			}
+
+			// A later count replaces the earlier one
+			for _, o := range []struct {
+				tool    string
+				workers int
+			}{{"yt-dlp", 4}, {"wget", 1}, {"yt-dlp", 3}} {
+				if err := repo.SetWorkerOverride(ctx, o.tool, o.workers); err != nil {
+					t.Fatalf("SetWorkerOverride failed: %v", err)
+				}
+			}
+
+			overrides, err = repo.WorkerOverrides(ctx)
+			if err != nil {
+				t.Fatalf("WorkerOverrides failed: %v", err)
+			}
+			if want := map[string]int{"yt-dlp": 3, "wget": 1}; !reflect.DeepEqual(overrides, want) {
+				t.Errorf("Expected overrides %v, got %v", want, overrides)
+			}
+		})
+	}
+}
+
+func TestSearchOutput(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+	lines := []string{
+		"Resolving example.com",
+		"Downloading 100% of 5.2MiB",
+		types.StderrPrefix + "ERROR: unable to download webpage",
+		"Saved to Straße_50%.mp4",
+		"Error summary: 1 failed",
+	}
+
+	tests := []struct {
+		name          string
+		search        types.OutputSearch
+		wantLines     []int
+		wantTruncated bool
+	}{
+		{"ignores case", types.OutputSearch{Query: "error", Limit: 10}, []int{2, 4}, false},
+		{"case sensitive", types.OutputSearch{Query: "Error", CaseSensitive: true, Limit: 10}, []int{4}, false},
+		{"like wildcards are literal", types.OutputSearch{Query: "_50%", Limit: 10}, []int{3}, false},
+		{"non-ascii", types.OutputSearch{Query: "STRASSE", Limit: 10}, nil, false},
+		{"non-ascii folding", types.OutputSearch{Query: "STRAßE", Limit: 10}, []int{3}, false},
+		{"regex", types.OutputSearch{Query: `\d+(\.\d+)?MiB`, Regex: true, Limit: 10}, []int{1}, false},
+		{"regex alternation", types.OutputSearch{Query: "^(resolving|saved)", Regex: true, Limit: 10}, []int{0, 3}, false},
+		{"limit", types.OutputSearch{Query: "o", Limit: 2}, []int{0, 1}, true},
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			task := types.TaskData{ID: "task", Tool: "yt-dlp", Command: "yt-dlp", Args: []string{}, Status: types.StatusRunning, CreatedAt: time.Now()}
+			if err := repo.Create(ctx, task); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+			for _, line := range lines {
+				if err := repo.AppendOutput(ctx, task.ID, line); err != nil {
+					t.Fatalf("Failed to append output: %v", err)
+				}
+			}
+
+			for _, tt := range tests {
+				result, err := repo.SearchOutput(ctx, task.ID, tt.search)
+				if err != nil {
+					t.Fatalf("%s: SearchOutput() error = %v", tt.name, err)
+				}
+				var got []int
+				for _, match := range result.Matches {
+					got = append(got, match.Line)
+					if match.Snippet != lines[match.Line] {
+						t.Errorf("%s: expected snippet %q, got %q", tt.name, lines[match.Line], match.Snippet)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.wantLines) || result.Truncated != tt.wantTruncated {
+					t.Errorf("%s: expected lines %v (truncated %v), got %v (truncated %v)", tt.name, tt.wantLines, tt.wantTruncated, got, result.Truncated)
+				}
+			}
+
+			result, err := repo.SearchOutput(ctx, task.ID, types.OutputSearch{Query: "unable", Limit: 10})
+			if err != nil || len(result.Matches) != 1 || result.Matches[0].Stream != types.StreamStderr {
+				t.Errorf("Expected a stderr match, got %+v: %v", result, err)
+			}
+			if _, err := repo.SearchOutput(ctx, "missing", types.OutputSearch{Query: "x", Limit: 10}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for a missing task, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSearchOutputInvalid(t *testing.T) {
+	repo := NewMockRepository()
+	ctx := context.Background()
+	if err := repo.Create(ctx, types.TaskData{ID: "task", Tool: "wget", Command: "wget", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	searches := []types.OutputSearch{
+		{Query: ""},
+		{Query: strings.Repeat("a", maxSearchQueryLength+1)},
+		{Query: "(unclosed", Regex: true},
+		{Query: "(a{1,100}){1,100}", Regex: true},
+		{Query: "a{1,1000}", Regex: true},
+	}
+	for _, search := range searches {
+		if _, err := repo.SearchOutput(ctx, "task", search); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("Query %.20q: expected ErrInvalidSearch, got %v", search.Query, err)
+		}
+	}
+}
+
+func TestSearchCompressedOutput(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	task := types.TaskData{ID: "task", Tool: "wget", Command: "wget", Args: []string{}, Status: types.StatusComplete, CreatedAt: time.Now()}
+	if err := repo.Create(ctx, task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	for _, line := range []string{"saving a.txt", "done", "saving b.txt"} {
+		if err := repo.AppendOutput(ctx, task.ID, line); err != nil {
+			t.Fatalf("Failed to append output: %v", err)
+		}
+	}
+	if err := repo.CompressOutput(ctx, task.ID); err != nil {
+		t.Fatalf("CompressOutput() error = %v", err)
+	}
+	if err := repo.AppendOutput(ctx, task.ID, "saving c.txt"); err != nil {
+		t.Fatalf("Failed to append output: %v", err)
+	}
+
+	// Lines appended after compressing are numbered after the compressed ones
+	result, err := repo.SearchOutput(ctx, task.ID, types.OutputSearch{Query: "saving", Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchOutput() error = %v", err)
+	}
+	var got []int
+	for _, match := range result.Matches {
+		got = append(got, match.Line)
+	}
+	if want := []int{0, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected lines %v, got %v", want, got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	short := "short line"
+	if got := snippet(short, 0, 5); got != short {
+		t.Errorf("Expected a short line in full, got %q", got)
+	}
+
+	long := strings.Repeat("a", 300) + "TOKEN" + strings.Repeat("ä", 150)
+	got := snippet(long, 300, 305)
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "TOKEN") {
+		t.Errorf("Expected the text around the match, got %q", got)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("Expected a valid UTF-8 snippet, got %q", got)
+	}
+	if len(got) > maxSnippetLength {
+		t.Errorf("Expected at most %d bytes, got %d", maxSnippetLength, len(got))
+	}
+}
+
+func TestListDirectoriesByTool(t *testing.T) {
+	repos := map[string]func(t *testing.T) FileRepository{
+		"sqlite": func(t *testing.T) FileRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) FileRepository { return NewMockRepository() },
+	}
+	ctx := context.Background()
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ytdlp, wget := "yt-dlp", "wget"
+			dirs := []*types.Directory{
+				{ID: "videos", Name: "Videos", Path: "/tmp/videos", ToolName: &ytdlp, CreatedAt: time.Now()},
+				{ID: "music", Name: "Music", Path: "/tmp/music", ToolName: &ytdlp, CreatedAt: time.Now()},
+				{ID: "web", Name: "Web", Path: "/tmp/web", ToolName: &wget, CreatedAt: time.Now()},
+				{ID: "default", Name: "Default", Path: "/tmp/default", DefaultDir: true, CreatedAt: time.Now()},
+			}
+			for _, dir := range dirs {
+				if err := repo.CreateDirectory(ctx, dir); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+			}
+
+			tests := []struct {
+				tool string
+				want []string
+			}{
+				{"", []string{"default", "music", "videos", "web"}},
+				{"yt-dlp", []string{"music", "videos"}},
+				{"wget", []string{"web"}},
+				{"curl", nil},
+			}
+			for _, tt := range tests {
+				found, err := repo.ListDirectories(ctx, types.DirectoryFilters{ToolName: tt.tool})
+				if err != nil {
+					t.Fatalf("ListDirectories(%q) error = %v", tt.tool, err)
+				}
+				var ids []string
+				for _, dir := range found {
+					ids = append(ids, dir.ID)
+				}
+				sort.Strings(ids)
+				if !reflect.DeepEqual(ids, tt.want) {
+					t.Errorf("ListDirectories(%q) = %v, want %v", tt.tool, ids, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+	ctx := context.Background()
+	created := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	data := types.TaskData{
+		ID:        "imported",
+		Tool:      "wget",
+		Command:   "wget",
+		Args:      []string{"https://example.com"},
+		Status:    types.StatusComplete,
+		Output:    []string{"saving to index.html", "[STDERR] 100%"},
+		CreatedAt: created,
+		StartedAt: created.Add(time.Second),
+		EndedAt:   created.Add(time.Minute),
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+
+			if err := repo.Import(ctx, data); err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			got, err := repo.GetByID(ctx, data.ID)
+			if err != nil {
+				t.Fatalf("GetByID failed: %v", err)
+			}
+			if got.Status != data.Status || !got.CreatedAt.Equal(data.CreatedAt) || !got.EndedAt.Equal(data.EndedAt) {
+				t.Errorf("Expected metadata of %+v, got %+v", data, got)
+			}
+			if !reflect.DeepEqual(got.Output, data.Output) {
+				t.Errorf("Expected output %v, got %v", data.Output, got.Output)
+			}
+
+			duplicate := data
+			duplicate.Output = []string{"replaced"}
+			if err := repo.Import(ctx, duplicate); !errors.Is(err, ErrAlreadyExists) {
+				t.Fatalf("Expected ErrAlreadyExists for a duplicate, got %v", err)
+			}
+			got, err = repo.GetByID(ctx, data.ID)
+			if err != nil {
+				t.Fatalf("GetByID failed: %v", err)
+			}
+			if !reflect.DeepEqual(got.Output, data.Output) {
+				t.Errorf("Expected the duplicate to leave the output alone, got %v", got.Output)
+			}
+		})
+	}
+}
+
+func TestCreateFilesSkipsTrackedPaths(t *testing.T) {
+	repos := map[string]func(t *testing.T) FileRepository{
+		"sqlite": func(t *testing.T) FileRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) FileRepository { return NewMockRepository() },
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			if err := repo.CreateDirectory(ctx, &types.Directory{ID: "dir", Name: "dir", Path: "/data", CreatedAt: now}); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			newFile := func(id, path string) *types.File {
+				return &types.File{ID: id, Filename: filepath.Base(path), FilePath: path, DirectoryID: "dir", CreatedAt: now, AccessedAt: now}
+			}
+			if err := repo.CreateFile(ctx, newFile("old", "/data/a.mp3")); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+
+			created, err := repo.CreateFiles(ctx, []*types.File{
+				newFile("a", "/data/a.mp3"),
+				newFile("b", "/data/b.mp3"),
+				newFile("c", "/data/c.mp3"),
+			})
+			if err != nil {
+				t.Fatalf("CreateFiles failed: %v", err)
+			}
+			var ids []string
+			for _, file := range created {
+				ids = append(ids, file.ID)
+			}
+			if want := []string{"b", "c"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("Expected files %v to be created, got %v", want, ids)
+			}
+			if _, err := repo.GetFile(ctx, "a"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected the file with a tracked path to be skipped, got %v", err)
+			}
+		})
+	}
+}
+
+func TestToolFailures(t *testing.T) {
+	repos := map[string]func(t *testing.T) TaskRepository{
+		"sqlite": func(t *testing.T) TaskRepository { return newTestRepository(t) },
+		"mock":   func(t *testing.T) TaskRepository { return NewMockRepository() },
+	}
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			tasks := []types.TaskData{
+				{ID: "ok", Status: types.StatusComplete, EndedAt: now.Add(-time.Hour)},
+				{ID: "older", Status: types.StatusFailed, Error: "Command failed: exit status 8", EndedAt: now.Add(-2 * time.Hour)},
+				{ID: "newer", Status: types.StatusFailed, Error: "Command failed: exit status 4", EndedAt: now.Add(-time.Minute)},
+				{ID: "latest", Status: types.StatusFailed, Error: "Task timed out", EndedAt: now},
+				{ID: "stopped", Status: types.StatusCanceled, EndedAt: now},
+				{ID: "ancient", Status: types.StatusFailed, Error: "old", EndedAt: now.AddDate(0, 0, -60)},
+			}
+			for _, task := range tasks {
+				task.Tool = "wget"
+				task.Command = "wget"
+				task.CreatedAt = task.EndedAt.Add(-time.Minute)
+				if err := repo.Create(ctx, task); err != nil {
+					t.Fatalf("Failed to create task: %v", err)
+				}
+			}
+			other := types.TaskData{ID: "other", Tool: "yt-dlp", Command: "yt-dlp", Status: types.StatusFailed, CreatedAt: now, EndedAt: now}
+			if err := repo.Create(ctx, other); err != nil {
+				t.Fatalf("Failed to create task: %v", err)
+			}
+
+			failures, err := repo.ToolFailures(ctx, "wget", now.AddDate(0, 0, -30), 2)
+			if err != nil {
+				t.Fatalf("ToolFailures failed: %v", err)
+			}
+			if failures.Finished != 4 || failures.Failed != 3 || failures.FailureRate != 0.75 {
+				t.Errorf("Expected 3 of 4 finished tasks failed, got %+v", failures)
+			}
+			var ids []string
+			for _, failure := range failures.Failures {
+				ids = append(ids, failure.TaskID)
+			}
+			if want := []string{"latest", "newer"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("Expected failures %v, got %v", want, ids)
+			}
+			if got := failures.Failures[1]; got.Error != "Command failed: exit status 4" || !got.EndedAt.Equal(now.Add(-time.Minute)) {
+				t.Errorf("Expected the error and end time of the failure, got %+v", got)
+			}
+
+			all, err := repo.ToolFailures(ctx, "wget", time.Time{}, 0)
+			if err != nil {
+				t.Fatalf("ToolFailures failed: %v", err)
+			}
+			if all.Failed != 4 || len(all.Failures) != 4 || all.Failures[3].TaskID != "ancient" {
+				t.Errorf("Expected all 4 failures without a window, got %+v", all)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	repos := map[string]func(t *testing.T) AuditLog{
+		"sqlite": func(t *testing.T) AuditLog { return newTestRepository(t) },
+		"mock":   func(t *testing.T) AuditLog { return NewMockRepository() },
+	}
+
+	for repoName, newRepo := range repos {
+		t.Run(repoName, func(t *testing.T) {
+			repo := newRepo(t)
+			ctx := context.Background()
+
+			now := time.Now()
+			entries := []types.AuditEntry{
+				{CreatedAt: now.Add(-48 * time.Hour), Actor: "anonymous", RemoteAddr: "10.0.0.2:4000", Action: "POST /api/tasks", Summary: "POST /api/tasks", Status: 201},
+				{CreatedAt: now.Add(-time.Hour), Actor: "api-key", RemoteAddr: "10.0.0.1:4000", Action: "POST /api/admin/vacuum", Summary: "POST /api/admin/vacuum", Status: 200},
+				{CreatedAt: now, Actor: "anonymous", RemoteAddr: "10.0.0.2:4000", Action: "POST /api/tasks/{id}/cancel", Summary: "POST /api/tasks/abc/cancel", Status: 200},
+			}
+			for i := range entries {
+				if err := repo.RecordAudit(ctx, &entries[i]); err != nil {
+					t.Fatalf("RecordAudit() error = %v", err)
+				}
+				if entries[i].ID == 0 {
+					t.Errorf("Expected entry %d to get an ID", i)
+				}
+			}
+
+			page, err := repo.ListAudit(ctx, types.AuditFilters{Limit: 2})
+			if err != nil {
+				t.Fatalf("ListAudit() error = %v", err)
+			}
+			if page.Total != 3 || len(page.Entries) != 2 || page.Entries[0].Action != "POST /api/tasks/{id}/cancel" || page.Entries[1].Actor != "api-key" {
+				t.Errorf("Expected the two newest of 3 entries, got %+v", page)
+			}
+
+			page, err = repo.ListAudit(ctx, types.AuditFilters{Offset: 2, Limit: 2})
+			if err != nil {
+				t.Fatalf("ListAudit() error = %v", err)
+			}
+			if page.Total != 3 || len(page.Entries) != 1 || page.Entries[0].Action != "POST /api/tasks" {
+				t.Errorf("Expected the oldest entry on the second page, got %+v", page)
+			}
+
+			since := now.Add(-24 * time.Hour)
+			page, err = repo.ListAudit(ctx, types.AuditFilters{Actor: "anonymous", Since: &since, Limit: 10})
+			if err != nil {
+				t.Fatalf("ListAudit() error = %v", err)
+			}
+			if page.Total != 1 || len(page.Entries) != 1 || page.Entries[0].Summary != "POST /api/tasks/abc/cancel" {
+				t.Errorf("Expected the recent anonymous entry, got %+v", page)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"math"
+	"sort"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// addStatusCount adds count tasks with the given status to stats
+func addStatusCount(stats *types.ToolStats, status types.Status, count int) {
+	stats.Total += count
+	switch status {
+	case types.StatusQueued:
+		stats.Queued += count
+	case types.StatusRunning:
+		stats.Running += count
+	case types.StatusComplete:
+		stats.Completed += count
+	case types.StatusFailed:
+		stats.Failed += count
+	case types.StatusCanceled:
+		stats.Canceled += count
+	}
+}
+
+// finishToolStats derives the success rate, duration and queue wait
+// summaries. durations are the run times in seconds of completed tasks and
+// waits the queue waits in milliseconds of tasks that started.
+func finishToolStats(stats *types.ToolStats, durations, waits []float64) {
+	if finished := stats.Completed + stats.Failed; finished > 0 {
+		stats.SuccessRate = float64(stats.Completed) / float64(finished)
+	}
+
+	if len(waits) > 0 {
+		sort.Float64s(waits)
+		var total float64
+		for _, w := range waits {
+			total += w
+		}
+		stats.AvgQueueWaitMs = int64(total / float64(len(waits)))
+		stats.P95QueueWaitMs = int64(percentile(waits, 95))
+	}
+
+	if len(durations) == 0 {
+		return
+	}
+
+	sort.Float64s(durations)
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+	stats.AvgDurationSeconds = total / float64(len(durations))
+	stats.P50DurationSeconds = percentile(durations, 50)
+	stats.P95DurationSeconds = percentile(durations, 95)
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = max(rank, 1)
+	return sorted[rank-1]
+}
+
+// finishToolFailures derives the failure rate from the counts
+func finishToolFailures(failures *types.ToolFailures) {
+	if failures.Finished > 0 {
+		failures.FailureRate = float64(failures.Failed) / float64(failures.Finished)
+	}
+}
@@ -2,68 +2,171 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/lepinkainen/commander/internal/events"
 	"github.com/lepinkainen/commander/internal/files"
+	"github.com/lepinkainen/commander/internal/notify"
 	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/types"
 )
 
+// Errors returned when a task can't be added to the manager or edited
+var (
+	ErrTaskExists = errors.New("task already exists")
+	ErrQueueFull  = errors.New("queue is full")
+	ErrNoQueue    = errors.New("no queue for tool")
+	ErrNotQueued  = errors.New("task is no longer queued")
+)
+
 // Manager manages all tasks
 type Manager struct {
 	repo          storage.TaskRepository
 	tasks         map[string]*Task // In-memory cache for active tasks
 	queues        map[string]chan *Task
+	queueSizes    map[string]int     // Buffer size asked for, see CreateQueue
+	pending       map[string][]*Task // Queued tasks in queue order, see ClaimTask
 	mu            sync.RWMutex
-	listeners     []chan TaskEvent
+	bus           *events.Bus
 	fileDiscovery *files.FileDiscovery
+	notifiers     []notify.Notifier
+	compressor    storage.OutputCompressor
 }
 
-// TaskEvent represents a task state change
+// TaskEvent represents a task state change, published on events.TopicTasks
 type TaskEvent struct {
 	TaskID string `json:"task_id"`
 	Type   string `json:"type"`
 	Data   string `json:"data"`
+
+	// Reason is set on the status event of a task canceled with a reason
+	Reason string `json:"reason,omitempty"`
+
+	// QueuePosition is set on events about a task waiting in its queue,
+	// 1 being the next one a worker picks up
+	QueuePosition int `json:"queue_position,omitempty"`
 }
 
 // NewManager creates a new task manager
 func NewManager(repo storage.TaskRepository) *Manager {
 	return &Manager{
-		repo:      repo,
-		tasks:     make(map[string]*Task),
-		queues:    make(map[string]chan *Task),
-		listeners: make([]chan TaskEvent, 0),
+		repo:       repo,
+		tasks:      make(map[string]*Task),
+		queues:     make(map[string]chan *Task),
+		queueSizes: make(map[string]int),
+		pending:    make(map[string][]*Task),
+		bus:        events.NewBus(),
 	}
 }
 
+// SetEventBus sets the bus task events are published on, so they can be
+// shared with other publishers
+func (m *Manager) SetEventBus(bus *events.Bus) {
+	m.bus = bus
+}
+
+// EventBus returns the bus task events are published on
+func (m *Manager) EventBus() *events.Bus {
+	return m.bus
+}
+
 // SetFileDiscovery sets the file discovery service for the manager
 func (m *Manager) SetFileDiscovery(fd *files.FileDiscovery) {
 	m.fileDiscovery = fd
 }
 
-// CreateQueue creates a new queue for a tool
+// SetNotifiers sets the notifiers told about tasks that finish, fail or are
+// canceled
+func (m *Manager) SetNotifiers(notifiers ...notify.Notifier) {
+	m.notifiers = notifiers
+}
+
+// SetOutputCompressor makes the manager compress the output of tasks once
+// they finish. Nil keeps output uncompressed.
+func (m *Manager) SetOutputCompressor(compressor storage.OutputCompressor) {
+	m.compressor = compressor
+}
+
+// CreateQueue creates a queue for a tool holding up to bufferSize waiting
+// tasks. Calling it again with the same size returns the existing queue.
+// With a different size the queue is recreated: the waiting tasks are moved
+// to the new queue in order and the old one is closed, so workers must pick
+// up the new queue with Queue once theirs is closed. When more tasks are
+// waiting than the new size allows, no tasks are accepted until enough of
+// them have been picked up.
 func (m *Manager) CreateQueue(tool string, bufferSize int) chan *Task {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.queues[tool]; !exists {
-		m.queues[tool] = make(chan *Task, bufferSize)
+	old, exists := m.queues[tool]
+	if exists && m.queueSizes[tool] == bufferSize {
+		return old
+	}
+
+	// Tasks are only sent while holding m.mu, so the old queue can only
+	// shrink from here on, as workers keep taking tasks from it
+	queue := make(chan *Task, max(bufferSize, len(old)))
+	if exists {
+	move:
+		for {
+			select {
+			case task := <-old:
+				queue <- task
+			default:
+				break move
+			}
+		}
+		close(old)
 	}
-	return m.queues[tool]
+	m.queues[tool] = queue
+	m.queueSizes[tool] = bufferSize
+	return queue
+}
+
+// Queue returns the current queue of a tool
+func (m *Manager) Queue(tool string) (chan *Task, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	queue, ok := m.queues[tool]
+	return queue, ok
+}
+
+// queueWithRoom returns the queue of a tool if it has room for another
+// task. The caller must hold m.mu and keep holding it until the task is
+// sent.
+func (m *Manager) queueWithRoom(tool string) (chan *Task, error) {
+	queue, ok := m.queues[tool]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoQueue, tool)
+	}
+	if len(queue) >= m.queueSizes[tool] {
+		return nil, fmt.Errorf("%w: %s", ErrQueueFull, tool)
+	}
+	return queue, nil
 }
 
 // AddTask adds a new task to the manager
-func (m *Manager) AddTask(task *Task) error {
+func (m *Manager) AddTask(ctx context.Context, task *Task) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if _, exists := m.tasks[task.ID]; exists {
-		return fmt.Errorf("task %s already exists", task.ID)
+		return fmt.Errorf("task %s: %w", task.ID, ErrTaskExists)
+	}
+
+	// Check for queue capacity before persisting so a rejected task doesn't
+	// leave an orphaned row behind. Tasks are only ever enqueued here while
+	// holding m.mu, so the free slot can't be taken before we send.
+	queue, err := m.queueWithRoom(task.Tool)
+	if err != nil {
+		return err
 	}
 
 	// Save to database
-	ctx := context.Background()
 	if err := m.repo.Create(ctx, task.Clone()); err != nil {
 		return fmt.Errorf("failed to save task to database: %w", err)
 	}
@@ -71,27 +174,46 @@ func (m *Manager) AddTask(task *Task) error {
 	// Add to in-memory cache
 	m.tasks[task.ID] = task
 
-	// Send to appropriate queue
-	if queue, ok := m.queues[task.Tool]; ok {
-		select {
-		case queue <- task:
-			m.broadcastEvent(TaskEvent{
-				TaskID: task.ID,
-				Type:   "created",
-				Data:   fmt.Sprintf("Task %s queued for %s", task.ID, task.Tool),
-			})
-		default:
-			return fmt.Errorf("queue for %s is full", task.Tool)
-		}
-	} else {
-		return fmt.Errorf("no queue for tool %s", task.Tool)
+	// Send to the tool's queue
+	queue <- task
+	m.enqueued(task)
+	m.broadcastEvent(TaskEvent{
+		TaskID:        task.ID,
+		Type:          "created",
+		Data:          fmt.Sprintf("Task %s queued for %s", task.ID, task.Tool),
+		QueuePosition: task.QueuePosition,
+	})
+
+	return nil
+}
+
+// ImportTask stores a finished task exported from another instance, with
+// its output. It isn't queued or cached, only the database holds it. It
+// returns ErrTaskExists if a task with the same ID exists.
+func (m *Manager) ImportTask(ctx context.Context, data types.TaskData) error {
+	m.mu.RLock()
+	_, exists := m.tasks[data.ID]
+	m.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("task %s: %w", data.ID, ErrTaskExists)
 	}
 
+	// The host is derived from the arguments, exports from older versions
+	// don't carry it
+	data.SourceHost = types.ExtractHost(data.Args)
+
+	err := m.repo.Import(ctx, data)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		return fmt.Errorf("task %s: %w", data.ID, ErrTaskExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import task: %w", err)
+	}
 	return nil
 }
 
 // GetTask returns a task by ID
-func (m *Manager) GetTask(id string) (*Task, error) {
+func (m *Manager) GetTask(ctx context.Context, id string) (*Task, error) {
 	m.mu.RLock()
 	// First check in-memory cache for active tasks
 	task, exists := m.tasks[id]
@@ -102,7 +224,6 @@ func (m *Manager) GetTask(id string) (*Task, error) {
 	}
 
 	// If not in cache, try to load from database
-	ctx := context.Background()
 	data, err := m.repo.GetByID(ctx, id)
 	if err != nil {
 		return nil, err
@@ -113,11 +234,24 @@ func (m *Manager) GetTask(id string) (*Task, error) {
 	return dbTask, nil
 }
 
+// ListTasks returns the tasks matching filters from the database
+func (m *Manager) ListTasks(ctx context.Context, filters types.TaskFilters) ([]*Task, error) {
+	data, err := m.repo.ListTasks(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, len(data))
+	for i, d := range data {
+		tasks[i] = &Task{TaskData: d}
+	}
+	return tasks, nil
+}
+
 // GetAllTasks returns all tasks
-func (m *Manager) GetAllTasks() []*Task {
+func (m *Manager) GetAllTasks(ctx context.Context) []*Task {
 	// Load all tasks from database
-	ctx := context.Background()
-	data, err := m.repo.List(ctx)
+	tasks, err := m.ListTasks(ctx, types.TaskFilters{WithOutput: true})
 	if err != nil {
 		// Fallback to in-memory tasks if database fails
 		m.mu.RLock()
@@ -128,20 +262,13 @@ func (m *Manager) GetAllTasks() []*Task {
 		}
 		return memoryTasks
 	}
-
-	// Convert TaskData slice to Task slice
-	tasks := make([]*Task, len(data))
-	for i, d := range data {
-		tasks[i] = &Task{TaskData: d}
-	}
 	return tasks
 }
 
 // GetTasksByTool returns tasks for a specific tool
-func (m *Manager) GetTasksByTool(tool string) []*Task {
+func (m *Manager) GetTasksByTool(ctx context.Context, tool string) []*Task {
 	// Load tasks from database
-	ctx := context.Background()
-	data, err := m.repo.ListByTool(ctx, tool)
+	tasks, err := m.ListTasks(ctx, types.TaskFilters{Tool: tool, WithOutput: true})
 	if err != nil {
 		// Fallback to in-memory tasks if database fails
 		m.mu.RLock()
@@ -154,138 +281,287 @@ func (m *Manager) GetTasksByTool(tool string) []*Task {
 		}
 		return memoryTasks
 	}
-
-	// Convert TaskData slice to Task slice
-	tasks := make([]*Task, len(data))
-	for i, d := range data {
-		tasks[i] = &Task{TaskData: d}
-	}
 	return tasks
 }
 
+// EachTask calls fn with every task matching filters, in the order they
+// are read from the database. An error from fn stops the iteration and is
+// returned.
+func (m *Manager) EachTask(ctx context.Context, filters types.TaskFilters, fn func(task *Task) error) error {
+	return m.repo.Each(ctx, filters, func(data types.TaskData) error {
+		return fn(&Task{TaskData: data})
+	})
+}
+
+// GetToolStats returns historical statistics for a tool's tasks created at
+// or after since. A zero since covers all tasks.
+func (m *Manager) GetToolStats(ctx context.Context, tool string, since time.Time) (types.ToolStats, error) {
+	return m.repo.ToolStats(ctx, tool, since)
+}
+
+// GetToolFailures returns the failure rate of a tool's tasks that ended at
+// or after since and its last limit failed tasks
+func (m *Manager) GetToolFailures(ctx context.Context, tool string, since time.Time, limit int) (types.ToolFailures, error) {
+	return m.repo.ToolFailures(ctx, tool, since, limit)
+}
+
+// GetTaskOutput returns up to limit of a task's output lines starting at
+// offset. A negative offset counts back from the last line. A non-empty
+// stream limits the output to that stream's lines.
+func (m *Manager) GetTaskOutput(ctx context.Context, id string, stream types.Stream, offset, limit int) (types.OutputPage, error) {
+	return m.repo.GetOutput(ctx, id, stream, offset, limit)
+}
+
+// SearchTaskOutput returns the lines of a task's output matching search
+func (m *Manager) SearchTaskOutput(ctx context.Context, id string, search types.OutputSearch) (types.OutputSearchResult, error) {
+	return m.repo.SearchOutput(ctx, id, search)
+}
+
 // UpdateTaskStatus updates a task's status and broadcasts the change
-func (m *Manager) UpdateTaskStatus(taskID string, status types.Status) error {
-	task, err := m.GetTask(taskID)
+func (m *Manager) UpdateTaskStatus(ctx context.Context, taskID string, status types.Status) error {
+	task, err := m.GetTask(ctx, taskID)
 	if err != nil {
 		return err
 	}
 
 	task.SetStatus(status)
+	if status != types.StatusQueued {
+		m.leaveQueue(task)
+	}
 
-	// If task is completing and we have file discovery, process files
-	if status == types.StatusComplete && m.fileDiscovery != nil {
-		go m.processTaskFiles(taskID, task.Tool, task.Output)
+	// If task is completing and we have file discovery, process files, and
+	// notify once the files are known
+	switch {
+	case status == types.StatusComplete && m.fileDiscovery != nil:
+		dirFiles, scanned := task.OutputDirFiles()
+		go func(data types.TaskData) {
+			m.notify(data, m.processTaskFiles(data, dirFiles, scanned))
+		}(task.Clone())
+	case isTerminal(status):
+		go m.notify(task.Clone(), 0)
 	}
 
 	// Update in database
-	ctx := context.Background()
 	if err := m.repo.Update(ctx, task.Clone()); err != nil {
 		// Log error but don't fail - we can continue with in-memory
 		fmt.Printf("Warning: failed to update task in database: %v\n", err)
 	}
 
-	m.broadcastEvent(TaskEvent{
+	// A finished task's output no longer grows, so it can be compacted
+	if isTerminal(status) && m.compressor != nil {
+		if err := m.compressor.CompressOutput(ctx, taskID); err != nil {
+			fmt.Printf("Warning: failed to compress output of task %s: %v\n", taskID, err)
+		}
+	}
+
+	event := TaskEvent{
 		TaskID: taskID,
 		Type:   "status",
 		Data:   string(status),
-	})
+	}
+	if status == types.StatusCanceled {
+		event.Reason = task.Clone().CancelReason
+	}
+	m.broadcastEvent(event)
 
 	return nil
 }
 
-// AppendTaskOutput appends output to a task and broadcasts it
-func (m *Manager) AppendTaskOutput(taskID string, output string) error {
-	task, err := m.GetTask(taskID)
+// CancelTask marks a task canceled by the user, recording why. The reason
+// may be empty.
+func (m *Manager) CancelTask(ctx context.Context, taskID, reason string) error {
+	task, err := m.GetTask(ctx, taskID)
 	if err != nil {
 		return err
 	}
 
-	task.AppendOutput(output)
+	task.SetCancelReason(reason)
+	return m.UpdateTaskStatus(ctx, taskID, types.StatusCanceled)
+}
 
-	// Save output to database
-	ctx := context.Background()
-	if err := m.repo.AppendOutput(ctx, taskID, output); err != nil {
-		// Log error but don't fail - we can continue with in-memory
-		fmt.Printf("Warning: failed to save output to database: %v\n", err)
+// SetTaskNotes replaces a task's notes and broadcasts the change. Notes can
+// be edited in any status.
+func (m *Manager) SetTaskNotes(ctx context.Context, taskID, notes string) (*Task, error) {
+	task, err := m.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	task.SetNotes(notes)
+	if err := m.repo.Update(ctx, task.Clone()); err != nil {
+		return nil, fmt.Errorf("failed to save task notes: %w", err)
 	}
 
 	m.broadcastEvent(TaskEvent{
 		TaskID: taskID,
-		Type:   "output",
-		Data:   output,
+		Type:   "notes",
+		Data:   notes,
 	})
 
-	return nil
+	return task, nil
 }
 
-// Subscribe creates a new event listener channel
-func (m *Manager) Subscribe() chan TaskEvent {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// EditQueuedTask changes a task that is still waiting in its queue and
+// broadcasts the change. The queue holds the same *Task as the cache, so
+// the task is edited in place and keeps its position. Tasks that have been
+// picked up by a worker, finished, or were queued by a previous server
+// process fail with ErrNotQueued.
+func (m *Manager) EditQueuedTask(ctx context.Context, taskID string, edit func(data *types.TaskData) error) (*Task, error) {
+	m.mu.RLock()
+	task, exists := m.tasks[taskID]
+	m.mu.RUnlock()
+
+	if !exists {
+		// Only tasks queued by this process are in the cache
+		if _, err := m.repo.GetByID(ctx, taskID); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("task %s: %w", taskID, ErrNotQueued)
+	}
+
+	if err := task.EditQueued(edit); err != nil {
+		return nil, err
+	}
+	if err := m.repo.Update(ctx, task.Clone()); err != nil {
+		return nil, fmt.Errorf("failed to save task: %w", err)
+	}
+
+	m.broadcastEvent(TaskEvent{
+		TaskID: taskID,
+		Type:   "updated",
+		Data:   fmt.Sprintf("Task %s edited while queued", taskID),
+	})
 
-	ch := make(chan TaskEvent, 100)
-	m.listeners = append(m.listeners, ch)
-	return ch
+	return task, nil
 }
 
-// Unsubscribe removes an event listener
-func (m *Manager) Unsubscribe(ch chan TaskEvent) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// AppendTaskOutput appends output to a task and broadcasts it
+func (m *Manager) AppendTaskOutput(ctx context.Context, taskID string, output string) error {
+	return m.AppendTaskOutputLines(ctx, taskID, []string{output})
+}
 
-	for i, listener := range m.listeners {
-		if listener == ch {
-			m.listeners = append(m.listeners[:i], m.listeners[i+1:]...)
-			close(ch)
-			break
-		}
+// AppendTaskOutputLines appends several lines of output to a task with a
+// single database write and broadcasts each of them
+func (m *Manager) AppendTaskOutputLines(ctx context.Context, taskID string, lines []string) error {
+	task, err := m.GetTask(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	// Save output to database
+	err = m.repo.AppendOutputLines(ctx, taskID, lines)
+	if errors.Is(err, storage.ErrNotFound) {
+		// The task was deleted while running, drop its remaining output
+		return nil
+	}
+	if err != nil {
+		// Log error but don't fail - we can continue with in-memory
+		fmt.Printf("Warning: failed to save output to database: %v\n", err)
+	}
+
+	for _, output := range lines {
+		task.AppendOutput(output)
+
+		m.broadcastEvent(TaskEvent{
+			TaskID: taskID,
+			Type:   "output",
+			Data:   output,
+		})
 	}
+
+	return nil
 }
 
-// broadcastEvent sends an event to all listeners
+// PublishEvent sends an event about a task to all subscribers
+func (m *Manager) PublishEvent(event TaskEvent) {
+	m.broadcastEvent(event)
+}
+
+// broadcastEvent publishes an event on the tasks topic
 func (m *Manager) broadcastEvent(event TaskEvent) {
-	for _, listener := range m.listeners {
-		select {
-		case listener <- event:
-		default:
-			// Skip if listener is full
-		}
-	}
+	m.bus.Publish(events.TopicTasks, event)
 }
 
-// processTaskFiles handles file discovery and organization for completed tasks
-func (m *Manager) processTaskFiles(taskID, toolName string, output []string) {
+// processTaskFiles handles file discovery for completed tasks. Tasks with
+// expected output register exactly those files instead, and tasks whose
+// output directory was scanned the dirFiles found there. Discovered files are
+// tagged with the task's tags, and moved into the tool's directory
+// when the task organizes files or registered in place otherwise. It runs in
+// the background after the status update returns, so it uses its own context
+// rather than the caller's. It returns the number of files found.
+func (m *Manager) processTaskFiles(data types.TaskData, dirFiles []string, scanned bool) int {
 	ctx := context.Background()
 
-	// Discover files from task output
-	discoveredFiles, err := m.fileDiscovery.DiscoverFilesFromOutput(ctx, taskID, toolName, output)
-	if err != nil {
-		fmt.Printf("Warning: failed to discover files for task %s: %v\n", taskID, err)
-		return
+	// Use the expected output or the output directory's new files, or
+	// discover files from task output
+	discoveredFiles := data.ExpectedOutput
+	if len(discoveredFiles) == 0 && scanned {
+		discoveredFiles = dirFiles
+	} else if len(discoveredFiles) == 0 {
+		var err error
+		discoveredFiles, err = m.fileDiscovery.DiscoverFilesFromOutput(ctx, data.ID, data.Tool, data.Output)
+		if err != nil {
+			fmt.Printf("Warning: failed to discover files for task %s: %v\n", data.ID, err)
+			return 0
+		}
 	}
 
 	if len(discoveredFiles) > 0 {
-		fmt.Printf("Discovered %d files for task %s\n", len(discoveredFiles), taskID)
+		fmt.Printf("Discovered %d files for task %s\n", len(discoveredFiles), data.ID)
 
-		// Organize files by tool/date pattern
-		if err := m.fileDiscovery.OrganizeFilesByPattern(ctx, taskID, toolName, discoveredFiles); err != nil {
-			fmt.Printf("Warning: failed to organize files for task %s: %v\n", taskID, err)
+		if data.Organize {
+			// Move files into the tool's directory using its organize pattern
+			if err := m.fileDiscovery.OrganizeFilesByPattern(ctx, data.ID, data.Tool, discoveredFiles, data.Tags); err != nil {
+				fmt.Printf("Warning: failed to organize files for task %s: %v\n", data.ID, err)
+			}
+		} else if err := m.fileDiscovery.RegisterDiscoveredFiles(ctx, data.ID, discoveredFiles, data.Tags); err != nil {
+			fmt.Printf("Warning: failed to register files for task %s: %v\n", data.ID, err)
 		}
 
 		// Broadcast file discovery event
 		m.broadcastEvent(TaskEvent{
-			TaskID: taskID,
+			TaskID: data.ID,
 			Type:   "files_discovered",
 			Data:   fmt.Sprintf("Discovered %d files", len(discoveredFiles)),
 		})
 	}
+	return len(discoveredFiles)
+}
+
+// isTerminal reports whether a task with status is done for good
+func isTerminal(status types.Status) bool {
+	return status == types.StatusComplete || status == types.StatusFailed || status == types.StatusCanceled
+}
+
+// notify tells every notifier that a task ended. Failures are logged and
+// otherwise ignored, a notification never affects the task.
+func (m *Manager) notify(data types.TaskData, files int) {
+	if len(m.notifiers) == 0 {
+		return
+	}
+
+	n := notify.Notification{
+		TaskID: data.ID,
+		Tool:   data.Tool,
+		Status: data.Status,
+		Error:  data.Error,
+		Files:  files,
+	}
+	for _, notifier := range m.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), notify.DefaultTimeout)
+		if err := notifier.Notify(ctx, n); err != nil {
+			fmt.Printf("Warning: %s notification for task %s failed: %v\n", notifier.Name(), data.ID, err)
+		}
+		cancel()
+	}
 }
 
 // GetQueueStats returns statistics about all queues
-func (m *Manager) GetQueueStats() map[string]QueueStats {
+func (m *Manager) GetQueueStats(ctx context.Context) map[string]QueueStats {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	now := time.Now()
 	stats := make(map[string]QueueStats)
 	for tool, queue := range m.queues {
 		// Create a local variable that we can modify
@@ -300,13 +576,19 @@ func (m *Manager) GetQueueStats() map[string]QueueStats {
 				switch task.GetStatus() {
 				case types.StatusRunning:
 					toolStats.Running++
+				case types.StatusQueued:
+					// CreatedAt never changes, so it's safe to read unlocked
+					wait := now.Sub(task.CreatedAt).Milliseconds()
+					toolStats.OldestQueueWaitMs = max(toolStats.OldestQueueWaitMs, wait)
 				}
 			}
 		}
 
 		// Count completed/failed from database
-		ctx := context.Background()
-		allTasks, err := m.repo.ListByTool(ctx, tool)
+		allTasks, err := m.repo.ListTasks(ctx, types.TaskFilters{
+			Tool:     tool,
+			Statuses: []types.Status{types.StatusComplete, types.StatusFailed},
+		})
 		if err == nil {
 			for _, taskData := range allTasks {
 				switch taskData.Status {
@@ -332,4 +614,18 @@ type QueueStats struct {
 	Running   int    `json:"running"`
 	Completed int    `json:"completed"`
 	Failed    int    `json:"failed"`
+
+	// OldestQueueWaitMs is how long the longest waiting queued task has been
+	// waiting for a worker. A value that keeps growing means the tool's
+	// workers are starved or stuck.
+	OldestQueueWaitMs int64 `json:"oldest_queue_wait_ms"`
+
+	// Worker utilization, filled in by the API from the executor since the
+	// manager doesn't know about workers
+	Workers     int `json:"workers"`
+	BusyWorkers int `json:"busy_workers"`
+
+	// StalledTasks are running tasks that produced no output for longer than
+	// the tool's stall timeout, also filled in from the executor
+	StalledTasks int `json:"stalled_tasks"`
 }
@@ -1,10 +1,16 @@
 package task
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/notify"
 	"github.com/lepinkainen/commander/internal/storage"
 	"github.com/lepinkainen/commander/internal/types"
 )
@@ -25,8 +31,8 @@ func TestNewManager(t *testing.T) {
 		t.Error("queues map not initialized")
 	}
 
-	if manager.listeners == nil {
-		t.Error("listeners slice not initialized")
+	if manager.EventBus() == nil {
+		t.Error("event bus not initialized")
 	}
 }
 
@@ -52,9 +58,51 @@ func TestManagerCreateQueue(t *testing.T) {
 	}
 }
 
+func TestManagerRecreateQueue(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+
+	old := manager.CreateQueue("wget", 3)
+	tasks := make([]*Task, 3)
+	for i := range tasks {
+		tasks[i] = NewTask("wget", "wget", []string{fmt.Sprintf("https://example.com/%d", i)})
+		if err := manager.AddTask(ctx, tasks[i]); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+	}
+
+	// Shrinking keeps the waiting tasks, in order, but takes no more until
+	// they fit
+	queue := manager.CreateQueue("wget", 2)
+	if queue == old {
+		t.Fatal("Expected a new queue for another size")
+	}
+	if _, open := <-old; open {
+		t.Error("Expected the old queue to be closed")
+	}
+	if current, _ := manager.Queue("wget"); current != queue {
+		t.Error("Expected Queue to return the new queue")
+	}
+	if err := manager.AddTask(ctx, NewTask("wget", "wget", nil)); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull while over the new size, got %v", err)
+	}
+	for _, want := range tasks[:2] {
+		if got := <-queue; got != want {
+			t.Errorf("Expected task %s, got %s", want.ID, got.ID)
+		}
+	}
+	if err := manager.AddTask(ctx, NewTask("wget", "wget", nil)); err != nil {
+		t.Errorf("Expected room once below the new size, got %v", err)
+	}
+	if len(queue) != 2 || <-queue != tasks[2] {
+		t.Error("Expected the last moved task ahead of the new one")
+	}
+}
+
 func TestManagerAddTask(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 
 	// Create queue first
@@ -62,40 +110,44 @@ func TestManagerAddTask(t *testing.T) {
 
 	// Add task
 	task := NewTask(tool, "echo", []string{"test"})
-	err := manager.AddTask(task)
+	err := manager.AddTask(ctx, task)
 
 	if err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
 
 	// Try to add the same task again
-	err = manager.AddTask(task)
-	if err == nil {
-		t.Error("Expected error when adding duplicate task")
+	err = manager.AddTask(ctx, task)
+	if !errors.Is(err, ErrTaskExists) {
+		t.Errorf("Expected ErrTaskExists when adding duplicate task, got %v", err)
 	}
 
 	// Try to add task for non-existent queue
 	task2 := NewTask("non-existent", "echo", []string{})
-	err = manager.AddTask(task2)
-	if err == nil {
-		t.Error("Expected error when adding task for non-existent queue")
+	err = manager.AddTask(ctx, task2)
+	if !errors.Is(err, ErrNoQueue) {
+		t.Errorf("Expected ErrNoQueue when adding task for non-existent queue, got %v", err)
+	}
+	if _, err := mockRepo.GetByID(ctx, task2.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected no database row for task without a queue, got %v", err)
 	}
 }
 
 func TestManagerGetTask(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 
 	// Create queue and add task
 	manager.CreateQueue(tool, 10)
 	originalTask := NewTask(tool, "echo", []string{"test"})
-	if err := manager.AddTask(originalTask); err != nil {
+	if err := manager.AddTask(ctx, originalTask); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
 
 	// Get the task
-	retrievedTask, err := manager.GetTask(originalTask.ID)
+	retrievedTask, err := manager.GetTask(ctx, originalTask.ID)
 	if err != nil {
 		t.Fatalf("GetTask failed: %v", err)
 	}
@@ -105,7 +157,7 @@ func TestManagerGetTask(t *testing.T) {
 	}
 
 	// Try to get non-existent task
-	_, err = manager.GetTask("non-existent-id")
+	_, err = manager.GetTask(ctx, "non-existent-id")
 	if err == nil {
 		t.Error("Expected error when getting non-existent task")
 	}
@@ -114,18 +166,19 @@ func TestManagerGetTask(t *testing.T) {
 func TestManagerGetAllTasks(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 
 	// Add multiple tasks
 	tools := []string{"tool1", "tool2", "tool3"}
 	for _, tool := range tools {
 		manager.CreateQueue(tool, 10)
 		task := NewTask(tool, "echo", []string{})
-		if err := manager.AddTask(task); err != nil {
+		if err := manager.AddTask(ctx, task); err != nil {
 			t.Fatalf("AddTask failed: %v", err)
 		}
 	}
 
-	tasks := manager.GetAllTasks()
+	tasks := manager.GetAllTasks(ctx)
 
 	if len(tasks) != len(tools) {
 		t.Errorf("Expected %d tasks, got %d", len(tools), len(tasks))
@@ -135,6 +188,7 @@ func TestManagerGetAllTasks(t *testing.T) {
 func TestManagerGetTasksByTool(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 
 	// Add tasks for different tools
 	tool1 := "tool1"
@@ -146,7 +200,7 @@ func TestManagerGetTasksByTool(t *testing.T) {
 	// Add 2 tasks for tool1
 	for i := 0; i < 2; i++ {
 		task := NewTask(tool1, "echo", []string{})
-		if err := manager.AddTask(task); err != nil {
+		if err := manager.AddTask(ctx, task); err != nil {
 			t.Fatalf("AddTask failed: %v", err)
 		}
 	}
@@ -154,17 +208,17 @@ func TestManagerGetTasksByTool(t *testing.T) {
 	// Add 3 tasks for tool2
 	for i := 0; i < 3; i++ {
 		task := NewTask(tool2, "echo", []string{})
-		if err := manager.AddTask(task); err != nil {
+		if err := manager.AddTask(ctx, task); err != nil {
 			t.Fatalf("AddTask failed: %v", err)
 		}
 	}
 
-	tool1Tasks := manager.GetTasksByTool(tool1)
+	tool1Tasks := manager.GetTasksByTool(ctx, tool1)
 	if len(tool1Tasks) != 2 {
 		t.Errorf("Expected 2 tasks for %s, got %d", tool1, len(tool1Tasks))
 	}
 
-	tool2Tasks := manager.GetTasksByTool(tool2)
+	tool2Tasks := manager.GetTasksByTool(ctx, tool2)
 	if len(tool2Tasks) != 3 {
 		t.Errorf("Expected 3 tasks for %s, got %d", tool2, len(tool2Tasks))
 	}
@@ -173,28 +227,29 @@ func TestManagerGetTasksByTool(t *testing.T) {
 func TestManagerUpdateTaskStatus(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 
 	manager.CreateQueue(tool, 10)
 	task := NewTask(tool, "echo", []string{})
-	if err := manager.AddTask(task); err != nil {
+	if err := manager.AddTask(ctx, task); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
 
 	// Update status
-	err := manager.UpdateTaskStatus(task.ID, types.StatusRunning)
+	err := manager.UpdateTaskStatus(ctx, task.ID, types.StatusRunning)
 	if err != nil {
 		t.Fatalf("UpdateTaskStatus failed: %v", err)
 	}
 
 	// Verify status was updated
-	retrievedTask, _ := manager.GetTask(task.ID)
+	retrievedTask, _ := manager.GetTask(ctx, task.ID)
 	if retrievedTask.GetStatus() != types.StatusRunning {
 		t.Error("Task status was not updated")
 	}
 
 	// Try to update non-existent task
-	err = manager.UpdateTaskStatus("non-existent", types.StatusRunning)
+	err = manager.UpdateTaskStatus(ctx, "non-existent", types.StatusRunning)
 	if err == nil {
 		t.Error("Expected error when updating non-existent task")
 	}
@@ -203,65 +258,115 @@ func TestManagerUpdateTaskStatus(t *testing.T) {
 func TestManagerAppendTaskOutput(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 
 	manager.CreateQueue(tool, 10)
 	task := NewTask(tool, "echo", []string{})
-	if err := manager.AddTask(task); err != nil {
+	if err := manager.AddTask(ctx, task); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
 
 	// Append output
 	output := "test output"
-	err := manager.AppendTaskOutput(task.ID, output)
+	err := manager.AppendTaskOutput(ctx, task.ID, output)
 	if err != nil {
 		t.Fatalf("AppendTaskOutput failed: %v", err)
 	}
 
 	// Verify output was appended
-	retrievedTask, _ := manager.GetTask(task.ID)
+	retrievedTask, _ := manager.GetTask(ctx, task.ID)
 	if len(retrievedTask.Output) != 1 || retrievedTask.Output[0] != output {
 		t.Error("Output was not appended correctly")
 	}
 
+	// Output arriving after the task was deleted is dropped
+	if err := mockRepo.DeleteTask(ctx, task.ID); err != nil {
+		t.Fatalf("DeleteTask failed: %v", err)
+	}
+	if err := manager.AppendTaskOutput(ctx, task.ID, "late output"); err != nil {
+		t.Fatalf("Expected output for a deleted task to be dropped, got %v", err)
+	}
+	if retrievedTask, _ := manager.GetTask(ctx, task.ID); len(retrievedTask.Output) != 1 {
+		t.Errorf("Expected late output to be dropped, got %v", retrievedTask.Output)
+	}
+
 	// Try to append to non-existent task
-	err = manager.AppendTaskOutput("non-existent", output)
+	err = manager.AppendTaskOutput(ctx, "non-existent", output)
 	if err == nil {
 		t.Error("Expected error when appending to non-existent task")
 	}
 }
 
-func TestManagerSubscribeUnsubscribe(t *testing.T) {
+func TestManagerSetEventBus(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
 
-	// Subscribe
-	ch := manager.Subscribe()
-	if ch == nil {
-		t.Fatal("Subscribe returned nil channel")
+	bus := events.NewBus()
+	manager.SetEventBus(bus)
+	if manager.EventBus() != bus {
+		t.Fatal("Event bus not replaced")
+	}
+
+	sub := bus.Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer bus.Unsubscribe(sub)
+
+	manager.PublishEvent(TaskEvent{TaskID: "task-1", Type: "waiting"})
+
+	select {
+	case event := <-sub.C:
+		if event.Topic != events.TopicTasks {
+			t.Errorf("Expected topic %s, got %s", events.TopicTasks, event.Topic)
+		}
+	case <-time.After(time.Second):
+		t.Error("Subscriber didn't receive event from shared bus")
+	}
+}
+
+func TestManagerCancelTask(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+
+	manager.CreateQueue("wget", 10)
+	task := NewTask("wget", "wget", []string{"https://example.com/big.iso"})
+	if err := manager.AddTask(ctx, task); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
 	}
+	sub := manager.EventBus().Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer manager.EventBus().Unsubscribe(sub)
 
-	// Verify channel is in listeners
-	if len(manager.listeners) != 1 {
-		t.Error("Listener not added")
+	if err := manager.CancelTask(ctx, task.ID, "wrong mirror"); err != nil {
+		t.Fatalf("CancelTask failed: %v", err)
+	}
+	data := task.Clone()
+	if data.Status != types.StatusCanceled || data.CancelReason != "wrong mirror" || data.Error != "" {
+		t.Errorf("Expected a canceled task with its reason and no error, got %+v", data)
 	}
 
-	// Unsubscribe
-	manager.Unsubscribe(ch)
+	select {
+	case event := <-sub.C:
+		got, ok := event.Payload.(TaskEvent)
+		if !ok || got.Type != "status" || got.Data != string(types.StatusCanceled) || got.Reason != "wrong mirror" {
+			t.Errorf("Expected a canceled status event with the reason, got %+v", event.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a status event")
+	}
 
-	// Verify channel is removed
-	if len(manager.listeners) != 0 {
-		t.Error("Listener not removed")
+	if err := manager.CancelTask(ctx, "missing", ""); err == nil {
+		t.Error("Expected an error canceling a missing task")
 	}
 }
 
 func TestManagerBroadcastEvent(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
+	bus := manager.EventBus()
 
 	// Subscribe multiple listeners
-	ch1 := manager.Subscribe()
-	ch2 := manager.Subscribe()
+	sub1 := bus.Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	sub2 := bus.Subscribe(events.DefaultBufferSize)
 
 	// Add a task to trigger events
 	tool := "test-tool"
@@ -269,7 +374,7 @@ func TestManagerBroadcastEvent(t *testing.T) {
 	task := NewTask(tool, "echo", []string{})
 
 	// This should broadcast a "created" event
-	if err := manager.AddTask(task); err != nil {
+	if err := manager.AddTask(ctx, task); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
 
@@ -277,11 +382,15 @@ func TestManagerBroadcastEvent(t *testing.T) {
 	timeout := time.After(1 * time.Second)
 
 	select {
-	case event := <-ch1:
-		if event.TaskID != task.ID {
+	case event := <-sub1.C:
+		taskEvent, ok := event.Payload.(TaskEvent)
+		if !ok {
+			t.Fatalf("Expected TaskEvent payload, got %T", event.Payload)
+		}
+		if taskEvent.TaskID != task.ID {
 			t.Error("Event has wrong task ID")
 		}
-		if event.Type != "created" {
+		if taskEvent.Type != "created" {
 			t.Error("Event has wrong type")
 		}
 	case <-timeout:
@@ -289,8 +398,8 @@ func TestManagerBroadcastEvent(t *testing.T) {
 	}
 
 	select {
-	case event := <-ch2:
-		if event.TaskID != task.ID {
+	case event := <-sub2.C:
+		if taskEvent, ok := event.Payload.(TaskEvent); !ok || taskEvent.TaskID != task.ID {
 			t.Error("Event has wrong task ID")
 		}
 	case <-timeout:
@@ -298,13 +407,14 @@ func TestManagerBroadcastEvent(t *testing.T) {
 	}
 
 	// Clean up
-	manager.Unsubscribe(ch1)
-	manager.Unsubscribe(ch2)
+	bus.Unsubscribe(sub1)
+	bus.Unsubscribe(sub2)
 }
 
 func TestManagerGetQueueStats(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 
 	// Create queues
 	tool1 := "tool1"
@@ -314,31 +424,31 @@ func TestManagerGetQueueStats(t *testing.T) {
 
 	// Add tasks with different statuses
 	task1 := NewTask(tool1, "echo", []string{})
-	if err := manager.AddTask(task1); err != nil {
+	if err := manager.AddTask(ctx, task1); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
-	if err := manager.UpdateTaskStatus(task1.ID, types.StatusRunning); err != nil {
+	if err := manager.UpdateTaskStatus(ctx, task1.ID, types.StatusRunning); err != nil {
 		t.Fatalf("UpdateTaskStatus failed: %v", err)
 	}
 
 	task2 := NewTask(tool1, "echo", []string{})
-	if err := manager.AddTask(task2); err != nil {
+	if err := manager.AddTask(ctx, task2); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
-	if err := manager.UpdateTaskStatus(task2.ID, types.StatusComplete); err != nil {
+	if err := manager.UpdateTaskStatus(ctx, task2.ID, types.StatusComplete); err != nil {
 		t.Fatalf("UpdateTaskStatus failed: %v", err)
 	}
 
 	task3 := NewTask(tool2, "echo", []string{})
-	if err := manager.AddTask(task3); err != nil {
+	if err := manager.AddTask(ctx, task3); err != nil {
 		t.Fatalf("AddTask failed: %v", err)
 	}
-	if err := manager.UpdateTaskStatus(task3.ID, types.StatusFailed); err != nil {
+	if err := manager.UpdateTaskStatus(ctx, task3.ID, types.StatusFailed); err != nil {
 		t.Fatalf("UpdateTaskStatus failed: %v", err)
 	}
 
 	// Get stats
-	stats := manager.GetQueueStats()
+	stats := manager.GetQueueStats(ctx)
 
 	if len(stats) != 2 {
 		t.Errorf("Expected stats for 2 tools, got %d", len(stats))
@@ -356,11 +466,25 @@ func TestManagerGetQueueStats(t *testing.T) {
 	if tool2Stats.Failed != 1 {
 		t.Errorf("Expected 1 failed task for %s, got %d", tool2, tool2Stats.Failed)
 	}
+	if tool1Stats.OldestQueueWaitMs != 0 {
+		t.Errorf("Expected no queue wait without queued tasks, got %dms", tool1Stats.OldestQueueWaitMs)
+	}
+
+	// A task waiting for a worker shows up as the oldest wait
+	waiting := NewTask(tool2, "echo", []string{})
+	waiting.CreatedAt = time.Now().Add(-time.Minute)
+	if err := manager.AddTask(ctx, waiting); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	if wait := manager.GetQueueStats(ctx)[tool2].OldestQueueWaitMs; wait < time.Minute.Milliseconds() {
+		t.Errorf("Expected oldest queue wait of at least a minute, got %dms", wait)
+	}
 }
 
 func TestManagerConcurrency(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 	manager.CreateQueue(tool, 100)
 
@@ -376,7 +500,7 @@ func TestManagerConcurrency(t *testing.T) {
 			defer wg.Done()
 			for j := 0; j < tasksPerGoroutine; j++ {
 				task := NewTask(tool, "echo", []string{})
-				if err := manager.AddTask(task); err != nil {
+				if err := manager.AddTask(ctx, task); err != nil {
 					// In concurrent tests, we might hit queue limits, which is expected
 					continue
 				}
@@ -399,7 +523,7 @@ func TestManagerConcurrency(t *testing.T) {
 	}
 
 	// Verify all tasks were added
-	tasks := manager.GetAllTasks()
+	tasks := manager.GetAllTasks(ctx)
 	expectedTasks := numGoroutines * tasksPerGoroutine
 	if len(tasks) != expectedTasks {
 		t.Errorf("Expected %d tasks, got %d", expectedTasks, len(tasks))
@@ -409,6 +533,7 @@ func TestManagerConcurrency(t *testing.T) {
 func TestQueueFullError(t *testing.T) {
 	mockRepo := storage.NewMockRepository()
 	manager := NewManager(mockRepo)
+	ctx := context.Background()
 	tool := "test-tool"
 	bufferSize := 2
 
@@ -418,7 +543,7 @@ func TestQueueFullError(t *testing.T) {
 	// Fill the queue
 	for i := 0; i < bufferSize; i++ {
 		task := NewTask(tool, "echo", []string{})
-		err := manager.AddTask(task)
+		err := manager.AddTask(ctx, task)
 		if err != nil {
 			t.Fatalf("Failed to add task %d: %v", i, err)
 		}
@@ -429,20 +554,111 @@ func TestQueueFullError(t *testing.T) {
 		t.Errorf("Queue should have %d tasks", bufferSize)
 	}
 
-	// Try to add one more task (should fail if queue is full)
-	// Note: This will only fail if nothing is consuming from the queue
+	// Try to add one more task, nothing is consuming from the queue
 	task := NewTask(tool, "echo", []string{})
-	err := manager.AddTask(task)
+	err := manager.AddTask(ctx, task)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
 
-	// The error check depends on whether the queue blocks or returns error
-	// In this implementation, it should return an error when full
-	if err == nil {
-		// If no error, the task might have been queued
-		// This could happen if default case in select is used
-		if len(manager.tasks) > bufferSize {
-			// Task was added even though queue was full
-			// This is expected behavior with the current implementation
-			t.Log("Task was queued despite full buffer - this is expected with buffered channels")
+	// The rejected task must not be left behind in storage or the cache
+	if _, err := mockRepo.GetByID(ctx, task.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected no database row for rejected task, got %v", err)
+	}
+	if _, exists := manager.tasks[task.ID]; exists {
+		t.Error("Rejected task should not be cached")
+	}
+}
+
+// recordingNotifier collects notifications and optionally fails them
+type recordingNotifier struct {
+	fail bool
+	sent chan notify.Notification
+}
+
+func (r *recordingNotifier) Name() string { return "recording" }
+
+func (r *recordingNotifier) Notify(ctx context.Context, n notify.Notification) error {
+	r.sent <- n
+	if r.fail {
+		return errors.New("service unavailable")
+	}
+	return nil
+}
+
+func TestManagerNotifiesTerminalStatus(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	manager.CreateQueue("wget", 10)
+
+	// A failing notifier doesn't keep the others from being told
+	failing := &recordingNotifier{fail: true, sent: make(chan notify.Notification, 10)}
+	working := &recordingNotifier{sent: make(chan notify.Notification, 10)}
+	manager.SetNotifiers(failing, working)
+
+	task := NewTask("wget", "wget", []string{"https://example.com"})
+	if err := manager.AddTask(ctx, task); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	if err := manager.UpdateTaskStatus(ctx, task.ID, types.StatusRunning); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	task.SetError("Command failed: exit status 8")
+	if err := manager.UpdateTaskStatus(ctx, task.ID, types.StatusFailed); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+
+	for _, notifier := range []*recordingNotifier{failing, working} {
+		select {
+		case n := <-notifier.sent:
+			if n.TaskID != task.ID || n.Tool != "wget" || n.Status != types.StatusFailed || n.Error == "" {
+				t.Errorf("Unexpected notification %+v", n)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for notification")
+		}
+	}
+
+	// Only terminal statuses are notified
+	select {
+	case n := <-working.sent:
+		t.Errorf("Unexpected extra notification %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// recordingCompressor records the tasks whose output it was asked to compress
+type recordingCompressor struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (c *recordingCompressor) CompressOutput(ctx context.Context, taskID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids = append(c.ids, taskID)
+	return nil
+}
+
+func TestManagerCompressesFinishedOutput(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+	manager.CreateQueue("wget", 10)
+	compressor := &recordingCompressor{}
+	manager.SetOutputCompressor(compressor)
+
+	task := NewTask("wget", "wget", []string{"https://example.com"})
+	if err := manager.AddTask(ctx, task); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+
+	// Output is only compressed once it stops growing
+	for _, status := range []types.Status{types.StatusRunning, types.StatusCanceled} {
+		if err := manager.UpdateTaskStatus(ctx, task.ID, status); err != nil {
+			t.Fatalf("UpdateTaskStatus failed: %v", err)
 		}
 	}
+	if !reflect.DeepEqual(compressor.ids, []string{task.ID}) {
+		t.Errorf("Expected the output to be compressed once when the task ended, got %v", compressor.ids)
+	}
 }
@@ -0,0 +1,63 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FlattenOptions turns structured options and positional arguments into
+// the flat argument list a task runs with: options sorted by key, each as
+// its flag followed by its value, then the positional arguments. Options
+// with an empty value become bare flags.
+func FlattenOptions(options map[string]string, positional []string) []string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, 2*len(options)+len(positional))
+	for _, key := range keys {
+		args = append(args, OptionFlag(key))
+		if value := options[key]; value != "" {
+			args = append(args, value)
+		}
+	}
+	return append(args, positional...)
+}
+
+// OptionFlag returns the flag for an option key. Keys that already start
+// with a dash are used as is; otherwise single letters get one dash ("o"
+// becomes "-o") and longer names two ("output" becomes "--output").
+func OptionFlag(key string) string {
+	switch {
+	case strings.HasPrefix(key, "-"):
+		return key
+	case len(key) == 1:
+		return "-" + key
+	default:
+		return "--" + key
+	}
+}
+
+// ValidateOptionKey checks that key names a single flag, so a key can't
+// smuggle in extra arguments
+func ValidateOptionKey(key string) error {
+	name := strings.TrimLeft(key, "-")
+	if len(key)-len(name) > 2 {
+		return fmt.Errorf("option %q has too many leading dashes", key)
+	}
+	if name == "" {
+		return fmt.Errorf("option %q has no name", key)
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && (r == '-' || r == '_' || r == '.'):
+		default:
+			return fmt.Errorf("option %q contains invalid character %q", key, r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenOptions(t *testing.T) {
+	options := map[string]string{
+		"output":  "%(title)s.%(ext)s",
+		"f":       "best",
+		"--quiet": "",
+	}
+	got := FlattenOptions(options, []string{"https://example.com/watch?v=1"})
+	want := []string{"--quiet", "-f", "best", "--output", "%(title)s.%(ext)s", "https://example.com/watch?v=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FlattenOptions() = %v, want %v", got, want)
+	}
+
+	if got := FlattenOptions(nil, []string{"a"}); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("FlattenOptions(nil) = %v, want [a]", got)
+	}
+}
+
+func TestValidateOptionKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"o", true},
+		{"output", true},
+		{"--no-warnings", true},
+		{"-f", true},
+		{"yes-playlist", true},
+		{"", false},
+		{"--", false},
+		{"---output", false},
+		{"out put", false},
+		{"output=x", false},
+		{"-", false},
+		{"-_x", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateOptionKey(tt.key)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateOptionKey(%q) error = %v, want valid %v", tt.key, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package task
+
+import "fmt"
+
+// enqueued records that task was sent to its tool's queue and gives it the
+// last position. The caller must hold m.mu.
+func (m *Manager) enqueued(task *Task) {
+	m.pending[task.Tool] = append(m.pending[task.Tool], task)
+	task.setQueuePosition(len(m.pending[task.Tool]))
+}
+
+// ClaimTask marks a task taken from its queue as picked up by a worker, see
+// Task.Claim, and moves the tasks behind it up one position
+func (m *Manager) ClaimTask(task *Task) bool {
+	claimed := task.Claim()
+	m.leaveQueue(task)
+	return claimed
+}
+
+// leaveQueue removes a task from its tool's pending tasks, if it is still
+// there, and broadcasts the new position of every task behind it
+func (m *Manager) leaveQueue(task *Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := m.pending[task.Tool]
+	for i, queued := range pending {
+		if queued != task {
+			continue
+		}
+		m.pending[task.Tool] = append(pending[:i], pending[i+1:]...)
+		task.setQueuePosition(0)
+		for j, behind := range m.pending[task.Tool][i:] {
+			position := i + j + 1
+			behind.setQueuePosition(position)
+			m.broadcastEvent(TaskEvent{
+				TaskID:        behind.ID,
+				Type:          "queue_position",
+				Data:          fmt.Sprintf("Task %s is number %d in the %s queue", behind.ID, position, behind.Tool),
+				QueuePosition: position,
+			})
+		}
+		return
+	}
+}
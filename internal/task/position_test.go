@@ -0,0 +1,84 @@
+package task
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lepinkainen/commander/internal/events"
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestQueuePosition(t *testing.T) {
+	manager := NewManager(storage.NewMockRepository())
+	ctx := context.Background()
+
+	queue := manager.CreateQueue("wget", 10)
+	manager.CreateQueue("ffmpeg", 10)
+	tasks := make([]*Task, 4)
+	for i := range tasks {
+		tasks[i] = NewTask("wget", "wget", nil)
+		if err := manager.AddTask(ctx, tasks[i]); err != nil {
+			t.Fatalf("AddTask failed: %v", err)
+		}
+		if got := tasks[i].Clone().QueuePosition; got != i+1 {
+			t.Errorf("Expected task %d at position %d, got %d", i, i+1, got)
+		}
+	}
+
+	// Other tools' queues are counted separately
+	other := NewTask("ffmpeg", "ffmpeg", nil)
+	if err := manager.AddTask(ctx, other); err != nil {
+		t.Fatalf("AddTask failed: %v", err)
+	}
+	if got := other.Clone().QueuePosition; got != 1 {
+		t.Errorf("Expected the ffmpeg task first in its queue, got %d", got)
+	}
+
+	sub := manager.EventBus().Subscribe(events.DefaultBufferSize, events.TopicTasks)
+	defer manager.EventBus().Unsubscribe(sub)
+
+	// Canceling a waiting task moves the ones behind it up
+	if err := manager.CancelTask(ctx, tasks[1].ID, ""); err != nil {
+		t.Fatalf("CancelTask failed: %v", err)
+	}
+	positions := func() []int {
+		got := make([]int, len(tasks))
+		for i, task := range tasks {
+			got[i] = task.Clone().QueuePosition
+		}
+		return got
+	}
+	if got := positions(); got[0] != 1 || got[1] != 0 || got[2] != 2 || got[3] != 3 {
+		t.Errorf("Expected positions [1 0 2 3], got %v", got)
+	}
+
+	// A worker picking up the first task moves the rest up again
+	if claimed := manager.ClaimTask(<-queue); !claimed {
+		t.Error("Expected the first task to be claimed")
+	}
+	if got := positions(); got[0] != 0 || got[2] != 1 || got[3] != 2 {
+		t.Errorf("Expected positions [0 0 1 2], got %v", got)
+	}
+	if claimed := manager.ClaimTask(<-queue); claimed {
+		t.Error("Expected the canceled task not to be claimed")
+	}
+
+	moved := map[string]int{}
+	for len(sub.C) > 0 {
+		if event, ok := (<-sub.C).Payload.(TaskEvent); ok && event.Type == "queue_position" {
+			moved[event.TaskID] = event.QueuePosition
+		}
+	}
+	if len(moved) != 2 || moved[tasks[2].ID] != 1 || moved[tasks[3].ID] != 2 {
+		t.Errorf("Expected queue_position events with the latest positions, got %v", moved)
+	}
+
+	// Finished tasks have no position
+	if err := manager.UpdateTaskStatus(ctx, tasks[0].ID, types.StatusComplete); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	if got := tasks[0].Clone().QueuePosition; got != 0 {
+		t.Errorf("Expected no position for a finished task, got %d", got)
+	}
+}
@@ -0,0 +1,111 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// InterruptedError is the error recorded on tasks that were running when a
+// previous server process stopped
+const InterruptedError = "interrupted by restart"
+
+// RecoverInterrupted reconciles tasks the database still lists as running
+// although no process of this server runs them, which happens when the
+// server crashed or was killed. Tasks whose tool requeue reports as safe to
+// run again are queued from scratch, all others are marked failed. It must
+// be called after the tools' queues are created.
+func (m *Manager) RecoverInterrupted(ctx context.Context, requeue func(tool string) bool) (requeued, failed int, err error) {
+	running, err := m.repo.ListTasks(ctx, types.TaskFilters{
+		Statuses: []types.Status{types.StatusRunning},
+		Sort:     types.TaskSortOldest,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list running tasks: %w", err)
+	}
+
+	for _, data := range running {
+		m.mu.RLock()
+		_, live := m.tasks[data.ID]
+		m.mu.RUnlock()
+		if live {
+			continue
+		}
+
+		if requeue(data.Tool) {
+			err := m.requeueInterrupted(ctx, data.ID)
+			if err == nil {
+				requeued++
+				continue
+			}
+			fmt.Printf("Warning: failed to requeue interrupted task %s: %v\n", data.ID, err)
+		}
+
+		if err := m.failInterrupted(ctx, data); err != nil {
+			return requeued, failed, err
+		}
+		failed++
+	}
+
+	return requeued, failed, nil
+}
+
+// requeueInterrupted resets an interrupted task to queued and sends it to its
+// tool's queue. Its earlier output is kept.
+func (m *Manager) requeueInterrupted(ctx context.Context, id string) error {
+	data, err := m.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	data.Status = types.StatusQueued
+	data.StartedAt = time.Time{}
+	data.EndedAt = time.Time{}
+	data.CPUUserMs = nil
+	data.CPUSystemMs = nil
+	data.MaxRSSBytes = nil
+	task := &Task{TaskData: data}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue, err := m.queueWithRoom(task.Tool)
+	if err != nil {
+		return err
+	}
+
+	if err := m.repo.Update(ctx, task.Clone()); err != nil {
+		return fmt.Errorf("failed to save task to database: %w", err)
+	}
+
+	m.tasks[task.ID] = task
+	queue <- task
+	m.enqueued(task)
+	m.broadcastEvent(TaskEvent{
+		TaskID:        task.ID,
+		Type:          "requeued",
+		Data:          fmt.Sprintf("Task %s %s, queued again for %s", task.ID, InterruptedError, task.Tool),
+		QueuePosition: task.QueuePosition,
+	})
+
+	return nil
+}
+
+// failInterrupted marks an interrupted task as failed
+func (m *Manager) failInterrupted(ctx context.Context, data types.TaskData) error {
+	data.Status = types.StatusFailed
+	data.Error = InterruptedError
+	data.EndedAt = time.Now()
+
+	if err := m.repo.Update(ctx, data); err != nil {
+		return fmt.Errorf("failed to mark task %s failed: %w", data.ID, err)
+	}
+
+	m.broadcastEvent(TaskEvent{
+		TaskID: data.ID,
+		Type:   "status",
+		Data:   string(types.StatusFailed),
+	})
+	return nil
+}
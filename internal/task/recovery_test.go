@@ -0,0 +1,71 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestRecoverInterrupted(t *testing.T) {
+	repo := storage.NewMockRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	// The previous process crashed while these tasks were running
+	stuck := []types.TaskData{
+		{ID: "download", Tool: "wget", Status: types.StatusRunning, CreatedAt: now.Add(-time.Hour), StartedAt: now.Add(-time.Minute)},
+		{ID: "convert", Tool: "ffmpeg", Status: types.StatusRunning, CreatedAt: now.Add(-time.Hour), StartedAt: now.Add(-time.Minute)},
+		{ID: "done", Tool: "wget", Status: types.StatusComplete, CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, data := range stuck {
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+	if err := repo.AppendOutput(ctx, "download", "50% done"); err != nil {
+		t.Fatalf("Failed to append output: %v", err)
+	}
+
+	// A restarted server starts with no live processes
+	manager := NewManager(repo)
+	wget := manager.CreateQueue("wget", 10)
+	manager.CreateQueue("ffmpeg", 10)
+
+	requeued, failed, err := manager.RecoverInterrupted(ctx, func(tool string) bool { return tool == "wget" })
+	if err != nil {
+		t.Fatalf("RecoverInterrupted() error = %v", err)
+	}
+	if requeued != 1 || failed != 1 {
+		t.Errorf("Expected 1 requeued and 1 failed task, got %d and %d", requeued, failed)
+	}
+
+	// The idempotent tool's task runs again from scratch
+	select {
+	case queued := <-wget:
+		if queued.ID != "download" || queued.GetStatus() != types.StatusQueued || !queued.StartedAt.IsZero() {
+			t.Errorf("Expected download to be queued again, got %+v", queued.Clone())
+		}
+		if len(queued.Output) != 1 {
+			t.Errorf("Expected earlier output to be kept, got %v", queued.Output)
+		}
+	default:
+		t.Fatal("Expected the interrupted download to be queued")
+	}
+
+	data, err := repo.GetByID(ctx, "convert")
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if data.Status != types.StatusFailed || data.Error != InterruptedError || data.EndedAt.IsZero() {
+		t.Errorf("Expected convert to be failed as interrupted, got %+v", data)
+	}
+
+	// Running recovery again finds nothing left to do
+	requeued, failed, err = manager.RecoverInterrupted(ctx, func(string) bool { return true })
+	if err != nil || requeued != 0 || failed != 0 {
+		t.Errorf("Expected nothing to recover, got %d requeued, %d failed, %v", requeued, failed, err)
+	}
+}
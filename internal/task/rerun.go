@@ -0,0 +1,83 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+// NewRerun creates a queued task that runs data's command again from
+// scratch. It keeps the arguments, options, tags, expected output, timeout
+// and notes, but gets a new ID and none of the earlier results. RerunOf
+// links it back to data.
+func NewRerun(data types.TaskData) *Task {
+	rerun := NewTask(data.Tool, data.Command, append([]string{}, data.Args...))
+	rerun.RerunOf = data.ID
+	rerun.Organize = data.Organize
+	rerun.TimeoutSeconds = data.TimeoutSeconds
+	rerun.Notes = data.Notes
+	if data.Tags != nil {
+		rerun.Tags = append([]string{}, data.Tags...)
+	}
+	if data.ExpectedOutput != nil {
+		rerun.ExpectedOutput = append([]string{}, data.ExpectedOutput...)
+	}
+	if data.Options != nil {
+		rerun.Options = make(map[string]string, len(data.Options))
+		for key, value := range data.Options {
+			rerun.Options[key] = value
+		}
+	}
+	if data.PositionalArgs != nil {
+		rerun.PositionalArgs = append([]string{}, data.PositionalArgs...)
+	}
+	return rerun
+}
+
+// RequeueFailed queues a rerun of every failed task matching filters,
+// oldest first, and returns the new tasks. The failed tasks are kept as
+// they are, and those that already have a rerun are skipped so that
+// calling it again doesn't queue them twice. When the queue fills up it
+// stops and returns the tasks queued so far together with the error and
+// how many failed tasks remain.
+func (m *Manager) RequeueFailed(ctx context.Context, filters types.TaskFilters) (requeued []*Task, remaining int, err error) {
+	filters.Statuses = []types.Status{types.StatusFailed}
+	filters.Sort = types.TaskSortOldest
+
+	// Reruns are newer than the task they rerun and may no longer match
+	// the other filters, so look for them among all of the tool's tasks
+	rerun := make(map[string]bool)
+	err = m.repo.Each(ctx, types.TaskFilters{Tool: filters.Tool}, func(data types.TaskData) error {
+		if data.RerunOf != "" {
+			rerun[data.RerunOf] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list reruns: %w", err)
+	}
+
+	// Collect them first, queueing a task writes to the database which
+	// shouldn't happen while still reading from it
+	var failed []types.TaskData
+	err = m.repo.Each(ctx, filters, func(data types.TaskData) error {
+		if !rerun[data.ID] {
+			failed = append(failed, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list failed tasks: %w", err)
+	}
+
+	requeued = []*Task{}
+	for i, data := range failed {
+		rerun := NewRerun(data)
+		if err := m.AddTask(ctx, rerun); err != nil {
+			return requeued, len(failed) - i, err
+		}
+		requeued = append(requeued, rerun)
+	}
+	return requeued, 0, nil
+}
@@ -0,0 +1,100 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lepinkainen/commander/internal/storage"
+	"github.com/lepinkainen/commander/internal/types"
+)
+
+func TestNewRerun(t *testing.T) {
+	data := types.TaskData{
+		ID: "old", Tool: "wget", Command: "wget", Args: []string{"-q", "https://example.com"},
+		Status: types.StatusFailed, Output: []string{"404"}, Error: "Command failed: exit status 8",
+		Organize: true, Tags: []string{"docs"}, TimeoutSeconds: 60, Notes: "retry after fixing the proxy",
+		Options: map[string]string{"-q": ""}, PositionalArgs: []string{"https://example.com"},
+		StartedAt: time.Now(), EndedAt: time.Now(),
+	}
+
+	rerun := NewRerun(data).Clone()
+	if rerun.ID == data.ID || rerun.Status != types.StatusQueued {
+		t.Errorf("Expected a new queued task, got %s %s", rerun.ID, rerun.Status)
+	}
+	if len(rerun.Output) != 0 || rerun.Error != "" || !rerun.StartedAt.IsZero() || !rerun.EndedAt.IsZero() {
+		t.Errorf("Expected no results to be carried over, got %+v", rerun)
+	}
+	if !reflect.DeepEqual(rerun.Args, data.Args) || !reflect.DeepEqual(rerun.Options, data.Options) ||
+		!reflect.DeepEqual(rerun.PositionalArgs, data.PositionalArgs) || !reflect.DeepEqual(rerun.Tags, data.Tags) ||
+		!rerun.Organize || rerun.TimeoutSeconds != 60 || rerun.Notes != data.Notes || rerun.RerunOf != "old" {
+		t.Errorf("Expected what the task runs with to be kept, got %+v", rerun)
+	}
+}
+
+func TestRequeueFailed(t *testing.T) {
+	repo := storage.NewMockRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	history := []types.TaskData{
+		{ID: "ancient", Tool: "wget", Args: []string{"a"}, Status: types.StatusFailed, CreatedAt: now.Add(-30 * 24 * time.Hour)},
+		{ID: "first", Tool: "wget", Args: []string{"b"}, Status: types.StatusFailed, CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "second", Tool: "wget", Args: []string{"c"}, Status: types.StatusFailed, CreatedAt: now.Add(-time.Hour)},
+		{ID: "done", Tool: "wget", Args: []string{"d"}, Status: types.StatusComplete, CreatedAt: now.Add(-time.Hour)},
+		{ID: "other", Tool: "ffmpeg", Args: []string{"e"}, Status: types.StatusFailed, CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, data := range history {
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	manager := NewManager(repo)
+	wget := manager.CreateQueue("wget", 10)
+	manager.CreateQueue("ffmpeg", 10)
+
+	from := now.Add(-24 * time.Hour)
+	requeued, remaining, err := manager.RequeueFailed(ctx, types.TaskFilters{Tool: "wget", CreatedFrom: &from})
+	if err != nil || remaining != 0 {
+		t.Fatalf("RequeueFailed() = %d remaining, %v", remaining, err)
+	}
+	if len(requeued) != 2 {
+		t.Fatalf("Expected 2 requeued tasks, got %d", len(requeued))
+	}
+
+	// Reruns are queued oldest first, the failed tasks stay as they were
+	for _, args := range []string{"b", "c"} {
+		queued := <-wget
+		if queued.Args[0] != args || queued.ID == "first" || queued.ID == "second" {
+			t.Errorf("Expected a rerun with args %s, got %+v", args, queued.Clone())
+		}
+	}
+	if data, err := repo.GetByID(ctx, "first"); err != nil || data.Status != types.StatusFailed {
+		t.Errorf("Expected the original task to stay failed, got %s, %v", data.Status, err)
+	}
+}
+
+func TestRequeueFailedQueueFull(t *testing.T) {
+	repo := storage.NewMockRepository()
+	ctx := context.Background()
+	for i, id := range []string{"a", "b", "c"} {
+		data := types.TaskData{ID: id, Tool: "wget", Status: types.StatusFailed, CreatedAt: time.Now().Add(time.Duration(i) * time.Second)}
+		if err := repo.Create(ctx, data); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	manager := NewManager(repo)
+	manager.CreateQueue("wget", 2)
+
+	requeued, remaining, err := manager.RequeueFailed(ctx, types.TaskFilters{Tool: "wget"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	if len(requeued) != 2 || remaining != 1 {
+		t.Errorf("Expected 2 requeued and 1 remaining, got %d and %d", len(requeued), remaining)
+	}
+}
@@ -1,6 +1,8 @@
 package task
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -8,23 +10,31 @@ import (
 	"github.com/lepinkainen/commander/internal/types"
 )
 
-// Task represents a command to be executed
+// Task represents a command to be executed. All persisted and serialized
+// fields live in the embedded types.TaskData; Task only adds locking.
 type Task struct {
 	types.TaskData
-	mu sync.RWMutex
+	mu      sync.RWMutex
+	cancel  context.CancelFunc
+	claimed bool // Picked up by a worker, see Claim
+
+	// Files found in the tool's output directory, see SetOutputDirFiles
+	outputDirFiles   []string
+	outputDirScanned bool
 }
 
 // NewTask creates a new task
 func NewTask(tool, command string, args []string) *Task {
 	return &Task{
 		TaskData: types.TaskData{
-			ID:        uuid.New().String(),
-			Tool:      tool,
-			Command:   command,
-			Args:      args,
-			Status:    types.StatusQueued,
-			Output:    make([]string, 0),
-			CreatedAt: time.Now(),
+			ID:         uuid.New().String(),
+			Tool:       tool,
+			Command:    command,
+			Args:       args,
+			Status:     types.StatusQueued,
+			Output:     make([]string, 0),
+			CreatedAt:  time.Now(),
+			SourceHost: types.ExtractHost(args),
 		},
 	}
 }
@@ -48,6 +58,61 @@ func (t *Task) SetStatus(status types.Status) {
 	case types.StatusComplete, types.StatusFailed, types.StatusCanceled:
 		t.EndedAt = time.Now()
 	}
+
+	// Stop the running process, if any
+	if status == types.StatusCanceled && t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// SetCancelFunc registers the function that stops the task's process. It is
+// called right away if the task was canceled before it started running.
+func (t *Task) SetCancelFunc(cancel context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancel = cancel
+	if t.Status == types.StatusCanceled {
+		cancel()
+	}
+}
+
+// Claim marks the task as picked up by a worker. From then on it can no
+// longer be edited, even while it waits for a host slot. It returns false if
+// the task was canceled while queued and must not run.
+func (t *Task) Claim() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.claimed = true
+	return t.Status != types.StatusCanceled
+}
+
+// setQueuePosition records the task's place in its queue, 0 once it left
+func (t *Task) setQueuePosition(position int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.QueuePosition = position
+}
+
+// EditQueued applies edit to the task's data if it is queued and no worker
+// has claimed it yet, and fails with ErrNotQueued otherwise. The task stays
+// in its queue, so the worker that picks it up runs the edited version, and
+// its source host follows the edited arguments. If edit returns an error the
+// task must be left unchanged.
+func (t *Task) EditQueued(edit func(data *types.TaskData) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Status != types.StatusQueued {
+		return fmt.Errorf("task %s is %s: %w", t.ID, t.Status, ErrNotQueued)
+	}
+	if t.claimed {
+		return fmt.Errorf("task %s was picked up by a worker: %w", t.ID, ErrNotQueued)
+	}
+	if err := edit(&t.TaskData); err != nil {
+		return err
+	}
+	t.SourceHost = types.ExtractHost(t.Args)
+	return nil
 }
 
 // SetError sets an error message
@@ -57,6 +122,55 @@ func (t *Task) SetError(err string) {
 	t.Error = err
 }
 
+// SetNotes replaces the task's notes
+func (t *Task) SetNotes(notes string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Notes = notes
+}
+
+// SetCancelReason records why the user canceled the task
+func (t *Task) SetCancelReason(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.CancelReason = reason
+}
+
+// SetOutputDirFiles records the files a finished run created or changed in
+// its tool's output directory. They replace discovering files from the
+// output, even when there are none.
+func (t *Task) SetOutputDirFiles(paths []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outputDirFiles = paths
+	t.outputDirScanned = true
+}
+
+// OutputDirFiles returns the files set with SetOutputDirFiles, and false if
+// the output directory wasn't scanned
+func (t *Task) OutputDirFiles() ([]string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.outputDirFiles, t.outputDirScanned
+}
+
+// SetArgv records the command line the task's process is started with
+func (t *Task) SetArgv(argv []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Argv = argv
+}
+
+// SetResourceUsage records the CPU times and peak memory of the finished
+// process. maxRSSBytes may be nil when the platform doesn't report it.
+func (t *Task) SetResourceUsage(cpuUserMs, cpuSystemMs int64, maxRSSBytes *int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.CPUUserMs = &cpuUserMs
+	t.CPUSystemMs = &cpuSystemMs
+	t.MaxRSSBytes = maxRSSBytes
+}
+
 // GetStatus returns the current status
 func (t *Task) GetStatus() types.Status {
 	t.mu.RLock()
@@ -81,10 +195,61 @@ func (t *Task) Clone() types.TaskData {
 		CreatedAt: t.CreatedAt,
 		StartedAt: t.StartedAt,
 		EndedAt:   t.EndedAt,
+		Organize:  t.Organize,
+		Notes:     t.Notes,
 	}
 
 	copy(clone.Output, t.Output)
 	copy(clone.Args, t.Args)
 
+	if t.Tags != nil {
+		clone.Tags = make([]string, len(t.Tags))
+		copy(clone.Tags, t.Tags)
+	}
+	if t.ExpectedOutput != nil {
+		clone.ExpectedOutput = make([]string, len(t.ExpectedOutput))
+		copy(clone.ExpectedOutput, t.ExpectedOutput)
+	}
+	if t.Options != nil {
+		clone.Options = make(map[string]string, len(t.Options))
+		for key, value := range t.Options {
+			clone.Options[key] = value
+		}
+	}
+	if t.PositionalArgs != nil {
+		clone.PositionalArgs = make([]string, len(t.PositionalArgs))
+		copy(clone.PositionalArgs, t.PositionalArgs)
+	}
+	if t.Argv != nil {
+		clone.Argv = make([]string, len(t.Argv))
+		copy(clone.Argv, t.Argv)
+	}
+
+	if t.OutputDirectory != nil {
+		dir := *t.OutputDirectory
+		clone.OutputDirectory = &dir
+	}
+	if t.AssociatedFiles != nil {
+		clone.AssociatedFiles = make([]string, len(t.AssociatedFiles))
+		copy(clone.AssociatedFiles, t.AssociatedFiles)
+	}
+	clone.CPUUserMs = copyInt64(t.CPUUserMs)
+	clone.CPUSystemMs = copyInt64(t.CPUSystemMs)
+	clone.MaxRSSBytes = copyInt64(t.MaxRSSBytes)
+	clone.TimeoutSeconds = t.TimeoutSeconds
+	clone.CancelReason = t.CancelReason
+	clone.SourceHost = t.SourceHost
+	clone.RerunOf = t.RerunOf
+	clone.QueuePosition = t.QueuePosition
+
 	return clone
 }
+
+// copyInt64 returns a pointer to a copy of *v, or nil
+func copyInt64(v *int64) *int64 {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	return &c
+}
@@ -1,6 +1,10 @@
 package task
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
@@ -83,6 +87,69 @@ func TestTaskSetStatus(t *testing.T) {
 	}
 }
 
+func TestTaskCancelFunc(t *testing.T) {
+	task := NewTask("test", "echo", []string{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task.SetCancelFunc(cancel)
+	task.SetStatus(types.StatusRunning)
+	if ctx.Err() != nil {
+		t.Fatal("Expected context to stay active while running")
+	}
+
+	task.SetStatus(types.StatusCanceled)
+	if ctx.Err() == nil {
+		t.Error("Expected canceling the task to cancel its context")
+	}
+
+	// A task canceled while queued is stopped as soon as it registers
+	queued := NewTask("test", "echo", []string{})
+	queued.SetStatus(types.StatusCanceled)
+	ctx, cancel = context.WithCancel(context.Background())
+	queued.SetCancelFunc(cancel)
+	if ctx.Err() == nil {
+		t.Error("Expected already canceled task to cancel its context immediately")
+	}
+}
+
+func TestTaskEditQueued(t *testing.T) {
+	task := NewTask("test", "echo", []string{"https://old.example/a"})
+	if task.SourceHost != "old.example" {
+		t.Errorf("Expected source host old.example, got %q", task.SourceHost)
+	}
+	setArgs := func(data *types.TaskData) error {
+		data.Args = []string{"https://new.example/a"}
+		return nil
+	}
+
+	if err := task.EditQueued(setArgs); err != nil {
+		t.Fatalf("EditQueued() error = %v", err)
+	}
+	if !reflect.DeepEqual(task.Args, []string{"https://new.example/a"}) {
+		t.Errorf("Expected edited args, got %v", task.Args)
+	}
+	if task.SourceHost != "new.example" {
+		t.Errorf("Expected the source host to follow the args, got %q", task.SourceHost)
+	}
+
+	// Once a worker claims the task it can't be edited, even while queued
+	if !task.Claim() {
+		t.Fatal("Expected a queued task to be claimable")
+	}
+	if err := task.EditQueued(setArgs); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("Expected ErrNotQueued for a claimed task, got %v", err)
+	}
+
+	canceled := NewTask("test", "echo", nil)
+	canceled.SetStatus(types.StatusCanceled)
+	if err := canceled.EditQueued(setArgs); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("Expected ErrNotQueued for a canceled task, got %v", err)
+	}
+	if canceled.Claim() {
+		t.Error("Expected a canceled task not to be run")
+	}
+}
+
 func TestTaskSetError(t *testing.T) {
 	task := NewTask("test", "echo", []string{})
 
@@ -114,6 +181,12 @@ func TestTaskClone(t *testing.T) {
 	task.SetStatus(types.StatusRunning)
 	task.AppendOutput("output line")
 	task.SetError("test error")
+	outputDir := "/tmp/output"
+	task.OutputDirectory = &outputDir
+	task.AssociatedFiles = []string{"file-1"}
+	task.TimeoutSeconds = 60
+	task.Options = map[string]string{"f": "best"}
+	task.SetArgv([]string{"echo", "arg1", "arg2"})
 
 	clone := task.Clone()
 
@@ -139,6 +212,23 @@ func TestTaskClone(t *testing.T) {
 	if len(clone.Output) != len(task.Output) {
 		t.Error("Clone Output length doesn't match")
 	}
+	if clone.OutputDirectory == nil || *clone.OutputDirectory != *task.OutputDirectory {
+		t.Error("Clone OutputDirectory doesn't match")
+	}
+	if len(clone.AssociatedFiles) != len(task.AssociatedFiles) {
+		t.Error("Clone AssociatedFiles length doesn't match")
+	}
+	if clone.TimeoutSeconds != task.TimeoutSeconds {
+		t.Error("Clone TimeoutSeconds doesn't match")
+	}
+	clone.Options["f"] = "worst"
+	if task.Options["f"] != "best" {
+		t.Error("Modifying clone Options affected original")
+	}
+	clone.Argv[0] = "modified"
+	if task.Argv[0] != "echo" {
+		t.Error("Modifying clone Argv affected original")
+	}
 
 	// Verify slices are independent copies
 	if len(clone.Args) > 0 {
@@ -216,3 +306,97 @@ func TestTaskConcurrency(t *testing.T) {
 		}
 	}
 }
+
+func TestTaskJSONMatchesTaskData(t *testing.T) {
+	task := NewTask("test", "echo", []string{"arg1"})
+	dir := "/tmp/out"
+	task.OutputDirectory = &dir
+	task.AssociatedFiles = []string{"file-1"}
+	task.SetStatus(types.StatusRunning)
+	task.AppendOutput("line")
+
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		t.Fatalf("Failed to marshal task: %v", err)
+	}
+
+	dataJSON, err := json.Marshal(task.Clone())
+	if err != nil {
+		t.Fatalf("Failed to marshal task data: %v", err)
+	}
+
+	if string(taskJSON) != string(dataJSON) {
+		t.Errorf("Task JSON differs from TaskData JSON:\n task: %s\n data: %s", taskJSON, dataJSON)
+	}
+}
+
+func TestTaskQueueWait(t *testing.T) {
+	created := time.Now().Add(-time.Minute)
+	now := created.Add(90 * time.Second)
+
+	tests := []struct {
+		name string
+		data types.TaskData
+		want time.Duration
+	}{
+		{"queued", types.TaskData{Status: types.StatusQueued, CreatedAt: created}, 90 * time.Second},
+		{"started", types.TaskData{Status: types.StatusComplete, CreatedAt: created, StartedAt: created.Add(5 * time.Second), EndedAt: now}, 5 * time.Second},
+		{"canceled while queued", types.TaskData{Status: types.StatusCanceled, CreatedAt: created, EndedAt: created.Add(20 * time.Second)}, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.data.QueueWait(now); got != tt.want {
+				t.Errorf("QueueWait() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Queued tasks report their wait so far when encoded
+	task := NewTask("test", "echo", nil)
+	task.CreatedAt = time.Now().Add(-time.Minute)
+	raw, err := json.Marshal(task)
+	if err != nil {
+		t.Fatalf("Failed to marshal task: %v", err)
+	}
+	var decoded types.TaskData
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal task JSON: %v", err)
+	}
+	if decoded.QueueWaitMs < time.Minute.Milliseconds() {
+		t.Errorf("Expected queue wait of at least a minute, got %dms", decoded.QueueWaitMs)
+	}
+}
+
+func TestTaskJSONFieldNames(t *testing.T) {
+	task := NewTask("test", "echo", []string{"arg1"})
+	dir := "/tmp/out"
+	task.OutputDirectory = &dir
+	task.AssociatedFiles = []string{"file-1"}
+	task.SetError("boom")
+	rss := int64(4096)
+	task.SetResourceUsage(10, 5, &rss)
+
+	raw, err := json.Marshal(task)
+	if err != nil {
+		t.Fatalf("Failed to marshal task: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatalf("Failed to unmarshal task JSON: %v", err)
+	}
+
+	expected := []string{
+		"id", "tool", "command", "args", "status", "output", "error",
+		"created_at", "started_at", "ended_at", "output_directory", "associated_files",
+		"cpu_user_ms", "cpu_system_ms", "max_rss_bytes", "organize", "queue_wait_ms",
+	}
+	for _, name := range expected {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("Expected JSON field %q to be present", name)
+		}
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("Expected %d JSON fields, got %d: %s", len(expected), len(fields), raw)
+	}
+}
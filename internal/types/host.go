@@ -0,0 +1,27 @@
+package types
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ExtractHost returns the lowercased host name of the first URL found in
+// args, or an empty string if none of them look like a URL
+func ExtractHost(args []string) string {
+	for _, arg := range args {
+		if !strings.Contains(arg, "://") {
+			continue
+		}
+
+		u, err := url.Parse(arg)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "ftp", "ftps":
+			return strings.ToLower(u.Hostname())
+		}
+	}
+	return ""
+}
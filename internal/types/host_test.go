@@ -0,0 +1,28 @@
+package types
+
+import "testing"
+
+func TestExtractHost(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no args", nil, ""},
+		{"plain url", []string{"https://Example.com/video"}, "example.com"},
+		{"url after flags", []string{"-o", "out.mp4", "https://www.youtube.com/watch?v=1"}, "www.youtube.com"},
+		{"port stripped", []string{"http://localhost:8080/file"}, "localhost"},
+		{"ftp", []string{"ftp://mirror.example.org/pub/linux.iso"}, "mirror.example.org"},
+		{"first url wins", []string{"https://a.example/1", "https://b.example/2"}, "a.example"},
+		{"unsupported scheme", []string{"file:///etc/passwd"}, ""},
+		{"no url", []string{"-i", "input.mkv", "output.mp4"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractHost(tt.args); got != tt.want {
+				t.Errorf("ExtractHost(%v) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
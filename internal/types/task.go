@@ -1,6 +1,8 @@
 package types
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -15,6 +17,26 @@ const (
 	StatusCanceled Status = "canceled"
 )
 
+// Stream identifies the pipe of a task's process an output line came from
+type Stream string
+
+const (
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+)
+
+// StderrPrefix marks output lines the process wrote to stderr. It is kept in
+// the stored line so that the combined output still shows which is which.
+const StderrPrefix = "[ERROR] "
+
+// OutputStream returns the stream an output line came from
+func OutputStream(line string) Stream {
+	if strings.HasPrefix(line, StderrPrefix) {
+		return StreamStderr
+	}
+	return StreamStdout
+}
+
 // TaskData represents the data fields of a task
 type TaskData struct {
 	ID              string    `json:"id"`
@@ -29,6 +51,165 @@ type TaskData struct {
 	EndedAt         time.Time `json:"ended_at,omitempty"`
 	OutputDirectory *string   `json:"output_directory,omitempty"` // Directory where task outputs files
 	AssociatedFiles []string  `json:"associated_files,omitempty"` // IDs of files created by this task
+
+	// Organize moves discovered files into the tool's directory. When false
+	// they are registered where the tool wrote them.
+	Organize bool `json:"organize"`
+
+	// Tags are applied to the files discovered for the task. They include
+	// the tool's default tags.
+	Tags []string `json:"tags,omitempty"`
+
+	// ExpectedOutput lists the files the task must produce. When set, they
+	// are registered directly instead of being discovered from the output,
+	// and the task fails if any of them is missing.
+	ExpectedOutput []string `json:"expected_output,omitempty"`
+
+	// TimeoutSeconds limits how long the task's process may run. Zero means
+	// only the tool's max runtime applies.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+
+	// Options and PositionalArgs are the structured form of Args for tasks
+	// created with options. Args stays the flattened list the task runs with;
+	// these let clients edit a single option and rerun.
+	Options        map[string]string `json:"options,omitempty"`
+	PositionalArgs []string          `json:"positional_args,omitempty"`
+
+	// Notes are the user's annotations, e.g. why the task was queued. They
+	// don't affect execution.
+	Notes string `json:"notes,omitempty"`
+
+	// CancelReason is why the user canceled the task, kept apart from Error
+	// which only reports why a run failed
+	CancelReason string `json:"cancel_reason,omitempty"`
+
+	// SourceHost is the host of the first URL in Args, see ExtractHost, for
+	// telling which site a download came from. Empty when the task has no
+	// URL.
+	SourceHost string `json:"source_host,omitempty"`
+
+	// RerunOf is the ID of the task this one was queued to run again, see
+	// task.NewRerun. Empty for tasks created directly.
+	RerunOf string `json:"rerun_of,omitempty"`
+
+	// Argv is the command line the process was started with, the command
+	// followed by the tool's default arguments and the task's own. It is
+	// recorded when a worker runs the task, so it's empty while queued.
+	Argv []string `json:"argv,omitempty"`
+
+	// Resource usage of the finished process. CPU times are in milliseconds
+	// and peak memory (max resident set size) in bytes. Nil when the
+	// platform doesn't report them.
+	CPUUserMs   *int64 `json:"cpu_user_ms,omitempty"`
+	CPUSystemMs *int64 `json:"cpu_system_ms,omitempty"`
+	MaxRSSBytes *int64 `json:"max_rss_bytes,omitempty"`
+
+	// QueueWaitMs is how long the task waited for a worker, see QueueWait.
+	// It is filled in when the task is encoded and not stored.
+	QueueWaitMs int64 `json:"queue_wait_ms"`
+
+	// QueuePosition is the task's place in its tool's queue while it waits
+	// for a worker, 1 being next. It is kept by the task manager for the
+	// tasks it queued and not stored.
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+// QueueWait returns how long the task waited in the queue: until it started,
+// until it ended for tasks canceled before starting, or until now for tasks
+// still queued
+func (d TaskData) QueueWait(now time.Time) time.Duration {
+	switch {
+	case !d.StartedAt.IsZero():
+		return d.StartedAt.Sub(d.CreatedAt)
+	case !d.EndedAt.IsZero():
+		return d.EndedAt.Sub(d.CreatedAt)
+	case d.Status == StatusQueued:
+		return now.Sub(d.CreatedAt)
+	default:
+		return 0
+	}
+}
+
+// MarshalJSON encodes the task with QueueWaitMs as of now
+func (d TaskData) MarshalJSON() ([]byte, error) {
+	type taskData TaskData
+	out := taskData(d)
+	out.QueueWaitMs = d.QueueWait(time.Now()).Milliseconds()
+	return json.Marshal(out)
+}
+
+// ToolStats summarizes the task history of a tool over a time window
+type ToolStats struct {
+	Tool               string    `json:"tool"`
+	Since              time.Time `json:"since,omitempty"`
+	Total              int       `json:"total"`
+	Queued             int       `json:"queued"`
+	Running            int       `json:"running"`
+	Completed          int       `json:"completed"`
+	Failed             int       `json:"failed"`
+	Canceled           int       `json:"canceled"`
+	SuccessRate        float64   `json:"success_rate"` // Completed / (Completed + Failed)
+	AvgDurationSeconds float64   `json:"avg_duration_seconds"`
+	P50DurationSeconds float64   `json:"p50_duration_seconds"`
+	P95DurationSeconds float64   `json:"p95_duration_seconds"`
+	AvgQueueWaitMs     int64     `json:"avg_queue_wait_ms"` // Over tasks that started
+	P95QueueWaitMs     int64     `json:"p95_queue_wait_ms"`
+	FilesDiscovered    int       `json:"files_discovered"`
+	BytesDiscovered    int64     `json:"bytes_discovered"`
+}
+
+// ToolFailures summarizes a tool's recent failures: the failure rate of the
+// tasks that ended in a time window and the most recent failed tasks
+type ToolFailures struct {
+	Tool        string        `json:"tool"`
+	Since       time.Time     `json:"since,omitempty"`
+	Finished    int           `json:"finished"` // Completed and failed tasks
+	Failed      int           `json:"failed"`
+	FailureRate float64       `json:"failure_rate"` // Failed / Finished
+	Failures    []TaskFailure `json:"failures"`     // Newest first
+}
+
+// TaskFailure is a failed task and why it failed
+type TaskFailure struct {
+	TaskID    string    `json:"task_id"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+	EndedAt   time.Time `json:"ended_at,omitempty"`
+}
+
+// OutputPage is a range of a task's output lines. Offset is the index of
+// the first line in Lines and Total the number of lines the task has. When
+// Stream is set only that stream's lines are counted and returned.
+type OutputPage struct {
+	TaskID string   `json:"task_id"`
+	Stream Stream   `json:"stream,omitempty"`
+	Offset int      `json:"offset"`
+	Total  int      `json:"total"`
+	Lines  []string `json:"lines"`
+}
+
+// OutputSearch describes a search within one task's output
+type OutputSearch struct {
+	Query         string
+	Regex         bool // Query is a regular expression instead of plain text
+	CaseSensitive bool
+	Limit         int // Maximum number of matches returned
+}
+
+// OutputMatch is an output line matching a search. Line is the line's
+// index in the task's output, usable as the offset to page to it.
+type OutputMatch struct {
+	Line    int    `json:"line"`
+	Stream  Stream `json:"stream"`
+	Snippet string `json:"snippet"`
+}
+
+// OutputSearchResult lists the lines of a task's output matching a search,
+// in output order. Truncated is set when more lines matched than the limit.
+type OutputSearchResult struct {
+	TaskID    string        `json:"task_id"`
+	Matches   []OutputMatch `json:"matches"`
+	Truncated bool          `json:"truncated"`
 }
 
 // Directory represents a download directory
@@ -41,6 +222,12 @@ type Directory struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// DirectoryUsage is the number and total size of a directory's files
+type DirectoryUsage struct {
+	FileCount int   `json:"file_count"`
+	TotalSize int64 `json:"total_size"`
+}
+
 // File represents a file in the system
 type File struct {
 	ID          string    `json:"id"`
@@ -55,6 +242,50 @@ type File struct {
 	AccessedAt  time.Time `json:"accessed_at"`
 }
 
+// TaskSort orders listed tasks by creation time
+type TaskSort string
+
+const (
+	TaskSortNewest TaskSort = "newest"
+	TaskSortOldest TaskSort = "oldest"
+)
+
+// TaskFilters represents filters for task listing. Empty fields don't
+// filter.
+type TaskFilters struct {
+	Tool        string     `json:"tool,omitempty"`
+	Statuses    []Status   `json:"statuses,omitempty"` // Any of them
+	CreatedFrom *time.Time `json:"created_from,omitempty"`
+	CreatedTo   *time.Time `json:"created_to,omitempty"`
+
+	// Host matches tasks whose SourceHost is the host, ignoring case, or
+	// one of its subdomains
+	Host string `json:"host,omitempty"`
+
+	// Query matches tasks whose tool, command, arguments or notes contain
+	// it, ignoring case, and with QueryOutput their output too
+	Query       string `json:"query,omitempty"`
+	QueryOutput bool   `json:"query_output,omitempty"`
+
+	// Sort orders the tasks, TaskSortNewest when empty
+	Sort TaskSort `json:"sort,omitempty"`
+
+	// Offset skips that many matching tasks, and Limit caps how many are
+	// returned. Zero means no limit.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+
+	// WithOutput loads each task's output lines, which are left out
+	// otherwise
+	WithOutput bool `json:"with_output,omitempty"`
+}
+
+// DirectoryFilters represents filters for directory listing
+type DirectoryFilters struct {
+	// ToolName limits the list to the directories linked to a tool
+	ToolName string `json:"tool_name,omitempty"`
+}
+
 // FileFilters represents filters for file listing
 type FileFilters struct {
 	DirectoryID string     `json:"directory_id,omitempty"`
@@ -64,4 +295,40 @@ type FileFilters struct {
 	MaxSize     int64      `json:"max_size,omitempty"`
 	CreatedFrom *time.Time `json:"created_from,omitempty"`
 	CreatedTo   *time.Time `json:"created_to,omitempty"`
+	Query       string     `json:"query,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+
+	// Orphaned limits the list to files without a task, or whose task no
+	// longer exists
+	Orphaned bool `json:"orphaned,omitempty"`
+}
+
+// AuditEntry records one mutating API request
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Actor      string    `json:"actor"`       // Who made the request, see the api package
+	RemoteAddr string    `json:"remote_addr"` // Client address
+	Action     string    `json:"action"`      // Method and route, e.g. "POST /api/tasks/{id}/cancel"
+	Summary    string    `json:"summary"`     // Method and path as requested
+	Status     int       `json:"status"`      // HTTP status of the response
+}
+
+// AuditFilters selects audit entries. Entries are listed newest first,
+// Offset and Limit page through them.
+type AuditFilters struct {
+	Actor  string     `json:"actor,omitempty"`
+	Action string     `json:"action,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Offset int        `json:"offset"`
+	Limit  int        `json:"limit"`
+}
+
+// AuditPage is a page of audit entries. Total counts every entry matching
+// the filters.
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Total   int          `json:"total"`
 }
@@ -132,6 +132,30 @@ class Commander {
             case 'files_discovered':
                 this.handleFileDiscovery(task_id, content);
                 break;
+
+            case 'lagged':
+                // Events were dropped, reload everything shown
+                this.loadAndRenderTasks();
+                this.loadAndRenderStats();
+                if (this.selectedDirectory) {
+                    this.loadAndRenderFiles(this.selectedDirectory.id);
+                }
+                break;
+
+            case 'file_created':
+            case 'file_moved':
+            case 'file_deleted':
+            case 'file_tagged':
+                this.handleFileEvent(data);
+                break;
+        }
+    }
+
+    handleFileEvent({ directory_id, from_directory_id }) {
+        // Refresh the file list if the change touches the directory on screen
+        const selectedId = this.selectedDirectory?.id;
+        if (selectedId && (directory_id === selectedId || from_directory_id === selectedId)) {
+            this.loadAndRenderFiles(selectedId);
         }
     }
 
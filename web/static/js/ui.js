@@ -330,19 +330,24 @@ export function renderStats(stats) {
     let totalRunning = 0;
     let totalCompleted = 0;
     let totalFailed = 0;
+    let totalWorkers = 0;
+    let totalBusyWorkers = 0;
     
     Object.values(stats).forEach(stat => {
         totalPending += stat.pending;
         totalRunning += stat.running;
         totalCompleted += stat.completed;
         totalFailed += stat.failed;
+        totalWorkers += stat.workers || 0;
+        totalBusyWorkers += stat.busy_workers || 0;
     });
     
     const statCards = [
         { label: 'Pending', value: totalPending },
         { label: 'Running', value: totalRunning },
         { label: 'Completed', value: totalCompleted },
-        { label: 'Failed', value: totalFailed }
+        { label: 'Failed', value: totalFailed },
+        { label: 'Busy Workers', value: `${totalBusyWorkers}/${totalWorkers}` }
     ];
     
     statCards.forEach(stat => {